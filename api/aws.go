@@ -0,0 +1,71 @@
+package api
+
+import (
+	"context"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/ratelimit"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// NewDynamoClient builds a DynamoDB client from the storage config.
+// Unset options fall back to the AWS defaults (environment, shared config, instance role),
+// so the same binary runs against localstack, DynamoDB Local or real AWS.
+// Throttled and failed requests are retried with exponential backoff, without the client side rate
+// limit of the SDK, which fails requests outright once too many have been retried.
+func NewDynamoClient(ctx context.Context, conf StorageConfig) (*dynamodb.Client, error) {
+	cfg, err := loadAWSConfig(ctx, conf.AWSConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	return dynamodb.NewFromConfig(cfg, func(o *dynamodb.Options) {
+		if conf.Endpoint != "" {
+			o.BaseEndpoint = aws.String(conf.Endpoint)
+		}
+		o.Retryer = retry.NewStandard(func(so *retry.StandardOptions) {
+			if conf.RetryMaxAttempts > 0 {
+				so.MaxAttempts = conf.RetryMaxAttempts
+			}
+			if conf.RetryMaxBackoff > 0 {
+				so.MaxBackoff = conf.RetryMaxBackoff
+			}
+			so.RateLimiter = ratelimit.None
+		})
+	}), nil
+}
+
+// NewS3Client builds the S3 client for the backup bucket, with the same endpoint and credentials as DynamoDB.
+// A custom endpoint is addressed path style, localstack does not resolve bucket subdomains.
+func NewS3Client(ctx context.Context, conf StorageConfig) (*s3.Client, error) {
+	cfg, err := loadAWSConfig(ctx, conf.AWSConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	return s3.NewFromConfig(cfg, func(o *s3.Options) {
+		if conf.Endpoint != "" {
+			o.BaseEndpoint = aws.String(conf.Endpoint)
+			o.UsePathStyle = true
+		}
+	}), nil
+}
+
+func loadAWSConfig(ctx context.Context, conf AWSConfig) (aws.Config, error) {
+	var opts []func(*awsconfig.LoadOptions) error
+	if conf.Region != "" {
+		opts = append(opts, awsconfig.WithRegion(conf.Region))
+	}
+	if conf.Profile != "" {
+		opts = append(opts, awsconfig.WithSharedConfigProfile(conf.Profile))
+	}
+	if conf.AccessKeyID != "" {
+		opts = append(opts, awsconfig.WithCredentialsProvider(
+			credentials.NewStaticCredentialsProvider(conf.AccessKeyID, conf.SecretAccessKey, conf.SessionToken)))
+	}
+
+	return awsconfig.LoadDefaultConfig(ctx, opts...)
+}
@@ -0,0 +1,56 @@
+package api
+
+import (
+	"context"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestNewDynamoClient(t *testing.T) {
+	ctx := context.Background()
+
+	client, err := NewDynamoClient(ctx, StorageConfig{AWSConfig: AWSConfig{
+		Endpoint:         "http://localhost:8000",
+		Region:           "eu-west-1",
+		AccessKeyID:      "local",
+		SecretAccessKey:  "secret",
+		RetryMaxAttempts: 7,
+	}})
+	require.NoError(t, err)
+
+	opts := client.Options()
+	assert.Equal(t, "http://localhost:8000", aws.ToString(opts.BaseEndpoint))
+	assert.Equal(t, "eu-west-1", opts.Region)
+	assert.Equal(t, 7, opts.Retryer.MaxAttempts())
+
+	creds, err := opts.Credentials.Retrieve(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "local", creds.AccessKeyID)
+	assert.Equal(t, "secret", creds.SecretAccessKey)
+}
+
+func TestNewDynamoClientDefaults(t *testing.T) {
+	t.Setenv("AWS_REGION", "us-east-2")
+
+	client, err := NewDynamoClient(context.Background(), StorageConfig{})
+	require.NoError(t, err)
+	assert.Nil(t, client.Options().BaseEndpoint)
+	assert.Equal(t, "us-east-2", client.Options().Region)
+}
+
+func TestNewS3Client(t *testing.T) {
+	client, err := NewS3Client(context.Background(), StorageConfig{AWSConfig: AWSConfig{
+		Endpoint:        "http://localhost:4566",
+		Region:          "eu-west-1",
+		AccessKeyID:     "local",
+		SecretAccessKey: "secret",
+	}})
+	require.NoError(t, err)
+
+	opts := client.Options()
+	assert.Equal(t, "http://localhost:4566", aws.ToString(opts.BaseEndpoint))
+	assert.True(t, opts.UsePathStyle)
+	assert.Equal(t, "eu-west-1", opts.Region)
+}
@@ -2,8 +2,10 @@ package api
 
 import (
 	"github.com/alex-pricope/simple-voting-system/logging"
+	"github.com/alex-pricope/simple-voting-system/storage"
 	"github.com/spf13/viper"
 	"sync"
+	"time"
 )
 
 type Config struct {
@@ -11,15 +13,96 @@ type Config struct {
 	ServerConfig
 }
 
+const (
+	StorageBackendDynamo = "dynamo"
+	StorageBackendMemory = "memory"
+	StorageBackendSQLite = "sqlite"
+)
+
+// Layouts of the DynamoDB Votes table
+const (
+	VoteLayoutRating = "rating" // one item per rating, SK cat#..#team#..
+	VoteLayoutBallot = "ballot" // one item per ballot, see storage.Ballot
+)
+
+// Table layouts of the dynamo backend
+const (
+	TableLayoutTables = "tables" // codes, votes, teams and categories each in their own table
+	TableLayoutSingle = "single" // all of them in one partition per event of a single table, see storage.SingleTable
+)
+
+// Sources of the results endpoint
+const (
+	ResultsFromVotes   = "votes"   // computed from all raw votes on every request
+	ResultsFromTallies = "tallies" // read from the tallies updated at vote time, see storage.TallyStorage
+)
+
 type StorageConfig struct {
+	Backend                   string
 	TableNameCodes            string
 	TableNameVotes            string
 	TableNameTeams            string
 	TableNameVotingCategories string
+	TableNameTallies          string
+	TableNameEvents           string
+	TableNameAudit            string
+	TableNameLedger           string
+	TableNameMigrations       string
+	TableNameSingle           string
+	SQLitePath                string
+	VoteLayout                string
+	TableLayout               string        // TableLayoutTables or TableLayoutSingle
+	Results                   string        // ResultsFromVotes or ResultsFromTallies
+	CheckTables               bool          // Check the DynamoDB key schemas on startup
+	CreateTables              bool          // Create missing DynamoDB tables on startup
+	Instrument                bool          // Time storage calls and expose the stats on /api/admin/metrics/storage
+	SlowCallThreshold         time.Duration // Storage calls at least this slow are logged, 0 disables it
+	CacheTTL                  time.Duration // How long teams and categories are cached in process, 1m by default, 0 disables the cache
+	BackupBucket              string        // S3 bucket GET /api/admin/backup?upload=true writes to, empty disables uploads
+	Faults                    FaultsConfig
+	AWSConfig
+}
+
+// FaultsConfig injects failures into every storage call, to see how voting degrades under load.
+// All zero, the default, disables it. Never set it in production.
+type FaultsConfig struct {
+	ErrorRate    float64       // Share of calls failing with storage.ErrUnavailable, 0 to 1
+	ThrottleRate float64       // Share of calls failing with storage.ErrThrottled, 0 to 1
+	Latency      time.Duration // Added to every call
+}
+
+// AWSConfig selects the DynamoDB endpoint and credentials. Empty values use the AWS SDK defaults.
+type AWSConfig struct {
+	Endpoint        string // e.g. http://localhost:4566 for localstack or http://localhost:8000 for DynamoDB Local
+	Region          string
+	Profile         string // Shared config profile
+	AccessKeyID     string // Static credentials, take precedence over the profile
+	SecretAccessKey string
+	SessionToken    string
+	// Retries of the SDK client for throttled and failed requests. The storage uses the same limits
+	// to retry unprocessed batch items and throttled transactions.
+	RetryMaxAttempts int           // Attempts per request including the first, 0 uses the SDK default of 3
+	RetryMaxBackoff  time.Duration // Longest wait between attempts, 0 uses the SDK default of 20s
 }
 
 type ServerConfig struct {
-	Port int
+	Port         int
+	DefaultEvent string // Event served by the /api routes without an /api/events/{eventId} prefix
+}
+
+// tableNames returns the configured table names, those of the default event before events existed
+func (c *Config) tableNames() storage.TableNames {
+	return storage.TableNames{
+		Codes:      c.TableNameCodes,
+		Votes:      c.TableNameVotes,
+		Teams:      c.TableNameTeams,
+		Categories: c.TableNameVotingCategories,
+		Tallies:    c.TableNameTallies,
+		Audit:      c.TableNameAudit,
+		Ledger:     c.TableNameLedger,
+		Single:     c.TableNameSingle,
+		Partition:  storage.SingleTablePartition(""),
+	}
 }
 
 var settingsOnce sync.Once
@@ -28,13 +111,46 @@ func ReadConfig() *Config {
 
 	var conf = &Config{
 		StorageConfig: StorageConfig{
+			Backend:                   getStringOrDefault("storage.backend", StorageBackendDynamo),
 			TableNameCodes:            viper.GetString("storage.TableNameCodes"),
 			TableNameVotes:            viper.GetString("storage.TableNameVotes"),
 			TableNameTeams:            viper.GetString("storage.TableNameTeams"),
 			TableNameVotingCategories: viper.GetString("storage.TableNameVotingCategories"),
+			TableNameTallies:          getStringOrDefault("storage.TableNameTallies", "VoteTallies"),
+			TableNameEvents:           getStringOrDefault("storage.TableNameEvents", "VotingEvents"),
+			TableNameAudit:            getStringOrDefault("storage.TableNameAudit", "VotingAudit"),
+			TableNameLedger:           getStringOrDefault("storage.TableNameLedger", "VotingLedger"),
+			TableNameMigrations:       getStringOrDefault("storage.TableNameMigrations", "VotingMigrations"),
+			TableNameSingle:           getStringOrDefault("storage.TableNameSingle", "Voting"),
+			SQLitePath:                getStringOrDefault("storage.sqlitePath", "voting.db"),
+			VoteLayout:                getStringOrDefault("storage.voteLayout", VoteLayoutRating),
+			TableLayout:               getStringOrDefault("storage.tableLayout", TableLayoutTables),
+			Results:                   getStringOrDefault("storage.results", ResultsFromVotes),
+			CheckTables:               getBoolOrDefault("storage.checkTables", true),
+			CreateTables:              getBoolOrDefault("storage.createTables", false),
+			Instrument:                getBoolOrDefault("storage.instrument", false),
+			SlowCallThreshold:         getDurationOrDefault("storage.slowCallThreshold", 200*time.Millisecond),
+			CacheTTL:                  getDurationOrDefault("storage.cacheTTL", time.Minute),
+			BackupBucket:              viper.GetString("storage.backupBucket"),
+			Faults: FaultsConfig{
+				ErrorRate:    getFloatOrDefault("storage.faults.errorRate", 0),
+				ThrottleRate: getFloatOrDefault("storage.faults.throttleRate", 0),
+				Latency:      getDurationOrDefault("storage.faults.latency", 0),
+			},
+			AWSConfig: AWSConfig{
+				Endpoint:         viper.GetString("storage.endpoint"),
+				Region:           viper.GetString("storage.region"),
+				Profile:          viper.GetString("storage.profile"),
+				AccessKeyID:      viper.GetString("storage.accessKeyId"),
+				SecretAccessKey:  viper.GetString("storage.secretAccessKey"),
+				SessionToken:     viper.GetString("storage.sessionToken"),
+				RetryMaxAttempts: getIntOrDefault("storage.retryMaxAttempts", 5),
+				RetryMaxBackoff:  getDurationOrDefault("storage.retryMaxBackoff", time.Second),
+			},
 		},
 		ServerConfig: ServerConfig{
-			Port: viper.GetInt("server.port"),
+			Port:         viper.GetInt("server.port"),
+			DefaultEvent: getStringOrDefault("server.defaultEvent", "default"),
 		},
 	}
 
@@ -89,7 +205,6 @@ func getBool(name string) bool {
 	return false
 }
 
-//nolint:unused
 func getIntOrDefault(name string, def int) int {
 	if viper.IsSet(name) {
 		v := viper.GetInt(name)
@@ -100,7 +215,6 @@ func getIntOrDefault(name string, def int) int {
 	return def
 }
 
-//nolint:unused
 func getBoolOrDefault(name string, def bool) bool {
 	if viper.IsSet(name) {
 		v := viper.GetBool(name)
@@ -111,7 +225,6 @@ func getBoolOrDefault(name string, def bool) bool {
 	return def
 }
 
-//nolint:unused
 func getStringOrDefault(name string, def string) string {
 	if viper.IsSet(name) {
 		v := viper.GetString(name)
@@ -121,3 +234,23 @@ func getStringOrDefault(name string, def string) string {
 	logging.Log.Printf("could not find '%s' in viper! Returning default", name)
 	return def
 }
+
+func getFloatOrDefault(name string, def float64) float64 {
+	if viper.IsSet(name) {
+		v := viper.GetFloat64(name)
+		logging.Log.Printf("found '%s' in viper", name)
+		return v
+	}
+	logging.Log.Printf("could not find '%s' in viper! Returning default", name)
+	return def
+}
+
+func getDurationOrDefault(name string, def time.Duration) time.Duration {
+	if viper.IsSet(name) {
+		v := viper.GetDuration(name)
+		logging.Log.Printf("found '%s' in viper", name)
+		return v
+	}
+	logging.Log.Printf("could not find '%s' in viper! Returning default", name)
+	return def
+}
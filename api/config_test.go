@@ -0,0 +1,49 @@
+package api
+
+import (
+	"github.com/alex-pricope/simple-voting-system/logging"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestReadConfigAWS(t *testing.T) {
+	logging.Log = logrus.New()
+	t.Cleanup(viper.Reset)
+
+	viper.Set("storage.endpoint", "http://localhost:4566")
+	viper.Set("storage.region", "eu-west-1")
+	viper.Set("storage.profile", "voting")
+	viper.Set("storage.accessKeyId", "local")
+	viper.Set("storage.secretAccessKey", "secret")
+	viper.Set("storage.sessionToken", "session")
+	viper.Set("storage.retryMaxAttempts", 8)
+	viper.Set("storage.retryMaxBackoff", "3s")
+
+	assert.Equal(t, AWSConfig{
+		Endpoint:         "http://localhost:4566",
+		Region:           "eu-west-1",
+		Profile:          "voting",
+		AccessKeyID:      "local",
+		SecretAccessKey:  "secret",
+		SessionToken:     "session",
+		RetryMaxAttempts: 8,
+		RetryMaxBackoff:  3 * time.Second,
+	}, ReadConfig().AWSConfig)
+}
+
+func TestReadConfigDefaults(t *testing.T) {
+	logging.Log = logrus.New()
+	t.Cleanup(viper.Reset)
+	viper.Reset()
+
+	conf := ReadConfig()
+	assert.Equal(t, AWSConfig{RetryMaxAttempts: 5, RetryMaxBackoff: time.Second}, conf.AWSConfig,
+		"empty values fall back to the AWS SDK defaults")
+	assert.Equal(t, time.Minute, conf.CacheTTL)
+
+	viper.Set("storage.cacheTTL", "0")
+	assert.Zero(t, ReadConfig().CacheTTL, "0 disables the cache")
+}
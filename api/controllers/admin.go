@@ -1,6 +1,8 @@
 package controllers
 
 import (
+	"context"
+	"errors"
 	"github.com/alex-pricope/simple-voting-system/api/models"
 	"github.com/alex-pricope/simple-voting-system/api/transport"
 	"github.com/alex-pricope/simple-voting-system/logging"
@@ -8,21 +10,32 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/matoous/go-nanoid/v2"
 	"net/http"
+	"sort"
 	"strconv"
 	"time"
 )
 
+// maxCodeGenerationRounds bounds how often createCode regenerates codes that collided
+const maxCodeGenerationRounds = 10
+
 type AdminController struct {
-	codesStorage storage.VotingCodeStorage
-	teamsStorage storage.TeamStorage
-	votesStorage storage.VoteStorage
+	codesStorage   storage.VotingCodeStorage
+	teamsStorage   storage.TeamStorage
+	votesStorage   storage.VoteStorage
+	talliesStorage storage.TallyStorage // Kept in step with deleted votes, nil when the results are computed from the votes
+	ledgerStorage  storage.LedgerStorage
+	newCode        func() (string, error)
 }
 
-func NewAdminController(codes storage.VotingCodeStorage, teams storage.TeamStorage, votes storage.VoteStorage) *AdminController {
+func NewAdminController(codes storage.VotingCodeStorage, teams storage.TeamStorage, votes storage.VoteStorage, tallies storage.TallyStorage,
+	ledger storage.LedgerStorage) *AdminController {
 	return &AdminController{
-		codesStorage: codes,
-		teamsStorage: teams,
-		votesStorage: votes,
+		codesStorage:   codes,
+		teamsStorage:   teams,
+		votesStorage:   votes,
+		talliesStorage: tallies,
+		ledgerStorage:  ledger,
+		newCode:        generateShortCode,
 	}
 }
 
@@ -34,25 +47,45 @@ func (c *AdminController) RegisterRoutes(engine *gin.Engine) {
 	group.DELETE("/codes/:code", c.deleteCode)
 	group.POST("/codes/reset", c.resetVotes)
 	group.POST("/codes/:code/reset", c.resetCode)
+	group.POST("/codes/:code/invalidate", c.invalidateCode)
+	group.POST("/codes/expiry", c.setCodesExpiry)
+	group.POST("/codes/:code/expiry", c.setCodeExpiry)
 	group.POST("/codes/:code/attach-team/:teamId", c.attachTeam)
 	group.GET("/categories", c.listCategories)
 	group.GET("/codes/:category", c.getCodesByCategory)
+	group.GET("/votes", c.listVotes)
 	group.DELETE("/votes", c.deleteAllVotes)
+	group.POST("/votes/convert-layout", c.convertVoteLayout)
 }
 
 // @Security AdminToken
 // listCodes godoc
 // @Summary List all voting codes
+// @Description Returns every code as a plain array of models.CodeResponse.
+// @Description With limit or next it returns a single page instead, in the envelope documented below: items and the next token, absent on the last page
 // @Tags admin
 // @Produce json
-// @Success 200 {array} models.CodeResponse
+// @Param limit query int false "Page size (1-1000), enables paging"
+// @Param next query string false "Token from the previous page"
+// @Success 200 {object} models.PageResponse[models.CodeResponse] "A page of codes with limit or next, otherwise the items alone as an array"
+// @Failure 400 {object} models.ErrorResponse
 // @Failure 500 {object} models.ErrorResponse
 // @Router /api/admin/codes [get]
 func (c *AdminController) listCodes(g *gin.Context) {
+	page, paged, err := parsePageRequest(g)
+	if err != nil {
+		g.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+	if paged {
+		c.listCodesPage(g, page)
+		return
+	}
+
 	codes, err := c.codesStorage.GetAll(g.Request.Context())
 	if err != nil {
 		logging.Log.Errorf("ADMIN: failed to list codes: %v", err)
-		g.JSON(http.StatusInternalServerError, models.ErrorResponse{Error: err.Error()})
+		g.JSON(storageErrorStatus(err), models.ErrorResponse{Error: err.Error()})
 		return
 	}
 
@@ -64,16 +97,40 @@ func (c *AdminController) listCodes(g *gin.Context) {
 	g.JSON(http.StatusOK, responseCodes)
 }
 
+func (c *AdminController) listCodesPage(g *gin.Context, page pageRequest) {
+	codes, next, err := c.codesStorage.GetPage(g.Request.Context(), page.limit, page.next)
+	if err != nil {
+		if errors.Is(err, storage.ErrInvalidPageToken) {
+			g.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+			return
+		}
+		logging.Log.Errorf("ADMIN: failed to list codes page: %v", err)
+		g.JSON(storageErrorStatus(err), models.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	response := models.PageResponse[*models.CodeResponse]{
+		Items: make([]*models.CodeResponse, 0, len(codes)),
+		Next:  next,
+	}
+	for _, code := range codes {
+		response.Items = append(response.Items, models.TransformVotingCodeToCodeResponse(code))
+	}
+	logging.Log.Infof("ADMIN: listed page of %d codes", len(response.Items))
+	g.JSON(http.StatusOK, response)
+}
+
 // @Security AdminToken
 // createCode godoc
 // @Summary Create one or more voting codes
 // @Tags admin
 // @Accept json
 // @Produce json
+// @Description Codes never expire unless expires_at or ttl is set
 // @Param request body models.CreateCodeRequest true "Create Code Request"
 // @Success 200 {array} models.CodeResponse
 // @Failure 400 {object} models.ErrorResponse
-// @Failure 500 {object} models.ErrorResponse
+// @Failure 500 {object} models.CreateCodesErrorResponse
 // @Router /api/admin/codes [post]
 func (c *AdminController) createCode(g *gin.Context) {
 	var req models.CreateCodeRequest
@@ -88,27 +145,222 @@ func (c *AdminController) createCode(g *gin.Context) {
 		return
 	}
 
-	codes := make([]*storage.VotingCode, 0, req.Count)
-	for i := 0; i < req.Count; i++ {
-		code := &storage.VotingCode{
-			Code:      c.generateShortCode(),
-			Category:  req.Category,
-			CreatedAt: time.Now().UTC(),
-			Used:      false,
+	expiresAt, err := resolveExpiry(req.CodeExpiry, time.Now().UTC(), false)
+	if err != nil {
+		g.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	created, err := createCodes(g.Request.Context(), c.codesStorage, c.newCode, req.Category, req.Count, expiresAt)
+
+	responseCodes := make([]*models.CodeResponse, 0, len(created))
+	for _, code := range created {
+		responseCodes = append(responseCodes, models.TransformVotingCodeToCodeResponse(code))
+	}
+	// Only the count, thousands of codes do not fit in one audit entry
+	auditAfter(g, gin.H{"category": req.Category, "created": len(created)})
+	if err != nil {
+		logging.Log.Errorf("ADMIN: created %d of %d codes: %v", len(created), req.Count, err)
+		g.JSON(storageErrorStatus(err), models.CreateCodesErrorResponse{
+			Error:   err.Error(),
+			Created: responseCodes,
+			Missing: req.Count - len(created),
+		})
+		return
+	}
+	logging.Log.Infof("ADMIN: created %d codes with category %s", len(created), req.Category)
+	g.JSON(http.StatusOK, responseCodes)
+}
+
+// createCodes generates and stores exactly count codes, regenerating the ones that collide with
+// existing codes. On error, the codes stored so far are returned with it.
+func createCodes(ctx context.Context, codesStorage storage.VotingCodeStorage, newCode func() (string, error),
+	category string, count int, expiresAt *time.Time) ([]*storage.VotingCode, error) {
+	created := make([]*storage.VotingCode, 0, count)
+	seen := make(map[string]bool, count)
+
+	for round := 0; len(created) < count; round++ {
+		if round == maxCodeGenerationRounds {
+			return created, errors.New("too many code collisions, try again")
+		}
+
+		needed := count - len(created)
+		batch := make([]*storage.VotingCode, 0, needed)
+		for attempts := 0; len(batch) < needed; attempts++ {
+			// Generated values repeat when few codes are left to draw from
+			if attempts == maxCodeGenerationRounds*needed {
+				return created, errors.New("too many code collisions, try again")
+			}
+			value, err := newCode()
+			if err != nil {
+				return created, err
+			}
+			if seen[value] {
+				continue
+			}
+			seen[value] = true
+			batch = append(batch, &storage.VotingCode{
+				Code:      value,
+				Category:  category,
+				CreatedAt: time.Now().UTC(),
+				Used:      false,
+				ExpiresAt: expiresAt,
+			})
+		}
+
+		stored, err := codesStorage.PutBatch(ctx, batch)
+		created = append(created, stored...)
+		if err != nil {
+			// The codes of the failed write may be stored too, only the returned ones are known to be
+			return created, err
 		}
-		if err := c.codesStorage.Put(g.Request.Context(), code); err == nil {
-			logging.Log.Infof("ADMIN: created code: %s with category %s", code.Code, code.Category)
-			codes = append(codes, code)
-		} else {
-			logging.Log.Errorf("ADMIN: failed to store code: %v", err)
+		if collisions := len(batch) - len(stored); collisions > 0 {
+			logging.Log.Warnf("ADMIN: %d generated codes collided, regenerating", collisions)
 		}
 	}
+	return created, nil
+}
 
-	responseCodes := make([]*models.CodeResponse, 0, len(codes))
+// resolveExpiry turns a CodeExpiry into an absolute time. Without expires_at and ttl it returns nil,
+// or an error when required is set.
+func resolveExpiry(expiry models.CodeExpiry, now time.Time, required bool) (*time.Time, error) {
+	switch {
+	case expiry.ExpiresAt != nil && expiry.TTL != "":
+		return nil, errors.New("set either expires_at or ttl, not both")
+	case expiry.ExpiresAt != nil:
+		if !expiry.ExpiresAt.After(now) {
+			return nil, errors.New("expires_at must be in the future")
+		}
+		expiresAt := expiry.ExpiresAt.UTC()
+		return &expiresAt, nil
+	case expiry.TTL != "":
+		ttl, err := time.ParseDuration(expiry.TTL)
+		if err != nil || ttl <= 0 {
+			return nil, errors.New("ttl must be a positive duration such as 90m or 12h")
+		}
+		expiresAt := now.Add(ttl)
+		return &expiresAt, nil
+	case required:
+		return nil, errors.New("expires_at or ttl is required")
+	default:
+		return nil, nil
+	}
+}
+
+// @Security AdminToken
+// setCodeExpiry godoc
+// @Summary Change when a voting code expires
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param code path string true "Voting code"
+// @Param request body models.CodeExpiry true "New expiry, expires_at or ttl"
+// @Success 200 {object} models.CodeResponse
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /api/admin/codes/{code}/expiry [post]
+func (c *AdminController) setCodeExpiry(g *gin.Context) {
+	code := g.Param("code")
+	var req models.CodeExpiry
+	if err := g.ShouldBindJSON(&req); err != nil {
+		g.JSON(http.StatusBadRequest, models.ErrorResponse{Error: "invalid request format"})
+		return
+	}
+	expiresAt, err := resolveExpiry(req, time.Now().UTC(), true)
+	if err != nil {
+		g.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	votingCode, err := c.codesStorage.Get(g.Request.Context(), code)
+	if err != nil {
+		writeStorageError(g, "code", err)
+		return
+	}
+	auditBefore(g, models.TransformVotingCodeToCodeResponse(votingCode))
+
+	if err := c.codesStorage.SetExpiry(g.Request.Context(), code, expiresAt); err != nil {
+		writeStorageError(g, "code", err)
+		return
+	}
+	votingCode, err = c.codesStorage.Get(g.Request.Context(), code)
+	if err != nil {
+		writeStorageError(g, "code", err)
+		return
+	}
+	logging.Log.Infof("ADMIN: code %s now expires at %s", code, expiresAt.Format(time.RFC3339))
+	response := models.TransformVotingCodeToCodeResponse(votingCode)
+	auditAfter(g, response)
+	g.JSON(http.StatusOK, response)
+}
+
+// @Security AdminToken
+// setCodesExpiry godoc
+// @Summary Change when a batch of voting codes expires
+// @Description Applies to the listed codes, or to every code of a category. Listed codes that do not exist are returned as missing
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param request body models.SetCodeExpiryRequest true "Codes or category, and the new expiry"
+// @Success 200 {object} models.SetCodeExpiryResponse
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /api/admin/codes/expiry [post]
+func (c *AdminController) setCodesExpiry(g *gin.Context) {
+	var req models.SetCodeExpiryRequest
+	if err := g.ShouldBindJSON(&req); err != nil {
+		g.JSON(http.StatusBadRequest, models.ErrorResponse{Error: "invalid request format"})
+		return
+	}
+	if (len(req.Codes) == 0) == (req.Category == "") {
+		g.JSON(http.StatusBadRequest, models.ErrorResponse{Error: "set either codes or category"})
+		return
+	}
+	if _, ok := models.ValidCategories[models.VotingCategory(req.Category)]; req.Category != "" && !ok {
+		g.JSON(http.StatusBadRequest, models.ErrorResponse{Error: "invalid category"})
+		return
+	}
+	expiresAt, err := resolveExpiry(req.CodeExpiry, time.Now().UTC(), true)
+	if err != nil {
+		g.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	codes := req.Codes
+	if req.Category != "" {
+		all, err := c.codesStorage.GetAll(g.Request.Context())
+		if err != nil {
+			logging.Log.Errorf("ADMIN: failed to list codes: %v", err)
+			g.JSON(storageErrorStatus(err), models.ErrorResponse{Error: err.Error()})
+			return
+		}
+		for _, vc := range all {
+			if vc.Category == req.Category {
+				codes = append(codes, vc.Code)
+			}
+		}
+	}
+
+	response := models.SetCodeExpiryResponse{ExpiresAt: *expiresAt}
+	var updated []string
+	defer func() { auditAfter(g, gin.H{"category": req.Category, "codes": len(updated), "expiresAt": expiresAt}) }()
 	for _, code := range codes {
-		responseCodes = append(responseCodes, models.TransformVotingCodeToCodeResponse(code))
+		err := c.codesStorage.SetExpiry(g.Request.Context(), code, expiresAt)
+		switch {
+		case errors.Is(err, storage.ErrNotFound):
+			response.Missing = append(response.Missing, code)
+		case err != nil:
+			logging.Log.Errorf("ADMIN: failed to set expiry of code %s after %d codes: %v", code, response.Updated, err)
+			g.JSON(storageErrorStatus(err), models.ErrorResponse{Error: err.Error()})
+			return
+		default:
+			response.Updated++
+			updated = append(updated, code)
+		}
 	}
-	g.JSON(http.StatusOK, responseCodes)
+	logging.Log.Infof("ADMIN: %d codes now expire at %s", response.Updated, expiresAt.Format(time.RFC3339))
+	g.JSON(http.StatusOK, response)
 }
 
 // @Security AdminToken
@@ -127,11 +379,22 @@ func (c *AdminController) deleteCode(g *gin.Context) {
 		g.JSON(http.StatusBadRequest, models.ErrorResponse{Error: "missing code"})
 		return
 	}
+	unlock, err := lockCode(g, c.ledgerStorage, code)
+	if err != nil {
+		g.JSON(storageErrorStatus(err), models.ErrorResponse{Error: err.Error()})
+		return
+	}
+	defer unlock()
+	// A missing code is left to Delete, which decides how to answer
+	if votingCode, err := c.codesStorage.Get(g.Request.Context(), code); err == nil {
+		auditBefore(g, models.TransformVotingCodeToCodeResponse(votingCode))
+	}
 	if err := c.codesStorage.Delete(g.Request.Context(), code); err != nil {
 		logging.Log.Errorf("ADMIN: failed to delete code %s: %v", code, err)
-		g.JSON(http.StatusInternalServerError, models.ErrorResponse{Error: err.Error()})
+		g.JSON(storageErrorStatus(err), models.ErrorResponse{Error: err.Error()})
 		return
 	}
+	recordLedger(g, c.ledgerStorage, &storage.LedgerEvent{Type: storage.LedgerCodeDeleted, Code: code})
 	logging.Log.Infof("ADMIN: deleted code: %s", code)
 	g.JSON(http.StatusOK, gin.H{"deleted": code})
 }
@@ -148,24 +411,47 @@ func (c *AdminController) resetVotes(g *gin.Context) {
 	codes, err := c.codesStorage.GetAll(g.Request.Context())
 	if err != nil {
 		logging.Log.Errorf("ADMIN: failed to get codes for reset: %v", err)
-		g.JSON(http.StatusInternalServerError, models.ErrorResponse{Error: err.Error()})
+		g.JSON(storageErrorStatus(err), models.ErrorResponse{Error: err.Error()})
 		return
 	}
 
 	updated := 0
+	used, stillUsed := []string{}, []string{}
 	for _, voteCode := range codes {
-		voteCode.Used = false
-		if err := c.codesStorage.MarkUnused(g.Request.Context(), voteCode.Code); err != nil {
-			logging.Log.Errorf("ADMIN: failed to reset code %s: %v", voteCode.Code, err)
-		} else {
-			updated++
+		wasUsed := voteCode.Used
+		if wasUsed {
+			used = append(used, voteCode.Code)
+		}
+		if !c.resetLocked(g, voteCode.Code, wasUsed) {
+			stillUsed = append(stillUsed, voteCode.Code)
+			continue
 		}
+		updated++
 	}
+	auditBefore(g, gin.H{"usedCodes": used})
+	auditAfter(g, gin.H{"usedCodes": stillUsed})
 
 	logging.Log.Infof("ADMIN: reset %d codes", updated)
 	g.JSON(http.StatusOK, gin.H{"message": "All codes reset"})
 }
 
+// resetLocked resets one code of resetVotes under the lock of the code, and reports whether it was reset
+func (c *AdminController) resetLocked(g *gin.Context, code string, wasUsed bool) bool {
+	unlock, err := lockCode(g, c.ledgerStorage, code)
+	if err != nil {
+		return false
+	}
+	defer unlock()
+	if err := c.codesStorage.MarkUnused(g.Request.Context(), code); err != nil {
+		logging.Log.Errorf("ADMIN: failed to reset code %s: %v", code, err)
+		return false
+	}
+	if wasUsed {
+		recordLedger(g, c.ledgerStorage, &storage.LedgerEvent{Type: storage.LedgerCodeReset, Code: code})
+	}
+	return true
+}
+
 // @Security AdminToken
 // resetCode godoc
 // @Summary Reset a specific voting code to unused
@@ -183,25 +469,107 @@ func (c *AdminController) resetCode(g *gin.Context) {
 		g.JSON(http.StatusBadRequest, models.ErrorResponse{Error: "missing code"})
 		return
 	}
+	unlock, err := lockCode(g, c.ledgerStorage, code)
+	if err != nil {
+		g.JSON(storageErrorStatus(err), models.ErrorResponse{Error: err.Error()})
+		return
+	}
+	defer unlock()
 
 	voteCode, err := c.codesStorage.Get(g.Request.Context(), code)
 	if err != nil {
-		logging.Log.Errorf("ADMIN: failed to retrieve code %s for reset: %v", code, err)
-		g.JSON(http.StatusNotFound, models.ErrorResponse{Error: "code not found"})
+		writeStorageError(g, "code", err)
 		return
 	}
 
+	auditBefore(g, models.TransformVotingCodeToCodeResponse(voteCode))
+	wasUsed := voteCode.Used
 	voteCode.Used = false
 	if err := c.codesStorage.MarkUnused(g.Request.Context(), voteCode.Code); err != nil {
 		logging.Log.Errorf("ADMIN: failed to reset code %s: %v", code, err)
-		g.JSON(http.StatusInternalServerError, models.ErrorResponse{Error: "failed to reset code"})
+		g.JSON(storageErrorStatus(err), models.ErrorResponse{Error: "failed to reset code"})
 		return
 	}
+	auditAfter(g, models.TransformVotingCodeToCodeResponse(voteCode))
+	if wasUsed {
+		recordLedger(g, c.ledgerStorage, &storage.LedgerEvent{Type: storage.LedgerCodeReset, Code: code})
+	}
 
 	logging.Log.Infof("ADMIN: reset code: %s", code)
 	g.JSON(http.StatusOK, gin.H{"reset": code})
 }
 
+// @Security AdminToken
+// invalidateCode godoc
+// @Summary Invalidate the ballot of a voting code
+// @Description Deletes every vote of the code and records why in the vote ledger. The code stays used, reset it to let it vote again.
+// @Description With storage.results set to tallies, the votes are subtracted from them
+// @Tags admin
+// @Produce json
+// @Param code path string true "Voting code"
+// @Param reason query string false "Why the ballot does not count, kept in the ledger"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse "The code has no votes"
+// @Failure 500 {object} models.ErrorResponse
+// @Router /api/admin/codes/{code}/invalidate [post]
+func (c *AdminController) invalidateCode(g *gin.Context) {
+	code := g.Param("code")
+	if code == "" {
+		g.JSON(http.StatusBadRequest, models.ErrorResponse{Error: "missing code"})
+		return
+	}
+	unlock, err := lockCode(g, c.ledgerStorage, code)
+	if err != nil {
+		g.JSON(storageErrorStatus(err), models.ErrorResponse{Error: err.Error()})
+		return
+	}
+	defer unlock()
+
+	votes, err := c.votesStorage.GetByCode(g.Request.Context(), code)
+	if err != nil {
+		logging.Log.Errorf("ADMIN: failed to get votes of code %s: %v", code, err)
+		g.JSON(storageErrorStatus(err), models.ErrorResponse{Error: "could not retrieve votes"})
+		return
+	}
+	if len(votes) == 0 {
+		g.JSON(http.StatusNotFound, models.ErrorResponse{Error: "no votes found for the given code"})
+		return
+	}
+
+	auditBefore(g, gin.H{"code": code, "votes": len(votes)})
+	if err := c.votesStorage.Delete(g.Request.Context(), votes); err != nil {
+		logging.Log.Errorf("ADMIN: failed to invalidate the votes of code %s: %v", code, err)
+		g.JSON(storageErrorStatus(err), models.ErrorResponse{Error: "could not delete votes"})
+		return
+	}
+	auditAfter(g, gin.H{"code": code, "votes": 0})
+	c.subtractTallies(g.Request.Context(), code, votes)
+	recordLedger(g, c.ledgerStorage, &storage.LedgerEvent{
+		Type: storage.LedgerVotesInvalidated,
+		Code: code,
+		Note: g.Query("reason"),
+	})
+
+	logging.Log.Infof("ADMIN: invalidated %d votes of code %s", len(votes), code)
+	g.JSON(http.StatusOK, gin.H{"invalidated": code, "votes": len(votes)})
+}
+
+// subtractTallies takes the deleted ballot of code out of the tallies
+func (c *AdminController) subtractTallies(ctx context.Context, code string, votes []*storage.Vote) {
+	if c.talliesStorage == nil {
+		return
+	}
+	groups := make(map[string]string, 1)
+	if votingCode, err := c.codesStorage.Get(ctx, code); err == nil {
+		groups[code] = votingCode.Category
+	} else if !errors.Is(err, storage.ErrNotFound) {
+		logging.Log.Errorf("ADMIN: failed to get code %s for the tallies, rebuild them with POST /api/admin/tallies/rebuild: %v", code, err)
+		return
+	}
+	subtractTallies(ctx, c.talliesStorage, groups, votes, []string{code})
+}
+
 // @Security AdminToken
 // attachTeam godoc
 // @Summary Attach a team to a voting code
@@ -228,26 +596,26 @@ func (c *AdminController) attachTeam(g *gin.Context) {
 		return
 	}
 
-	team, err := c.teamsStorage.Get(g.Request.Context(), teamID)
-	if err != nil || team == nil {
-		logging.Log.Errorf("ADMIN: team %d not found or lookup failed: %v", teamID, err)
-		g.JSON(http.StatusNotFound, models.ErrorResponse{Error: "team not found"})
+	_, err = c.teamsStorage.Get(g.Request.Context(), teamID)
+	if err != nil {
+		writeStorageError(g, "team", err)
 		return
 	}
 
 	voteCode, err := c.codesStorage.Get(g.Request.Context(), code)
 	if err != nil {
-		logging.Log.Errorf("ADMIN: failed to get code %s: %v", code, err)
-		g.JSON(http.StatusNotFound, models.ErrorResponse{Error: "code not found"})
+		writeStorageError(g, "code", err)
 		return
 	}
 
+	auditBefore(g, models.TransformVotingCodeToCodeResponse(voteCode))
 	voteCode.TeamID = &teamID
 	if err := c.codesStorage.Overwrite(g.Request.Context(), voteCode); err != nil {
 		logging.Log.Errorf("ADMIN: failed to update code %s with team ID: %v", code, err)
-		g.JSON(http.StatusInternalServerError, models.ErrorResponse{Error: "could not update team ID"})
+		g.JSON(storageErrorStatus(err), models.ErrorResponse{Error: "could not update team ID"})
 		return
 	}
+	auditAfter(g, models.TransformVotingCodeToCodeResponse(voteCode))
 
 	logging.Log.Infof("ADMIN: attached team %d to code %s", teamID, code)
 	g.JSON(http.StatusOK, gin.H{"message": "team attached", "code": code, "teamId": teamID})
@@ -293,7 +661,7 @@ func (c *AdminController) getCodesByCategory(g *gin.Context) {
 	codes, err := c.codesStorage.GetAll(g.Request.Context())
 	if err != nil {
 		logging.Log.Errorf("ADMIN: failed to get codes: %v", err)
-		g.JSON(http.StatusInternalServerError, models.ErrorResponse{Error: err.Error()})
+		g.JSON(storageErrorStatus(err), models.ErrorResponse{Error: err.Error()})
 		return
 	}
 
@@ -313,29 +681,161 @@ func (c *AdminController) getCodesByCategory(g *gin.Context) {
 	g.JSON(http.StatusOK, responseCodes)
 }
 
-func (c *AdminController) generateShortCode() string {
+func generateShortCode() (string, error) {
 	code, err := gonanoid.Generate(models.Alphabet, 5)
 	if err != nil {
 		logging.Log.Errorf("ADMIN: failed to generate code: %v", err)
-		return "ERROR"
+		return "", err
+	}
+	return code, nil
+}
+
+// @Security AdminToken
+// listVotes godoc
+// @Summary List the ratings for a team or category
+// @Description Returns the matching ratings with the voter group of their code, not the code. teamId or categoryId is required
+// @Tags admin
+// @Produce json
+// @Param teamId query int false "Only ratings for this team"
+// @Param categoryId query int false "Only ratings in this category"
+// @Param group query string false "Only ratings from this voter group"
+// @Success 200 {array} models.AdminVoteResponse
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /api/admin/votes [get]
+func (c *AdminController) listVotes(g *gin.Context) {
+	teamID, hasTeam, err := intQuery(g, "teamId")
+	if err != nil {
+		g.JSON(http.StatusBadRequest, models.ErrorResponse{Error: "invalid teamId"})
+		return
+	}
+	categoryID, hasCategory, err := intQuery(g, "categoryId")
+	if err != nil {
+		g.JSON(http.StatusBadRequest, models.ErrorResponse{Error: "invalid categoryId"})
+		return
+	}
+	if !hasTeam && !hasCategory {
+		g.JSON(http.StatusBadRequest, models.ErrorResponse{Error: "teamId or categoryId is required"})
+		return
+	}
+	group := g.Query("group")
+	if _, ok := models.ValidCategories[models.VotingCategory(group)]; group != "" && !ok {
+		g.JSON(http.StatusBadRequest, models.ErrorResponse{Error: "invalid group"})
+		return
+	}
+
+	var votes []*storage.Vote
+	if hasTeam {
+		votes, err = c.votesStorage.GetByTeam(g.Request.Context(), teamID)
+	} else {
+		votes, err = c.votesStorage.GetByCategory(g.Request.Context(), categoryID)
+	}
+	if err != nil {
+		logging.Log.Errorf("ADMIN: failed to query votes: %v", err)
+		g.JSON(storageErrorStatus(err), models.ErrorResponse{Error: "could not list votes"})
+		return
+	}
+
+	codes, err := c.codesStorage.GetAll(g.Request.Context())
+	if err != nil {
+		logging.Log.Errorf("ADMIN: failed to list codes: %v", err)
+		g.JSON(storageErrorStatus(err), models.ErrorResponse{Error: "could not list votes"})
+		return
+	}
+	groups := make(map[string]string, len(codes))
+	for _, code := range codes {
+		groups[code.Code] = code.Category
 	}
-	return code
+
+	response := make([]*models.AdminVoteResponse, 0, len(votes))
+	for _, v := range votes {
+		if hasCategory && v.CategoryID != categoryID {
+			continue
+		}
+		if group != "" && groups[v.Code] != group {
+			continue
+		}
+		response = append(response, &models.AdminVoteResponse{
+			CategoryID: v.CategoryID,
+			TeamID:     v.TeamID,
+			Rating:     v.Rating,
+			VoterGroup: groups[v.Code],
+			Timestamp:  v.Timestamp,
+		})
+	}
+	// Ordered by time, so the listing does not follow the order of the codes
+	sort.SliceStable(response, func(i, j int) bool {
+		return response[i].Timestamp.Before(response[j].Timestamp)
+	})
+
+	logging.Log.Infof("ADMIN: listed %d votes", len(response))
+	g.JSON(http.StatusOK, response)
+}
+
+// intQuery reads an optional integer query parameter
+func intQuery(g *gin.Context, name string) (int, bool, error) {
+	value, ok := g.GetQuery(name)
+	if !ok {
+		return 0, false, nil
+	}
+	n, err := strconv.Atoi(value)
+	return n, true, err
 }
 
 // @Security AdminToken
 // deleteAllVotes godoc
 // @Summary Delete all votes
+// @Description With storage.results set to tallies, they are reset too
 // @Tags admin
 // @Produce json
 // @Success 200 {object} map[string]string
 // @Failure 500 {object} models.ErrorResponse
 // @Router /api/admin/votes [delete]
 func (c *AdminController) deleteAllVotes(g *gin.Context) {
+	// The votes themselves are kept out of the audit log, it would get as big as the table
+	if votes, err := c.votesStorage.GetAll(g.Request.Context()); err == nil {
+		auditBefore(g, gin.H{"votes": len(votes)})
+	}
 	if err := c.votesStorage.DeleteAll(g.Request.Context()); err != nil {
 		logging.Log.Errorf("ADMIN: failed to delete all votes: %v", err)
-		g.JSON(http.StatusInternalServerError, models.ErrorResponse{Error: "could not delete all votes"})
+		g.JSON(storageErrorStatus(err), models.ErrorResponse{Error: "could not delete all votes"})
 		return
 	}
+	if c.talliesStorage != nil {
+		if err := c.talliesStorage.Replace(g.Request.Context(), &storage.Tallies{}); err != nil {
+			logging.Log.Errorf("ADMIN: failed to reset the tallies, rebuild them with POST /api/admin/tallies/rebuild: %v", err)
+		}
+	}
+	recordLedger(g, c.ledgerStorage, &storage.LedgerEvent{Type: storage.LedgerAllVotesDeleted})
 	logging.Log.Infof("ADMIN: deleted all votes")
+	auditAfter(g, gin.H{"votes": 0})
 	g.JSON(http.StatusOK, gin.H{"message": "all votes deleted"})
 }
+
+// @Security AdminToken
+// convertVoteLayout godoc
+// @Summary Convert votes to the ballot layout
+// @Description Moves votes stored one item per rating into one ballot item per code. Rows not converted yet are still read with the ballots. Only available with storage.voteLayout set to ballot
+// @Tags admin
+// @Produce json
+// @Success 200 {object} map[string]int
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /api/admin/votes/convert-layout [post]
+func (c *AdminController) convertVoteLayout(g *gin.Context) {
+	converter, ok := c.votesStorage.(storage.VoteLayoutConverter)
+	if !ok {
+		g.JSON(http.StatusBadRequest, models.ErrorResponse{Error: "vote storage does not support layout conversion"})
+		return
+	}
+
+	converted, err := converter.ConvertRatingRows(g.Request.Context())
+	if err != nil {
+		logging.Log.Errorf("ADMIN: vote layout conversion failed after %d ballots: %v", converted, err)
+		g.JSON(storageErrorStatus(err), models.ErrorResponse{Error: "could not convert votes"})
+		return
+	}
+	logging.Log.Infof("ADMIN: converted votes of %d codes to ballots", converted)
+	auditAfter(g, gin.H{"converted": converted})
+	g.JSON(http.StatusOK, gin.H{"converted": converted})
+}
@@ -8,10 +8,7 @@ import (
 	"github.com/alex-pricope/simple-voting-system/api/models"
 	"github.com/alex-pricope/simple-voting-system/logging"
 	"github.com/alex-pricope/simple-voting-system/storage"
-	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/config"
-	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
-	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/alex-pricope/simple-voting-system/storage/memory"
 	"github.com/gin-gonic/gin"
 	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
@@ -21,46 +18,15 @@ import (
 	"time"
 )
 
-//nolint:staticcheck
 func setupTestAdminController(t *testing.T) (*AdminController, *gin.Engine) {
 	t.Helper()
 	logging.Log = logrus.New()
 
-	// Load localstack config
-	cfg, err := config.LoadDefaultConfig(context.TODO(),
-		config.WithRegion("us-east-1"),
-		config.WithEndpointResolverWithOptions(
-			aws.EndpointResolverWithOptionsFunc(func(service, region string, options ...interface{}) (aws.Endpoint, error) {
-				return aws.Endpoint{URL: "http://localhost:4566", HostnameImmutable: true}, nil
-			}),
-		),
-	)
-	require.NoError(t, err, "failed to load AWS config")
-
-	db := dynamodb.NewFromConfig(cfg)
-	v := &storage.DynamoVotingCodesStorage{
-		Client:    db,
-		TableName: "VotingCodes",
-	}
-
-	s := &storage.DynamoTeamStorage{
-		Client:    db,
-		TableName: "VotingTeams",
-	}
-
-	vv := &storage.DynamoVoteStorage{
-		Client:    db,
-		TableName: "Votes",
-	}
-
-	// teardown
-	t.Cleanup(func() {
-		cleanupTable(t, db, "VotingCodes")
-		cleanupTable(t, db, "VotingTeams")
-		cleanupTableVotes(t, db)
-	})
+	v := memory.NewVotingCodesStorage()
+	s := memory.NewTeamStorage()
+	vv := memory.NewVoteStorage()
 
-	controller := NewAdminController(v, s, vv)
+	controller := NewAdminController(v, s, vv, nil, nil)
 	gin.SetMode(gin.TestMode)
 	r := gin.New()
 	r.POST("/api/admin/codes", controller.createCode)
@@ -69,7 +35,10 @@ func setupTestAdminController(t *testing.T) (*AdminController, *gin.Engine) {
 	r.DELETE("/api/admin/codes/:code", controller.deleteCode)
 	r.POST("api/admin/codes/:code/attach-team/:teamId", controller.attachTeam)
 	r.POST("/api/admin/codes/:code/reset", controller.resetCode)
+	r.POST("/api/admin/codes/expiry", controller.setCodesExpiry)
+	r.POST("/api/admin/codes/:code/expiry", controller.setCodeExpiry)
 	r.POST("api/admin/votes/delete-all", controller.deleteAllVotes)
+	r.GET("/api/admin/votes", controller.listVotes)
 
 	return controller, r
 }
@@ -120,26 +89,6 @@ func TestGetCodesByCategory(t *testing.T) {
 	})
 }
 
-func cleanupTable(t *testing.T, client *dynamodb.Client, tableName string) {
-	t.Helper()
-
-	out, err := client.Scan(context.TODO(), &dynamodb.ScanInput{
-		TableName: aws.String(tableName),
-	})
-	require.NoError(t, err, "cleanup failed to scan %s", tableName)
-
-	for _, item := range out.Items {
-		key := map[string]types.AttributeValue{
-			"PK": item["PK"],
-		}
-		_, err := client.DeleteItem(context.TODO(), &dynamodb.DeleteItemInput{
-			TableName: aws.String(tableName),
-			Key:       key,
-		})
-		require.NoError(t, err, "cleanup failed to delete item")
-	}
-}
-
 func TestDeleteVotingCodes(t *testing.T) {
 	_, router := setupTestAdminController(t)
 
@@ -243,6 +192,57 @@ func TestListVotingCodes(t *testing.T) {
 	}
 }
 
+func TestListVotingCodesPaged(t *testing.T) {
+	_, router := setupTestAdminController(t)
+
+	payload := models.CreateCodeRequest{
+		Count:    7,
+		Category: "general_public",
+	}
+	postRes := testutils.PerformRequest(router, http.MethodPost, "/api/admin/codes", payload, map[string]string{
+		"x-admin-token": "secret",
+	})
+	require.Equal(t, http.StatusOK, postRes.Code)
+
+	// Act - walk the pages
+	seen := make(map[string]bool)
+	pages := 0
+	next := ""
+	for {
+		path := "/api/admin/codes?limit=3"
+		if next != "" {
+			path += "&next=" + next
+		}
+		getRes := testutils.PerformRequest(router, http.MethodGet, path, nil, map[string]string{
+			"x-admin-token": "secret",
+		})
+		require.Equal(t, http.StatusOK, getRes.Code)
+
+		var page models.PageResponse[*models.CodeResponse]
+		require.NoError(t, json.Unmarshal(getRes.Body.Bytes(), &page))
+		assert.LessOrEqual(t, len(page.Items), 3)
+		for _, c := range page.Items {
+			assert.False(t, seen[c.Code], "code %s returned twice", c.Code)
+			seen[c.Code] = true
+		}
+
+		pages++
+		next = page.Next
+		if next == "" || pages > 5 {
+			break
+		}
+	}
+
+	// Assert - every code exactly once, over 3 pages
+	assert.Len(t, seen, 7)
+	assert.Equal(t, 3, pages)
+
+	badRes := testutils.PerformRequest(router, http.MethodGet, "/api/admin/codes?limit=abc", nil, map[string]string{
+		"x-admin-token": "secret",
+	})
+	assert.Equal(t, http.StatusBadRequest, badRes.Code)
+}
+
 func TestCreateVotingCodes(t *testing.T) {
 	_, router := setupTestAdminController(t)
 
@@ -304,7 +304,7 @@ func TestCreateVotingCodes(t *testing.T) {
 
 func TestGetCategories(t *testing.T) {
 	logging.Log = logrus.New()
-	controller := NewAdminController(nil, nil, nil)
+	controller := NewAdminController(nil, nil, nil, nil, nil)
 	gin.SetMode(gin.TestMode)
 	r := gin.New()
 	r.GET("/api/admin/categories", controller.listCategories)
@@ -326,9 +326,8 @@ func TestGetCategories(t *testing.T) {
 	}
 }
 
-//nolint:staticcheck
 func TestResetVotingCode(t *testing.T) {
-	_, router := setupTestAdminController(t)
+	controller, router := setupTestAdminController(t)
 
 	// Create a code
 	payload := models.CreateCodeRequest{
@@ -350,13 +349,7 @@ func TestResetVotingCode(t *testing.T) {
 
 	// Manually mark the code as used
 	created[0].Used = true
-	client := storage.DynamoVotingCodesStorage{
-		Client: dynamodb.NewFromConfig(aws.Config{Region: "us-east-1", EndpointResolverWithOptions: aws.EndpointResolverWithOptionsFunc(func(service, region string, options ...interface{}) (aws.Endpoint, error) {
-			return aws.Endpoint{URL: "http://localhost:4566", HostnameImmutable: true}, nil
-		})}),
-		TableName: "VotingCodes",
-	}
-	err = client.MarkUsed(context.TODO(), created[0].Code)
+	err = controller.codesStorage.MarkUsed(context.TODO(), created[0].Code)
 	require.NoError(t, err)
 
 	// Reset the code via the API
@@ -523,3 +516,255 @@ func TestDeleteAllVotes(t *testing.T) {
 	require.NoError(t, err)
 	require.Len(t, votesAfter, 0)
 }
+
+func TestCreateCodesRetriesCollisions(t *testing.T) {
+	controller, router := setupTestAdminController(t)
+	headers := map[string]string{"x-admin-token": "secret"}
+
+	require.NoError(t, controller.codesStorage.Put(context.Background(), &storage.VotingCode{Code: "AAAAA", Category: "other_team"}))
+
+	// The generator repeats itself and hits the existing code before producing fresh values
+	values := []string{"AAAAA", "BBBBB", "BBBBB", "AAAAA", "CCCCC", "DDDDD"}
+	controller.newCode = func() (string, error) {
+		v := values[0]
+		values = values[1:]
+		return v, nil
+	}
+
+	res := testutils.PerformRequest(router, http.MethodPost, "/api/admin/codes", models.CreateCodeRequest{Count: 3, Category: "other_team"}, headers)
+	require.Equal(t, http.StatusOK, res.Code)
+
+	var created []*models.CodeResponse
+	require.NoError(t, json.Unmarshal(res.Body.Bytes(), &created))
+	var got []string
+	for _, c := range created {
+		got = append(got, c.Code)
+	}
+	assert.ElementsMatch(t, []string{"BBBBB", "CCCCC", "DDDDD"}, got)
+
+	all, err := controller.codesStorage.GetAll(context.Background())
+	require.NoError(t, err)
+	assert.Len(t, all, 4)
+}
+
+func TestCreateCodesReportsFailures(t *testing.T) {
+	controller, router := setupTestAdminController(t)
+	headers := map[string]string{"x-admin-token": "secret"}
+
+	calls := 0
+	controller.newCode = func() (string, error) {
+		calls++
+		if calls > 2 {
+			return "", fmt.Errorf("no entropy")
+		}
+		return fmt.Sprintf("CODE%d", calls), nil
+	}
+
+	res := testutils.PerformRequest(router, http.MethodPost, "/api/admin/codes", models.CreateCodeRequest{Count: 5, Category: "other_team"}, headers)
+	require.Equal(t, http.StatusInternalServerError, res.Code)
+
+	var body models.CreateCodesErrorResponse
+	require.NoError(t, json.Unmarshal(res.Body.Bytes(), &body))
+	assert.Empty(t, body.Created, "nothing is stored when generation fails before the batch is written")
+	assert.Equal(t, 5, body.Missing)
+
+	all, err := controller.codesStorage.GetAll(context.Background())
+	require.NoError(t, err)
+	for _, c := range all {
+		assert.NotEqual(t, "ERROR", c.Code)
+	}
+}
+
+func TestCreateCodesStopsOnRepeatedValues(t *testing.T) {
+	controller, router := setupTestAdminController(t)
+	headers := map[string]string{"x-admin-token": "secret"}
+
+	// Only one value is left to draw from
+	controller.newCode = func() (string, error) { return "AAAAA", nil }
+
+	res := testutils.PerformRequest(router, http.MethodPost, "/api/admin/codes", models.CreateCodeRequest{Count: 2, Category: "other_team"}, headers)
+	require.Equal(t, http.StatusInternalServerError, res.Code)
+
+	var body models.CreateCodesErrorResponse
+	require.NoError(t, json.Unmarshal(res.Body.Bytes(), &body))
+	assert.Empty(t, body.Created)
+	assert.Equal(t, 2, body.Missing)
+}
+
+// partialCodeStorage stores only the first code of a batch, then fails
+type partialCodeStorage struct {
+	storage.VotingCodeStorage
+}
+
+func (s *partialCodeStorage) PutBatch(ctx context.Context, codes []*storage.VotingCode) ([]*storage.VotingCode, error) {
+	stored, err := s.VotingCodeStorage.PutBatch(ctx, codes[:1])
+	if err != nil {
+		return stored, err
+	}
+	return stored, storage.ErrThrottled
+}
+
+func TestCreateCodesReportsPartialWrites(t *testing.T) {
+	controller, router := setupTestAdminController(t)
+	headers := map[string]string{"x-admin-token": "secret"}
+	controller.codesStorage = &partialCodeStorage{VotingCodeStorage: controller.codesStorage}
+
+	res := testutils.PerformRequest(router, http.MethodPost, "/api/admin/codes", models.CreateCodeRequest{Count: 3, Category: "other_team"}, headers)
+	require.Equal(t, http.StatusTooManyRequests, res.Code)
+
+	var body models.CreateCodesErrorResponse
+	require.NoError(t, json.Unmarshal(res.Body.Bytes(), &body))
+	require.Len(t, body.Created, 1)
+	assert.Equal(t, 2, body.Missing)
+
+	all, err := controller.codesStorage.GetAll(context.Background())
+	require.NoError(t, err)
+	require.Len(t, all, 1)
+	assert.Equal(t, body.Created[0].Code, all[0].Code)
+}
+
+func TestListVotes(t *testing.T) {
+	controller, router := setupTestAdminController(t)
+	headers := map[string]string{"x-admin-token": "secret"}
+	ctx := context.TODO()
+	start := time.Date(2025, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	require.NoError(t, controller.codesStorage.Put(ctx, &storage.VotingCode{Code: "JURY1", Category: "grand_jury"}))
+	require.NoError(t, controller.codesStorage.Put(ctx, &storage.VotingCode{Code: "PUBL1", Category: "general_public"}))
+	for _, v := range []*storage.Vote{
+		{Code: "PUBL1", SortKey: "cat#1#team#1", CategoryID: 1, TeamID: 1, Rating: 2, Timestamp: start},
+		{Code: "PUBL1", SortKey: "cat#2#team#1", CategoryID: 2, TeamID: 1, Rating: 3, Timestamp: start},
+		{Code: "JURY1", SortKey: "cat#1#team#1", CategoryID: 1, TeamID: 1, Rating: 5, Timestamp: start.Add(-time.Minute)},
+		{Code: "JURY1", SortKey: "cat#1#team#2", CategoryID: 1, TeamID: 2, Rating: 4, Timestamp: start.Add(-time.Minute)},
+	} {
+		require.NoError(t, controller.votesStorage.Create(ctx, v))
+	}
+
+	list := func(query string) []models.AdminVoteResponse {
+		res := testutils.PerformRequest(router, http.MethodGet, "/api/admin/votes?"+query, nil, headers)
+		require.Equal(t, http.StatusOK, res.Code, res.Body.String())
+		assert.NotContains(t, res.Body.String(), "JURY1", "codes must not be returned")
+		var votes []models.AdminVoteResponse
+		require.NoError(t, json.Unmarshal(res.Body.Bytes(), &votes))
+		return votes
+	}
+
+	t.Run("By team, oldest first", func(t *testing.T) {
+		votes := list("teamId=1")
+		require.Len(t, votes, 3)
+		assert.Equal(t, "grand_jury", votes[0].VoterGroup)
+		assert.Equal(t, 5, votes[0].Rating)
+		assert.Equal(t, "general_public", votes[1].VoterGroup)
+	})
+
+	t.Run("By team and category", func(t *testing.T) {
+		votes := list("teamId=1&categoryId=2")
+		require.Len(t, votes, 1)
+		assert.Equal(t, 3, votes[0].Rating)
+	})
+
+	t.Run("By category and group", func(t *testing.T) {
+		votes := list("categoryId=1&group=grand_jury")
+		require.Len(t, votes, 2)
+		for _, v := range votes {
+			assert.Equal(t, "grand_jury", v.VoterGroup)
+			assert.Equal(t, 1, v.CategoryID)
+		}
+	})
+
+	t.Run("Invalid filters", func(t *testing.T) {
+		for _, query := range []string{"", "group=grand_jury", "teamId=abc", "categoryId=1&group=nope"} {
+			res := testutils.PerformRequest(router, http.MethodGet, "/api/admin/votes?"+query, nil, headers)
+			assert.Equal(t, http.StatusBadRequest, res.Code, query)
+		}
+	})
+}
+
+func TestResolveExpiry(t *testing.T) {
+	now := time.Date(2025, 5, 1, 12, 0, 0, 0, time.UTC)
+	later := now.Add(2 * time.Hour)
+
+	expiresAt, err := resolveExpiry(models.CodeExpiry{}, now, false)
+	require.NoError(t, err)
+	assert.Nil(t, expiresAt)
+
+	expiresAt, err = resolveExpiry(models.CodeExpiry{TTL: "90m"}, now, false)
+	require.NoError(t, err)
+	assert.Equal(t, now.Add(90*time.Minute), *expiresAt)
+
+	expiresAt, err = resolveExpiry(models.CodeExpiry{ExpiresAt: &later}, now, true)
+	require.NoError(t, err)
+	assert.Equal(t, later, *expiresAt)
+
+	for _, expiry := range []models.CodeExpiry{
+		{},
+		{TTL: "soon"},
+		{TTL: "-1h"},
+		{ExpiresAt: &now},
+		{ExpiresAt: &later, TTL: "1h"},
+	} {
+		_, err := resolveExpiry(expiry, now, true)
+		assert.Error(t, err, "%+v", expiry)
+	}
+}
+
+func TestSetCodeExpiry(t *testing.T) {
+	controller, router := setupTestAdminController(t)
+	headers := map[string]string{"x-admin-token": "secret"}
+	ctx := context.TODO()
+
+	for _, vc := range []*storage.VotingCode{
+		{Code: "PUBL1", Category: "general_public"},
+		{Code: "PUBL2", Category: "general_public"},
+		{Code: "JURY1", Category: "grand_jury"},
+	} {
+		require.NoError(t, controller.codesStorage.Put(ctx, vc))
+	}
+
+	t.Run("Single code", func(t *testing.T) {
+		res := testutils.PerformRequest(router, http.MethodPost, "/api/admin/codes/JURY1/expiry", models.CodeExpiry{TTL: "48h"}, headers)
+		require.Equal(t, http.StatusOK, res.Code, res.Body.String())
+		var code models.CodeResponse
+		require.NoError(t, json.Unmarshal(res.Body.Bytes(), &code))
+		require.NotNil(t, code.ExpiresAt)
+		assert.WithinDuration(t, time.Now().Add(48*time.Hour), *code.ExpiresAt, time.Minute)
+
+		res = testutils.PerformRequest(router, http.MethodPost, "/api/admin/codes/NOPE1/expiry", models.CodeExpiry{TTL: "1h"}, headers)
+		assert.Equal(t, http.StatusNotFound, res.Code)
+		res = testutils.PerformRequest(router, http.MethodPost, "/api/admin/codes/JURY1/expiry", models.CodeExpiry{}, headers)
+		assert.Equal(t, http.StatusBadRequest, res.Code)
+	})
+
+	t.Run("Every code of a category", func(t *testing.T) {
+		req := models.SetCodeExpiryRequest{Category: "general_public", CodeExpiry: models.CodeExpiry{TTL: "3h"}}
+		res := testutils.PerformRequest(router, http.MethodPost, "/api/admin/codes/expiry", req, headers)
+		require.Equal(t, http.StatusOK, res.Code, res.Body.String())
+		var response models.SetCodeExpiryResponse
+		require.NoError(t, json.Unmarshal(res.Body.Bytes(), &response))
+		assert.Equal(t, 2, response.Updated)
+
+		jury, err := controller.codesStorage.Get(ctx, "JURY1")
+		require.NoError(t, err)
+		assert.True(t, jury.ExpiresAt.After(response.ExpiresAt), "other categories keep their expiry")
+	})
+
+	t.Run("Listed codes report the missing ones", func(t *testing.T) {
+		req := models.SetCodeExpiryRequest{Codes: []string{"PUBL1", "NOPE1"}, CodeExpiry: models.CodeExpiry{TTL: "1h"}}
+		res := testutils.PerformRequest(router, http.MethodPost, "/api/admin/codes/expiry", req, headers)
+		require.Equal(t, http.StatusOK, res.Code, res.Body.String())
+		var response models.SetCodeExpiryResponse
+		require.NoError(t, json.Unmarshal(res.Body.Bytes(), &response))
+		assert.Equal(t, 1, response.Updated)
+		assert.Equal(t, []string{"NOPE1"}, response.Missing)
+	})
+
+	t.Run("Codes and category are exclusive", func(t *testing.T) {
+		for _, req := range []models.SetCodeExpiryRequest{
+			{CodeExpiry: models.CodeExpiry{TTL: "1h"}},
+			{Codes: []string{"PUBL1"}, Category: "general_public", CodeExpiry: models.CodeExpiry{TTL: "1h"}},
+		} {
+			res := testutils.PerformRequest(router, http.MethodPost, "/api/admin/codes/expiry", req, headers)
+			assert.Equal(t, http.StatusBadRequest, res.Code)
+		}
+	})
+}
@@ -0,0 +1,235 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/alex-pricope/simple-voting-system/api/models"
+	"github.com/alex-pricope/simple-voting-system/api/transport"
+	"github.com/alex-pricope/simple-voting-system/logging"
+	"github.com/alex-pricope/simple-voting-system/storage"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Context keys of the values a handler records for the audit entry of its request
+const (
+	auditBeforeKey = "audit.before"
+	auditAfterKey  = "audit.after"
+)
+
+// auditFailures counts the audit entries of every event that could not be appended since the start
+var auditFailures struct {
+	count atomic.Int64
+	last  atomic.Pointer[time.Time]
+}
+
+// AuditController records the admin changes of an event and lists them
+type AuditController struct {
+	storage storage.AuditStorage
+}
+
+func NewAuditController(s storage.AuditStorage) *AuditController {
+	return &AuditController{storage: s}
+}
+
+func (c *AuditController) RegisterRoutes(engine *gin.Engine) {
+	group := engine.Group("/api/admin/audit", transport.AdminAuthMiddleware())
+
+	group.GET("", c.list)
+	group.GET("/failures", c.failures)
+}
+
+// Middleware appends an audit entry for every admin request that can change data, once it has been handled.
+// It has to be used before the routes are registered. Requests without an admin token are rejected before
+// they change anything and are not recorded.
+// The response is held back until the entry is appended, so a failed append is counted, logged and
+// reported to the admin with transport.AuditFailedHeader.
+func (c *AuditController) Middleware() gin.HandlerFunc {
+	return func(g *gin.Context) {
+		switch g.Request.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			g.Next()
+			return
+		}
+		writer := &auditResponseWriter{ResponseWriter: g.Writer, status: http.StatusOK}
+		g.Writer = writer
+		defer writer.flush()
+		g.Next()
+
+		route := g.FullPath()
+		if !strings.HasPrefix(route, "/api/admin/") && !strings.HasPrefix(route, "/api/meta/") {
+			return
+		}
+		actor, ok := transport.AdminName(g)
+		if !ok {
+			return
+		}
+
+		now := time.Now().UTC()
+		entry := &storage.AuditEntry{
+			ID:        storage.NewAuditID(now),
+			Timestamp: now,
+			Actor:     actor,
+			Method:    g.Request.Method,
+			Route:     route,
+			Path:      g.Request.URL.RequestURI(),
+			Status:    writer.Status(),
+			RequestID: g.GetHeader(transport.RequestIDHeader),
+			Before:    g.GetString(auditBeforeKey),
+			After:     g.GetString(auditAfterKey),
+		}
+		// The change is already made, a failed append cannot undo it
+		if err := c.storage.Append(context.WithoutCancel(g.Request.Context()), entry); err != nil {
+			auditFailures.count.Add(1)
+			auditFailures.last.Store(&now)
+			writer.Header().Set(transport.AuditFailedHeader, "true")
+			logging.Log.Errorf("AUDIT: failed to record %s %s by %s with status %d, request ID %s: %v",
+				entry.Method, entry.Path, actor, entry.Status, entry.RequestID, err)
+		}
+	}
+}
+
+// auditResponseWriter holds back the response of a request until its audit entry is appended,
+// so headers can still be added. Admin changes answer with small bodies.
+type auditResponseWriter struct {
+	gin.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (w *auditResponseWriter) WriteHeader(code int) {
+	if code > 0 {
+		w.status = code
+	}
+}
+
+func (w *auditResponseWriter) WriteHeaderNow() {}
+
+func (w *auditResponseWriter) Write(b []byte) (int, error) {
+	return w.body.Write(b)
+}
+
+func (w *auditResponseWriter) WriteString(s string) (int, error) {
+	return w.body.WriteString(s)
+}
+
+func (w *auditResponseWriter) Status() int {
+	return w.status
+}
+
+func (w *auditResponseWriter) Size() int {
+	return w.body.Len()
+}
+
+func (w *auditResponseWriter) Written() bool {
+	return false
+}
+
+func (w *auditResponseWriter) Flush() {}
+
+// flush writes the held back response
+func (w *auditResponseWriter) flush() {
+	w.ResponseWriter.WriteHeader(w.status)
+	w.ResponseWriter.WriteHeaderNow()
+	if w.body.Len() > 0 {
+		if _, err := w.ResponseWriter.Write(w.body.Bytes()); err != nil {
+			logging.Log.Errorf("AUDIT: failed to write the response: %v", err)
+		}
+	}
+}
+
+// auditBefore records the values a request is about to change, for its audit entry
+func auditBefore(g *gin.Context, v any) {
+	g.Set(auditBeforeKey, auditJSON(v))
+}
+
+// auditAfter records the values a request changed, for its audit entry
+func auditAfter(g *gin.Context, v any) {
+	g.Set(auditAfterKey, auditJSON(v))
+}
+
+// auditJSON encodes v right away, so later changes to it do not leak into the entry
+func auditJSON(v any) string {
+	if v == nil {
+		return ""
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		logging.Log.Errorf("AUDIT: failed to encode audit value: %v", err)
+		return ""
+	}
+	if string(b) == "null" {
+		return ""
+	}
+	return string(b)
+}
+
+// @Security AdminToken
+// list godoc
+// @Summary List the admin changes
+// @Description Every admin request that could change data, oldest first: who made it, the route, the response status,
+// @Description the request ID and, where known, the values before and after
+// @Tags admin
+// @Produce json
+// @Param from query string false "Only entries at or after this time, RFC3339"
+// @Param to query string false "Only entries before this time, RFC3339"
+// @Param actor query string false "Name of the admin credential"
+// @Param method query string false "HTTP method"
+// @Param path query string false "Part of the requested path, such as a code or /api/meta/teams"
+// @Param requestId query string false "Request ID"
+// @Success 200 {array} models.AuditEntryResponse
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /api/admin/audit [get]
+func (c *AuditController) list(g *gin.Context) {
+	filter := storage.AuditFilter{
+		Actor:     g.Query("actor"),
+		Method:    g.Query("method"),
+		Path:      g.Query("path"),
+		RequestID: g.Query("requestId"),
+	}
+	for name, t := range map[string]*time.Time{"from": &filter.From, "to": &filter.To} {
+		value := g.Query(name)
+		if value == "" {
+			continue
+		}
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			g.JSON(http.StatusBadRequest, models.ErrorResponse{Error: "invalid " + name + ", use RFC3339"})
+			return
+		}
+		*t = parsed
+	}
+
+	entries, err := c.storage.Query(g.Request.Context(), filter)
+	if err != nil {
+		logging.Log.Errorf("ADMIN: failed to query the audit log: %v", err)
+		g.JSON(storageErrorStatus(err), models.ErrorResponse{Error: err.Error()})
+		return
+	}
+	responses := make([]models.AuditEntryResponse, 0, len(entries))
+	for _, entry := range entries {
+		responses = append(responses, models.TransformAuditEntryFromStorage(entry))
+	}
+	g.JSON(http.StatusOK, responses)
+}
+
+// @Security AdminToken
+// failures godoc
+// @Summary Count the audit entries that could not be recorded
+// @Description Admin changes of every event whose audit entry failed to append since the service started.
+// @Description Each of them was answered with the X-Audit-Failed header and logged with its request ID
+// @Tags admin
+// @Produce json
+// @Success 200 {object} models.AuditFailuresResponse
+// @Router /api/admin/audit/failures [get]
+func (c *AuditController) failures(g *gin.Context) {
+	g.JSON(http.StatusOK, models.AuditFailuresResponse{
+		Failed:      int(auditFailures.count.Load()),
+		LastFailure: auditFailures.last.Load(),
+	})
+}
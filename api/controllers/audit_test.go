@@ -0,0 +1,244 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	testutils "github.com/alex-pricope/simple-voting-system/api/controllers/testing"
+	"github.com/alex-pricope/simple-voting-system/api/models"
+	"github.com/alex-pricope/simple-voting-system/api/transport"
+	"github.com/alex-pricope/simple-voting-system/logging"
+	"github.com/alex-pricope/simple-voting-system/storage"
+	"github.com/alex-pricope/simple-voting-system/storage/memory"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// setupTestAuditController records the admin and team routes over memory storages, with a second admin named jury
+func setupTestAuditController(t *testing.T) (*memory.VotingCodesStorage, *gin.Engine) {
+	t.Helper()
+	logging.Log = logrus.New()
+	t.Setenv("ADMIN_TOKEN", "secret")
+	t.Setenv("ADMIN_TOKENS", "jury:jury-token, helper:helper-token")
+
+	codes, teams, votes := memory.NewVotingCodesStorage(), memory.NewTeamStorage(), memory.NewVoteStorage()
+	controller := NewAuditController(memory.NewAuditStorage())
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(transport.RequestIDMiddleware(), controller.Middleware())
+	controller.RegisterRoutes(r)
+	NewAdminController(codes, teams, votes, nil, nil).RegisterRoutes(r)
+	NewTeamMetaController(teams, votes, codes, nil, nil).RegisterRoutes(r)
+
+	return codes, r
+}
+
+func getAudit(t *testing.T, router *gin.Engine, query string) []models.AuditEntryResponse {
+	t.Helper()
+	res := testutils.PerformRequest(router, http.MethodGet, "/api/admin/audit"+query, nil, map[string]string{"x-admin-token": "secret"})
+	require.Equal(t, http.StatusOK, res.Code, res.Body.String())
+	var entries []models.AuditEntryResponse
+	require.NoError(t, json.Unmarshal(res.Body.Bytes(), &entries))
+	return entries
+}
+
+func TestAuditRecordsAdminChanges(t *testing.T) {
+	codes, router := setupTestAuditController(t)
+	require.NoError(t, codes.Put(context.Background(), &storage.VotingCode{Code: "ABC123", Category: "jury"}))
+	require.NoError(t, codes.MarkUsed(context.Background(), "ABC123"))
+
+	t.Run("Reset of a code records actor, request ID and values", func(t *testing.T) {
+		res := testutils.PerformRequest(router, http.MethodPost, "/api/admin/codes/ABC123/reset", nil,
+			map[string]string{"x-admin-token": "jury-token", transport.RequestIDHeader: "req-42"})
+		require.Equal(t, http.StatusOK, res.Code)
+		assert.Equal(t, "req-42", res.Header().Get(transport.RequestIDHeader))
+
+		entries := getAudit(t, router, "")
+		require.Len(t, entries, 1)
+		entry := entries[0]
+		assert.Equal(t, "jury", entry.Actor)
+		assert.Equal(t, http.MethodPost, entry.Method)
+		assert.Equal(t, "/api/admin/codes/:code/reset", entry.Route)
+		assert.Equal(t, "/api/admin/codes/ABC123/reset", entry.Path)
+		assert.Equal(t, http.StatusOK, entry.Status)
+		assert.Equal(t, "req-42", entry.RequestID)
+
+		var before, after models.CodeResponse
+		require.NoError(t, json.Unmarshal(entry.Before, &before))
+		require.NoError(t, json.Unmarshal(entry.After, &after))
+		assert.True(t, before.Used)
+		assert.False(t, after.Used)
+	})
+
+	t.Run("Team update records the team before and after", func(t *testing.T) {
+		admin := map[string]string{"x-admin-token": "secret"}
+		res := testutils.PerformRequest(router, http.MethodPost, "/api/meta/teams", models.TeamCreateRequest{ID: 7, Name: "Rockets"}, admin)
+		require.Equal(t, http.StatusOK, res.Code)
+		res = testutils.PerformRequest(router, http.MethodPut, "/api/meta/teams/7", models.TeamUpdateRequest{Name: "Comets"}, admin)
+		require.Equal(t, http.StatusOK, res.Code)
+		assert.NotEmpty(t, res.Header().Get(transport.RequestIDHeader))
+
+		entries := getAudit(t, router, "?method=PUT")
+		require.Len(t, entries, 1)
+		assert.Equal(t, "admin", entries[0].Actor)
+		assert.NotEmpty(t, entries[0].RequestID)
+		var before, after models.TeamResponse
+		require.NoError(t, json.Unmarshal(entries[0].Before, &before))
+		require.NoError(t, json.Unmarshal(entries[0].After, &after))
+		assert.Equal(t, "Rockets", before.Name)
+		assert.Equal(t, "Comets", after.Name)
+	})
+
+	t.Run("Created codes are recorded as a count", func(t *testing.T) {
+		res := testutils.PerformRequest(router, http.MethodPost, "/api/admin/codes", models.CreateCodeRequest{Category: "general_public", Count: 3},
+			map[string]string{"x-admin-token": "secret"})
+		require.Equal(t, http.StatusOK, res.Code)
+
+		entries := getAudit(t, router, "?path=/api/admin/codes&method=POST")
+		require.NotEmpty(t, entries)
+		assert.JSONEq(t, `{"category":"general_public","created":3}`, string(entries[len(entries)-1].After))
+	})
+
+	t.Run("Failed changes are recorded with their status", func(t *testing.T) {
+		res := testutils.PerformRequest(router, http.MethodPost, "/api/admin/codes/NOPE/reset", nil, map[string]string{"x-admin-token": "helper-token"})
+		require.Equal(t, http.StatusNotFound, res.Code)
+
+		entries := getAudit(t, router, "?actor=helper")
+		require.Len(t, entries, 1)
+		assert.Equal(t, http.StatusNotFound, entries[0].Status)
+		assert.Empty(t, entries[0].Before)
+	})
+
+	t.Run("Reads and rejected requests are not recorded", func(t *testing.T) {
+		before := len(getAudit(t, router, ""))
+		res := testutils.PerformRequest(router, http.MethodGet, "/api/admin/codes", nil, map[string]string{"x-admin-token": "secret"})
+		require.Equal(t, http.StatusOK, res.Code)
+		res = testutils.PerformRequest(router, http.MethodDelete, "/api/admin/codes/ABC123", nil, map[string]string{"x-admin-token": "wrong"})
+		require.Equal(t, http.StatusUnauthorized, res.Code)
+
+		assert.Len(t, getAudit(t, router, ""), before)
+	})
+}
+
+func TestListAudit(t *testing.T) {
+	codes, router := setupTestAuditController(t)
+	require.NoError(t, codes.Put(context.Background(), &storage.VotingCode{Code: "ABC123", Category: "jury"}))
+	require.NoError(t, codes.Put(context.Background(), &storage.VotingCode{Code: "DEF456", Category: "jury"}))
+
+	res := testutils.PerformRequest(router, http.MethodDelete, "/api/admin/codes/ABC123", nil, map[string]string{"x-admin-token": "secret"})
+	require.Equal(t, http.StatusOK, res.Code)
+	res = testutils.PerformRequest(router, http.MethodDelete, "/api/admin/codes/DEF456", nil, map[string]string{"x-admin-token": "jury-token"})
+	require.Equal(t, http.StatusOK, res.Code)
+
+	t.Run("Filters by path and actor", func(t *testing.T) {
+		entries := getAudit(t, router, "?path=DEF456")
+		require.Len(t, entries, 1)
+		assert.Equal(t, "jury", entries[0].Actor)
+		var deleted models.CodeResponse
+		require.NoError(t, json.Unmarshal(entries[0].Before, &deleted))
+		assert.Equal(t, "DEF456", deleted.Code)
+		assert.Empty(t, entries[0].After)
+
+		assert.Len(t, getAudit(t, router, "?actor=admin"), 1)
+	})
+
+	t.Run("Filters by time", func(t *testing.T) {
+		future := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+		assert.Len(t, getAudit(t, router, "?to="+future), 2)
+		assert.Empty(t, getAudit(t, router, "?from="+future))
+	})
+
+	t.Run("Invalid time", func(t *testing.T) {
+		res := testutils.PerformRequest(router, http.MethodGet, "/api/admin/audit?from=yesterday", nil, map[string]string{"x-admin-token": "secret"})
+		assert.Equal(t, http.StatusBadRequest, res.Code)
+	})
+
+	t.Run("Requires an admin token", func(t *testing.T) {
+		res := testutils.PerformRequest(router, http.MethodGet, "/api/admin/audit", nil, nil)
+		assert.Equal(t, http.StatusUnauthorized, res.Code)
+	})
+}
+
+func TestAuditRecordsEventChanges(t *testing.T) {
+	logging.Log = logrus.New()
+	t.Setenv("ADMIN_TOKEN", "secret")
+	audit := NewAuditController(memory.NewAuditStorage())
+	events := NewEventController(memory.NewEventStorage(), "default", func(event *storage.Event) (http.Handler, error) {
+		r := gin.New()
+		audit.RegisterRoutes(r)
+		return r, nil
+	}, nil)
+	require.NoError(t, events.Init(context.Background()))
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(audit.Middleware())
+	events.RegisterRoutes(router)
+	admin := map[string]string{"x-admin-token": "secret"}
+
+	res := testutils.PerformRequest(router, http.MethodPost, "/api/admin/events", models.EventCreateRequest{ID: "spring"}, admin)
+	require.Equal(t, http.StatusOK, res.Code)
+	res = testutils.PerformRequest(router, http.MethodPost, "/api/admin/events/spring/archive", nil, admin)
+	require.Equal(t, http.StatusOK, res.Code)
+
+	entries := getAudit(t, router, "")
+	require.Len(t, entries, 2)
+	assert.Equal(t, "/api/admin/events", entries[0].Route)
+	assert.Equal(t, "/api/admin/events/:id/archive", entries[1].Route)
+	var after models.EventResponse
+	require.NoError(t, json.Unmarshal(entries[1].After, &after))
+	assert.True(t, after.Archived)
+}
+
+// failingAuditStorage rejects every append
+type failingAuditStorage struct {
+	storage.AuditStorage
+}
+
+func (failingAuditStorage) Append(context.Context, *storage.AuditEntry) error {
+	return storage.ErrUnavailable
+}
+
+func TestAuditFailuresAreReported(t *testing.T) {
+	logging.Log = logrus.New()
+	t.Setenv("ADMIN_TOKEN", "secret")
+	codes, teams, votes := memory.NewVotingCodesStorage(), memory.NewTeamStorage(), memory.NewVoteStorage()
+	require.NoError(t, codes.Put(context.Background(), &storage.VotingCode{Code: "ABC123", Category: "jury"}))
+	audit := NewAuditController(failingAuditStorage{memory.NewAuditStorage()})
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(audit.Middleware())
+	audit.RegisterRoutes(router)
+	NewAdminController(codes, teams, votes, nil, nil).RegisterRoutes(router)
+	admin := map[string]string{"x-admin-token": "secret"}
+
+	failures := func() models.AuditFailuresResponse {
+		t.Helper()
+		res := testutils.PerformRequest(router, http.MethodGet, "/api/admin/audit/failures", nil, admin)
+		require.Equal(t, http.StatusOK, res.Code)
+		var response models.AuditFailuresResponse
+		require.NoError(t, json.Unmarshal(res.Body.Bytes(), &response))
+		return response
+	}
+	before := failures()
+
+	// The change is made and answered as usual, with the failure flagged
+	res := testutils.PerformRequest(router, http.MethodPost, "/api/admin/codes/ABC123/reset", nil, admin)
+	require.Equal(t, http.StatusOK, res.Code)
+	assert.Equal(t, "true", res.Header().Get(transport.AuditFailedHeader))
+	assert.JSONEq(t, `{"reset":"ABC123"}`, res.Body.String())
+
+	res = testutils.PerformRequest(router, http.MethodPost, "/api/admin/codes/NOPE/reset", nil, admin)
+	assert.Equal(t, http.StatusNotFound, res.Code, "the status is kept")
+	assert.Equal(t, "true", res.Header().Get(transport.AuditFailedHeader))
+
+	after := failures()
+	assert.Equal(t, before.Failed+2, after.Failed)
+	require.NotNil(t, after.LastFailure)
+
+	res = testutils.PerformRequest(router, http.MethodGet, "/api/admin/audit/failures", nil, admin)
+	assert.Empty(t, res.Header().Get(transport.AuditFailedHeader), "reads are not recorded")
+}
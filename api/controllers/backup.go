@@ -0,0 +1,302 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/alex-pricope/simple-voting-system/api/models"
+	"github.com/alex-pricope/simple-voting-system/api/transport"
+	"github.com/alex-pricope/simple-voting-system/logging"
+	"github.com/alex-pricope/simple-voting-system/storage"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"time"
+)
+
+// maxRestoreProblems caps the problems listed for an invalid archive
+const maxRestoreProblems = 50
+
+// BackupController exports everything stored for an event into a versioned archive,
+// and loads such an archive back into empty tables
+type BackupController struct {
+	event             string
+	codesStorage      storage.VotingCodeStorage
+	votesStorage      storage.VoteStorage
+	teamsStorage      storage.TeamStorage
+	categoriesStorage storage.VotingCategoryStorage
+	talliesStorage    storage.TallyStorage  // Rebuilt after a restore, nil when the results are computed from the votes
+	backupStorage     storage.BackupStorage // Where ?upload=true writes the archive, nil when no bucket is configured
+	ledgerStorage     storage.LedgerStorage // Records the restored ballots
+}
+
+func NewBackupController(event string, codes storage.VotingCodeStorage, votes storage.VoteStorage, teams storage.TeamStorage,
+	categories storage.VotingCategoryStorage, tallies storage.TallyStorage, backups storage.BackupStorage, ledger storage.LedgerStorage) *BackupController {
+	return &BackupController{
+		event:             event,
+		codesStorage:      codes,
+		votesStorage:      votes,
+		teamsStorage:      teams,
+		categoriesStorage: categories,
+		talliesStorage:    tallies,
+		backupStorage:     backups,
+		ledgerStorage:     ledger,
+	}
+}
+
+func (c *BackupController) RegisterRoutes(engine *gin.Engine) {
+	group := engine.Group("/api/admin", transport.AdminAuthMiddleware())
+
+	group.GET("/backup", c.backup)
+	group.POST("/restore", c.restore)
+}
+
+// @Security AdminToken
+// backup godoc
+// @Summary Back up the codes, teams, categories and votes
+// @Description Returns the archive as a JSON download. With upload=true the archive is written to the
+// @Description storage.backupBucket S3 bucket instead, and the response only counts what it holds
+// @Tags admin
+// @Produce json
+// @Param upload query bool false "Write the archive to the backup bucket"
+// @Success 200 {object} models.Backup
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /api/admin/backup [get]
+func (c *BackupController) backup(g *gin.Context) {
+	upload := g.Query("upload") == "true"
+	if upload && c.backupStorage == nil {
+		g.JSON(http.StatusBadRequest, models.ErrorResponse{Error: "no backup bucket configured, set storage.backupBucket"})
+		return
+	}
+
+	ctx := g.Request.Context()
+	codes, err := c.codesStorage.GetAll(ctx)
+	if err != nil {
+		writeStorageError(g, "codes", err)
+		return
+	}
+	teams, err := c.teamsStorage.GetAll(ctx)
+	if err != nil {
+		writeStorageError(g, "teams", err)
+		return
+	}
+	categories, err := c.categoriesStorage.GetAll(ctx)
+	if err != nil {
+		writeStorageError(g, "categories", err)
+		return
+	}
+	votes, err := c.votesStorage.GetAll(ctx)
+	if err != nil {
+		writeStorageError(g, "votes", err)
+		return
+	}
+
+	backup := models.TransformStorageToBackup(c.event, codes, teams, categories, votes)
+	name := fmt.Sprintf("backup-%s-%s.json", c.event, backup.CreatedAt.Format("20060102T150405Z"))
+	logging.Log.Infof("ADMIN: backing up %d codes, %d teams, %d categories and %d votes of event %s",
+		len(backup.Codes), len(backup.Teams), len(backup.Categories), len(backup.Votes), c.event)
+	if !upload {
+		g.Header("Content-Disposition", `attachment; filename="`+name+`"`)
+		g.JSON(http.StatusOK, backup)
+		return
+	}
+
+	archive, err := json.Marshal(backup)
+	if err != nil {
+		logging.Log.Errorf("ADMIN: failed to marshal backup: %v", err)
+		g.JSON(http.StatusInternalServerError, models.ErrorResponse{Error: "could not build the archive"})
+		return
+	}
+	location, err := c.backupStorage.Put(ctx, "backups/"+name, archive)
+	if err != nil {
+		g.JSON(storageErrorStatus(err), models.ErrorResponse{Error: "could not write the archive: " + err.Error()})
+		return
+	}
+	summary := backup.Summary()
+	summary.Location = location
+	logging.Log.Infof("ADMIN: wrote backup of event %s to %s", c.event, location)
+	g.JSON(http.StatusOK, summary)
+}
+
+// @Security AdminToken
+// restore godoc
+// @Summary Restore a backup into empty tables
+// @Description Validates the whole archive first and writes nothing if it has a problem, or if any table has data.
+// @Description A storage failure half way leaves a partial restore behind, delete it before trying again
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param request body models.Backup true "Archive from GET /api/admin/backup"
+// @Success 200 {object} models.BackupSummary
+// @Failure 400 {object} models.RestoreErrorResponse
+// @Failure 409 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /api/admin/restore [post]
+func (c *BackupController) restore(g *gin.Context) {
+	var backup models.Backup
+	if err := g.ShouldBindJSON(&backup); err != nil {
+		g.JSON(http.StatusBadRequest, models.ErrorResponse{Error: "invalid request format"})
+		return
+	}
+	if backup.Version != models.BackupVersion {
+		g.JSON(http.StatusBadRequest, models.ErrorResponse{
+			Error: fmt.Sprintf("unsupported backup version %d, expected %d", backup.Version, models.BackupVersion)})
+		return
+	}
+	if problems := validateBackup(&backup); len(problems) > 0 {
+		g.JSON(http.StatusBadRequest, models.RestoreErrorResponse{Error: "invalid backup", Problems: problems})
+		return
+	}
+
+	ctx := g.Request.Context()
+	if table, err := c.nonEmptyTable(ctx); err != nil {
+		writeStorageError(g, table, err)
+		return
+	} else if table != "" {
+		g.JSON(http.StatusConflict, models.ErrorResponse{Error: "restore only loads into empty tables, " + table + " has data"})
+		return
+	}
+
+	failed := func(what string, err error) {
+		logging.Log.Errorf("ADMIN: restore of event %s failed writing %s: %v", c.event, what, err)
+		g.JSON(storageErrorStatus(err), models.ErrorResponse{
+			Error: "restore failed writing " + what + ", the restore is partial: " + err.Error()})
+	}
+	for _, t := range backup.Teams {
+		if err := c.teamsStorage.Create(ctx, t.ToStorage()); err != nil {
+			failed(fmt.Sprintf("team %d", t.ID), err)
+			return
+		}
+	}
+	for _, vc := range backup.Categories {
+		if err := c.categoriesStorage.Create(ctx, vc.ToStorage()); err != nil {
+			failed(fmt.Sprintf("category %d", vc.ID), err)
+			return
+		}
+	}
+	codes := make([]*storage.VotingCode, 0, len(backup.Codes))
+	for _, code := range backup.Codes {
+		codes = append(codes, code.ToStorage())
+	}
+	if stored, err := c.codesStorage.PutBatch(ctx, codes); err != nil || len(stored) < len(codes) {
+		if err == nil {
+			err = fmt.Errorf("%w: %d codes were created meanwhile", storage.ErrAlreadyExists, len(codes)-len(stored))
+		}
+		failed("codes", err)
+		return
+	}
+	for _, code := range backup.Codes {
+		if !code.Used {
+			continue
+		}
+		if err := c.codesStorage.MarkUsed(ctx, code.Code); err != nil {
+			failed("code "+code.Code, err)
+			return
+		}
+	}
+	votes := make([]*storage.Vote, 0, len(backup.Votes))
+	for _, v := range backup.Votes {
+		vote := v.ToStorage()
+		if err := c.votesStorage.Create(ctx, vote); err != nil {
+			failed("votes of code "+v.Code, err)
+			return
+		}
+		votes = append(votes, vote)
+	}
+	recordLedger(g, c.ledgerStorage, importedBallots(codes, votes, "restored from the backup taken at "+backup.CreatedAt.Format(time.RFC3339))...)
+
+	// The tallies follow the restored votes, a failure is repaired with POST /api/admin/tallies/rebuild like after a ballot
+	if c.talliesStorage != nil {
+		if err := c.talliesStorage.Replace(ctx, storage.BuildTallies(votes, codes)); err != nil {
+			logging.Log.Errorf("ADMIN: failed to rebuild tallies after restore, run POST /api/admin/tallies/rebuild: %v", err)
+		}
+	}
+
+	logging.Log.Infof("ADMIN: restored %d codes, %d teams, %d categories and %d votes from the backup of event %s taken at %s",
+		len(backup.Codes), len(backup.Teams), len(backup.Categories), len(backup.Votes), backup.Event, backup.CreatedAt)
+	summary := backup.Summary()
+	auditAfter(g, summary)
+	g.JSON(http.StatusOK, summary)
+}
+
+// nonEmptyTable returns the first of the event tables holding data, or "" when they are all empty
+func (c *BackupController) nonEmptyTable(ctx context.Context) (string, error) {
+	if codes, err := c.codesStorage.GetAll(ctx); err != nil || len(codes) > 0 {
+		return "codes", err
+	}
+	if teams, err := c.teamsStorage.GetAll(ctx); err != nil || len(teams) > 0 {
+		return "teams", err
+	}
+	if categories, err := c.categoriesStorage.GetAll(ctx); err != nil || len(categories) > 0 {
+		return "categories", err
+	}
+	if votes, err := c.votesStorage.GetAll(ctx); err != nil || len(votes) > 0 {
+		return "votes", err
+	}
+	return "", nil
+}
+
+// validateBackup checks that the IDs of the archive are unique and that every reference points at an item of it
+func validateBackup(backup *models.Backup) []string {
+	var problems []string
+	add := func(format string, args ...any) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	teams := make(map[int]bool, len(backup.Teams))
+	for _, t := range backup.Teams {
+		if teams[t.ID] {
+			add("team %d is listed twice", t.ID)
+		}
+		teams[t.ID] = true
+	}
+	categories := make(map[int]bool, len(backup.Categories))
+	for _, vc := range backup.Categories {
+		if categories[vc.ID] {
+			add("category %d is listed twice", vc.ID)
+		}
+		categories[vc.ID] = true
+	}
+	codes := make(map[string]bool, len(backup.Codes))
+	for _, code := range backup.Codes {
+		switch {
+		case code.Code == "":
+			add("a code has no value")
+		case codes[code.Code]:
+			add("code %s is listed twice", code.Code)
+		}
+		codes[code.Code] = true
+		if _, ok := models.ValidCategories[models.VotingCategory(code.Category)]; !ok {
+			add("code %s has unknown voter group %q", code.Code, code.Category)
+		}
+		if code.TeamID != nil && !teams[*code.TeamID] {
+			add("code %s is attached to unknown team %d", code.Code, *code.TeamID)
+		}
+	}
+	votes := make(map[string]bool, len(backup.Votes))
+	for _, v := range backup.Votes {
+		key := v.Code + "#" + storage.VoteSortKey(v.CategoryID, v.TeamID)
+		if votes[key] {
+			add("code %s rates team %d in category %d twice", v.Code, v.TeamID, v.CategoryID)
+		}
+		votes[key] = true
+		if !codes[v.Code] {
+			add("vote of unknown code %s", v.Code)
+		}
+		if !teams[v.TeamID] {
+			add("vote of code %s for unknown team %d", v.Code, v.TeamID)
+		}
+		if !categories[v.CategoryID] {
+			add("vote of code %s in unknown category %d", v.Code, v.CategoryID)
+		}
+		if v.Rating < 1 || v.Rating > 5 {
+			add("vote of code %s has rating %d, outside 1-5", v.Code, v.Rating)
+		}
+	}
+
+	if len(problems) > maxRestoreProblems {
+		more := len(problems) - maxRestoreProblems
+		problems = append(problems[:maxRestoreProblems], fmt.Sprintf("and %d more", more))
+	}
+	return problems
+}
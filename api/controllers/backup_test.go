@@ -0,0 +1,185 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	testutils "github.com/alex-pricope/simple-voting-system/api/controllers/testing"
+	"github.com/alex-pricope/simple-voting-system/api/models"
+	"github.com/alex-pricope/simple-voting-system/logging"
+	"github.com/alex-pricope/simple-voting-system/storage"
+	"github.com/alex-pricope/simple-voting-system/storage/memory"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func setupTestBackupController(t *testing.T, backups storage.BackupStorage) (*BackupController, *gin.Engine) {
+	t.Helper()
+	logging.Log = logrus.New()
+	t.Setenv("ADMIN_TOKEN", "secret")
+
+	controller := NewBackupController("spring", memory.NewVotingCodesStorage(), memory.NewVoteStorage(), memory.NewTeamStorage(),
+		memory.NewVotingCategoryStorage(), memory.NewTallyStorage(), backups, nil)
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	controller.RegisterRoutes(r)
+
+	return controller, r
+}
+
+// seedBackupData stores one team, one category and a used and an unused code, the used one with a vote
+func seedBackupData(t *testing.T, c *BackupController) {
+	t.Helper()
+	ctx := context.Background()
+	teamID := 1
+	expiresAt := time.Date(2025, 5, 1, 18, 0, 0, 0, time.UTC)
+
+	require.NoError(t, c.teamsStorage.Create(ctx, &storage.Team{ID: 1, Name: "Alpha", Members: []string{"Alice"}, Archived: true}))
+	require.NoError(t, c.categoriesStorage.Create(ctx, &storage.VotingCategory{ID: 2, Name: "Design", Weight: 0.5}))
+	require.NoError(t, c.codesStorage.Put(ctx, &storage.VotingCode{Code: "AAAAA", Category: "other_team", TeamID: &teamID}))
+	require.NoError(t, c.codesStorage.Put(ctx, &storage.VotingCode{Code: "BBBBB", Category: "general_public", ExpiresAt: &expiresAt}))
+	require.NoError(t, c.codesStorage.MarkUsed(ctx, "AAAAA"))
+	require.NoError(t, c.votesStorage.Create(ctx, &storage.Vote{
+		Code: "AAAAA", SortKey: storage.VoteSortKey(2, 1), CategoryID: 2, TeamID: 1, Rating: 4, Timestamp: expiresAt.Add(-time.Hour),
+	}))
+}
+
+func TestBackupAndRestore(t *testing.T) {
+	admin := map[string]string{"x-admin-token": "secret"}
+	source, sourceRouter := setupTestBackupController(t, nil)
+	seedBackupData(t, source)
+
+	res := testutils.PerformRequest(sourceRouter, http.MethodGet, "/api/admin/backup", nil, admin)
+	require.Equal(t, http.StatusOK, res.Code)
+	assert.Contains(t, res.Header().Get("Content-Disposition"), `filename="backup-spring-`)
+	var backup models.Backup
+	require.NoError(t, json.Unmarshal(res.Body.Bytes(), &backup))
+	assert.Equal(t, models.BackupVersion, backup.Version)
+	assert.Equal(t, "spring", backup.Event)
+	require.Len(t, backup.Codes, 2)
+	assert.Equal(t, "AAAAA", backup.Codes[0].Code)
+	assert.True(t, backup.Codes[0].Used)
+	require.Len(t, backup.Votes, 1)
+
+	target, targetRouter := setupTestBackupController(t, nil)
+
+	t.Run("Happy path - restore into empty tables", func(t *testing.T) {
+		res := testutils.PerformRequest(targetRouter, http.MethodPost, "/api/admin/restore", backup, admin)
+		require.Equal(t, http.StatusOK, res.Code)
+		var summary models.BackupSummary
+		require.NoError(t, json.Unmarshal(res.Body.Bytes(), &summary))
+		assert.Equal(t, models.BackupSummary{Event: "spring", CreatedAt: backup.CreatedAt, Codes: 2, Teams: 1, Categories: 1, Votes: 1}, summary)
+
+		ctx := context.Background()
+		code, err := target.codesStorage.Get(ctx, "AAAAA")
+		require.NoError(t, err)
+		assert.True(t, code.Used)
+		assert.Equal(t, 1, *code.TeamID)
+		code, err = target.codesStorage.Get(ctx, "BBBBB")
+		require.NoError(t, err)
+		assert.False(t, code.Used)
+		require.NotNil(t, code.ExpiresAt)
+		team, err := target.teamsStorage.Get(ctx, 1)
+		require.NoError(t, err)
+		assert.True(t, team.Archived)
+		votes, err := target.votesStorage.GetByCode(ctx, "AAAAA")
+		require.NoError(t, err)
+		require.Len(t, votes, 1)
+		assert.Equal(t, storage.VoteSortKey(2, 1), votes[0].SortKey)
+		tallies, err := target.talliesStorage.GetAll(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, 1, tallies.Ballots)
+
+		// The restored data backs up to the same archive
+		res = testutils.PerformRequest(targetRouter, http.MethodGet, "/api/admin/backup", nil, admin)
+		require.Equal(t, http.StatusOK, res.Code)
+		var again models.Backup
+		require.NoError(t, json.Unmarshal(res.Body.Bytes(), &again))
+		again.CreatedAt = backup.CreatedAt
+		assert.Equal(t, backup, again)
+	})
+
+	t.Run("Tables with data are a conflict", func(t *testing.T) {
+		res := testutils.PerformRequest(targetRouter, http.MethodPost, "/api/admin/restore", backup, admin)
+		assert.Equal(t, http.StatusConflict, res.Code)
+	})
+
+	t.Run("Missing admin token", func(t *testing.T) {
+		res := testutils.PerformRequest(targetRouter, http.MethodGet, "/api/admin/backup", nil, nil)
+		assert.Equal(t, http.StatusUnauthorized, res.Code)
+	})
+}
+
+func TestRestoreRejectsInvalidBackup(t *testing.T) {
+	admin := map[string]string{"x-admin-token": "secret"}
+	controller, router := setupTestBackupController(t, nil)
+
+	t.Run("Unsupported version", func(t *testing.T) {
+		res := testutils.PerformRequest(router, http.MethodPost, "/api/admin/restore", models.Backup{Version: 99}, admin)
+		assert.Equal(t, http.StatusBadRequest, res.Code)
+	})
+
+	t.Run("Broken references", func(t *testing.T) {
+		teamID := 7
+		backup := models.Backup{
+			Version: models.BackupVersion,
+			Teams:   []models.BackupTeam{{ID: 1, Name: "Alpha"}, {ID: 1, Name: "Again"}},
+			Codes: []models.BackupCode{
+				{Code: "AAAAA", Category: "other_team", TeamID: &teamID},
+				{Code: "BBBBB", Category: "nobody"},
+			},
+			Votes: []models.BackupVote{{Code: "CCCCC", CategoryID: 3, TeamID: 1, Rating: 9}},
+		}
+		res := testutils.PerformRequest(router, http.MethodPost, "/api/admin/restore", backup, admin)
+		require.Equal(t, http.StatusBadRequest, res.Code)
+
+		var body models.RestoreErrorResponse
+		require.NoError(t, json.Unmarshal(res.Body.Bytes(), &body))
+		assert.Equal(t, []string{
+			"team 1 is listed twice",
+			"code AAAAA is attached to unknown team 7",
+			`code BBBBB has unknown voter group "nobody"`,
+			"vote of unknown code CCCCC",
+			"vote of code CCCCC in unknown category 3",
+			"vote of code CCCCC has rating 9, outside 1-5",
+		}, body.Problems)
+
+		// Nothing was written
+		teams, err := controller.teamsStorage.GetAll(context.Background())
+		require.NoError(t, err)
+		assert.Empty(t, teams)
+	})
+}
+
+func TestBackupUpload(t *testing.T) {
+	admin := map[string]string{"x-admin-token": "secret"}
+
+	t.Run("Happy path - archive is written to the bucket", func(t *testing.T) {
+		backups := memory.NewBackupStorage()
+		controller, router := setupTestBackupController(t, backups)
+		seedBackupData(t, controller)
+
+		res := testutils.PerformRequest(router, http.MethodGet, "/api/admin/backup?upload=true", nil, admin)
+		require.Equal(t, http.StatusOK, res.Code)
+		var summary models.BackupSummary
+		require.NoError(t, json.Unmarshal(res.Body.Bytes(), &summary))
+		assert.Equal(t, 2, summary.Codes)
+		assert.Equal(t, 1, summary.Votes)
+
+		key := "backups/backup-spring-" + summary.CreatedAt.Format("20060102T150405Z") + ".json"
+		assert.Equal(t, "memory://"+key, summary.Location)
+		var backup models.Backup
+		require.NoError(t, json.Unmarshal(backups.Get(key), &backup))
+		assert.Len(t, backup.Codes, 2)
+	})
+
+	t.Run("No bucket configured", func(t *testing.T) {
+		_, router := setupTestBackupController(t, nil)
+		res := testutils.PerformRequest(router, http.MethodGet, "/api/admin/backup?upload=true", nil, admin)
+		assert.Equal(t, http.StatusBadRequest, res.Code)
+	})
+}
@@ -0,0 +1,41 @@
+package controllers
+
+import (
+	"github.com/alex-pricope/simple-voting-system/api/transport"
+	"github.com/alex-pricope/simple-voting-system/logging"
+	"github.com/alex-pricope/simple-voting-system/storage/cached"
+	"github.com/gin-gonic/gin"
+	"net/http"
+)
+
+// CacheController lets organisers drop the cached teams and categories of this instance
+type CacheController struct {
+	caches []cached.Flusher
+}
+
+func NewCacheController(caches ...cached.Flusher) *CacheController {
+	return &CacheController{caches: caches}
+}
+
+func (c *CacheController) RegisterRoutes(engine *gin.Engine) {
+	group := engine.Group("/api/admin/cache", transport.AdminAuthMiddleware())
+
+	group.POST("/flush", c.flush)
+}
+
+// @Security AdminToken
+// flush godoc
+// @Summary Flush the teams and categories cache
+// @Description Only flushes the instance serving the request, other warm Lambda instances catch up after storage.cacheTTL
+// @Tags admin
+// @Produce json
+// @Success 200 {object} map[string]string
+// @Router /api/admin/cache/flush [post]
+func (c *CacheController) flush(g *gin.Context) {
+	for _, cache := range c.caches {
+		cache.Flush()
+	}
+	auditAfter(g, gin.H{"caches": len(c.caches)})
+	logging.Log.Infof("ADMIN: flushed %d caches", len(c.caches))
+	g.JSON(http.StatusOK, gin.H{"message": "cache flushed"})
+}
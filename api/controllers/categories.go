@@ -13,11 +13,13 @@ import (
 )
 
 type CategoryMetaController struct {
-	storage storage.VotingCategoryStorage
+	storage    storage.VotingCategoryStorage
+	references *referenceFinder
 }
 
-func NewCategoryMetaController(s storage.VotingCategoryStorage) *CategoryMetaController {
-	return &CategoryMetaController{storage: s}
+func NewCategoryMetaController(s storage.VotingCategoryStorage, votes storage.VoteStorage, codes storage.VotingCodeStorage, tallies storage.TallyStorage,
+	ledger storage.LedgerStorage) *CategoryMetaController {
+	return &CategoryMetaController{storage: s, references: &referenceFinder{votes: votes, codes: codes, tallies: tallies, ledger: ledger}}
 }
 
 func (c *CategoryMetaController) RegisterRoutes(engine *gin.Engine) {
@@ -27,20 +29,35 @@ func (c *CategoryMetaController) RegisterRoutes(engine *gin.Engine) {
 	group.GET("/:id", transport.AdminAuthMiddleware(), c.get)
 	group.POST("", transport.AdminAuthMiddleware(), c.create)
 	group.PUT("/:id", transport.AdminAuthMiddleware(), c.update)
+	group.PATCH("/:id", transport.AdminAuthMiddleware(), c.patch)
 	group.DELETE("/:id", transport.AdminAuthMiddleware(), c.delete)
+	group.POST("/:id/archive", transport.AdminAuthMiddleware(), c.archive)
+	group.POST("/:id/restore", transport.AdminAuthMiddleware(), c.restore)
 }
 
 // @Summary Get all voting categories
+// @Description Returns every category that is not archived as a plain array of models.VotingCategoryResponse.
+// @Description With limit or next it returns a single page instead, in the envelope documented below: items and the next token, absent on the last page
 // @Tags Meta/Categories
 // @Produce json
-// @Success 200 {array} models.VotingCategoryResponse
+// @Param includeArchived query bool false "Also return archived categories, needs the admin token"
+// @Param limit query int false "Page size (1-1000), enables paging"
+// @Param next query string false "Token from the previous page"
+// @Success 200 {object} models.PageResponse[models.VotingCategoryResponse] "A page of categories with limit or next, otherwise the items alone as an array"
+// @Failure 400 {object} models.ErrorResponse
 // @Failure 500 {object} models.ErrorResponse
 // @Router /api/meta/categories [get]
 func (c *CategoryMetaController) getAll(g *gin.Context) {
+	page, paged, err := parsePageRequest(g)
+	if err != nil {
+		g.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+
 	categories, err := c.storage.GetAll(g.Request.Context())
 	if err != nil {
 		logging.Log.Errorf("META: failed to get all categories: %v", err)
-		g.JSON(http.StatusInternalServerError, models.ErrorResponse{Error: err.Error()})
+		g.JSON(storageErrorStatus(err), models.ErrorResponse{Error: err.Error()})
 		return
 	}
 
@@ -49,17 +66,34 @@ func (c *CategoryMetaController) getAll(g *gin.Context) {
 		return categories[i].ID < categories[j].ID
 	})
 
+	withArchived := includeArchived(g)
 	responses := make([]models.VotingCategoryResponse, 0, len(categories))
 	for _, cat := range categories {
+		if cat.Archived && !withArchived {
+			continue
+		}
 		responses = append(responses, models.TransformVotingCategoryFromStorage(cat))
 	}
+
+	if paged {
+		// Categories are few, so they are paged after loading and sorting the full list
+		items, next, err := paginateByID(responses, func(r models.VotingCategoryResponse) int { return r.ID }, page)
+		if err != nil {
+			g.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+			return
+		}
+		g.JSON(http.StatusOK, models.PageResponse[models.VotingCategoryResponse]{Items: items, Next: next})
+		return
+	}
 	g.JSON(http.StatusOK, responses)
 }
 
 // @Summary Get a voting category by ID
+// @Description An archived category is not found, unless an admin asks for it with includeArchived
 // @Tags Meta/Categories
 // @Produce json
 // @Param id path int true "Category ID"
+// @Param includeArchived query bool false "Also return an archived category, needs the admin token"
 // @Success 200 {object} models.VotingCategoryResponse
 // @Failure 400 {object} models.ErrorResponse
 // @Failure 404 {object} models.ErrorResponse
@@ -73,13 +107,11 @@ func (c *CategoryMetaController) get(g *gin.Context) {
 		return
 	}
 	category, err := c.storage.Get(g.Request.Context(), id)
-	if err != nil {
-		logging.Log.Errorf("META: failed to get category: %v", err)
-		g.JSON(http.StatusInternalServerError, models.ErrorResponse{Error: err.Error()})
-		return
+	if err == nil && category.Archived && !includeArchived(g) {
+		err = storage.ErrNotFound
 	}
-	if category == nil {
-		g.JSON(http.StatusNotFound, models.ErrorResponse{Error: "category not found"})
+	if err != nil {
+		writeStorageError(g, "category", err)
 		return
 	}
 	g.JSON(http.StatusOK, models.TransformVotingCategoryFromStorage(category))
@@ -119,17 +151,19 @@ func (c *CategoryMetaController) create(g *gin.Context) {
 	}
 
 	if err := c.storage.Create(g.Request.Context(), category); err != nil {
-		if errors.Is(err, storage.ErrItemWithIDAlreadyExists) {
+		if errors.Is(err, storage.ErrAlreadyExists) {
 			logging.Log.Warnf("META: category with ID %d already exists", req.ID)
 			g.JSON(http.StatusConflict, models.ErrorResponse{Error: "category with ID already exists"})
 			return
 		}
 
 		logging.Log.Errorf("META: failed to create category: %v", err)
-		g.JSON(http.StatusInternalServerError, models.ErrorResponse{Error: err.Error()})
+		g.JSON(storageErrorStatus(err), models.ErrorResponse{Error: err.Error()})
 		return
 	}
-	g.JSON(http.StatusOK, models.TransformVotingCategoryFromStorage(category))
+	response := models.TransformVotingCategoryFromStorage(category)
+	auditAfter(g, response)
+	g.JSON(http.StatusOK, response)
 }
 
 // @Security AdminToken
@@ -141,6 +175,8 @@ func (c *CategoryMetaController) create(g *gin.Context) {
 // @Param category body models.VotingCategoryUpdateRequest true "VotingCategory object"
 // @Success 200 {object} models.VotingCategoryResponse
 // @Failure 400 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 409 {object} models.ErrorResponse
 // @Failure 500 {object} models.ErrorResponse
 // @Router /api/meta/categories/{id} [put]
 func (c *CategoryMetaController) update(g *gin.Context) {
@@ -164,28 +200,108 @@ func (c *CategoryMetaController) update(g *gin.Context) {
 		return
 	}
 
-	category := &storage.VotingCategory{
-		ID:          id,
-		Name:        req.Name,
-		Description: req.Description,
-		Weight:      req.Weight,
+	category, ok := c.getForUpdate(g, id)
+	if !ok {
+		return
+	}
+	category.Name = req.Name
+	category.Description = req.Description
+	category.Weight = req.Weight
+	if req.Version != nil {
+		category.Version = *req.Version
 	}
 
 	if err := c.storage.Update(g.Request.Context(), category); err != nil {
-		logging.Log.Errorf("META: failed to update category: %v", err)
-		g.JSON(http.StatusInternalServerError, models.ErrorResponse{Error: err.Error()})
+		writeStorageError(g, "category", err)
 		return
 	}
-	g.JSON(http.StatusOK, models.TransformVotingCategoryFromStorage(category))
+	response := models.TransformVotingCategoryFromStorage(category)
+	auditAfter(g, response)
+	g.JSON(http.StatusOK, response)
+}
+
+// @Security AdminToken
+// @Summary Update some fields of a voting category
+// @Description Only the fields present in the body are changed. The version must match the stored category, otherwise 409 is returned
+// @Tags Meta/Categories
+// @Accept json
+// @Produce json
+// @Param id path int true "Category ID"
+// @Param category body models.VotingCategoryPatchRequest true "Fields to change"
+// @Success 200 {object} models.VotingCategoryResponse
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 409 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /api/meta/categories/{id} [patch]
+func (c *CategoryMetaController) patch(g *gin.Context) {
+	id, err := strconv.Atoi(g.Param("id"))
+	if err != nil {
+		g.JSON(http.StatusBadRequest, models.ErrorResponse{Error: "invalid category id"})
+		return
+	}
+
+	var req models.VotingCategoryPatchRequest
+	if err := g.ShouldBindJSON(&req); err != nil {
+		logging.Log.Errorf("META: invalid patch category request: %v", err)
+		g.JSON(http.StatusBadRequest, models.ErrorResponse{Error: "invalid request"})
+		return
+	}
+	if req.Version == nil {
+		g.JSON(http.StatusBadRequest, models.ErrorResponse{Error: errMissingVersion.Error()})
+		return
+	}
+	if req.Name != nil && *req.Name == "" {
+		g.JSON(http.StatusBadRequest, models.ErrorResponse{Error: "invalid request empty name"})
+		return
+	}
+
+	category, ok := c.getForUpdate(g, id)
+	if !ok {
+		return
+	}
+	if req.Name != nil {
+		category.Name = *req.Name
+	}
+	if req.Description != nil {
+		category.Description = *req.Description
+	}
+	if req.Weight != nil {
+		category.Weight = *req.Weight
+	}
+	category.Version = *req.Version
+
+	if err := c.storage.Update(g.Request.Context(), category); err != nil {
+		writeStorageError(g, "category", err)
+		return
+	}
+	response := models.TransformVotingCategoryFromStorage(category)
+	auditAfter(g, response)
+	g.JSON(http.StatusOK, response)
+}
+
+// getForUpdate loads the category an update applies to, writing the error response if there is none
+func (c *CategoryMetaController) getForUpdate(g *gin.Context, id int) (*storage.VotingCategory, bool) {
+	category, err := c.storage.Get(g.Request.Context(), id)
+	if err != nil {
+		writeStorageError(g, "category", err)
+		return nil, false
+	}
+	auditBefore(g, models.TransformVotingCategoryFromStorage(category))
+	return category, true
 }
 
 // @Security AdminToken
 // @Summary Delete a voting category
+// @Description Fails with 409 while votes point at the category, unless cascade is set.
+// @Description A cascade deletes those votes. Archive the category to keep the results intact.
 // @Tags Meta/Categories
 // @Produce json
 // @Param id path int true "Category ID"
+// @Param cascade query bool false "Also delete the votes in the category"
 // @Success 200 {object} map[string]string
 // @Failure 400 {object} models.ErrorResponse
+// @Failure 409 {object} models.DeleteConflictResponse
 // @Failure 500 {object} models.ErrorResponse
 // @Router /api/meta/categories/{id} [delete]
 func (c *CategoryMetaController) delete(g *gin.Context) {
@@ -195,10 +311,94 @@ func (c *CategoryMetaController) delete(g *gin.Context) {
 		g.JSON(http.StatusBadRequest, models.ErrorResponse{Error: "invalid category id"})
 		return
 	}
+
+	// A missing category is left to Delete, which decides how to answer
+	if category, err := c.storage.Get(g.Request.Context(), id); err == nil {
+		auditBefore(g, models.TransformVotingCategoryFromStorage(category))
+	}
+
+	refs, err := c.references.forCategory(g.Request.Context(), id)
+	if err != nil {
+		logging.Log.Errorf("META: failed to look up references to category %d: %v", id, err)
+		g.JSON(storageErrorStatus(err), models.ErrorResponse{Error: err.Error()})
+		return
+	}
+	if !refs.empty() {
+		if g.Query("cascade") != "true" {
+			logging.Log.Warnf("META: delete of category %d blocked by %d votes", id, len(refs.votes))
+			g.JSON(http.StatusConflict, models.DeleteConflictResponse{
+				Error: "category has votes, archive it or delete with cascade=true",
+				Votes: len(refs.votes),
+			})
+			return
+		}
+		if err := c.references.cascade(g, refs); err != nil {
+			logging.Log.Errorf("META: failed to cascade delete of category %d: %v", id, err)
+			g.JSON(storageErrorStatus(err), models.ErrorResponse{Error: err.Error()})
+			return
+		}
+		auditAfter(g, gin.H{"deletedVotes": len(refs.votes)})
+	}
+
 	if err := c.storage.Delete(g.Request.Context(), id); err != nil {
 		logging.Log.Errorf("META: failed to delete category: %v", err)
-		g.JSON(http.StatusInternalServerError, models.ErrorResponse{Error: err.Error()})
+		g.JSON(storageErrorStatus(err), models.ErrorResponse{Error: err.Error()})
 		return
 	}
 	g.JSON(http.StatusOK, gin.H{"message": "category deleted"})
 }
+
+// @Security AdminToken
+// @Summary Archive a voting category
+// @Description Hides the category from the public category list. Its votes still count in the results
+// @Tags Meta/Categories
+// @Produce json
+// @Param id path int true "Category ID"
+// @Success 200 {object} models.VotingCategoryResponse
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 409 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /api/meta/categories/{id}/archive [post]
+func (c *CategoryMetaController) archive(g *gin.Context) {
+	c.setArchived(g, true)
+}
+
+// @Security AdminToken
+// @Summary Restore an archived voting category
+// @Tags Meta/Categories
+// @Produce json
+// @Param id path int true "Category ID"
+// @Success 200 {object} models.VotingCategoryResponse
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 409 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /api/meta/categories/{id}/restore [post]
+func (c *CategoryMetaController) restore(g *gin.Context) {
+	c.setArchived(g, false)
+}
+
+func (c *CategoryMetaController) setArchived(g *gin.Context, archived bool) {
+	id, err := strconv.Atoi(g.Param("id"))
+	if err != nil {
+		g.JSON(http.StatusBadRequest, models.ErrorResponse{Error: "invalid category id"})
+		return
+	}
+
+	category, ok := c.getForUpdate(g, id)
+	if !ok {
+		return
+	}
+	if category.Archived != archived {
+		category.Archived = archived
+		if err := c.storage.Update(g.Request.Context(), category); err != nil {
+			writeStorageError(g, "category", err)
+			return
+		}
+		logging.Log.Infof("META: category %d archived: %t", id, archived)
+	}
+	response := models.TransformVotingCategoryFromStorage(category)
+	auditAfter(g, response)
+	g.JSON(http.StatusOK, response)
+}
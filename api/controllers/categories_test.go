@@ -7,10 +7,7 @@ import (
 	"github.com/alex-pricope/simple-voting-system/api/models"
 	"github.com/alex-pricope/simple-voting-system/logging"
 	"github.com/alex-pricope/simple-voting-system/storage"
-	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/config"
-	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
-	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/alex-pricope/simple-voting-system/storage/memory"
 	"github.com/gin-gonic/gin"
 	"github.com/sirupsen/logrus"
 	"net/http"
@@ -22,63 +19,27 @@ import (
 	"github.com/stretchr/testify/require"
 )
 
-//nolint:staticcheck
 func setupCategoryTestController(t *testing.T) (*CategoryMetaController, *gin.Engine) {
 	t.Helper()
 	logging.Log = logrus.New()
 
-	cfg, err := config.LoadDefaultConfig(context.TODO(),
-		config.WithRegion("us-east-1"),
-		config.WithEndpointResolverWithOptions(
-			aws.EndpointResolverWithOptionsFunc(func(service, region string, options ...interface{}) (aws.Endpoint, error) {
-				return aws.Endpoint{URL: "http://localhost:4566", HostnameImmutable: true}, nil
-			}),
-		),
-	)
-	require.NoError(t, err, "failed to load config")
-
-	client := dynamodb.NewFromConfig(cfg)
-	t.Cleanup(func() {
-		cleanupCategoryTable(t, client, "VotingCategories")
-	})
-
-	s := &storage.DynamoVotingCategoryStorage{
-		Client:    client,
-		TableName: "VotingCategories",
-	}
+	s := memory.NewVotingCategoryStorage()
 
-	controller := NewCategoryMetaController(s)
+	controller := NewCategoryMetaController(s, memory.NewVoteStorage(), memory.NewVotingCodesStorage(), nil, nil)
 	gin.SetMode(gin.TestMode)
 	r := gin.New()
 	r.POST("/api/meta/categories", controller.create)
 	r.PUT("/api/meta/categories/:id", controller.update)
+	r.PATCH("/api/meta/categories/:id", controller.patch)
 	r.GET("/api/meta/categories/:id", controller.get)
 	r.GET("/api/meta/categories", controller.getAll)
 	r.DELETE("/api/meta/categories/:id", controller.delete)
+	r.POST("/api/meta/categories/:id/archive", controller.archive)
+	r.POST("/api/meta/categories/:id/restore", controller.restore)
 
 	return controller, r
 }
 
-func cleanupCategoryTable(t *testing.T, client *dynamodb.Client, tableName string) {
-	t.Helper()
-
-	out, err := client.Scan(context.TODO(), &dynamodb.ScanInput{
-		TableName: aws.String(tableName),
-	})
-	require.NoError(t, err, "cleanup failed to scan %s", tableName)
-
-	for _, item := range out.Items {
-		key := map[string]types.AttributeValue{
-			"PK": item["PK"],
-		}
-		_, err := client.DeleteItem(context.TODO(), &dynamodb.DeleteItemInput{
-			TableName: aws.String(tableName),
-			Key:       key,
-		})
-		require.NoError(t, err, "cleanup failed to delete item")
-	}
-}
-
 func TestDeleteVotingCategory(t *testing.T) {
 	_, router := setupCategoryTestController(t)
 
@@ -340,3 +301,110 @@ func TestListVotingCategories(t *testing.T) {
 		assert.Equal(t, expected[cat.ID], cat.Name, "unexpected category data")
 	}
 }
+
+func TestPatchVotingCategory(t *testing.T) {
+	_, router := setupCategoryTestController(t)
+	headers := map[string]string{"x-admin-token": "secret"}
+
+	createReq := models.VotingCategoryCreateRequest{ID: 300, Name: "Jury", Description: "Keep me", Weight: 0.5}
+	res := testutils.PerformRequest(router, http.MethodPost, "/api/meta/categories", createReq, headers)
+	require.Equal(t, http.StatusOK, res.Code)
+
+	t.Run("Happy path - only the given fields change", func(t *testing.T) {
+		name, version := "Grand Jury", 1
+		res := testutils.PerformRequest(router, http.MethodPatch, "/api/meta/categories/300",
+			models.VotingCategoryPatchRequest{Name: &name, Version: &version}, headers)
+		require.Equal(t, http.StatusOK, res.Code, res.Body.String())
+
+		var updated models.VotingCategoryResponse
+		require.NoError(t, json.Unmarshal(res.Body.Bytes(), &updated))
+		assert.Equal(t, "Grand Jury", updated.Name)
+		assert.Equal(t, "Keep me", updated.Description)
+		assert.Equal(t, 0.5, updated.Weight)
+		assert.Equal(t, 2, updated.Version)
+	})
+
+	t.Run("Unhappy path - stale version", func(t *testing.T) {
+		weight, version := 0.1, 1
+		res := testutils.PerformRequest(router, http.MethodPatch, "/api/meta/categories/300",
+			models.VotingCategoryPatchRequest{Weight: &weight, Version: &version}, headers)
+		require.Equal(t, http.StatusConflict, res.Code, res.Body.String())
+	})
+
+	t.Run("Unhappy path - missing version", func(t *testing.T) {
+		weight := 0.1
+		res := testutils.PerformRequest(router, http.MethodPatch, "/api/meta/categories/300",
+			models.VotingCategoryPatchRequest{Weight: &weight}, headers)
+		require.Equal(t, http.StatusBadRequest, res.Code, res.Body.String())
+	})
+
+	t.Run("Unhappy path - unknown ID is not created", func(t *testing.T) {
+		version := 1
+		res := testutils.PerformRequest(router, http.MethodPatch, "/api/meta/categories/999",
+			models.VotingCategoryPatchRequest{Version: &version}, headers)
+		require.Equal(t, http.StatusNotFound, res.Code, res.Body.String())
+
+		res = testutils.PerformRequest(router, http.MethodPut, "/api/meta/categories/999",
+			models.VotingCategoryUpdateRequest{Name: "Ghost"}, headers)
+		require.Equal(t, http.StatusNotFound, res.Code, res.Body.String())
+	})
+}
+
+func TestDeleteReferencedVotingCategory(t *testing.T) {
+	controller, router := setupCategoryTestController(t)
+	headers := map[string]string{"x-admin-token": "secret"}
+	ctx := context.TODO()
+
+	res := testutils.PerformRequest(router, http.MethodPost, "/api/meta/categories", models.VotingCategoryCreateRequest{ID: 1, Name: "Voted"}, headers)
+	require.Equal(t, http.StatusOK, res.Code)
+	require.NoError(t, controller.references.votes.Create(ctx, &storage.Vote{Code: "AAAAA", SortKey: "cat#1#team#1", CategoryID: 1, TeamID: 1, Rating: 4}))
+	require.NoError(t, controller.references.votes.Create(ctx, &storage.Vote{Code: "AAAAA", SortKey: "cat#2#team#1", CategoryID: 2, TeamID: 1, Rating: 3}))
+
+	res = testutils.PerformRequest(router, http.MethodDelete, "/api/meta/categories/1", nil, headers)
+	require.Equal(t, http.StatusConflict, res.Code, res.Body.String())
+	var conflict models.DeleteConflictResponse
+	require.NoError(t, json.Unmarshal(res.Body.Bytes(), &conflict))
+	assert.Equal(t, 1, conflict.Votes)
+	assert.Equal(t, 0, conflict.Codes)
+
+	res = testutils.PerformRequest(router, http.MethodDelete, "/api/meta/categories/1?cascade=true", nil, headers)
+	require.Equal(t, http.StatusOK, res.Code, res.Body.String())
+
+	votes, err := controller.references.votes.GetByCode(ctx, "AAAAA")
+	require.NoError(t, err)
+	require.Len(t, votes, 1)
+	assert.Equal(t, 2, votes[0].CategoryID)
+}
+
+func TestArchiveVotingCategory(t *testing.T) {
+	t.Setenv("ADMIN_TOKEN", "secret")
+	_, router := setupCategoryTestController(t)
+	headers := map[string]string{"x-admin-token": "secret"}
+
+	res := testutils.PerformRequest(router, http.MethodPost, "/api/meta/categories", models.VotingCategoryCreateRequest{ID: 1, Name: "Old"}, headers)
+	require.Equal(t, http.StatusOK, res.Code)
+
+	res = testutils.PerformRequest(router, http.MethodPost, "/api/meta/categories/1/archive", nil, headers)
+	require.Equal(t, http.StatusOK, res.Code, res.Body.String())
+
+	var categories []models.VotingCategoryResponse
+	res = testutils.PerformRequest(router, http.MethodGet, "/api/meta/categories", nil, nil)
+	require.NoError(t, json.Unmarshal(res.Body.Bytes(), &categories))
+	assert.Empty(t, categories)
+
+	res = testutils.PerformRequest(router, http.MethodGet, "/api/meta/categories?includeArchived=true", nil, headers)
+	require.NoError(t, json.Unmarshal(res.Body.Bytes(), &categories))
+	require.Len(t, categories, 1)
+	assert.True(t, categories[0].Archived)
+	res = testutils.PerformRequest(router, http.MethodGet, "/api/meta/categories/1", nil, nil)
+	assert.Equal(t, http.StatusNotFound, res.Code, "the public route hides an archived category")
+	res = testutils.PerformRequest(router, http.MethodGet, "/api/meta/categories/1?includeArchived=true", nil, headers)
+	assert.Equal(t, http.StatusOK, res.Code)
+
+	res = testutils.PerformRequest(router, http.MethodPost, "/api/meta/categories/1/restore", nil, headers)
+	require.Equal(t, http.StatusOK, res.Code, res.Body.String())
+	res = testutils.PerformRequest(router, http.MethodGet, "/api/meta/categories", nil, nil)
+	require.NoError(t, json.Unmarshal(res.Body.Bytes(), &categories))
+	require.Len(t, categories, 1)
+	assert.False(t, categories[0].Archived)
+}
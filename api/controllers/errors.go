@@ -0,0 +1,43 @@
+package controllers
+
+import (
+	"errors"
+	"github.com/alex-pricope/simple-voting-system/api/models"
+	"github.com/alex-pricope/simple-voting-system/logging"
+	"github.com/alex-pricope/simple-voting-system/storage"
+	"github.com/gin-gonic/gin"
+	"net/http"
+)
+
+// storageErrorStatus maps the kind of a storage error to the HTTP status of the response.
+// Errors without a kind are internal errors.
+func storageErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, storage.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, storage.ErrAlreadyExists), errors.Is(err, storage.ErrConflict):
+		return http.StatusConflict
+	case errors.Is(err, storage.ErrThrottled):
+		return http.StatusTooManyRequests
+	case errors.Is(err, storage.ErrUnavailable):
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// writeStorageError writes the response for a failed storage call on entity.
+// A not found error names the entity, the other errors keep their own message.
+func writeStorageError(g *gin.Context, entity string, err error) {
+	status := storageErrorStatus(err)
+	message := err.Error()
+	switch {
+	case status == http.StatusNotFound:
+		message = entity + " not found"
+	case status < http.StatusInternalServerError:
+		logging.Log.Warnf("STORAGE: %s request rejected: %v", entity, err)
+	default:
+		logging.Log.Errorf("STORAGE: %s request failed: %v", entity, err)
+	}
+	g.JSON(status, models.ErrorResponse{Error: message})
+}
@@ -0,0 +1,19 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"github.com/alex-pricope/simple-voting-system/storage"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"testing"
+)
+
+func TestStorageErrorStatus(t *testing.T) {
+	assert.Equal(t, http.StatusNotFound, storageErrorStatus(storage.ErrNotFound))
+	assert.Equal(t, http.StatusConflict, storageErrorStatus(storage.ErrAlreadyExists))
+	assert.Equal(t, http.StatusConflict, storageErrorStatus(storage.ErrVersionConflict))
+	assert.Equal(t, http.StatusTooManyRequests, storageErrorStatus(fmt.Errorf("%w: slow down", storage.ErrThrottled)))
+	assert.Equal(t, http.StatusServiceUnavailable, storageErrorStatus(fmt.Errorf("%w: timeout", storage.ErrUnavailable)))
+	assert.Equal(t, http.StatusInternalServerError, storageErrorStatus(errors.New("boom")))
+}
@@ -0,0 +1,341 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"github.com/alex-pricope/simple-voting-system/api/models"
+	"github.com/alex-pricope/simple-voting-system/api/transport"
+	"github.com/alex-pricope/simple-voting-system/logging"
+	"github.com/alex-pricope/simple-voting-system/storage"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// eventIDPattern keeps event IDs usable as a suffix of DynamoDB and SQLite table names
+var eventIDPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,39}$`)
+
+// eventCacheTTL is how long an event read by a request is reused. Archiving on another instance
+// is seen here after at most this long, changes made through this instance are seen at once.
+const eventCacheTTL = 30 * time.Second
+
+// eventProvisionTimeout bounds the creation of the storage of an event created through the API
+const eventProvisionTimeout = 5 * time.Minute
+
+// EventHandlerFactory builds the handler serving the /api routes of one event, on top of the storages of the event.
+// It only opens the storages, it does not create them.
+type EventHandlerFactory func(event *storage.Event) (http.Handler, error)
+
+// EventProvisioner creates the storage of an event when it is missing, e.g. its DynamoDB tables
+type EventProvisioner func(ctx context.Context, event *storage.Event) error
+
+// EventController manages the events and hands every event scoped request to the handler of its event.
+// /api/events/{eventId}/... is served as /api/... of that event, the other /api routes go to the default event.
+// Creating the storage of an event, which can take minutes with DynamoDB, is kept out of the requests:
+// every stored event is provisioned by Init on startup, and an event created through the API in the background.
+type EventController struct {
+	storage      storage.EventStorage
+	defaultEvent string
+	newHandler   EventHandlerFactory
+	provision    EventProvisioner // Nil when the storage of the events is created by hand
+
+	mu       sync.Mutex
+	handlers map[string]http.Handler // by namespace
+	events   map[string]cachedEvent  // by ID
+}
+
+type cachedEvent struct {
+	event   *storage.Event
+	expires time.Time
+}
+
+func NewEventController(s storage.EventStorage, defaultEvent string, newHandler EventHandlerFactory, provision EventProvisioner) *EventController {
+	return &EventController{
+		storage:      s,
+		defaultEvent: defaultEvent,
+		newHandler:   newHandler,
+		provision:    provision,
+		handlers:     make(map[string]http.Handler),
+		events:       make(map[string]cachedEvent),
+	}
+}
+
+func (c *EventController) RegisterRoutes(engine *gin.Engine) {
+	group := engine.Group("/api/admin/events", transport.AdminAuthMiddleware())
+	group.GET("", c.list)
+	group.POST("", c.create)
+	group.POST("/:id/archive", c.archive)
+	group.POST("/:id/restore", c.restore)
+
+	engine.Any("/api/events/:eventId/*path", c.serveEvent)
+	engine.NoRoute(c.serveDefault)
+}
+
+// Init creates the default event on first start, provisions the storage of every event and opens the storages
+// of the default one, so a broken setup fails on startup. An event that cannot be provisioned is only logged,
+// its requests fail until its storage exists.
+// The first default event takes over the unsuffixed tables that existed before events.
+func (c *EventController) Init(ctx context.Context) error {
+	event, err := c.storage.Get(ctx, c.defaultEvent)
+	if errors.Is(err, storage.ErrNotFound) {
+		event, err = c.createDefault(ctx)
+	}
+	if err != nil {
+		return err
+	}
+	if c.provision != nil {
+		if err := c.provision(ctx, event); err != nil {
+			return err
+		}
+		events, err := c.storage.GetAll(ctx)
+		if err != nil {
+			return err
+		}
+		for _, e := range events {
+			if e.ID == event.ID {
+				continue
+			}
+			if err := c.provision(ctx, e); err != nil {
+				logging.Log.Warnf("EVENT: failed to provision the storage of event %s: %v", e.ID, err)
+			}
+		}
+	}
+	_, err = c.handler(event)
+	return err
+}
+
+func (c *EventController) createDefault(ctx context.Context) (*storage.Event, error) {
+	events, err := c.storage.GetAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+	event := &storage.Event{ID: c.defaultEvent, Name: c.defaultEvent, CreatedAt: time.Now().UTC()}
+	for _, e := range events {
+		if e.Namespace == "" {
+			// Another event owns the unsuffixed tables, the default event was changed in the config
+			event.Namespace = c.defaultEvent
+		}
+	}
+
+	err = c.storage.Create(ctx, event)
+	if errors.Is(err, storage.ErrAlreadyExists) {
+		// Another instance created it at the same time
+		return c.storage.Get(ctx, c.defaultEvent)
+	}
+	if err != nil {
+		return nil, err
+	}
+	logging.Log.Infof("EVENT: created default event %s", event.ID)
+	return event, nil
+}
+
+// handler returns the handler of the event, building it on first use
+func (c *EventController) handler(event *storage.Event) (http.Handler, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if handler, ok := c.handlers[event.Namespace]; ok {
+		return handler, nil
+	}
+	handler, err := c.newHandler(event)
+	if err != nil {
+		logging.Log.Errorf("EVENT: failed to open the storages of event %s: %v", event.ID, err)
+		return nil, err
+	}
+	c.handlers[event.Namespace] = handler
+	return handler, nil
+}
+
+func (c *EventController) serveEvent(g *gin.Context) {
+	c.serve(g, g.Param("eventId"), "/api"+g.Param("path"))
+}
+
+func (c *EventController) serveDefault(g *gin.Context) {
+	if !strings.HasPrefix(g.Request.URL.Path, "/api/") {
+		transport.NoRouteHandler()(g)
+		return
+	}
+	c.serve(g, c.defaultEvent, g.Request.URL.Path)
+}
+
+// event returns the event, read from the storage at most once per eventCacheTTL
+func (c *EventController) event(ctx context.Context, id string) (*storage.Event, error) {
+	c.mu.Lock()
+	cached, ok := c.events[id]
+	c.mu.Unlock()
+	if ok && time.Now().Before(cached.expires) {
+		return cached.event, nil
+	}
+
+	event, err := c.storage.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	c.cacheEvent(event)
+	return event, nil
+}
+
+func (c *EventController) cacheEvent(event *storage.Event) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.events[event.ID] = cachedEvent{event: event, expires: time.Now().Add(eventCacheTTL)}
+}
+
+// serve hands the request to the handler of the event as a request for path
+func (c *EventController) serve(g *gin.Context, eventID, path string) {
+	event, err := c.event(g.Request.Context(), eventID)
+	if err != nil {
+		writeStorageError(g, "event", err)
+		return
+	}
+	if event.Archived && g.Request.Method != http.MethodGet {
+		g.JSON(http.StatusConflict, models.ErrorResponse{Error: "event is archived, restore it to make changes"})
+		return
+	}
+	handler, err := c.handler(event)
+	if errors.Is(err, storage.ErrTableMissing) {
+		g.JSON(http.StatusServiceUnavailable, models.ErrorResponse{Error: "the storage of the event is not created yet"})
+		return
+	}
+	if err != nil {
+		g.JSON(storageErrorStatus(err), models.ErrorResponse{Error: "could not open the storage of the event"})
+		return
+	}
+
+	req := g.Request.Clone(g.Request.Context())
+	req.URL.Path = path
+	req.URL.RawPath = ""
+	handler.ServeHTTP(g.Writer, req)
+}
+
+// @Security AdminToken
+// list godoc
+// @Summary List the events
+// @Tags events
+// @Produce json
+// @Success 200 {array} models.EventResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /api/admin/events [get]
+func (c *EventController) list(g *gin.Context) {
+	events, err := c.storage.GetAll(g.Request.Context())
+	if err != nil {
+		logging.Log.Errorf("EVENT: failed to list events: %v", err)
+		g.JSON(storageErrorStatus(err), models.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	response := make([]models.EventResponse, 0, len(events))
+	for _, event := range events {
+		response = append(response, models.TransformEventFromStorage(event, c.defaultEvent))
+	}
+	g.JSON(http.StatusOK, response)
+}
+
+// @Security AdminToken
+// create godoc
+// @Summary Create an event
+// @Description Creates the event, its routes are /api/events/{id}/... Its tables are created in the background when storage.createTables
+// @Description is set, or by hand; until they exist its routes answer 503
+// @Tags events
+// @Accept json
+// @Produce json
+// @Param request body models.EventCreateRequest true "Event, the id is lowercase letters, digits and dashes"
+// @Success 200 {object} models.EventResponse
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 409 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /api/admin/events [post]
+func (c *EventController) create(g *gin.Context) {
+	var req models.EventCreateRequest
+	if err := g.ShouldBindJSON(&req); err != nil {
+		g.JSON(http.StatusBadRequest, models.ErrorResponse{Error: "invalid request format"})
+		return
+	}
+	if !eventIDPattern.MatchString(req.ID) {
+		g.JSON(http.StatusBadRequest, models.ErrorResponse{Error: "id must be 1-40 lowercase letters, digits or dashes"})
+		return
+	}
+	if req.Name == "" {
+		req.Name = req.ID
+	}
+
+	event := &storage.Event{ID: req.ID, Name: req.Name, Namespace: req.ID, CreatedAt: time.Now().UTC()}
+	if existing, err := c.storage.Get(g.Request.Context(), event.ID); err == nil {
+		g.JSON(http.StatusConflict, models.ErrorResponse{Error: "event " + existing.ID + " already exists"})
+		return
+	}
+	if err := c.storage.Create(g.Request.Context(), event); err != nil {
+		writeStorageError(g, "event", err)
+		return
+	}
+	if c.provision != nil {
+		go c.provisionInBackground(event)
+	}
+
+	response := models.TransformEventFromStorage(event, c.defaultEvent)
+	auditAfter(g, response)
+	logging.Log.Infof("EVENT: created event %s", event.ID)
+	g.JSON(http.StatusOK, response)
+}
+
+// provisionInBackground creates the storage of a new event after its request was answered.
+// If it does not finish, e.g. because the instance is stopped, the next Init provisions the event.
+func (c *EventController) provisionInBackground(event *storage.Event) {
+	ctx, cancel := context.WithTimeout(context.Background(), eventProvisionTimeout)
+	defer cancel()
+	if err := c.provision(ctx, event); err != nil {
+		logging.Log.Errorf("EVENT: failed to provision the storage of event %s: %v", event.ID, err)
+		return
+	}
+	logging.Log.Infof("EVENT: provisioned the storage of event %s", event.ID)
+}
+
+// @Security AdminToken
+// archive godoc
+// @Summary Archive an event
+// @Description The event stays readable, its results included, but every change is rejected until it is restored
+// @Tags events
+// @Produce json
+// @Param id path string true "Event ID"
+// @Success 200 {object} models.EventResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /api/admin/events/{id}/archive [post]
+func (c *EventController) archive(g *gin.Context) {
+	c.setArchived(g, true)
+}
+
+// @Security AdminToken
+// restore godoc
+// @Summary Restore an archived event
+// @Tags events
+// @Produce json
+// @Param id path string true "Event ID"
+// @Success 200 {object} models.EventResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /api/admin/events/{id}/restore [post]
+func (c *EventController) restore(g *gin.Context) {
+	c.setArchived(g, false)
+}
+
+func (c *EventController) setArchived(g *gin.Context, archived bool) {
+	id := g.Param("id")
+	if err := c.storage.SetArchived(g.Request.Context(), id, archived); err != nil {
+		writeStorageError(g, "event", err)
+		return
+	}
+	event, err := c.storage.Get(g.Request.Context(), id)
+	if err != nil {
+		writeStorageError(g, "event", err)
+		return
+	}
+	c.cacheEvent(event)
+	response := models.TransformEventFromStorage(event, c.defaultEvent)
+	auditAfter(g, response)
+	logging.Log.Infof("EVENT: set archived=%t on event %s", archived, id)
+	g.JSON(http.StatusOK, response)
+}
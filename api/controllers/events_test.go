@@ -0,0 +1,257 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	testutils "github.com/alex-pricope/simple-voting-system/api/controllers/testing"
+	"github.com/alex-pricope/simple-voting-system/api/models"
+	"github.com/alex-pricope/simple-voting-system/api/transport"
+	"github.com/alex-pricope/simple-voting-system/logging"
+	"github.com/alex-pricope/simple-voting-system/storage"
+	"github.com/alex-pricope/simple-voting-system/storage/memory"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// setupTestEventController serves the team routes of every event from its own memory storage
+func setupTestEventController(t *testing.T) (*EventController, *gin.Engine) {
+	t.Helper()
+	logging.Log = logrus.New()
+	t.Setenv("ADMIN_TOKEN", "secret")
+
+	newHandler := func(event *storage.Event) (http.Handler, error) {
+		r := gin.New()
+		r.NoRoute(transport.NoRouteHandler())
+		NewTeamMetaController(memory.NewTeamStorage(), memory.NewVoteStorage(), memory.NewVotingCodesStorage(), nil, nil).RegisterRoutes(r)
+		return r, nil
+	}
+
+	controller := NewEventController(memory.NewEventStorage(), "default", newHandler, nil)
+	require.NoError(t, controller.Init(context.Background()))
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	controller.RegisterRoutes(r)
+
+	return controller, r
+}
+
+func TestCreateEvent(t *testing.T) {
+	_, router := setupTestEventController(t)
+	admin := map[string]string{"x-admin-token": "secret"}
+
+	t.Run("Happy path - create and list", func(t *testing.T) {
+		res := testutils.PerformRequest(router, http.MethodPost, "/api/admin/events", models.EventCreateRequest{ID: "spring-2025", Name: "Spring hackathon"}, admin)
+		require.Equal(t, http.StatusOK, res.Code)
+
+		res = testutils.PerformRequest(router, http.MethodGet, "/api/admin/events", nil, admin)
+		require.Equal(t, http.StatusOK, res.Code)
+		var events []models.EventResponse
+		require.NoError(t, json.Unmarshal(res.Body.Bytes(), &events))
+		require.Len(t, events, 2)
+		assert.Equal(t, "default", events[0].ID)
+		assert.True(t, events[0].Default)
+		assert.Equal(t, "spring-2025", events[1].ID)
+		assert.Equal(t, "Spring hackathon", events[1].Name)
+		assert.False(t, events[1].Default)
+	})
+
+	t.Run("Existing event is a conflict", func(t *testing.T) {
+		res := testutils.PerformRequest(router, http.MethodPost, "/api/admin/events", models.EventCreateRequest{ID: "default"}, admin)
+		assert.Equal(t, http.StatusConflict, res.Code)
+	})
+
+	t.Run("Invalid id is rejected", func(t *testing.T) {
+		for _, id := range []string{"", "Upper", "-dash", "with space", "a/b"} {
+			res := testutils.PerformRequest(router, http.MethodPost, "/api/admin/events", models.EventCreateRequest{ID: id}, admin)
+			assert.Equal(t, http.StatusBadRequest, res.Code, id)
+		}
+	})
+
+	t.Run("Missing admin token", func(t *testing.T) {
+		res := testutils.PerformRequest(router, http.MethodPost, "/api/admin/events", models.EventCreateRequest{ID: "other"}, nil)
+		assert.Equal(t, http.StatusUnauthorized, res.Code)
+	})
+}
+
+func TestEventScopedRoutes(t *testing.T) {
+	_, router := setupTestEventController(t)
+	admin := map[string]string{"x-admin-token": "secret"}
+
+	res := testutils.PerformRequest(router, http.MethodPost, "/api/admin/events", models.EventCreateRequest{ID: "spring"}, admin)
+	require.Equal(t, http.StatusOK, res.Code)
+
+	teamsOf := func(path string) []models.TeamResponse {
+		res := testutils.PerformRequest(router, http.MethodGet, path, nil, nil)
+		require.Equal(t, http.StatusOK, res.Code)
+		var teams []models.TeamResponse
+		require.NoError(t, json.Unmarshal(res.Body.Bytes(), &teams))
+		return teams
+	}
+
+	t.Run("Events do not share data", func(t *testing.T) {
+		res := testutils.PerformRequest(router, http.MethodPost, "/api/events/spring/meta/teams", models.TeamCreateRequest{ID: 1, Name: "Spring team"}, admin)
+		require.Equal(t, http.StatusOK, res.Code)
+
+		teams := teamsOf("/api/events/spring/meta/teams")
+		require.Len(t, teams, 1)
+		assert.Equal(t, "Spring team", teams[0].Name)
+		assert.Empty(t, teamsOf("/api/events/default/meta/teams"))
+	})
+
+	t.Run("Unscoped routes are served by the default event", func(t *testing.T) {
+		res := testutils.PerformRequest(router, http.MethodPost, "/api/meta/teams", models.TeamCreateRequest{ID: 2, Name: "Default team"}, admin)
+		require.Equal(t, http.StatusOK, res.Code)
+
+		teams := teamsOf("/api/events/default/meta/teams")
+		require.Len(t, teams, 1)
+		assert.Equal(t, "Default team", teams[0].Name)
+		assert.Len(t, teamsOf("/api/meta/teams"), 1)
+	})
+
+	t.Run("Unknown event", func(t *testing.T) {
+		res := testutils.PerformRequest(router, http.MethodGet, "/api/events/unknown/meta/teams", nil, nil)
+		assert.Equal(t, http.StatusNotFound, res.Code)
+	})
+
+	t.Run("Unknown route of an event", func(t *testing.T) {
+		res := testutils.PerformRequest(router, http.MethodGet, "/api/events/spring/unknown", nil, nil)
+		assert.Equal(t, http.StatusNotFound, res.Code)
+	})
+}
+
+func TestArchiveEvent(t *testing.T) {
+	_, router := setupTestEventController(t)
+	admin := map[string]string{"x-admin-token": "secret"}
+
+	res := testutils.PerformRequest(router, http.MethodPost, "/api/admin/events", models.EventCreateRequest{ID: "spring"}, admin)
+	require.Equal(t, http.StatusOK, res.Code)
+	res = testutils.PerformRequest(router, http.MethodPost, "/api/events/spring/meta/teams", models.TeamCreateRequest{ID: 1, Name: "Team"}, admin)
+	require.Equal(t, http.StatusOK, res.Code)
+
+	t.Run("Archived event is read only", func(t *testing.T) {
+		res := testutils.PerformRequest(router, http.MethodPost, "/api/admin/events/spring/archive", nil, admin)
+		require.Equal(t, http.StatusOK, res.Code)
+		var event models.EventResponse
+		require.NoError(t, json.Unmarshal(res.Body.Bytes(), &event))
+		assert.True(t, event.Archived)
+
+		res = testutils.PerformRequest(router, http.MethodGet, "/api/events/spring/meta/teams", nil, nil)
+		assert.Equal(t, http.StatusOK, res.Code)
+		res = testutils.PerformRequest(router, http.MethodPost, "/api/events/spring/meta/teams", models.TeamCreateRequest{ID: 2, Name: "Late team"}, admin)
+		assert.Equal(t, http.StatusConflict, res.Code)
+	})
+
+	t.Run("Restored event accepts changes again", func(t *testing.T) {
+		res := testutils.PerformRequest(router, http.MethodPost, "/api/admin/events/spring/restore", nil, admin)
+		require.Equal(t, http.StatusOK, res.Code)
+
+		res = testutils.PerformRequest(router, http.MethodPost, "/api/events/spring/meta/teams", models.TeamCreateRequest{ID: 2, Name: "Late team"}, admin)
+		assert.Equal(t, http.StatusOK, res.Code)
+	})
+
+	t.Run("Unknown event", func(t *testing.T) {
+		res := testutils.PerformRequest(router, http.MethodPost, "/api/admin/events/unknown/archive", nil, admin)
+		assert.Equal(t, http.StatusNotFound, res.Code)
+	})
+}
+
+func TestDefaultEventNamespace(t *testing.T) {
+	logging.Log = logrus.New()
+	events := memory.NewEventStorage()
+	var namespaces []string
+	newHandler := func(event *storage.Event) (http.Handler, error) {
+		namespaces = append(namespaces, event.Namespace)
+		return gin.New(), nil
+	}
+
+	// The first default event takes over the unsuffixed tables
+	require.NoError(t, NewEventController(events, "default", newHandler, nil).Init(context.Background()))
+	// A new default event cannot, they belong to the first one
+	require.NoError(t, NewEventController(events, "other", newHandler, nil).Init(context.Background()))
+	// A restart opens the existing default event
+	require.NoError(t, NewEventController(events, "default", newHandler, nil).Init(context.Background()))
+
+	assert.Equal(t, []string{"", "other", ""}, namespaces)
+}
+
+// countingEventStorage counts the reads of single events
+type countingEventStorage struct {
+	storage.EventStorage
+	gets int
+}
+
+func (s *countingEventStorage) Get(ctx context.Context, id string) (*storage.Event, error) {
+	s.gets++
+	return s.EventStorage.Get(ctx, id)
+}
+
+func TestEventsAreCached(t *testing.T) {
+	logging.Log = logrus.New()
+	t.Setenv("ADMIN_TOKEN", "secret")
+	events := &countingEventStorage{EventStorage: memory.NewEventStorage()}
+	controller := NewEventController(events, "default", func(event *storage.Event) (http.Handler, error) {
+		r := gin.New()
+		NewTeamMetaController(memory.NewTeamStorage(), memory.NewVoteStorage(), memory.NewVotingCodesStorage(), nil, nil).RegisterRoutes(r)
+		return r, nil
+	}, nil)
+	require.NoError(t, controller.Init(context.Background()))
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	controller.RegisterRoutes(router)
+	admin := map[string]string{"x-admin-token": "secret"}
+
+	events.gets = 0
+	for i := 0; i < 3; i++ {
+		res := testutils.PerformRequest(router, http.MethodGet, "/api/meta/teams", nil, nil)
+		require.Equal(t, http.StatusOK, res.Code)
+	}
+	assert.Equal(t, 1, events.gets)
+
+	// Archiving through this instance is seen at once
+	res := testutils.PerformRequest(router, http.MethodPost, "/api/admin/events/default/archive", nil, admin)
+	require.Equal(t, http.StatusOK, res.Code)
+	res = testutils.PerformRequest(router, http.MethodPost, "/api/meta/teams", models.TeamCreateRequest{ID: 1, Name: "Team"}, admin)
+	assert.Equal(t, http.StatusConflict, res.Code)
+}
+
+func TestEventProvisioning(t *testing.T) {
+	logging.Log = logrus.New()
+	t.Setenv("ADMIN_TOKEN", "secret")
+	events := memory.NewEventStorage()
+	require.NoError(t, events.Create(context.Background(), &storage.Event{ID: "spring", Namespace: "spring"}))
+
+	provisioned := make(chan string, 4)
+	newHandler := func(event *storage.Event) (http.Handler, error) {
+		return gin.New(), nil
+	}
+	provision := func(ctx context.Context, event *storage.Event) error {
+		provisioned <- event.ID
+		return nil
+	}
+	controller := NewEventController(events, "default", newHandler, provision)
+
+	t.Run("Init provisions every event", func(t *testing.T) {
+		require.NoError(t, controller.Init(context.Background()))
+		assert.ElementsMatch(t, []string{"default", "spring"}, []string{<-provisioned, <-provisioned})
+	})
+
+	t.Run("A created event is provisioned after the response", func(t *testing.T) {
+		gin.SetMode(gin.TestMode)
+		router := gin.New()
+		controller.RegisterRoutes(router)
+		res := testutils.PerformRequest(router, http.MethodPost, "/api/admin/events", models.EventCreateRequest{ID: "autumn"}, map[string]string{"x-admin-token": "secret"})
+		require.Equal(t, http.StatusOK, res.Code)
+
+		select {
+		case id := <-provisioned:
+			assert.Equal(t, "autumn", id)
+		case <-time.After(time.Second):
+			t.Fatal("the event was not provisioned")
+		}
+	})
+}
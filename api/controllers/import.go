@@ -0,0 +1,449 @@
+package controllers
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"github.com/alex-pricope/simple-voting-system/api/models"
+	"github.com/alex-pricope/simple-voting-system/api/transport"
+	"github.com/alex-pricope/simple-voting-system/logging"
+	"github.com/alex-pricope/simple-voting-system/storage"
+	"github.com/gin-gonic/gin"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// membersSeparator separates the members of a team inside the members column
+const membersSeparator = ";"
+
+// ImportController creates teams, categories and codes in bulk from CSV files.
+// Every row is validated before anything is written, and a dry run only reports what would be created.
+type ImportController struct {
+	codesStorage      storage.VotingCodeStorage
+	teamsStorage      storage.TeamStorage
+	categoriesStorage storage.VotingCategoryStorage
+}
+
+func NewImportController(codes storage.VotingCodeStorage, teams storage.TeamStorage, categories storage.VotingCategoryStorage) *ImportController {
+	return &ImportController{
+		codesStorage:      codes,
+		teamsStorage:      teams,
+		categoriesStorage: categories,
+	}
+}
+
+func (c *ImportController) RegisterRoutes(engine *gin.Engine) {
+	group := engine.Group("/api/admin/import", transport.AdminAuthMiddleware())
+
+	group.POST("/teams", c.importTeams)
+	group.POST("/categories", c.importCategories)
+	group.POST("/codes", c.importCodes)
+}
+
+// @Security AdminToken
+// importTeams godoc
+// @Summary Import teams from CSV
+// @Description Columns id and name, optional description and members (separated by ";"). IDs must be new.
+// @Description The CSV is the request body or the "file" field of a multipart form
+// @Tags admin
+// @Accept text/csv
+// @Produce json
+// @Param dryRun query bool false "Validate and report without writing"
+// @Success 200 {object} models.ImportResponse
+// @Failure 400 {object} models.ImportResponse
+// @Failure 500 {object} models.ImportResponse
+// @Router /api/admin/import/teams [post]
+func (c *ImportController) importTeams(g *gin.Context) {
+	table, ok := readCSV(g, []string{"id", "name"}, []string{"description", "members"})
+	if !ok {
+		return
+	}
+	existing, err := c.teamsStorage.GetAll(g.Request.Context())
+	if err != nil {
+		writeStorageError(g, "teams", err)
+		return
+	}
+	ids := make(map[int]int, len(existing))
+	for _, t := range existing {
+		ids[t.ID] = 0
+	}
+
+	result := newImportResult(table)
+	var teams []*storage.Team
+	for _, row := range table.rows {
+		id, ok := result.id(row, "id", ids)
+		name := row.value("name")
+		if name == "" {
+			result.fail(row, "name is empty")
+			ok = false
+		}
+		if !ok {
+			continue
+		}
+		var members []string
+		for _, member := range strings.Split(row.value("members"), membersSeparator) {
+			if member = strings.TrimSpace(member); member != "" {
+				members = append(members, member)
+			}
+		}
+		teams = append(teams, &storage.Team{ID: id, Name: name, Description: row.value("description"), Members: members})
+		result.created = append(result.created, strconv.Itoa(id))
+	}
+
+	c.finish(g, "teams", table, result, func(ctx context.Context) ([]string, error) {
+		var created []string
+		for _, team := range teams {
+			if err := c.teamsStorage.Create(ctx, team); err != nil {
+				return created, fmt.Errorf("team %d: %w", team.ID, err)
+			}
+			created = append(created, strconv.Itoa(team.ID))
+		}
+		return created, nil
+	})
+}
+
+// @Security AdminToken
+// importCategories godoc
+// @Summary Import voting categories from CSV
+// @Description Columns id, name and weight, optional description. IDs must be new.
+// @Description The CSV is the request body or the "file" field of a multipart form
+// @Tags admin
+// @Accept text/csv
+// @Produce json
+// @Param dryRun query bool false "Validate and report without writing"
+// @Success 200 {object} models.ImportResponse
+// @Failure 400 {object} models.ImportResponse
+// @Failure 500 {object} models.ImportResponse
+// @Router /api/admin/import/categories [post]
+func (c *ImportController) importCategories(g *gin.Context) {
+	table, ok := readCSV(g, []string{"id", "name", "weight"}, []string{"description"})
+	if !ok {
+		return
+	}
+	existing, err := c.categoriesStorage.GetAll(g.Request.Context())
+	if err != nil {
+		writeStorageError(g, "categories", err)
+		return
+	}
+	ids := make(map[int]int, len(existing))
+	for _, vc := range existing {
+		ids[vc.ID] = 0
+	}
+
+	result := newImportResult(table)
+	var categories []*storage.VotingCategory
+	for _, row := range table.rows {
+		id, ok := result.id(row, "id", ids)
+		name := row.value("name")
+		if name == "" {
+			result.fail(row, "name is empty")
+			ok = false
+		}
+		weight, err := strconv.ParseFloat(row.value("weight"), 64)
+		if err != nil || weight < 0 {
+			result.fail(row, fmt.Sprintf("weight %q is not a non-negative number", row.value("weight")))
+			ok = false
+		}
+		if !ok {
+			continue
+		}
+		categories = append(categories, &storage.VotingCategory{ID: id, Name: name, Description: row.value("description"), Weight: weight})
+		result.created = append(result.created, strconv.Itoa(id))
+	}
+
+	c.finish(g, "categories", table, result, func(ctx context.Context) ([]string, error) {
+		var created []string
+		for _, category := range categories {
+			if err := c.categoriesStorage.Create(ctx, category); err != nil {
+				return created, fmt.Errorf("category %d: %w", category.ID, err)
+			}
+			created = append(created, strconv.Itoa(category.ID))
+		}
+		return created, nil
+	})
+}
+
+// @Security AdminToken
+// importCodes godoc
+// @Summary Import pre-printed voting codes from CSV
+// @Description Columns code and category (the voter group), optional team_id for other_team codes.
+// @Description Codes are upper-cased and must be new. The CSV is the request body or the "file" field of a multipart form
+// @Tags admin
+// @Accept text/csv
+// @Produce json
+// @Param dryRun query bool false "Validate and report without writing"
+// @Success 200 {object} models.ImportResponse
+// @Failure 400 {object} models.ImportResponse
+// @Failure 500 {object} models.ImportResponse
+// @Router /api/admin/import/codes [post]
+func (c *ImportController) importCodes(g *gin.Context) {
+	table, ok := readCSV(g, []string{"code", "category"}, []string{"team_id"})
+	if !ok {
+		return
+	}
+	ctx := g.Request.Context()
+	existing, err := c.codesStorage.GetAll(ctx)
+	if err != nil {
+		writeStorageError(g, "codes", err)
+		return
+	}
+	teams, err := c.teamsStorage.GetAll(ctx)
+	if err != nil {
+		writeStorageError(g, "teams", err)
+		return
+	}
+	known := make(map[string]int, len(existing)) // line of the code, 0 for stored codes
+	for _, vc := range existing {
+		known[vc.Code] = 0
+	}
+	teamIDs := make(map[int]bool, len(teams))
+	for _, t := range teams {
+		teamIDs[t.ID] = true
+	}
+
+	result := newImportResult(table)
+	now := time.Now().UTC()
+	var codes []*storage.VotingCode
+	for _, row := range table.rows {
+		ok := true
+		code := strings.ToUpper(row.value("code"))
+		switch {
+		case code == "":
+			result.fail(row, "code is empty")
+			ok = false
+		case strings.Trim(code, models.Alphabet) != "":
+			result.fail(row, fmt.Sprintf("code %s has characters other than letters and digits", code))
+			ok = false
+		default:
+			if line, taken := known[code]; taken {
+				if line == 0 {
+					result.fail(row, fmt.Sprintf("code %s already exists", code))
+				} else {
+					result.fail(row, fmt.Sprintf("code %s is already on line %d", code, line))
+				}
+				ok = false
+			} else {
+				known[code] = row.line
+			}
+		}
+
+		category := row.value("category")
+		if _, valid := models.ValidCategories[models.VotingCategory(category)]; !valid {
+			result.fail(row, fmt.Sprintf("unknown category %q", category))
+			ok = false
+		}
+		var teamID *int
+		if value := row.value("team_id"); value != "" {
+			id, err := strconv.Atoi(value)
+			switch {
+			case err != nil:
+				result.fail(row, fmt.Sprintf("team_id %q is not a number", value))
+				ok = false
+			case !teamIDs[id]:
+				result.fail(row, fmt.Sprintf("team %d does not exist", id))
+				ok = false
+			}
+			teamID = &id
+		}
+		if !ok {
+			continue
+		}
+		codes = append(codes, &storage.VotingCode{Code: code, Category: category, TeamID: teamID, CreatedAt: now})
+		result.created = append(result.created, code)
+	}
+
+	c.finish(g, "codes", table, result, func(ctx context.Context) ([]string, error) {
+		stored, err := c.codesStorage.PutBatch(ctx, codes)
+		created := make([]string, 0, len(stored))
+		for _, vc := range stored {
+			created = append(created, vc.Code)
+		}
+		if err == nil && len(stored) < len(codes) {
+			err = fmt.Errorf("%w: %d codes were created by someone else meanwhile", storage.ErrAlreadyExists, len(codes)-len(stored))
+		}
+		return created, err
+	})
+}
+
+// finish reports the row errors, or the dry run, or writes the rows
+func (c *ImportController) finish(g *gin.Context, entity string, table *csvTable, result *importResult,
+	write func(ctx context.Context) ([]string, error)) {
+	sort.SliceStable(result.errors, func(i, j int) bool { return result.errors[i].Line < result.errors[j].Line })
+	response := models.ImportResponse{
+		DryRun:  g.Query("dryRun") == "true",
+		Rows:    len(table.rows) + len(table.malformed),
+		Created: result.created,
+		Errors:  result.errors,
+	}
+	if len(result.errors) > 0 {
+		logging.Log.Warnf("ADMIN: rejected import of %d %s, %d rows have errors", response.Rows, entity, len(result.errors))
+		response.Created = []string{}
+		g.JSON(http.StatusBadRequest, response)
+		return
+	}
+	if response.DryRun {
+		g.JSON(http.StatusOK, response)
+		return
+	}
+
+	created, err := write(g.Request.Context())
+	response.Created = append([]string{}, created...)
+	auditAfter(g, gin.H{entity: len(response.Created)})
+	if err != nil {
+		logging.Log.Errorf("ADMIN: import of %s failed after %d rows: %v", entity, len(created), err)
+		response.Error = err.Error()
+		g.JSON(storageErrorStatus(err), response)
+		return
+	}
+	logging.Log.Infof("ADMIN: imported %d %s", len(created), entity)
+	g.JSON(http.StatusOK, response)
+}
+
+// importResult collects the row errors and the keys of the valid rows
+type importResult struct {
+	created []string
+	errors  []models.ImportRowError
+}
+
+// newImportResult starts with the errors of the rows that could not be read
+func newImportResult(table *csvTable) *importResult {
+	return &importResult{errors: append([]models.ImportRowError{}, table.malformed...)}
+}
+
+func (r *importResult) fail(row csvRow, message string) {
+	r.errors = append(r.errors, models.ImportRowError{Line: row.line, Error: message})
+}
+
+// id parses the integer ID in column, which must not be in taken. taken maps the stored IDs to 0
+// and the IDs of earlier rows to their line, the ID of this row is added to it.
+func (r *importResult) id(row csvRow, column string, taken map[int]int) (int, bool) {
+	value := row.value(column)
+	id, err := strconv.Atoi(value)
+	if err != nil {
+		r.fail(row, fmt.Sprintf("%s %q is not a number", column, value))
+		return 0, false
+	}
+	if line, ok := taken[id]; ok {
+		if line == 0 {
+			r.fail(row, fmt.Sprintf("%s %d already exists", column, id))
+		} else {
+			r.fail(row, fmt.Sprintf("%s %d is already on line %d", column, id, line))
+		}
+		return 0, false
+	}
+	taken[id] = row.line
+	return id, true
+}
+
+type csvTable struct {
+	rows      []csvRow
+	malformed []models.ImportRowError // Rows with a different number of fields than the header
+}
+
+type csvRow struct {
+	line    int
+	columns map[string]int
+	fields  []string
+}
+
+// value returns the trimmed field of column, empty when the file has no such column
+func (r csvRow) value(column string) string {
+	i, ok := r.columns[column]
+	if !ok {
+		return ""
+	}
+	return strings.TrimSpace(r.fields[i])
+}
+
+// readCSV reads the CSV file of the request, named by its header line. It writes the 400 response
+// when the file cannot be read or its header does not match the columns.
+func readCSV(g *gin.Context, required, optional []string) (*csvTable, bool) {
+	body, err := csvBody(g)
+	if err != nil {
+		g.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+		return nil, false
+	}
+	defer body.Close()
+
+	table, err := parseCSV(body, required, optional)
+	if err != nil {
+		g.JSON(http.StatusBadRequest, models.ErrorResponse{Error: "invalid CSV: " + err.Error()})
+		return nil, false
+	}
+	return table, true
+}
+
+// csvBody returns the "file" field of a multipart form, or else the request body
+func csvBody(g *gin.Context) (io.ReadCloser, error) {
+	if !strings.HasPrefix(g.ContentType(), "multipart/") {
+		return g.Request.Body, nil
+	}
+	header, err := g.FormFile("file")
+	if err != nil {
+		return nil, errors.New("multipart form has no file field")
+	}
+	return header.Open()
+}
+
+func parseCSV(r io.Reader, required, optional []string) (*csvTable, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+	// The number of fields is checked per row below, so one ragged row is reported instead of failing the file
+	reader.FieldsPerRecord = -1
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, errors.New("the file is empty")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	expected := append(append([]string{}, required...), optional...)
+	allowed := make(map[string]bool, len(expected))
+	for _, column := range expected {
+		allowed[column] = true
+	}
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		// Spreadsheets often save with a byte order mark
+		name = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))
+		if !allowed[name] {
+			return nil, fmt.Errorf("unknown column %q, expected %s", name, strings.Join(expected, ", "))
+		}
+		if _, ok := columns[name]; ok {
+			return nil, fmt.Errorf("column %q appears twice", name)
+		}
+		columns[name] = i
+	}
+	for _, column := range required {
+		if _, ok := columns[column]; !ok {
+			return nil, fmt.Errorf("missing column %q", column)
+		}
+	}
+
+	table := &csvTable{}
+	for {
+		fields, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		line, _ := reader.FieldPos(0)
+		if len(fields) != len(header) {
+			table.malformed = append(table.malformed, models.ImportRowError{
+				Line:  line,
+				Error: fmt.Sprintf("row has %d fields, the header has %d", len(fields), len(header)),
+			})
+			continue
+		}
+		table.rows = append(table.rows, csvRow{line: line, columns: columns, fields: fields})
+	}
+	return table, nil
+}
@@ -0,0 +1,174 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	testutils "github.com/alex-pricope/simple-voting-system/api/controllers/testing"
+	"github.com/alex-pricope/simple-voting-system/api/models"
+	"github.com/alex-pricope/simple-voting-system/logging"
+	"github.com/alex-pricope/simple-voting-system/storage"
+	"github.com/alex-pricope/simple-voting-system/storage/memory"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func setupTestImportController(t *testing.T) (*ImportController, *gin.Engine) {
+	t.Helper()
+	logging.Log = logrus.New()
+	t.Setenv("ADMIN_TOKEN", "secret")
+
+	controller := NewImportController(memory.NewVotingCodesStorage(), memory.NewTeamStorage(), memory.NewVotingCategoryStorage())
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	controller.RegisterRoutes(r)
+
+	return controller, r
+}
+
+func postCSV(router *gin.Engine, path, csv string) (*httptest.ResponseRecorder, models.ImportResponse) {
+	res := testutils.PerformRawRequest(router, http.MethodPost, path, "text/csv", strings.NewReader(csv), map[string]string{
+		"x-admin-token": "secret",
+	})
+	var body models.ImportResponse
+	_ = json.Unmarshal(res.Body.Bytes(), &body)
+	return res, body
+}
+
+func TestImportTeams(t *testing.T) {
+	controller, router := setupTestImportController(t)
+	ctx := context.Background()
+	csv := "id,name,description,members\n1,Alpha,First team,Alice; Bob\n2,Beta,,\n"
+
+	t.Run("Dry run writes nothing", func(t *testing.T) {
+		res, body := postCSV(router, "/api/admin/import/teams?dryRun=true", csv)
+		require.Equal(t, http.StatusOK, res.Code)
+		assert.True(t, body.DryRun)
+		assert.Equal(t, []string{"1", "2"}, body.Created)
+
+		teams, err := controller.teamsStorage.GetAll(ctx)
+		require.NoError(t, err)
+		assert.Empty(t, teams)
+	})
+
+	t.Run("Happy path - import", func(t *testing.T) {
+		res, body := postCSV(router, "/api/admin/import/teams", csv)
+		require.Equal(t, http.StatusOK, res.Code)
+		assert.Equal(t, 2, body.Rows)
+		assert.Equal(t, []string{"1", "2"}, body.Created)
+
+		team, err := controller.teamsStorage.Get(ctx, 1)
+		require.NoError(t, err)
+		assert.Equal(t, "First team", team.Description)
+		assert.Equal(t, []string{"Alice", "Bob"}, team.Members)
+	})
+
+	t.Run("Every bad row is reported and nothing is written", func(t *testing.T) {
+		res, body := postCSV(router, "/api/admin/import/teams", "id,name\n1,Again\nx,Gamma\n3,\n4,Delta\n4,Delta twice\n")
+		require.Equal(t, http.StatusBadRequest, res.Code)
+		assert.Equal(t, []models.ImportRowError{
+			{Line: 2, Error: "id 1 already exists"},
+			{Line: 3, Error: `id "x" is not a number`},
+			{Line: 4, Error: "name is empty"},
+			{Line: 6, Error: "id 4 is already on line 5"},
+		}, body.Errors)
+		assert.Empty(t, body.Created)
+
+		_, err := controller.teamsStorage.Get(ctx, 4)
+		assert.ErrorIs(t, err, storage.ErrNotFound)
+	})
+
+	t.Run("Ragged rows are reported per row", func(t *testing.T) {
+		res, body := postCSV(router, "/api/admin/import/teams", "id,name\n7,Eta\n8\n9,Theta,extra\n10,\n")
+		require.Equal(t, http.StatusBadRequest, res.Code)
+		assert.Equal(t, 4, body.Rows)
+		assert.Equal(t, []models.ImportRowError{
+			{Line: 3, Error: "row has 1 fields, the header has 2"},
+			{Line: 4, Error: "row has 3 fields, the header has 2"},
+			{Line: 5, Error: "name is empty"},
+		}, body.Errors)
+	})
+
+	t.Run("Unknown column", func(t *testing.T) {
+		res, _ := postCSV(router, "/api/admin/import/teams", "id,name,colour\n5,Epsilon,red\n")
+		assert.Equal(t, http.StatusBadRequest, res.Code)
+	})
+
+	t.Run("Missing column", func(t *testing.T) {
+		res, _ := postCSV(router, "/api/admin/import/teams", "name\nEpsilon\n")
+		assert.Equal(t, http.StatusBadRequest, res.Code)
+	})
+}
+
+func TestImportCategories(t *testing.T) {
+	controller, router := setupTestImportController(t)
+
+	res, body := postCSV(router, "/api/admin/import/categories", "\ufeffID,Name,Weight\n1,Design,0.4\n2,Pitch,-1\n")
+	require.Equal(t, http.StatusBadRequest, res.Code)
+	assert.Equal(t, []models.ImportRowError{{Line: 3, Error: `weight "-1" is not a non-negative number`}}, body.Errors)
+
+	res, _ = postCSV(router, "/api/admin/import/categories", "ID,Name,Weight\n1,Design,0.4\n2,Pitch,0.6\n")
+	require.Equal(t, http.StatusOK, res.Code)
+	category, err := controller.categoriesStorage.Get(context.Background(), 2)
+	require.NoError(t, err)
+	assert.Equal(t, 0.6, category.Weight)
+}
+
+func TestImportCodes(t *testing.T) {
+	controller, router := setupTestImportController(t)
+	ctx := context.Background()
+	require.NoError(t, controller.teamsStorage.Create(ctx, &storage.Team{ID: 1, Name: "Alpha"}))
+	require.NoError(t, controller.codesStorage.Put(ctx, &storage.VotingCode{Code: "TAKEN", Category: "grand_jury"}))
+
+	t.Run("Bad rows", func(t *testing.T) {
+		res, body := postCSV(router, "/api/admin/import/codes", "code,category,team_id\ntaken,grand_jury,\nAB-12,grand_jury,\nNEW01,jury,\nNEW02,other_team,9\n")
+		require.Equal(t, http.StatusBadRequest, res.Code)
+		assert.Equal(t, []models.ImportRowError{
+			{Line: 2, Error: "code TAKEN already exists"},
+			{Line: 3, Error: "code AB-12 has characters other than letters and digits"},
+			{Line: 4, Error: `unknown category "jury"`},
+			{Line: 5, Error: "team 9 does not exist"},
+		}, body.Errors)
+	})
+
+	t.Run("Happy path - multipart upload", func(t *testing.T) {
+		var form bytes.Buffer
+		writer := multipart.NewWriter(&form)
+		file, err := writer.CreateFormFile("file", "codes.csv")
+		require.NoError(t, err)
+		_, err = file.Write([]byte("code,category,team_id\nnew01,other_team,1\nNEW02,general_public,\n"))
+		require.NoError(t, err)
+		require.NoError(t, writer.Close())
+
+		res := testutils.PerformRawRequest(router, http.MethodPost, "/api/admin/import/codes", writer.FormDataContentType(), &form, map[string]string{
+			"x-admin-token": "secret",
+		})
+		require.Equal(t, http.StatusOK, res.Code)
+
+		code, err := controller.codesStorage.Get(ctx, "NEW01")
+		require.NoError(t, err)
+		assert.Equal(t, "other_team", code.Category)
+		assert.False(t, code.CreatedAt.IsZero())
+		assert.Equal(t, 1, *code.TeamID)
+		code, err = controller.codesStorage.Get(ctx, "NEW02")
+		require.NoError(t, err)
+		assert.Nil(t, code.TeamID)
+	})
+	t.Run("Codes stored before a failure are reported", func(t *testing.T) {
+		codes := controller.codesStorage
+		controller.codesStorage = &partialCodeStorage{VotingCodeStorage: codes}
+		defer func() { controller.codesStorage = codes }()
+
+		res, body := postCSV(router, "/api/admin/import/codes", "code,category\nNEW03,general_public\nNEW04,general_public\n")
+		require.Equal(t, http.StatusTooManyRequests, res.Code)
+		assert.Equal(t, []string{"NEW03"}, body.Created)
+		assert.NotEmpty(t, body.Error)
+	})
+}
@@ -0,0 +1,464 @@
+package controllers
+
+import (
+	"context"
+	"github.com/alex-pricope/simple-voting-system/api/models"
+	"github.com/alex-pricope/simple-voting-system/api/transport"
+	"github.com/alex-pricope/simple-voting-system/logging"
+	"github.com/alex-pricope/simple-voting-system/storage"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"reflect"
+	"sort"
+	"time"
+)
+
+// LedgerController reads the vote ledger of an event and rebuilds the stored votes and tallies from it.
+// The ledger is written by the handlers that change votes, see recordLedger.
+type LedgerController struct {
+	ledgerStorage     storage.LedgerStorage
+	codesStorage      storage.VotingCodeStorage
+	votesStorage      storage.VoteStorage
+	teamsStorage      storage.TeamStorage
+	categoriesStorage storage.VotingCategoryStorage
+	talliesStorage    storage.TallyStorage // Replaced by a rebuild, nil when the results are computed from the votes
+}
+
+func NewLedgerController(ledger storage.LedgerStorage, codes storage.VotingCodeStorage, votes storage.VoteStorage,
+	teams storage.TeamStorage, categories storage.VotingCategoryStorage, tallies storage.TallyStorage) *LedgerController {
+	return &LedgerController{
+		ledgerStorage:     ledger,
+		codesStorage:      codes,
+		votesStorage:      votes,
+		teamsStorage:      teams,
+		categoriesStorage: categories,
+		talliesStorage:    tallies,
+	}
+}
+
+func (c *LedgerController) RegisterRoutes(engine *gin.Engine) {
+	group := engine.Group("/api/admin/ledger", transport.AdminAuthMiddleware())
+
+	group.GET("", c.list)
+	group.GET("/results", c.results)
+	group.GET("/verify", c.verify)
+	group.POST("/rebuild", c.rebuild)
+	group.POST("/seed", c.seed)
+}
+
+// recordLedger appends the events of an admin request to the ledger, stamped with the time, the admin and the request ID.
+// The change is already stored, a failed append can only be logged. A nil ledger records nothing.
+func recordLedger(g *gin.Context, ledger storage.LedgerStorage, events ...*storage.LedgerEvent) {
+	for _, event := range events {
+		if err := appendLedger(g, ledger, event); err != nil {
+			logging.Log.Errorf("LEDGER: failed to record %s of code %q, compare with GET /api/admin/ledger/verify: %v",
+				event.Type, event.Code, err)
+		}
+	}
+}
+
+// ledgerSeqKey prefixes the context key of the sequence of a code locked by lockCode
+const ledgerSeqKey = "ledger.seq."
+
+// lockCode locks code in the ledger for the change a request is about to store, until unlock is called once
+// the change is stored and its events appended. The events of the code then carry the sequence of the lock,
+// so they replay in the order the changes were stored. unlock is never nil, a nil ledger locks nothing.
+func lockCode(g *gin.Context, ledger storage.LedgerStorage, code string) (unlock func(), err error) {
+	if ledger == nil {
+		return func() {}, nil
+	}
+	lock, err := ledger.LockCode(g.Request.Context(), code)
+	if err != nil {
+		logging.Log.Errorf("LEDGER: failed to lock code %s: %v", code, err)
+		return func() {}, err
+	}
+	g.Set(ledgerSeqKey+code, lock.Seq)
+	return func() {
+		if err := ledger.UnlockCode(context.WithoutCancel(g.Request.Context()), lock); err != nil {
+			logging.Log.Errorf("LEDGER: failed to unlock code %s, it stays locked until the lock expires: %v", code, err)
+		}
+	}, nil
+}
+
+// appendLedger stamps and appends a single event, split into parts that each fit in one item.
+// An event of a code locked by lockCode gets the sequence of the lock.
+func appendLedger(g *gin.Context, ledger storage.LedgerStorage, event *storage.LedgerEvent) error {
+	if ledger == nil {
+		return nil
+	}
+	actor, _ := transport.AdminName(g)
+	seq, _ := g.Get(ledgerSeqKey + event.Code)
+	for _, part := range event.Split() {
+		now := time.Now().UTC()
+		part.ID = storage.NewAuditID(now)
+		part.Timestamp = now
+		part.Actor = actor
+		part.RequestID = g.GetHeader(transport.RequestIDHeader)
+		if seq, ok := seq.(int64); ok && event.Code != "" {
+			part.Seq = seq
+		}
+		if err := ledger.Append(context.WithoutCancel(g.Request.Context()), part); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// timeQuery reads an optional RFC3339 query parameter
+func timeQuery(g *gin.Context, name string) (time.Time, error) {
+	value := g.Query(name)
+	if value == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+// @Security AdminToken
+// list godoc
+// @Summary List the vote ledger
+// @Description Every ballot, reset, invalidation and deletion of votes, oldest first
+// @Tags admin
+// @Produce json
+// @Param from query string false "Only events at or after this time, RFC3339"
+// @Param to query string false "Only events before this time, RFC3339"
+// @Param code query string false "Only events changing this code or its votes"
+// @Success 200 {array} models.LedgerEventResponse
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /api/admin/ledger [get]
+func (c *LedgerController) list(g *gin.Context) {
+	filter := storage.LedgerFilter{Code: g.Query("code")}
+	var err error
+	if filter.From, err = timeQuery(g, "from"); err != nil {
+		g.JSON(http.StatusBadRequest, models.ErrorResponse{Error: "invalid from, use RFC3339"})
+		return
+	}
+	if filter.To, err = timeQuery(g, "to"); err != nil {
+		g.JSON(http.StatusBadRequest, models.ErrorResponse{Error: "invalid to, use RFC3339"})
+		return
+	}
+
+	events, err := c.ledgerStorage.Query(g.Request.Context(), filter)
+	if err != nil {
+		logging.Log.Errorf("ADMIN: failed to query the ledger: %v", err)
+		g.JSON(storageErrorStatus(err), models.ErrorResponse{Error: err.Error()})
+		return
+	}
+	responses := make([]models.LedgerEventResponse, 0, len(events))
+	for _, event := range events {
+		responses = append(responses, models.TransformLedgerEventFromStorage(event))
+	}
+	g.JSON(http.StatusOK, responses)
+}
+
+// @Security AdminToken
+// results godoc
+// @Summary Results replayed from the ledger
+// @Description Replays the ledger up to a point in time and computes the results the way GET /api/vote/results does.
+// @Description The current teams and categories are used, codes count with the voter group they voted with
+// @Tags admin
+// @Produce json
+// @Param at query string false "Replay the events before this time, RFC3339. Defaults to every event"
+// @Success 200 {object} models.VoteResultsResponse
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /api/admin/ledger/results [get]
+func (c *LedgerController) results(g *gin.Context) {
+	at, err := timeQuery(g, "at")
+	if err != nil {
+		g.JSON(http.StatusBadRequest, models.ErrorResponse{Error: "invalid at, use RFC3339"})
+		return
+	}
+	projection, ok := c.replay(g, storage.LedgerFilter{To: at})
+	if !ok {
+		return
+	}
+	categories, teams, ok := c.loadTeamsAndCategories(g)
+	if !ok {
+		return
+	}
+	g.JSON(http.StatusOK, voteResultsResponse(projection.Votes(), projection.Codes(), categories, teams))
+}
+
+// @Security AdminToken
+// verify godoc
+// @Summary Compare the ledger with the stored votes
+// @Description Replays the whole ledger and compares the votes and the results with the stored ones.
+// @Description Differences come from changes whose ledger append failed, or that were made before the ledger was seeded
+// @Tags admin
+// @Produce json
+// @Success 200 {object} models.LedgerVerifyResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /api/admin/ledger/verify [get]
+func (c *LedgerController) verify(g *gin.Context) {
+	ctx := g.Request.Context()
+	projection, ok := c.replay(g, storage.LedgerFilter{})
+	if !ok {
+		return
+	}
+	stored, err := c.votesStorage.GetAll(ctx)
+	if err != nil {
+		logging.Log.Errorf("ADMIN: failed to load votes for ledger verify: %v", err)
+		g.JSON(storageErrorStatus(err), models.ErrorResponse{Error: "could not load votes"})
+		return
+	}
+	codes, err := c.codesStorage.GetAll(ctx)
+	if err != nil {
+		logging.Log.Errorf("ADMIN: failed to load codes for ledger verify: %v", err)
+		g.JSON(storageErrorStatus(err), models.ErrorResponse{Error: "could not load voting codes"})
+		return
+	}
+	categories, teams, ok := c.loadTeamsAndCategories(g)
+	if !ok {
+		return
+	}
+
+	replayed := projection.Votes()
+	missing, extra, changed := diffVotes(replayed, stored)
+	g.JSON(http.StatusOK, models.LedgerVerifyResponse{
+		Events:      projection.Events,
+		Votes:       len(replayed),
+		StoredVotes: len(stored),
+		Missing:     len(missing),
+		Extra:       len(extra),
+		Changed:     len(changed),
+		ResultsMatch: reflect.DeepEqual(
+			calculateVoteResults(replayed, projection.Codes(), categories, teams),
+			calculateVoteResults(stored, codes, categories, teams)),
+	})
+}
+
+// @Security AdminToken
+// rebuild godoc
+// @Summary Rebuild the stored votes from the ledger
+// @Description Replays the whole ledger and makes the stored votes, the used flags of the codes that voted and the tallies match it.
+// @Description Stored votes missing from the ledger are kept and counted, unless deleteExtra is set: check GET /api/admin/ledger/verify first.
+// @Description Run it while nobody votes
+// @Tags admin
+// @Produce json
+// @Param deleteExtra query bool false "Also delete the stored votes the ledger does not have"
+// @Success 200 {object} models.LedgerRebuildResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /api/admin/ledger/rebuild [post]
+func (c *LedgerController) rebuild(g *gin.Context) {
+	ctx := g.Request.Context()
+	projection, ok := c.replay(g, storage.LedgerFilter{})
+	if !ok {
+		return
+	}
+	stored, err := c.votesStorage.GetAll(ctx)
+	if err != nil {
+		logging.Log.Errorf("ADMIN: failed to load votes for ledger rebuild: %v", err)
+		g.JSON(storageErrorStatus(err), models.ErrorResponse{Error: "could not load votes"})
+		return
+	}
+	codes, err := c.codesStorage.GetAll(ctx)
+	if err != nil {
+		logging.Log.Errorf("ADMIN: failed to load codes for ledger rebuild: %v", err)
+		g.JSON(storageErrorStatus(err), models.ErrorResponse{Error: "could not load voting codes"})
+		return
+	}
+
+	replayed := projection.Votes()
+	missing, extra, changed := diffVotes(replayed, stored)
+	response := models.LedgerRebuildResponse{Events: projection.Events, Votes: len(replayed), Extra: len(extra)}
+	// Changed votes are deleted and written again, Create never overwrites
+	deletes := changed
+	if g.Query("deleteExtra") == "true" {
+		deletes = append(deletes, extra...)
+		extra = nil
+	}
+	if len(deletes) > 0 {
+		if err := c.votesStorage.Delete(ctx, deletes); err != nil {
+			logging.Log.Errorf("ADMIN: ledger rebuild failed deleting %d votes: %v", len(deletes), err)
+			g.JSON(storageErrorStatus(err), models.ErrorResponse{Error: "could not delete votes, the rebuild is partial"})
+			return
+		}
+		response.Deleted = len(deletes)
+	}
+	for _, vote := range append(missing, changed...) {
+		if err := c.votesStorage.Create(ctx, vote); err != nil {
+			logging.Log.Errorf("ADMIN: ledger rebuild failed writing vote %s of code %s: %v", vote.SortKey, vote.Code, err)
+			g.JSON(storageErrorStatus(err), models.ErrorResponse{Error: "could not write votes, the rebuild is partial"})
+			return
+		}
+		response.Created++
+	}
+
+	// Codes deleted since they voted are left deleted
+	used := make(map[string]bool)
+	for _, code := range projection.Codes() {
+		used[code.Code] = code.Used
+	}
+	for _, code := range codes {
+		shouldBeUsed, voted := used[code.Code]
+		if !voted || code.Used == shouldBeUsed {
+			continue
+		}
+		mark := c.codesStorage.MarkUnused
+		if shouldBeUsed {
+			mark = c.codesStorage.MarkUsed
+		}
+		if err := mark(ctx, code.Code); err != nil {
+			logging.Log.Errorf("ADMIN: ledger rebuild failed updating code %s: %v", code.Code, err)
+			g.JSON(storageErrorStatus(err), models.ErrorResponse{Error: "could not update codes, the rebuild is partial"})
+			return
+		}
+		code.Used = shouldBeUsed
+		response.Codes++
+	}
+
+	if c.talliesStorage != nil {
+		tallies := storage.BuildTallies(append(replayed, extra...), codes)
+		if err := c.talliesStorage.Replace(ctx, tallies); err != nil {
+			logging.Log.Errorf("ADMIN: ledger rebuild failed writing tallies, run POST /api/admin/tallies/rebuild: %v", err)
+			g.JSON(storageErrorStatus(err), models.ErrorResponse{Error: "could not write tallies"})
+			return
+		}
+		response.Tallies = len(tallies.Scores)
+	}
+
+	logging.Log.Infof("ADMIN: rebuilt %d votes from %d ledger events, %d written, %d deleted and %d extra kept",
+		response.Votes, response.Events, response.Created, response.Deleted, len(extra))
+	auditAfter(g, response)
+	g.JSON(http.StatusOK, response)
+}
+
+// @Security AdminToken
+// seed godoc
+// @Summary Start the ledger from the stored votes
+// @Description Records the stored votes of every code, and whether it is used, as imported ballots.
+// @Description It starts the ledger of an event that had votes before the ledger existed, and is refused once the ledger has events
+// @Tags admin
+// @Produce json
+// @Success 200 {object} models.LedgerSeedResponse
+// @Failure 409 {object} models.ErrorResponse "The ledger has events"
+// @Failure 500 {object} models.ErrorResponse
+// @Router /api/admin/ledger/seed [post]
+func (c *LedgerController) seed(g *gin.Context) {
+	ctx := g.Request.Context()
+	events, err := c.ledgerStorage.Query(ctx, storage.LedgerFilter{})
+	if err != nil {
+		logging.Log.Errorf("ADMIN: failed to query the ledger: %v", err)
+		g.JSON(storageErrorStatus(err), models.ErrorResponse{Error: err.Error()})
+		return
+	}
+	if len(events) > 0 {
+		g.JSON(http.StatusConflict, models.ErrorResponse{Error: "the ledger already has events"})
+		return
+	}
+	votes, err := c.votesStorage.GetAll(ctx)
+	if err != nil {
+		logging.Log.Errorf("ADMIN: failed to load votes for ledger seed: %v", err)
+		g.JSON(storageErrorStatus(err), models.ErrorResponse{Error: "could not load votes"})
+		return
+	}
+	codes, err := c.codesStorage.GetAll(ctx)
+	if err != nil {
+		logging.Log.Errorf("ADMIN: failed to load codes for ledger seed: %v", err)
+		g.JSON(storageErrorStatus(err), models.ErrorResponse{Error: "could not load voting codes"})
+		return
+	}
+
+	response := models.LedgerSeedResponse{}
+	for _, event := range importedBallots(codes, votes, "seeded from the stored votes") {
+		if err := appendLedger(g, c.ledgerStorage, event); err != nil {
+			logging.Log.Errorf("ADMIN: ledger seed failed after %d ballots: %v", response.Ballots, err)
+			g.JSON(storageErrorStatus(err), models.ErrorResponse{Error: "could not record ballots, the seed is partial"})
+			return
+		}
+		response.Ballots++
+		response.Votes += len(event.Votes)
+	}
+	logging.Log.Infof("ADMIN: seeded the ledger with %d ballots and %d votes", response.Ballots, response.Votes)
+	auditAfter(g, response)
+	g.JSON(http.StatusOK, response)
+}
+
+// importedBallots are the ledger events of codes and votes loaded by an admin, one per code that is used or has votes
+func importedBallots(codes []*storage.VotingCode, votes []*storage.Vote, note string) []*storage.LedgerEvent {
+	byCode := make(map[string][]*storage.Vote)
+	for _, v := range votes {
+		byCode[v.Code] = append(byCode[v.Code], v)
+	}
+	events := make([]*storage.LedgerEvent, 0, len(byCode))
+	add := func(code *storage.VotingCode) {
+		events = append(events, &storage.LedgerEvent{
+			Type:       storage.LedgerBallotImported,
+			Code:       code.Code,
+			VoterGroup: code.Category,
+			Votes:      byCode[code.Code],
+			Used:       code.Used,
+			Note:       note,
+		})
+	}
+	for _, code := range codes {
+		if code.Used || len(byCode[code.Code]) > 0 {
+			add(code)
+		}
+		delete(byCode, code.Code)
+	}
+	// Votes of deleted codes count without a voter group, like in the results
+	orphans := make([]string, 0, len(byCode))
+	for code := range byCode {
+		orphans = append(orphans, code)
+	}
+	sort.Strings(orphans)
+	for _, code := range orphans {
+		add(&storage.VotingCode{Code: code})
+	}
+	return events
+}
+
+// replay reads the events matching filter and applies them, writing the error response when that fails
+func (c *LedgerController) replay(g *gin.Context, filter storage.LedgerFilter) (*storage.LedgerProjection, bool) {
+	events, err := c.ledgerStorage.Query(g.Request.Context(), filter)
+	if err != nil {
+		logging.Log.Errorf("ADMIN: failed to query the ledger: %v", err)
+		g.JSON(storageErrorStatus(err), models.ErrorResponse{Error: "could not load the ledger"})
+		return nil, false
+	}
+	return storage.ReplayLedger(events), true
+}
+
+func (c *LedgerController) loadTeamsAndCategories(g *gin.Context) ([]*storage.VotingCategory, []*storage.Team, bool) {
+	categories, err := c.categoriesStorage.GetAll(g.Request.Context())
+	if err != nil {
+		logging.Log.Errorf("failed to load categories: %v", err)
+		g.JSON(storageErrorStatus(err), models.ErrorResponse{Error: "could not load categories"})
+		return nil, nil, false
+	}
+	teams, err := c.teamsStorage.GetAll(g.Request.Context())
+	if err != nil {
+		logging.Log.Errorf("failed to load teams: %v", err)
+		g.JSON(storageErrorStatus(err), models.ErrorResponse{Error: "could not load teams"})
+		return nil, nil, false
+	}
+	return categories, teams, true
+}
+
+// diffVotes compares the replayed votes with the stored ones by code and sort key. Changed votes are
+// returned as replayed.
+func diffVotes(replayed, stored []*storage.Vote) (missing, extra, changed []*storage.Vote) {
+	storedByKey := make(map[string]*storage.Vote, len(stored))
+	for _, v := range stored {
+		storedByKey[v.Code+"#"+v.SortKey] = v
+	}
+	for _, v := range replayed {
+		key := v.Code + "#" + v.SortKey
+		s, ok := storedByKey[key]
+		switch {
+		case !ok:
+			missing = append(missing, v)
+		case s.CategoryID != v.CategoryID || s.TeamID != v.TeamID || s.Rating != v.Rating:
+			changed = append(changed, v)
+		}
+		delete(storedByKey, key)
+	}
+	for _, v := range stored {
+		if _, ok := storedByKey[v.Code+"#"+v.SortKey]; ok {
+			extra = append(extra, v)
+		}
+	}
+	return missing, extra, changed
+}
@@ -0,0 +1,299 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	testutils "github.com/alex-pricope/simple-voting-system/api/controllers/testing"
+	"github.com/alex-pricope/simple-voting-system/api/models"
+	"github.com/alex-pricope/simple-voting-system/logging"
+	"github.com/alex-pricope/simple-voting-system/storage"
+	"github.com/alex-pricope/simple-voting-system/storage/memory"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+)
+
+type ledgerTestStorages struct {
+	codes  *memory.VotingCodesStorage
+	votes  *memory.VoteStorage
+	ledger *memory.LedgerStorage
+}
+
+// setupTestLedgerController registers every route that changes votes, and the ledger routes, over memory storages
+// with two categories, three teams and the codes A to E
+func setupTestLedgerController(t *testing.T) (*ledgerTestStorages, *gin.Engine) {
+	t.Helper()
+	logging.Log = logrus.New()
+	t.Setenv("ADMIN_TOKEN", "secret")
+
+	s := &ledgerTestStorages{codes: memory.NewVotingCodesStorage(), votes: memory.NewVoteStorage(), ledger: memory.NewLedgerStorage()}
+	teams, categories := memory.NewTeamStorage(), memory.NewVotingCategoryStorage()
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	NewVotingController(s.codes, s.votes, memory.NewBallotStorage(s.codes, s.votes), teams, categories, nil, nil, s.ledger).RegisterRoutes(r)
+	NewAdminController(s.codes, teams, s.votes, nil, s.ledger).RegisterRoutes(r)
+	NewTeamMetaController(teams, s.votes, s.codes, nil, s.ledger).RegisterRoutes(r)
+	NewLedgerController(s.ledger, s.codes, s.votes, teams, categories, nil).RegisterRoutes(r)
+
+	ctx := context.Background()
+	for _, vc := range []*storage.VotingCategory{{ID: 1, Name: "Cat1", Weight: 0.4}, {ID: 2, Name: "Cat2", Weight: 0.6}} {
+		require.NoError(t, categories.Create(ctx, vc))
+	}
+	for i := 1; i <= 3; i++ {
+		require.NoError(t, teams.Create(ctx, &storage.Team{ID: i, Name: fmt.Sprintf("Team %d", i)}))
+	}
+	for code, group := range map[string]string{"A": "grand_jury", "B": "grand_jury", "C": "general_public", "D": "general_public", "E": "other_team"} {
+		require.NoError(t, s.codes.Put(ctx, &storage.VotingCode{Code: code, Category: group}))
+	}
+	return s, r
+}
+
+func submitTestBallot(t *testing.T, router *gin.Engine, code string, seed int) {
+	t.Helper()
+	var entries []models.VoteEntry
+	for catID := 1; catID <= 2; catID++ {
+		for teamID := 1; teamID <= 3; teamID++ {
+			entries = append(entries, models.VoteEntry{CategoryID: catID, TeamID: teamID, Rating: (seed+teamID*catID)%5 + 1})
+		}
+	}
+	res := testutils.PerformRequest(router, http.MethodPost, "/api/vote", models.RegisterVoteRequest{Code: code, Votes: entries}, nil)
+	require.Equal(t, http.StatusOK, res.Code, res.Body.String())
+}
+
+func getTestJSON[T any](t *testing.T, router *gin.Engine, path string) T {
+	t.Helper()
+	res := testutils.PerformRequest(router, http.MethodGet, path, nil, map[string]string{"x-admin-token": "secret"})
+	require.Equal(t, http.StatusOK, res.Code, res.Body.String())
+	var v T
+	require.NoError(t, json.Unmarshal(res.Body.Bytes(), &v))
+	return v
+}
+
+func TestLedgerReplayReproducesResults(t *testing.T) {
+	s, router := setupTestLedgerController(t)
+	admin := map[string]string{"x-admin-token": "secret"}
+
+	// The results computed from the stored votes and replayed from the ledger are the same, to the last bit
+	assertReplayMatches := func(t *testing.T) models.VoteResultsResponse {
+		t.Helper()
+		live := getTestJSON[models.VoteResultsResponse](t, router, "/api/vote/results")
+		assert.Equal(t, live, getTestJSON[models.VoteResultsResponse](t, router, "/api/admin/ledger/results"))
+
+		verify := getTestJSON[models.LedgerVerifyResponse](t, router, "/api/admin/ledger/verify")
+		assert.True(t, verify.ResultsMatch)
+		assert.Equal(t, verify.StoredVotes, verify.Votes)
+		assert.Zero(t, verify.Missing+verify.Extra+verify.Changed)
+		return live
+	}
+
+	for i, code := range []string{"A", "B", "C", "D", "E"} {
+		submitTestBallot(t, router, code, i)
+	}
+	before := assertReplayMatches(t)
+	checkpoint := time.Now().UTC()
+	require.Equal(t, 5, before.UsedCodes)
+
+	res := testutils.PerformRequest(router, http.MethodPost, "/api/admin/codes/B/reset", nil, admin)
+	require.Equal(t, http.StatusOK, res.Code)
+	res = testutils.PerformRequest(router, http.MethodPost, "/api/admin/codes/C/invalidate?reason=shared+code", nil, admin)
+	require.Equal(t, http.StatusOK, res.Code, res.Body.String())
+	changed := assertReplayMatches(t)
+	assert.Equal(t, 4, changed.UsedCodes)
+	assert.Equal(t, 24, changed.TotalVotes)
+
+	t.Run("Replay up to a time gives the standings of that time", func(t *testing.T) {
+		at := getTestJSON[models.VoteResultsResponse](t, router, "/api/admin/ledger/results?at="+url.QueryEscape(checkpoint.Format(time.RFC3339Nano)))
+		assert.Equal(t, before, at)
+	})
+
+	res = testutils.PerformRequest(router, http.MethodDelete, "/api/meta/teams/3?cascade=true", nil, admin)
+	require.Equal(t, http.StatusOK, res.Code, res.Body.String())
+	res = testutils.PerformRequest(router, http.MethodDelete, "/api/admin/codes/E", nil, admin)
+	require.Equal(t, http.StatusOK, res.Code)
+	assertReplayMatches(t)
+
+	res = testutils.PerformRequest(router, http.MethodDelete, "/api/admin/votes", nil, admin)
+	require.Equal(t, http.StatusOK, res.Code)
+	for _, code := range []string{"A", "C"} {
+		res = testutils.PerformRequest(router, http.MethodPost, "/api/admin/codes/"+code+"/reset", nil, admin)
+		require.Equal(t, http.StatusOK, res.Code)
+	}
+	submitTestBallot(t, router, "A", 7)
+	submitTestBallot(t, router, "C", 3)
+	after := assertReplayMatches(t)
+	assert.Equal(t, 12, after.TotalVotes)
+
+	t.Run("The ledger lists the events of a code", func(t *testing.T) {
+		events := getTestJSON[[]models.LedgerEventResponse](t, router, "/api/admin/ledger?code=D")
+		require.Len(t, events, 3)
+		assert.Equal(t, storage.LedgerVotesDeleted, events[1].Type)
+		assert.Len(t, events[1].Votes, 8) // Team 3 in both categories, for A, B, D and E
+
+		events = getTestJSON[[]models.LedgerEventResponse](t, router, "/api/admin/ledger?code=C")
+		var types []string
+		for _, e := range events {
+			types = append(types, e.Type)
+		}
+		assert.Equal(t, []string{storage.LedgerBallotSubmitted, storage.LedgerVotesInvalidated, storage.LedgerAllVotesDeleted,
+			storage.LedgerCodeReset, storage.LedgerBallotSubmitted}, types)
+		assert.Equal(t, "shared code", events[1].Note)
+		assert.Equal(t, "admin", events[1].Actor)
+		assert.Equal(t, "general_public", events[0].VoterGroup)
+		assert.Len(t, events[0].Votes, 6)
+	})
+
+	t.Run("Rebuild repairs the stored votes and codes", func(t *testing.T) {
+		ctx := context.Background()
+		votes, err := s.votes.GetByCode(ctx, "A")
+		require.NoError(t, err)
+		require.NoError(t, s.votes.Delete(ctx, votes[:2]))
+		require.NoError(t, s.codes.MarkUnused(ctx, "C"))
+
+		verify := getTestJSON[models.LedgerVerifyResponse](t, router, "/api/admin/ledger/verify")
+		assert.Equal(t, 2, verify.Missing)
+		assert.False(t, verify.ResultsMatch)
+
+		res := testutils.PerformRequest(router, http.MethodPost, "/api/admin/ledger/rebuild", nil, admin)
+		require.Equal(t, http.StatusOK, res.Code, res.Body.String())
+		var rebuilt models.LedgerRebuildResponse
+		require.NoError(t, json.Unmarshal(res.Body.Bytes(), &rebuilt))
+		assert.Equal(t, 2, rebuilt.Created)
+		assert.Zero(t, rebuilt.Deleted)
+		assert.Equal(t, 1, rebuilt.Codes)
+
+		assert.Equal(t, after, assertReplayMatches(t))
+	})
+
+	t.Run("Rebuild keeps the votes the ledger does not have unless asked", func(t *testing.T) {
+		ctx := context.Background()
+		extra := &storage.Vote{Code: "B", SortKey: storage.VoteSortKey(1, 1), CategoryID: 1, TeamID: 1, Rating: 4}
+		require.NoError(t, s.votes.Create(ctx, extra))
+
+		res := testutils.PerformRequest(router, http.MethodPost, "/api/admin/ledger/rebuild", nil, admin)
+		require.Equal(t, http.StatusOK, res.Code, res.Body.String())
+		var rebuilt models.LedgerRebuildResponse
+		require.NoError(t, json.Unmarshal(res.Body.Bytes(), &rebuilt))
+		assert.Equal(t, 1, rebuilt.Extra)
+		assert.Zero(t, rebuilt.Deleted)
+		stored, err := s.votes.GetByCode(ctx, "B")
+		require.NoError(t, err)
+		assert.Len(t, stored, 1)
+
+		res = testutils.PerformRequest(router, http.MethodPost, "/api/admin/ledger/rebuild?deleteExtra=true", nil, admin)
+		require.Equal(t, http.StatusOK, res.Code, res.Body.String())
+		require.NoError(t, json.Unmarshal(res.Body.Bytes(), &rebuilt))
+		assert.Equal(t, 1, rebuilt.Deleted)
+		assert.Equal(t, after, assertReplayMatches(t))
+	})
+
+	t.Run("Seed is refused once the ledger has events", func(t *testing.T) {
+		res := testutils.PerformRequest(router, http.MethodPost, "/api/admin/ledger/seed", nil, admin)
+		assert.Equal(t, http.StatusConflict, res.Code)
+	})
+}
+
+func TestLedgerSeedFromStoredVotes(t *testing.T) {
+	s, router := setupTestLedgerController(t)
+	ctx := context.Background()
+
+	// Votes stored before the ledger existed, including those of a deleted code
+	for i, code := range []string{"A", "C", "Z"} {
+		for teamID := 1; teamID <= 3; teamID++ {
+			require.NoError(t, s.votes.Create(ctx, &storage.Vote{
+				Code: code, SortKey: storage.VoteSortKey(1, teamID), CategoryID: 1, TeamID: teamID, Rating: (i+teamID)%5 + 1,
+			}))
+		}
+		require.NoError(t, s.codes.MarkUsed(ctx, code))
+	}
+	require.NoError(t, s.codes.Delete(ctx, "Z"))
+	require.NoError(t, s.codes.MarkUsed(ctx, "D"))
+
+	verify := getTestJSON[models.LedgerVerifyResponse](t, router, "/api/admin/ledger/verify")
+	assert.Equal(t, 9, verify.Extra)
+
+	res := testutils.PerformRequest(router, http.MethodPost, "/api/admin/ledger/seed", nil, map[string]string{"x-admin-token": "secret"})
+	require.Equal(t, http.StatusOK, res.Code, res.Body.String())
+	var seeded models.LedgerSeedResponse
+	require.NoError(t, json.Unmarshal(res.Body.Bytes(), &seeded))
+	assert.Equal(t, 4, seeded.Ballots)
+	assert.Equal(t, 9, seeded.Votes)
+
+	verify = getTestJSON[models.LedgerVerifyResponse](t, router, "/api/admin/ledger/verify")
+	assert.True(t, verify.ResultsMatch)
+	assert.Zero(t, verify.Missing+verify.Extra+verify.Changed)
+	live := getTestJSON[models.VoteResultsResponse](t, router, "/api/vote/results")
+	assert.Equal(t, live, getTestJSON[models.VoteResultsResponse](t, router, "/api/admin/ledger/results"))
+}
+
+// failingLedger refuses appends while fail is set
+type failingLedger struct {
+	storage.LedgerStorage
+	fail bool
+}
+
+func (l *failingLedger) Append(ctx context.Context, event *storage.LedgerEvent) error {
+	if l.fail {
+		return errors.New("ledger unavailable")
+	}
+	return l.LedgerStorage.Append(ctx, event)
+}
+
+func TestBallotRolledBackWhenLedgerFails(t *testing.T) {
+	s, _ := setupTestLedgerController(t)
+	ledger := &failingLedger{LedgerStorage: s.ledger, fail: true}
+	router := gin.New()
+	teams, categories := memory.NewTeamStorage(), memory.NewVotingCategoryStorage()
+	NewVotingController(s.codes, s.votes, memory.NewBallotStorage(s.codes, s.votes), teams, categories, nil, nil, ledger).RegisterRoutes(router)
+	ctx := context.Background()
+
+	vote := models.RegisterVoteRequest{Code: "A", Votes: []models.VoteEntry{{CategoryID: 1, TeamID: 1, Rating: 5}}}
+	res := testutils.PerformRequest(router, http.MethodPost, "/api/vote", vote, nil)
+	assert.Equal(t, http.StatusInternalServerError, res.Code)
+
+	code, err := s.codes.Get(ctx, "A")
+	require.NoError(t, err)
+	assert.False(t, code.Used)
+	votes, err := s.votes.GetByCode(ctx, "A")
+	require.NoError(t, err)
+	assert.Empty(t, votes)
+
+	// The voter can submit again once the ledger is back
+	ledger.fail = false
+	res = testutils.PerformRequest(router, http.MethodPost, "/api/vote", vote, nil)
+	require.Equal(t, http.StatusOK, res.Code, res.Body.String())
+	events, err := s.ledger.Query(ctx, storage.LedgerFilter{Code: "A"})
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	assert.Equal(t, storage.LedgerBallotSubmitted, events[0].Type)
+}
+
+func TestLedgerEventsOfACodeCarryItsSequence(t *testing.T) {
+	s, router := setupTestLedgerController(t)
+	admin := map[string]string{"x-admin-token": "secret"}
+
+	submitTestBallot(t, router, "A", 1)
+	res := testutils.PerformRequest(router, http.MethodPost, "/api/admin/codes/A/invalidate", nil, admin)
+	require.Equal(t, http.StatusOK, res.Code, res.Body.String())
+	res = testutils.PerformRequest(router, http.MethodPost, "/api/admin/codes/A/reset", nil, admin)
+	require.Equal(t, http.StatusOK, res.Code)
+	submitTestBallot(t, router, "A", 2)
+	submitTestBallot(t, router, "B", 3)
+	res = testutils.PerformRequest(router, http.MethodDelete, "/api/admin/votes", nil, admin)
+	require.Equal(t, http.StatusOK, res.Code)
+
+	events, err := s.ledger.Query(context.Background(), storage.LedgerFilter{})
+	require.NoError(t, err)
+	seqs := make(map[string][]int64)
+	for _, e := range events {
+		seqs[e.Code] = append(seqs[e.Code], e.Seq)
+	}
+	assert.Equal(t, map[string][]int64{"A": {1, 2, 3, 4}, "B": {1}, "": {0}}, seqs,
+		"the changes of a code are numbered in the order they were stored, bulk changes are not")
+}
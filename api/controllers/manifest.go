@@ -0,0 +1,405 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/alex-pricope/simple-voting-system/api/models"
+	"github.com/alex-pricope/simple-voting-system/api/transport"
+	"github.com/alex-pricope/simple-voting-system/logging"
+	"github.com/alex-pricope/simple-voting-system/storage"
+	"github.com/gin-gonic/gin"
+	"gopkg.in/yaml.v3"
+	"io"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ManifestController compares a YAML manifest of the event setup with the stored teams, categories and codes,
+// and applies the difference. Applying the same manifest twice changes nothing the second time.
+type ManifestController struct {
+	teamsStorage      storage.TeamStorage
+	categoriesStorage storage.VotingCategoryStorage
+	codesStorage      storage.VotingCodeStorage
+	references        *referenceFinder
+	newCode           func() (string, error)
+}
+
+func NewManifestController(teams storage.TeamStorage, categories storage.VotingCategoryStorage, codes storage.VotingCodeStorage,
+	votes storage.VoteStorage) *ManifestController {
+	return &ManifestController{
+		teamsStorage:      teams,
+		categoriesStorage: categories,
+		codesStorage:      codes,
+		references:        &referenceFinder{votes: votes, codes: codes},
+		newCode:           generateShortCode,
+	}
+}
+
+func (c *ManifestController) RegisterRoutes(engine *gin.Engine) {
+	group := engine.Group("/api/admin/manifest", transport.AdminAuthMiddleware())
+
+	group.POST("/plan", c.plan)
+	group.POST("/apply", c.apply)
+}
+
+// manifestStep is one change of a plan and the write making it
+type manifestStep struct {
+	change models.ManifestChange
+	apply  func(ctx context.Context) error
+}
+
+// @Security AdminToken
+// plan godoc
+// @Summary Show what applying a manifest would change
+// @Description The body is the YAML manifest: teams, categories and the number of codes per voter group.
+// @Description Stored teams and categories missing from it are deleted, or archived when votes or codes point at them.
+// @Description A section left out of the manifest is not managed: a manifest without teams leaves the stored teams alone, an empty list (teams: []) removes them
+// @Tags admin
+// @Accept application/yaml
+// @Produce json
+// @Success 200 {object} models.ManifestPlanResponse
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /api/admin/manifest/plan [post]
+func (c *ManifestController) plan(g *gin.Context) {
+	c.run(g, false)
+}
+
+// @Security AdminToken
+// apply godoc
+// @Summary Apply a manifest
+// @Description Plans the manifest against the current data and applies the changes in order
+// @Tags admin
+// @Accept application/yaml
+// @Produce json
+// @Success 200 {object} models.ManifestPlanResponse
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 409 {object} models.ManifestPlanResponse
+// @Failure 500 {object} models.ManifestPlanResponse
+// @Router /api/admin/manifest/apply [post]
+func (c *ManifestController) apply(g *gin.Context) {
+	c.run(g, true)
+}
+
+func (c *ManifestController) run(g *gin.Context, apply bool) {
+	manifest, err := parseManifest(g.Request.Body)
+	if err != nil {
+		g.JSON(http.StatusBadRequest, models.ErrorResponse{Error: "invalid manifest: " + err.Error()})
+		return
+	}
+	if problems := validateManifest(manifest); len(problems) > 0 {
+		g.JSON(http.StatusBadRequest, models.ErrorResponse{Error: "invalid manifest: " + strings.Join(problems, "; ")})
+		return
+	}
+
+	ctx := g.Request.Context()
+	steps, warnings, err := c.buildPlan(ctx, manifest)
+	if err != nil {
+		logging.Log.Errorf("ADMIN: failed to plan manifest: %v", err)
+		g.JSON(storageErrorStatus(err), models.ErrorResponse{Error: "could not read the current setup: " + err.Error()})
+		return
+	}
+
+	response := models.ManifestPlanResponse{Changes: make([]models.ManifestChange, 0, len(steps)), Warnings: warnings}
+	if !apply {
+		for _, step := range steps {
+			response.Changes = append(response.Changes, step.change)
+		}
+		g.JSON(http.StatusOK, response)
+		return
+	}
+
+	defer func() { auditAfter(g, response.Changes) }()
+	for _, step := range steps {
+		if err := step.apply(ctx); err != nil {
+			logging.Log.Errorf("ADMIN: manifest %s of %s %s failed: %v", step.change.Action, step.change.Kind, step.change.ID, err)
+			response.Error = fmt.Sprintf("%s of %s %s failed: %v", step.change.Action, step.change.Kind, step.change.ID, err)
+			g.JSON(storageErrorStatus(err), response)
+			return
+		}
+		response.Changes = append(response.Changes, step.change)
+	}
+	response.Applied = true
+	logging.Log.Infof("ADMIN: applied manifest with %d changes", len(steps))
+	g.JSON(http.StatusOK, response)
+}
+
+func parseManifest(r io.Reader) (*models.Manifest, error) {
+	decoder := yaml.NewDecoder(r)
+	decoder.KnownFields(true)
+	var manifest models.Manifest
+	if err := decoder.Decode(&manifest); err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, errors.New("the manifest is empty")
+		}
+		return nil, err
+	}
+	return &manifest, nil
+}
+
+func validateManifest(manifest *models.Manifest) []string {
+	var problems []string
+	teams := make(map[int]bool, len(manifest.Teams))
+	for _, t := range manifest.Teams {
+		if t.ID <= 0 {
+			problems = append(problems, fmt.Sprintf("team %q has no id, ids start at 1", t.Name))
+			continue
+		}
+		if teams[t.ID] {
+			problems = append(problems, fmt.Sprintf("team %d is listed twice", t.ID))
+		}
+		teams[t.ID] = true
+		if t.Name == "" {
+			problems = append(problems, fmt.Sprintf("team %d has no name", t.ID))
+		}
+	}
+	categories := make(map[int]bool, len(manifest.Categories))
+	for _, vc := range manifest.Categories {
+		if vc.ID <= 0 {
+			problems = append(problems, fmt.Sprintf("category %q has no id, ids start at 1", vc.Name))
+			continue
+		}
+		if categories[vc.ID] {
+			problems = append(problems, fmt.Sprintf("category %d is listed twice", vc.ID))
+		}
+		categories[vc.ID] = true
+		if vc.Name == "" {
+			problems = append(problems, fmt.Sprintf("category %d has no name", vc.ID))
+		}
+		if vc.Weight < 0 {
+			problems = append(problems, fmt.Sprintf("category %d has a negative weight", vc.ID))
+		}
+	}
+	for group, count := range manifest.Codes {
+		if _, ok := models.ValidCategories[models.VotingCategory(group)]; !ok {
+			problems = append(problems, fmt.Sprintf("unknown voter group %q in codes", group))
+		}
+		if count < 0 {
+			problems = append(problems, fmt.Sprintf("negative code count for %s", group))
+		}
+	}
+	sort.Strings(problems)
+	return problems
+}
+
+// buildPlan returns the steps turning the stored setup into the manifest: teams, then categories, then codes.
+// A section missing from the manifest is nil and left as it is stored
+func (c *ManifestController) buildPlan(ctx context.Context, manifest *models.Manifest) ([]manifestStep, []string, error) {
+	teamSteps, err := c.planTeams(ctx, manifest.Teams)
+	if err != nil {
+		return nil, nil, err
+	}
+	categorySteps, err := c.planCategories(ctx, manifest.Categories)
+	if err != nil {
+		return nil, nil, err
+	}
+	codeSteps, warnings, err := c.planCodes(ctx, manifest.Codes)
+	if err != nil {
+		return nil, nil, err
+	}
+	return append(append(teamSteps, categorySteps...), codeSteps...), warnings, nil
+}
+
+func (c *ManifestController) planTeams(ctx context.Context, wanted []models.ManifestTeam) ([]manifestStep, error) {
+	if wanted == nil {
+		return nil, nil
+	}
+	teams, err := c.teamsStorage.GetAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(teams, func(i, j int) bool { return teams[i].ID < teams[j].ID })
+	stored := make(map[int]*storage.Team, len(teams))
+	for _, t := range teams {
+		stored[t.ID] = t
+	}
+
+	var steps []manifestStep
+	inManifest := make(map[int]bool, len(wanted))
+	for _, mt := range wanted {
+		inManifest[mt.ID] = true
+		change := models.ManifestChange{Kind: "team", ID: strconv.Itoa(mt.ID), Name: mt.Name}
+		team := &storage.Team{ID: mt.ID, Name: mt.Name, Description: mt.Description, Members: mt.Members}
+
+		current, ok := stored[mt.ID]
+		if !ok {
+			change.Action = models.ManifestCreate
+			steps = append(steps, manifestStep{change: change, apply: func(ctx context.Context) error {
+				return c.teamsStorage.Create(ctx, team)
+			}})
+			continue
+		}
+		change.Fields = changedFields(map[string]bool{
+			"name":        current.Name != team.Name,
+			"description": current.Description != team.Description,
+			"members":     !slices.Equal(current.Members, team.Members),
+			"archived":    current.Archived,
+		})
+		if len(change.Fields) == 0 {
+			continue
+		}
+		change.Action = models.ManifestUpdate
+		team.Version = current.Version
+		steps = append(steps, manifestStep{change: change, apply: func(ctx context.Context) error {
+			return c.teamsStorage.Update(ctx, team)
+		}})
+	}
+
+	for _, current := range teams {
+		if inManifest[current.ID] || current.Archived {
+			continue
+		}
+		refs, err := c.references.forTeam(ctx, current.ID)
+		if err != nil {
+			return nil, err
+		}
+		change := models.ManifestChange{Kind: "team", ID: strconv.Itoa(current.ID), Name: current.Name}
+		if refs.empty() {
+			change.Action = models.ManifestDelete
+			id := current.ID
+			steps = append(steps, manifestStep{change: change, apply: func(ctx context.Context) error {
+				return c.teamsStorage.Delete(ctx, id)
+			}})
+			continue
+		}
+		change.Action = models.ManifestArchive
+		archived := *current
+		archived.Archived = true
+		steps = append(steps, manifestStep{change: change, apply: func(ctx context.Context) error {
+			return c.teamsStorage.Update(ctx, &archived)
+		}})
+	}
+	return steps, nil
+}
+
+func (c *ManifestController) planCategories(ctx context.Context, wanted []models.ManifestCategory) ([]manifestStep, error) {
+	if wanted == nil {
+		return nil, nil
+	}
+	categories, err := c.categoriesStorage.GetAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(categories, func(i, j int) bool { return categories[i].ID < categories[j].ID })
+	stored := make(map[int]*storage.VotingCategory, len(categories))
+	for _, vc := range categories {
+		stored[vc.ID] = vc
+	}
+
+	var steps []manifestStep
+	inManifest := make(map[int]bool, len(wanted))
+	for _, mc := range wanted {
+		inManifest[mc.ID] = true
+		change := models.ManifestChange{Kind: "category", ID: strconv.Itoa(mc.ID), Name: mc.Name}
+		category := &storage.VotingCategory{ID: mc.ID, Name: mc.Name, Description: mc.Description, Weight: mc.Weight}
+
+		current, ok := stored[mc.ID]
+		if !ok {
+			change.Action = models.ManifestCreate
+			steps = append(steps, manifestStep{change: change, apply: func(ctx context.Context) error {
+				return c.categoriesStorage.Create(ctx, category)
+			}})
+			continue
+		}
+		change.Fields = changedFields(map[string]bool{
+			"name":        current.Name != category.Name,
+			"description": current.Description != category.Description,
+			"weight":      current.Weight != category.Weight,
+			"archived":    current.Archived,
+		})
+		if len(change.Fields) == 0 {
+			continue
+		}
+		change.Action = models.ManifestUpdate
+		category.Version = current.Version
+		steps = append(steps, manifestStep{change: change, apply: func(ctx context.Context) error {
+			return c.categoriesStorage.Update(ctx, category)
+		}})
+	}
+
+	for _, current := range categories {
+		if inManifest[current.ID] || current.Archived {
+			continue
+		}
+		refs, err := c.references.forCategory(ctx, current.ID)
+		if err != nil {
+			return nil, err
+		}
+		change := models.ManifestChange{Kind: "category", ID: strconv.Itoa(current.ID), Name: current.Name}
+		if refs.empty() {
+			change.Action = models.ManifestDelete
+			id := current.ID
+			steps = append(steps, manifestStep{change: change, apply: func(ctx context.Context) error {
+				return c.categoriesStorage.Delete(ctx, id)
+			}})
+			continue
+		}
+		change.Action = models.ManifestArchive
+		archived := *current
+		archived.Archived = true
+		steps = append(steps, manifestStep{change: change, apply: func(ctx context.Context) error {
+			return c.categoriesStorage.Update(ctx, &archived)
+		}})
+	}
+	return steps, nil
+}
+
+// planCodes generates the codes missing from each voter group. Codes may already be handed out, so a group
+// with more codes than the manifest asks for is only reported.
+func (c *ManifestController) planCodes(ctx context.Context, wanted map[string]int) ([]manifestStep, []string, error) {
+	if len(wanted) == 0 {
+		return nil, nil, nil
+	}
+	codes, err := c.codesStorage.GetAll(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	counts := make(map[string]int)
+	for _, code := range codes {
+		counts[code.Category]++
+	}
+
+	groups := make([]string, 0, len(wanted))
+	for group := range wanted {
+		groups = append(groups, group)
+	}
+	sort.Strings(groups)
+
+	var steps []manifestStep
+	var warnings []string
+	for _, group := range groups {
+		missing := wanted[group] - counts[group]
+		if missing < 0 {
+			warnings = append(warnings, fmt.Sprintf("%s has %d codes, the manifest asks for %d; codes are never deleted",
+				group, counts[group], wanted[group]))
+		}
+		if missing <= 0 {
+			continue
+		}
+		group := group
+		steps = append(steps, manifestStep{
+			change: models.ManifestChange{Action: models.ManifestCreate, Kind: "codes", ID: group, Count: missing},
+			apply: func(ctx context.Context) error {
+				_, err := createCodes(ctx, c.codesStorage, c.newCode, group, missing, nil)
+				return err
+			},
+		})
+	}
+	return steps, warnings, nil
+}
+
+// changedFields returns the names set to true, sorted
+func changedFields(changed map[string]bool) []string {
+	var fields []string
+	for name, ok := range changed {
+		if ok {
+			fields = append(fields, name)
+		}
+	}
+	sort.Strings(fields)
+	return fields
+}
@@ -0,0 +1,183 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	testutils "github.com/alex-pricope/simple-voting-system/api/controllers/testing"
+	"github.com/alex-pricope/simple-voting-system/api/models"
+	"github.com/alex-pricope/simple-voting-system/logging"
+	"github.com/alex-pricope/simple-voting-system/storage"
+	"github.com/alex-pricope/simple-voting-system/storage/memory"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testManifest = `
+teams:
+  - id: 1
+    name: Alpha
+    members: [Alice, Bob]
+  - id: 2
+    name: Beta
+  - id: 3
+    name: Gamma
+categories:
+  - id: 1
+    name: Design
+    weight: 0.4
+codes:
+  grand_jury: 2
+  general_public: 3
+`
+
+func setupTestManifestController(t *testing.T) (*ManifestController, *memory.VoteStorage, *gin.Engine) {
+	t.Helper()
+	logging.Log = logrus.New()
+	t.Setenv("ADMIN_TOKEN", "secret")
+
+	votes := memory.NewVoteStorage()
+	controller := NewManifestController(memory.NewTeamStorage(), memory.NewVotingCategoryStorage(), memory.NewVotingCodesStorage(), votes)
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	controller.RegisterRoutes(r)
+
+	return controller, votes, r
+}
+
+func postManifest(router *gin.Engine, path, manifest string) (*httptest.ResponseRecorder, models.ManifestPlanResponse) {
+	res := testutils.PerformRawRequest(router, http.MethodPost, path, "application/yaml", strings.NewReader(manifest), map[string]string{
+		"x-admin-token": "secret",
+	})
+	var body models.ManifestPlanResponse
+	_ = json.Unmarshal(res.Body.Bytes(), &body)
+	return res, body
+}
+
+func TestManifestPlanAndApply(t *testing.T) {
+	controller, votes, router := setupTestManifestController(t)
+	ctx := context.Background()
+
+	t.Run("Plan writes nothing", func(t *testing.T) {
+		res, body := postManifest(router, "/api/admin/manifest/plan", testManifest)
+		require.Equal(t, http.StatusOK, res.Code)
+		assert.False(t, body.Applied)
+		assert.Equal(t, []models.ManifestChange{
+			{Action: models.ManifestCreate, Kind: "team", ID: "1", Name: "Alpha"},
+			{Action: models.ManifestCreate, Kind: "team", ID: "2", Name: "Beta"},
+			{Action: models.ManifestCreate, Kind: "team", ID: "3", Name: "Gamma"},
+			{Action: models.ManifestCreate, Kind: "category", ID: "1", Name: "Design"},
+			{Action: models.ManifestCreate, Kind: "codes", ID: "general_public", Count: 3},
+			{Action: models.ManifestCreate, Kind: "codes", ID: "grand_jury", Count: 2},
+		}, body.Changes)
+
+		teams, err := controller.teamsStorage.GetAll(ctx)
+		require.NoError(t, err)
+		assert.Empty(t, teams)
+	})
+
+	t.Run("Happy path - apply, then applying again changes nothing", func(t *testing.T) {
+		res, body := postManifest(router, "/api/admin/manifest/apply", testManifest)
+		require.Equal(t, http.StatusOK, res.Code)
+		assert.True(t, body.Applied)
+		assert.Len(t, body.Changes, 6)
+
+		team, err := controller.teamsStorage.Get(ctx, 1)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"Alice", "Bob"}, team.Members)
+		codes, err := controller.codesStorage.GetAll(ctx)
+		require.NoError(t, err)
+		assert.Len(t, codes, 5)
+
+		res, body = postManifest(router, "/api/admin/manifest/apply", testManifest)
+		require.Equal(t, http.StatusOK, res.Code)
+		assert.True(t, body.Applied)
+		assert.Empty(t, body.Changes)
+	})
+
+	t.Run("Changed manifest updates, deletes and archives", func(t *testing.T) {
+		// Gamma has a vote, so it is archived instead of deleted
+		require.NoError(t, votes.Create(ctx, &storage.Vote{
+			Code: "AAAAA", SortKey: storage.VoteSortKey(1, 3), CategoryID: 1, TeamID: 3, Rating: 5, Timestamp: time.Now(),
+		}))
+		changed := `
+teams:
+  - id: 1
+    name: Alpha
+    description: Renamed
+    members: [Alice]
+categories:
+  - id: 1
+    name: Design
+    weight: 0.5
+codes:
+  grand_jury: 1
+`
+		res, body := postManifest(router, "/api/admin/manifest/apply", changed)
+		require.Equal(t, http.StatusOK, res.Code)
+		assert.Equal(t, []models.ManifestChange{
+			{Action: models.ManifestUpdate, Kind: "team", ID: "1", Name: "Alpha", Fields: []string{"description", "members"}},
+			{Action: models.ManifestDelete, Kind: "team", ID: "2", Name: "Beta"},
+			{Action: models.ManifestArchive, Kind: "team", ID: "3", Name: "Gamma"},
+			{Action: models.ManifestUpdate, Kind: "category", ID: "1", Name: "Design", Fields: []string{"weight"}},
+		}, body.Changes)
+		assert.Equal(t, []string{"grand_jury has 2 codes, the manifest asks for 1; codes are never deleted"}, body.Warnings)
+
+		_, err := controller.teamsStorage.Get(ctx, 2)
+		assert.ErrorIs(t, err, storage.ErrNotFound)
+		team, err := controller.teamsStorage.Get(ctx, 3)
+		require.NoError(t, err)
+		assert.True(t, team.Archived)
+
+		res, body = postManifest(router, "/api/admin/manifest/plan", changed)
+		require.Equal(t, http.StatusOK, res.Code)
+		assert.Empty(t, body.Changes)
+	})
+
+	t.Run("Listing an archived team restores it", func(t *testing.T) {
+		res, body := postManifest(router, "/api/admin/manifest/plan", "teams:\n  - id: 3\n    name: Gamma\n")
+		require.Equal(t, http.StatusOK, res.Code)
+		require.NotEmpty(t, body.Changes)
+		assert.Equal(t, models.ManifestChange{Action: models.ManifestUpdate, Kind: "team", ID: "3", Name: "Gamma", Fields: []string{"archived"}}, body.Changes[0])
+	})
+
+	t.Run("Missing sections are left alone", func(t *testing.T) {
+		res, body := postManifest(router, "/api/admin/manifest/plan", "codes:\n  grand_jury: 3\n")
+		require.Equal(t, http.StatusOK, res.Code)
+		assert.Equal(t, []models.ManifestChange{
+			{Action: models.ManifestCreate, Kind: "codes", ID: "grand_jury", Count: 1},
+		}, body.Changes)
+
+		res, body = postManifest(router, "/api/admin/manifest/plan", "teams: []\n")
+		require.Equal(t, http.StatusOK, res.Code)
+		assert.Equal(t, []models.ManifestChange{
+			{Action: models.ManifestDelete, Kind: "team", ID: "1", Name: "Alpha"},
+		}, body.Changes)
+	})
+}
+
+func TestInvalidManifest(t *testing.T) {
+	_, _, router := setupTestManifestController(t)
+
+	for name, manifest := range map[string]string{
+		"Empty":            "",
+		"Unknown field":    "teams:\n  - id: 1\n    name: Alpha\n    colour: red\n",
+		"Duplicate team":   "teams:\n  - id: 1\n    name: Alpha\n  - id: 1\n    name: Beta\n",
+		"Unknown group":    "codes:\n  jury: 3\n",
+		"Missing team id":  "teams:\n  - name: Alpha\n",
+		"Zero category id": "categories:\n  - id: 0\n    name: Design\n",
+		"Negative weight":  "categories:\n  - id: 1\n    name: Design\n    weight: -1\n",
+		"Not YAML":         "teams: [",
+	} {
+		t.Run(name, func(t *testing.T) {
+			res, _ := postManifest(router, "/api/admin/manifest/plan", manifest)
+			assert.Equal(t, http.StatusBadRequest, res.Code)
+		})
+	}
+}
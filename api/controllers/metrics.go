@@ -0,0 +1,48 @@
+package controllers
+
+import (
+	"github.com/alex-pricope/simple-voting-system/api/transport"
+	"github.com/alex-pricope/simple-voting-system/storage/instrumented"
+	"github.com/gin-gonic/gin"
+	"net/http"
+)
+
+// StorageMetricsController exposes the stats of the instrumented storages
+type StorageMetricsController struct {
+	recorder *instrumented.Recorder
+}
+
+func NewStorageMetricsController(recorder *instrumented.Recorder) *StorageMetricsController {
+	return &StorageMetricsController{recorder: recorder}
+}
+
+func (c *StorageMetricsController) RegisterRoutes(engine *gin.Engine) {
+	group := engine.Group("/api/admin/metrics", transport.AdminAuthMiddleware())
+
+	group.GET("/storage", c.get)
+	group.DELETE("/storage", c.reset)
+}
+
+// @Security AdminToken
+// get godoc
+// @Summary Storage call stats
+// @Description Calls, errors, slow calls and latency per table and method since start or the last reset. Only registered when storage.instrument is enabled
+// @Tags admin
+// @Produce json
+// @Success 200 {array} instrumented.OperationStats
+// @Router /api/admin/metrics/storage [get]
+func (c *StorageMetricsController) get(g *gin.Context) {
+	g.JSON(http.StatusOK, c.recorder.Snapshot())
+}
+
+// @Security AdminToken
+// reset godoc
+// @Summary Reset the storage call stats
+// @Tags admin
+// @Produce json
+// @Success 200 {object} map[string]string
+// @Router /api/admin/metrics/storage [delete]
+func (c *StorageMetricsController) reset(g *gin.Context) {
+	c.recorder.Reset()
+	g.JSON(http.StatusOK, gin.H{"message": "storage stats reset"})
+}
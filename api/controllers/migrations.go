@@ -0,0 +1,110 @@
+package controllers
+
+import (
+	"github.com/alex-pricope/simple-voting-system/api/models"
+	"github.com/alex-pricope/simple-voting-system/api/transport"
+	"github.com/alex-pricope/simple-voting-system/logging"
+	"github.com/alex-pricope/simple-voting-system/storage"
+	"github.com/gin-gonic/gin"
+	"net/http"
+)
+
+// MigrationController brings the stored items of an event up to the current item shapes,
+// and copies the event into the single-table layout. Without a migrator, as in the single-table
+// layout, only the copy is registered.
+type MigrationController struct {
+	migrator storage.Migrator
+	copier   storage.SingleTableCopier
+}
+
+func NewMigrationController(migrator storage.Migrator, copier storage.SingleTableCopier) *MigrationController {
+	return &MigrationController{migrator: migrator, copier: copier}
+}
+
+func (c *MigrationController) RegisterRoutes(engine *gin.Engine) {
+	group := engine.Group("/api/admin/migrations", transport.AdminAuthMiddleware())
+
+	if c.migrator != nil {
+		group.GET("", c.status)
+		group.POST("/run", c.run)
+	}
+	group.POST("/single-table", c.copyToSingleTable)
+}
+
+// @Security AdminToken
+// status godoc
+// @Summary List the item migrations
+// @Description Every migration with the table it rewrites and, once applied, when and how many items it rewrote. Only registered with the dynamo backend
+// @Tags admin
+// @Produce json
+// @Success 200 {array} models.MigrationResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /api/admin/migrations [get]
+func (c *MigrationController) status(g *gin.Context) {
+	results, err := c.migrator.Status(g.Request.Context())
+	if err != nil {
+		logging.Log.Errorf("ADMIN: failed to read the migration records: %v", err)
+		g.JSON(storageErrorStatus(err), models.ErrorResponse{Error: err.Error()})
+		return
+	}
+	g.JSON(http.StatusOK, models.TransformMigrationResults(results))
+}
+
+// @Security AdminToken
+// run godoc
+// @Summary Apply the pending item migrations
+// @Description Scans each table with a pending migration, rewrites the items that need it and records the migration, in version order.
+// @Description A failed run stops at the failing migration and can be run again. Only the missing attributes are written, item by item
+// @Tags admin
+// @Produce json
+// @Param dryRun query bool false "Only count the items that would be rewritten"
+// @Success 200 {object} models.MigrationRunResponse
+// @Failure 500 {object} models.MigrationRunResponse
+// @Router /api/admin/migrations/run [post]
+func (c *MigrationController) run(g *gin.Context) {
+	dryRun := g.Query("dryRun") == "true"
+	results, err := c.migrator.Run(g.Request.Context(), dryRun)
+	response := models.MigrationRunResponse{DryRun: dryRun, Migrations: models.TransformMigrationResults(results)}
+	if !dryRun {
+		auditAfter(g, response.Migrations)
+	}
+	if err != nil {
+		logging.Log.Errorf("ADMIN: migration run failed: %v", err)
+		response.Error = err.Error()
+		g.JSON(storageErrorStatus(err), response)
+		return
+	}
+	g.JSON(http.StatusOK, response)
+}
+
+// @Security AdminToken
+// copyToSingleTable godoc
+// @Summary Copy the event into the single table
+// @Description Reads the codes, votes, teams and categories of the event from their four tables and puts them into the partition
+// @Description of the event in the single table (storage.tableNameSingle), to switch to storage.tableLayout single afterwards.
+// @Description A partition that already has items is refused, since it may be served with codes claimed since the copy.
+// @Description With overwrite the partition is replaced by the four tables, so a failed copy is finished by running it again with overwrite. Run it while nobody votes
+// @Tags admin
+// @Produce json
+// @Param dryRun query bool false "Only count the items that would be copied"
+// @Param overwrite query bool false "Replace the items already in the partition"
+// @Success 200 {object} models.SingleTableCopyResponse
+// @Failure 409 {object} models.SingleTableCopyResponse
+// @Failure 500 {object} models.SingleTableCopyResponse
+// @Router /api/admin/migrations/single-table [post]
+func (c *MigrationController) copyToSingleTable(g *gin.Context) {
+	dryRun := g.Query("dryRun") == "true"
+	overwrite := g.Query("overwrite") == "true"
+	result, err := c.copier.Copy(g.Request.Context(), dryRun, overwrite)
+	response := models.TransformSingleTableCopyResult(dryRun, result)
+	if !dryRun {
+		auditAfter(g, response)
+	}
+	if err != nil {
+		logging.Log.Errorf("ADMIN: copy into the single table failed: %v", err)
+		response.Error = err.Error()
+		g.JSON(storageErrorStatus(err), response)
+		return
+	}
+	g.JSON(http.StatusOK, response)
+}
@@ -0,0 +1,66 @@
+package controllers
+
+import (
+	"errors"
+	"github.com/alex-pricope/simple-voting-system/storage"
+	"github.com/gin-gonic/gin"
+	"strconv"
+)
+
+const (
+	defaultPageLimit = 100
+	maxPageLimit     = 1000
+)
+
+var errInvalidPageLimit = errors.New("invalid limit")
+
+type pageRequest struct {
+	limit int
+	next  string
+}
+
+// parsePageRequest reads the limit and next query parameters.
+// The second return value is false when the caller did not ask for paging, so list
+// endpoints keep returning the plain array the UI expects.
+func parsePageRequest(g *gin.Context) (pageRequest, bool, error) {
+	limitStr, hasLimit := g.GetQuery("limit")
+	next, hasNext := g.GetQuery("next")
+	if !hasLimit && !hasNext {
+		return pageRequest{}, false, nil
+	}
+
+	page := pageRequest{limit: defaultPageLimit, next: next}
+	if hasLimit {
+		limit, err := strconv.Atoi(limitStr)
+		if err != nil || limit < 1 || limit > maxPageLimit {
+			return pageRequest{}, true, errInvalidPageLimit
+		}
+		page.limit = limit
+	}
+	return page, true, nil
+}
+
+// paginateByID pages through items already sorted by ascending ID, using the last returned ID as the token.
+func paginateByID[T any](items []T, id func(T) int, page pageRequest) ([]T, string, error) {
+	start := 0
+	if page.next != "" {
+		key, err := storage.DecodePageToken(page.next)
+		if err != nil {
+			return nil, "", err
+		}
+		after, err := strconv.Atoi(key)
+		if err != nil {
+			return nil, "", storage.ErrInvalidPageToken
+		}
+		for start < len(items) && id(items[start]) <= after {
+			start++
+		}
+	}
+
+	end := min(start+page.limit, len(items))
+	var next string
+	if end < len(items) {
+		next = storage.EncodePageToken(strconv.Itoa(id(items[end-1])))
+	}
+	return items[start:end], next, nil
+}
@@ -0,0 +1,114 @@
+package controllers
+
+import (
+	"context"
+	"github.com/alex-pricope/simple-voting-system/api/transport"
+	"github.com/alex-pricope/simple-voting-system/logging"
+	"github.com/alex-pricope/simple-voting-system/storage"
+	"github.com/gin-gonic/gin"
+)
+
+// references are the votes and voting codes pointing at a team or category
+type references struct {
+	votes []*storage.Vote
+	codes []*storage.VotingCode
+}
+
+func (r *references) empty() bool {
+	return len(r.votes) == 0 && len(r.codes) == 0
+}
+
+// referenceFinder looks up what points at a team or category before it is deleted.
+// Deletes are rare, so codes are read from the full codes table.
+type referenceFinder struct {
+	votes   storage.VoteStorage
+	codes   storage.VotingCodeStorage
+	tallies storage.TallyStorage  // The votes a cascade deletes are subtracted, nil when the results are computed from the votes
+	ledger  storage.LedgerStorage // Records the votes a cascade deletes
+}
+
+// forTeam returns the votes for the team and the codes attached to it
+func (f *referenceFinder) forTeam(ctx context.Context, teamID int) (*references, error) {
+	votes, err := f.votes.GetByTeam(ctx, teamID)
+	if err != nil {
+		return nil, err
+	}
+	refs := &references{votes: votes}
+
+	codes, err := f.codes.GetAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range codes {
+		if c.TeamID != nil && *c.TeamID == teamID {
+			refs.codes = append(refs.codes, c)
+		}
+	}
+	return refs, nil
+}
+
+// forCategory returns the votes in the category. Codes carry a voter group, not a category,
+// so only votes can point at a category.
+func (f *referenceFinder) forCategory(ctx context.Context, categoryID int) (*references, error) {
+	votes, err := f.votes.GetByCategory(ctx, categoryID)
+	if err != nil {
+		return nil, err
+	}
+	return &references{votes: votes}, nil
+}
+
+// cascade deletes the referencing votes and detaches the referencing codes from their team.
+// The codes stay used, their ballots are only partially deleted.
+func (f *referenceFinder) cascade(g *gin.Context, refs *references) error {
+	ctx := g.Request.Context()
+	if len(refs.votes) > 0 {
+		if err := f.votes.Delete(ctx, refs.votes); err != nil {
+			return err
+		}
+		recordLedger(g, f.ledger, &storage.LedgerEvent{Type: storage.LedgerVotesDeleted, Votes: refs.votes})
+		f.subtractTallies(ctx, refs.votes)
+	}
+	for _, code := range refs.codes {
+		code.TeamID = nil
+		if err := f.codes.Overwrite(ctx, code); err != nil {
+			return err
+		}
+	}
+	logging.Log.Infof("META: cascade deleted %d votes and detached %d codes", len(refs.votes), len(refs.codes))
+	return nil
+}
+
+// subtractTallies takes the deleted votes out of the tallies, by the voter group of their code.
+// A code left without votes no longer counts as a ballot, as in a rebuild.
+func (f *referenceFinder) subtractTallies(ctx context.Context, votes []*storage.Vote) {
+	if f.tallies == nil {
+		return
+	}
+	codes, err := f.codes.GetAll(ctx)
+	if err != nil {
+		logging.Log.Errorf("META: failed to load codes for the tallies, rebuild them with POST /api/admin/tallies/rebuild: %v", err)
+		return
+	}
+	groups := make(map[string]string, len(codes))
+	for _, c := range codes {
+		groups[c.Code] = c.Category
+	}
+
+	var emptied []string
+	for _, code := range votedCodes(votes) {
+		left, err := f.votes.GetByCode(ctx, code)
+		if err != nil {
+			logging.Log.Errorf("META: failed to load the votes of code %s for the tallies: %v", code, err)
+			continue
+		}
+		if len(left) == 0 {
+			emptied = append(emptied, code)
+		}
+	}
+	subtractTallies(ctx, f.tallies, groups, votes, emptied)
+}
+
+// includeArchived reports whether a list request asked for archived items, which only admins get to see
+func includeArchived(g *gin.Context) bool {
+	return g.Query("includeArchived") == "true" && transport.IsAdmin(g)
+}
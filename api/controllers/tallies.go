@@ -0,0 +1,108 @@
+package controllers
+
+import (
+	"context"
+	"github.com/alex-pricope/simple-voting-system/api/models"
+	"github.com/alex-pricope/simple-voting-system/api/transport"
+	"github.com/alex-pricope/simple-voting-system/logging"
+	"github.com/alex-pricope/simple-voting-system/storage"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"sort"
+)
+
+// TallyController repairs the materialized tallies used for the results
+type TallyController struct {
+	codesStorage   storage.VotingCodeStorage
+	votesStorage   storage.VoteStorage
+	talliesStorage storage.TallyStorage
+}
+
+func NewTallyController(codes storage.VotingCodeStorage, votes storage.VoteStorage, tallies storage.TallyStorage) *TallyController {
+	return &TallyController{codesStorage: codes, votesStorage: votes, talliesStorage: tallies}
+}
+
+func (c *TallyController) RegisterRoutes(engine *gin.Engine) {
+	group := engine.Group("/api/admin/tallies", transport.AdminAuthMiddleware())
+
+	group.POST("/rebuild", c.rebuild)
+}
+
+// @Security AdminToken
+// rebuild godoc
+// @Summary Rebuild the tallies from the raw votes
+// @Description Recounts every stored vote and replaces the tallies, repairing drift after failed tally updates.
+// @Description Ballots submitted while it runs may be missed, run it again once voting is quiet. Only registered when storage.results is tallies
+// @Tags admin
+// @Produce json
+// @Success 200 {object} models.RebuildTalliesResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /api/admin/tallies/rebuild [post]
+func (c *TallyController) rebuild(g *gin.Context) {
+	ctx := g.Request.Context()
+
+	votes, err := c.votesStorage.GetAll(ctx)
+	if err != nil {
+		logging.Log.Errorf("ADMIN: failed to load votes for tally rebuild: %v", err)
+		g.JSON(storageErrorStatus(err), models.ErrorResponse{Error: "could not load votes"})
+		return
+	}
+	codes, err := c.codesStorage.GetAll(ctx)
+	if err != nil {
+		logging.Log.Errorf("ADMIN: failed to load codes for tally rebuild: %v", err)
+		g.JSON(storageErrorStatus(err), models.ErrorResponse{Error: "could not load voting codes"})
+		return
+	}
+
+	tallies := storage.BuildTallies(votes, codes)
+	if err := c.talliesStorage.Replace(ctx, tallies); err != nil {
+		logging.Log.Errorf("ADMIN: failed to replace tallies: %v", err)
+		g.JSON(storageErrorStatus(err), models.ErrorResponse{Error: "could not write tallies"})
+		return
+	}
+
+	logging.Log.Infof("ADMIN: rebuilt %d tallies from %d votes of %d ballots", len(tallies.Scores), len(votes), tallies.Ballots)
+	g.JSON(http.StatusOK, models.RebuildTalliesResponse{
+		Ballots: tallies.Ballots,
+		Votes:   len(votes),
+		Tallies: len(tallies.Scores),
+	})
+}
+
+// subtractTallies takes deleted votes out of the tallies, by the voter group of their code in groups.
+// The emptied codes are left without votes and no longer count as ballots. Like a failed Add,
+// a failure only leaves the tallies behind the votes until they are rebuilt.
+func subtractTallies(ctx context.Context, tallies storage.TallyStorage, groups map[string]string, votes []*storage.Vote, emptied []string) {
+	if tallies == nil {
+		return
+	}
+	byGroup := make(map[string][]*storage.Vote)
+	for _, v := range votes {
+		byGroup[groups[v.Code]] = append(byGroup[groups[v.Code]], v)
+	}
+	ballots := make(map[string]int)
+	for _, code := range emptied {
+		ballots[groups[code]]++
+	}
+
+	for group, groupVotes := range byGroup {
+		if err := tallies.Subtract(ctx, group, groupVotes, ballots[group]); err != nil {
+			logging.Log.Errorf("Failed to subtract %d votes of group %q from the tallies, rebuild them with POST /api/admin/tallies/rebuild: %v",
+				len(groupVotes), group, err)
+		}
+	}
+}
+
+// votedCodes returns the sorted codes of the votes, each once
+func votedCodes(votes []*storage.Vote) []string {
+	seen := make(map[string]bool)
+	var codes []string
+	for _, v := range votes {
+		if !seen[v.Code] {
+			seen[v.Code] = true
+			codes = append(codes, v.Code)
+		}
+	}
+	sort.Strings(codes)
+	return codes
+}
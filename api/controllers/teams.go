@@ -13,11 +13,13 @@ import (
 )
 
 type TeamMetaController struct {
-	storage storage.TeamStorage
+	storage    storage.TeamStorage
+	references *referenceFinder
 }
 
-func NewTeamMetaController(s storage.TeamStorage) *TeamMetaController {
-	return &TeamMetaController{storage: s}
+func NewTeamMetaController(s storage.TeamStorage, votes storage.VoteStorage, codes storage.VotingCodeStorage, tallies storage.TallyStorage,
+	ledger storage.LedgerStorage) *TeamMetaController {
+	return &TeamMetaController{storage: s, references: &referenceFinder{votes: votes, codes: codes, tallies: tallies, ledger: ledger}}
 }
 
 func (c *TeamMetaController) RegisterRoutes(engine *gin.Engine) {
@@ -27,20 +29,35 @@ func (c *TeamMetaController) RegisterRoutes(engine *gin.Engine) {
 	group.GET("/:id", transport.AdminAuthMiddleware(), c.get)
 	group.POST("", transport.AdminAuthMiddleware(), c.create)
 	group.PUT("/:id", transport.AdminAuthMiddleware(), c.update)
+	group.PATCH("/:id", transport.AdminAuthMiddleware(), c.patch)
 	group.DELETE("/:id", transport.AdminAuthMiddleware(), c.delete)
+	group.POST("/:id/archive", transport.AdminAuthMiddleware(), c.archive)
+	group.POST("/:id/restore", transport.AdminAuthMiddleware(), c.restore)
 }
 
 // @Summary Get all teams
+// @Description Returns every team that is not archived as a plain array of models.TeamResponse.
+// @Description With limit or next it returns a single page instead, in the envelope documented below: items and the next token, absent on the last page
 // @Tags Meta/Teams
 // @Produce json
-// @Success 200 {array} models.TeamResponse
+// @Param includeArchived query bool false "Also return archived teams, needs the admin token"
+// @Param limit query int false "Page size (1-1000), enables paging"
+// @Param next query string false "Token from the previous page"
+// @Success 200 {object} models.PageResponse[models.TeamResponse] "A page of teams with limit or next, otherwise the items alone as an array"
+// @Failure 400 {object} models.ErrorResponse
 // @Failure 500 {object} models.ErrorResponse
 // @Router /api/meta/teams [get]
 func (c *TeamMetaController) getAll(g *gin.Context) {
+	page, paged, err := parsePageRequest(g)
+	if err != nil {
+		g.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+
 	teams, err := c.storage.GetAll(g.Request.Context())
 	if err != nil {
 		logging.Log.Errorf("META: failed to get all teams: %v", err)
-		g.JSON(http.StatusInternalServerError, models.ErrorResponse{Error: err.Error()})
+		g.JSON(storageErrorStatus(err), models.ErrorResponse{Error: err.Error()})
 		return
 	}
 
@@ -48,17 +65,34 @@ func (c *TeamMetaController) getAll(g *gin.Context) {
 	sort.SliceStable(teams, func(i, j int) bool {
 		return teams[i].ID < teams[j].ID
 	})
+	withArchived := includeArchived(g)
 	responses := make([]models.TeamResponse, 0, len(teams))
 	for _, t := range teams {
+		if t.Archived && !withArchived {
+			continue
+		}
 		responses = append(responses, models.TransformTeamFromStorage(t))
 	}
+
+	if paged {
+		// Teams are few, so they are paged after loading and sorting the full list
+		items, next, err := paginateByID(responses, func(r models.TeamResponse) int { return r.ID }, page)
+		if err != nil {
+			g.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+			return
+		}
+		g.JSON(http.StatusOK, models.PageResponse[models.TeamResponse]{Items: items, Next: next})
+		return
+	}
 	g.JSON(http.StatusOK, responses)
 }
 
 // @Summary Get a team by ID
 // @Tags Meta/Teams
 // @Produce json
+// @Description An archived team is not found, unless an admin asks for it with includeArchived
 // @Param id path int true "Team ID"
+// @Param includeArchived query bool false "Also return an archived team, needs the admin token"
 // @Success 200 {object} models.TeamResponse
 // @Failure 400 {object} models.ErrorResponse
 // @Failure 404 {object} models.ErrorResponse
@@ -72,13 +106,11 @@ func (c *TeamMetaController) get(g *gin.Context) {
 		return
 	}
 	team, err := c.storage.Get(g.Request.Context(), id)
-	if err != nil {
-		logging.Log.Errorf("META: failed to get team: %v", err)
-		g.JSON(http.StatusInternalServerError, models.ErrorResponse{Error: err.Error()})
-		return
+	if err == nil && team.Archived && !includeArchived(g) {
+		err = storage.ErrNotFound
 	}
-	if team == nil {
-		g.JSON(http.StatusNotFound, models.ErrorResponse{Error: "team not found"})
+	if err != nil {
+		writeStorageError(g, "team", err)
 		return
 	}
 	g.JSON(http.StatusOK, models.TransformTeamFromStorage(team))
@@ -123,17 +155,19 @@ func (c *TeamMetaController) create(g *gin.Context) {
 	}
 
 	if err := c.storage.Create(g.Request.Context(), team); err != nil {
-		if errors.Is(err, storage.ErrItemWithIDAlreadyExists) {
+		if errors.Is(err, storage.ErrAlreadyExists) {
 			logging.Log.Warnf("META: team with ID %d already exists", req.ID)
 			g.JSON(http.StatusConflict, models.ErrorResponse{Error: "team with ID already exists"})
 			return
 		}
 
 		logging.Log.Errorf("META: failed to create team: %v", err)
-		g.JSON(http.StatusInternalServerError, models.ErrorResponse{Error: err.Error()})
+		g.JSON(storageErrorStatus(err), models.ErrorResponse{Error: err.Error()})
 		return
 	}
-	g.JSON(http.StatusOK, models.TransformTeamFromStorage(team))
+	response := models.TransformTeamFromStorage(team)
+	auditAfter(g, response)
+	g.JSON(http.StatusOK, response)
 }
 
 // @Security AdminToken
@@ -145,6 +179,8 @@ func (c *TeamMetaController) create(g *gin.Context) {
 // @Param team body models.TeamUpdateRequest true "Team update object"
 // @Success 200 {object} models.TeamResponse
 // @Failure 400 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 409 {object} models.ErrorResponse
 // @Failure 500 {object} models.ErrorResponse
 // @Router /api/meta/teams/{id} [put]
 func (c *TeamMetaController) update(g *gin.Context) {
@@ -167,28 +203,108 @@ func (c *TeamMetaController) update(g *gin.Context) {
 		return
 	}
 
-	team := &storage.Team{
-		ID:          id,
-		Name:        req.Name,
-		Description: req.Description,
-		Members:     req.Members,
+	team, ok := c.getForUpdate(g, id)
+	if !ok {
+		return
+	}
+	team.Name = req.Name
+	team.Description = req.Description
+	team.Members = req.Members
+	if req.Version != nil {
+		team.Version = *req.Version
 	}
 
 	if err := c.storage.Update(g.Request.Context(), team); err != nil {
-		logging.Log.Errorf("META: failed to update team: %v", err)
-		g.JSON(http.StatusInternalServerError, models.ErrorResponse{Error: err.Error()})
+		writeStorageError(g, "team", err)
 		return
 	}
-	g.JSON(http.StatusOK, models.TransformTeamFromStorage(team))
+	response := models.TransformTeamFromStorage(team)
+	auditAfter(g, response)
+	g.JSON(http.StatusOK, response)
+}
+
+// @Security AdminToken
+// @Summary Update some fields of a team
+// @Description Only the fields present in the body are changed. The version must match the stored team, otherwise 409 is returned
+// @Tags Meta/Teams
+// @Accept json
+// @Produce json
+// @Param id path int true "Team ID"
+// @Param team body models.TeamPatchRequest true "Fields to change"
+// @Success 200 {object} models.TeamResponse
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 409 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /api/meta/teams/{id} [patch]
+func (c *TeamMetaController) patch(g *gin.Context) {
+	id, err := strconv.Atoi(g.Param("id"))
+	if err != nil {
+		g.JSON(http.StatusBadRequest, models.ErrorResponse{Error: "invalid team id"})
+		return
+	}
+
+	var req models.TeamPatchRequest
+	if err := g.ShouldBindJSON(&req); err != nil {
+		logging.Log.Errorf("META: invalid patch team request: %v", err)
+		g.JSON(http.StatusBadRequest, models.ErrorResponse{Error: "invalid request"})
+		return
+	}
+	if req.Version == nil {
+		g.JSON(http.StatusBadRequest, models.ErrorResponse{Error: errMissingVersion.Error()})
+		return
+	}
+	if req.Name != nil && *req.Name == "" {
+		g.JSON(http.StatusBadRequest, models.ErrorResponse{Error: "invalid request empty name"})
+		return
+	}
+
+	team, ok := c.getForUpdate(g, id)
+	if !ok {
+		return
+	}
+	if req.Name != nil {
+		team.Name = *req.Name
+	}
+	if req.Description != nil {
+		team.Description = *req.Description
+	}
+	if req.Members != nil {
+		team.Members = *req.Members
+	}
+	team.Version = *req.Version
+
+	if err := c.storage.Update(g.Request.Context(), team); err != nil {
+		writeStorageError(g, "team", err)
+		return
+	}
+	response := models.TransformTeamFromStorage(team)
+	auditAfter(g, response)
+	g.JSON(http.StatusOK, response)
+}
+
+// getForUpdate loads the team an update applies to, writing the error response if there is none
+func (c *TeamMetaController) getForUpdate(g *gin.Context, id int) (*storage.Team, bool) {
+	team, err := c.storage.Get(g.Request.Context(), id)
+	if err != nil {
+		writeStorageError(g, "team", err)
+		return nil, false
+	}
+	auditBefore(g, models.TransformTeamFromStorage(team))
+	return team, true
 }
 
 // @Security AdminToken
 // @Summary Delete a team
+// @Description Fails with 409 while votes or voting codes point at the team, unless cascade is set.
+// @Description A cascade deletes those votes and detaches the codes. Archive the team to keep the results intact.
 // @Tags Meta/Teams
 // @Produce json
 // @Param id path int true "Team ID"
+// @Param cascade query bool false "Also delete the votes for the team and detach its codes"
 // @Success 200 {object} map[string]string
 // @Failure 400 {object} models.ErrorResponse
+// @Failure 409 {object} models.DeleteConflictResponse
 // @Failure 500 {object} models.ErrorResponse
 // @Router /api/meta/teams/{id} [delete]
 func (c *TeamMetaController) delete(g *gin.Context) {
@@ -198,10 +314,95 @@ func (c *TeamMetaController) delete(g *gin.Context) {
 		g.JSON(http.StatusBadRequest, models.ErrorResponse{Error: "invalid team id"})
 		return
 	}
+
+	// A missing team is left to Delete, which decides how to answer
+	if team, err := c.storage.Get(g.Request.Context(), id); err == nil {
+		auditBefore(g, models.TransformTeamFromStorage(team))
+	}
+
+	refs, err := c.references.forTeam(g.Request.Context(), id)
+	if err != nil {
+		logging.Log.Errorf("META: failed to look up references to team %d: %v", id, err)
+		g.JSON(storageErrorStatus(err), models.ErrorResponse{Error: err.Error()})
+		return
+	}
+	if !refs.empty() {
+		if g.Query("cascade") != "true" {
+			logging.Log.Warnf("META: delete of team %d blocked by %d votes and %d codes", id, len(refs.votes), len(refs.codes))
+			g.JSON(http.StatusConflict, models.DeleteConflictResponse{
+				Error: "team has votes or voting codes, archive it or delete with cascade=true",
+				Votes: len(refs.votes),
+				Codes: len(refs.codes),
+			})
+			return
+		}
+		if err := c.references.cascade(g, refs); err != nil {
+			logging.Log.Errorf("META: failed to cascade delete of team %d: %v", id, err)
+			g.JSON(storageErrorStatus(err), models.ErrorResponse{Error: err.Error()})
+			return
+		}
+		auditAfter(g, gin.H{"deletedVotes": len(refs.votes), "detachedCodes": len(refs.codes)})
+	}
+
 	if err := c.storage.Delete(g.Request.Context(), id); err != nil {
 		logging.Log.Errorf("META: failed to delete team: %v", err)
-		g.JSON(http.StatusInternalServerError, models.ErrorResponse{Error: err.Error()})
+		g.JSON(storageErrorStatus(err), models.ErrorResponse{Error: err.Error()})
 		return
 	}
 	g.JSON(http.StatusOK, gin.H{"message": "team deleted"})
 }
+
+// @Security AdminToken
+// @Summary Archive a team
+// @Description Hides the team from the public team list. Its votes still count in the results
+// @Tags Meta/Teams
+// @Produce json
+// @Param id path int true "Team ID"
+// @Success 200 {object} models.TeamResponse
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 409 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /api/meta/teams/{id}/archive [post]
+func (c *TeamMetaController) archive(g *gin.Context) {
+	c.setArchived(g, true)
+}
+
+// @Security AdminToken
+// @Summary Restore an archived team
+// @Tags Meta/Teams
+// @Produce json
+// @Param id path int true "Team ID"
+// @Success 200 {object} models.TeamResponse
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 409 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /api/meta/teams/{id}/restore [post]
+func (c *TeamMetaController) restore(g *gin.Context) {
+	c.setArchived(g, false)
+}
+
+func (c *TeamMetaController) setArchived(g *gin.Context, archived bool) {
+	id, err := strconv.Atoi(g.Param("id"))
+	if err != nil {
+		g.JSON(http.StatusBadRequest, models.ErrorResponse{Error: "invalid team id"})
+		return
+	}
+
+	team, ok := c.getForUpdate(g, id)
+	if !ok {
+		return
+	}
+	if team.Archived != archived {
+		team.Archived = archived
+		if err := c.storage.Update(g.Request.Context(), team); err != nil {
+			writeStorageError(g, "team", err)
+			return
+		}
+		logging.Log.Infof("META: team %d archived: %t", id, archived)
+	}
+	response := models.TransformTeamFromStorage(team)
+	auditAfter(g, response)
+	g.JSON(http.StatusOK, response)
+}
@@ -7,10 +7,7 @@ import (
 	"github.com/alex-pricope/simple-voting-system/api/models"
 	"github.com/alex-pricope/simple-voting-system/logging"
 	"github.com/alex-pricope/simple-voting-system/storage"
-	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/config"
-	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
-	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/alex-pricope/simple-voting-system/storage/memory"
 	"github.com/gin-gonic/gin"
 	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
@@ -20,54 +17,22 @@ import (
 	"testing"
 )
 
-func cleanupTeamTable(t *testing.T, client *dynamodb.Client, tableName string) {
-	t.Helper()
-	out, err := client.Scan(context.TODO(), &dynamodb.ScanInput{
-		TableName: aws.String(tableName),
-	})
-	require.NoError(t, err, "cleanup scan failed")
-	for _, item := range out.Items {
-		key := map[string]types.AttributeValue{
-			"PK": item["PK"],
-		}
-		_, err := client.DeleteItem(context.TODO(), &dynamodb.DeleteItemInput{
-			TableName: aws.String(tableName),
-			Key:       key,
-		})
-		require.NoError(t, err, "cleanup delete failed")
-	}
-}
-
 func setupTeamTestController(t *testing.T) (*TeamMetaController, *gin.Engine) {
 	t.Helper()
 	logging.Log = logrus.New()
 
-	cfg, err := config.LoadDefaultConfig(context.TODO(),
-		config.WithRegion("us-east-1"),
-		//nolint:staticcheck
-		config.WithEndpointResolverWithOptions(
-			aws.EndpointResolverWithOptionsFunc(func(service, region string, options ...interface{}) (aws.Endpoint, error) {
-				return aws.Endpoint{URL: "http://localhost:4566", HostnameImmutable: true}, nil
-			}),
-		),
-	)
-	require.NoError(t, err, "failed to load AWS config")
-	client := dynamodb.NewFromConfig(cfg)
-	t.Cleanup(func() {
-		cleanupTeamTable(t, client, "VotingTeams")
-	})
-	s := &storage.DynamoTeamStorage{
-		Client:    client,
-		TableName: "VotingTeams",
-	}
-	controller := NewTeamMetaController(s)
+	s := memory.NewTeamStorage()
+	controller := NewTeamMetaController(s, memory.NewVoteStorage(), memory.NewVotingCodesStorage(), nil, nil)
 	gin.SetMode(gin.TestMode)
 	r := gin.New()
 	r.POST("/api/meta/teams", controller.create)
 	r.PUT("/api/meta/teams/:id", controller.update)
+	r.PATCH("/api/meta/teams/:id", controller.patch)
 	r.GET("/api/meta/teams/:id", controller.get)
 	r.GET("/api/meta/teams", controller.getAll)
 	r.DELETE("/api/meta/teams/:id", controller.delete)
+	r.POST("/api/meta/teams/:id/archive", controller.archive)
+	r.POST("/api/meta/teams/:id/restore", controller.restore)
 	return controller, r
 }
 
@@ -346,3 +311,180 @@ func TestDeleteTeam(t *testing.T) {
 		require.Equal(t, http.StatusBadRequest, res.Code, "expected 400 for invalid ID")
 	})
 }
+
+func TestListTeamsPaged(t *testing.T) {
+	_, router := setupTeamTestController(t)
+
+	for i := 1; i <= 5; i++ {
+		req := models.TeamCreateRequest{ID: i, Name: "Team " + strconv.Itoa(i)}
+		res := testutils.PerformRequest(router, http.MethodPost, "/api/meta/teams", req, map[string]string{
+			"x-admin-token": "secret",
+		})
+		require.Equal(t, http.StatusOK, res.Code)
+	}
+
+	t.Run("Happy path - walk all pages", func(t *testing.T) {
+		var ids []int
+		next := ""
+		for page := 0; page < 3; page++ {
+			path := "/api/meta/teams?limit=2"
+			if next != "" {
+				path += "&next=" + next
+			}
+			res := testutils.PerformRequest(router, http.MethodGet, path, nil, nil)
+			require.Equal(t, http.StatusOK, res.Code)
+
+			var body models.PageResponse[models.TeamResponse]
+			require.NoError(t, json.Unmarshal(res.Body.Bytes(), &body))
+			for _, team := range body.Items {
+				ids = append(ids, team.ID)
+			}
+			next = body.Next
+		}
+
+		assert.Equal(t, []int{1, 2, 3, 4, 5}, ids)
+		assert.Empty(t, next, "expected no token after the last page")
+	})
+
+	t.Run("Unhappy path - invalid limit and token", func(t *testing.T) {
+		res := testutils.PerformRequest(router, http.MethodGet, "/api/meta/teams?limit=0", nil, nil)
+		assert.Equal(t, http.StatusBadRequest, res.Code)
+
+		res = testutils.PerformRequest(router, http.MethodGet, "/api/meta/teams?next=!!!", nil, nil)
+		assert.Equal(t, http.StatusBadRequest, res.Code)
+	})
+}
+
+func TestPatchTeam(t *testing.T) {
+	_, router := setupTeamTestController(t)
+	headers := map[string]string{"x-admin-token": "secret"}
+
+	createReq := models.TeamCreateRequest{ID: 20, Name: "Team Aqua", Description: "Sea lovers", Members: []string{"Archie", "Shelly"}}
+	res := testutils.PerformRequest(router, http.MethodPost, "/api/meta/teams", createReq, headers)
+	require.Equal(t, http.StatusOK, res.Code)
+
+	t.Run("Happy path - members change, the rest is kept", func(t *testing.T) {
+		members, version := []string{"Archie"}, 1
+		res := testutils.PerformRequest(router, http.MethodPatch, "/api/meta/teams/20",
+			models.TeamPatchRequest{Members: &members, Version: &version}, headers)
+		require.Equal(t, http.StatusOK, res.Code, res.Body.String())
+
+		var updated models.TeamResponse
+		require.NoError(t, json.Unmarshal(res.Body.Bytes(), &updated))
+		assert.Equal(t, "Team Aqua", updated.Name)
+		assert.Equal(t, "Sea lovers", updated.Description)
+		assert.Equal(t, []string{"Archie"}, updated.Members)
+		assert.Equal(t, 2, updated.Version)
+	})
+
+	t.Run("Unhappy path - concurrent edits, the second one gets 409", func(t *testing.T) {
+		first, second, version := "Team Aqua A", "Team Aqua B", 2
+		res := testutils.PerformRequest(router, http.MethodPatch, "/api/meta/teams/20",
+			models.TeamPatchRequest{Name: &first, Version: &version}, headers)
+		require.Equal(t, http.StatusOK, res.Code, res.Body.String())
+
+		res = testutils.PerformRequest(router, http.MethodPatch, "/api/meta/teams/20",
+			models.TeamPatchRequest{Name: &second, Version: &version}, headers)
+		require.Equal(t, http.StatusConflict, res.Code, res.Body.String())
+
+		res = testutils.PerformRequest(router, http.MethodGet, "/api/meta/teams/20", nil, headers)
+		var stored models.TeamResponse
+		require.NoError(t, json.Unmarshal(res.Body.Bytes(), &stored))
+		assert.Equal(t, "Team Aqua A", stored.Name)
+	})
+
+	t.Run("Unhappy path - unknown ID", func(t *testing.T) {
+		version := 1
+		res := testutils.PerformRequest(router, http.MethodPatch, "/api/meta/teams/404",
+			models.TeamPatchRequest{Version: &version}, headers)
+		require.Equal(t, http.StatusNotFound, res.Code, res.Body.String())
+	})
+}
+
+func TestDeleteReferencedTeam(t *testing.T) {
+	controller, router := setupTeamTestController(t)
+	headers := map[string]string{"x-admin-token": "secret"}
+	ctx := context.TODO()
+
+	res := testutils.PerformRequest(router, http.MethodPost, "/api/meta/teams", models.TeamCreateRequest{ID: 1, Name: "Voted"}, headers)
+	require.Equal(t, http.StatusOK, res.Code)
+	teamID := 1
+	require.NoError(t, controller.references.codes.Put(ctx, &storage.VotingCode{Code: "AAAAA", Category: "other_team", TeamID: &teamID}))
+	require.NoError(t, controller.references.votes.Create(ctx, &storage.Vote{Code: "BBBBB", SortKey: "cat#1#team#1", CategoryID: 1, TeamID: 1, Rating: 4}))
+	require.NoError(t, controller.references.votes.Create(ctx, &storage.Vote{Code: "BBBBB", SortKey: "cat#1#team#2", CategoryID: 1, TeamID: 2, Rating: 3}))
+
+	t.Run("Delete is blocked while votes and codes point at the team", func(t *testing.T) {
+		res := testutils.PerformRequest(router, http.MethodDelete, "/api/meta/teams/1", nil, headers)
+		require.Equal(t, http.StatusConflict, res.Code, res.Body.String())
+
+		var conflict models.DeleteConflictResponse
+		require.NoError(t, json.Unmarshal(res.Body.Bytes(), &conflict))
+		assert.Equal(t, 1, conflict.Votes)
+		assert.Equal(t, 1, conflict.Codes)
+
+		res = testutils.PerformRequest(router, http.MethodGet, "/api/meta/teams/1", nil, headers)
+		assert.Equal(t, http.StatusOK, res.Code)
+	})
+
+	t.Run("Cascade deletes the votes and detaches the codes", func(t *testing.T) {
+		res := testutils.PerformRequest(router, http.MethodDelete, "/api/meta/teams/1?cascade=true", nil, headers)
+		require.Equal(t, http.StatusOK, res.Code, res.Body.String())
+
+		votes, err := controller.references.votes.GetByCode(ctx, "BBBBB")
+		require.NoError(t, err)
+		require.Len(t, votes, 1)
+		assert.Equal(t, 2, votes[0].TeamID)
+
+		code, err := controller.references.codes.Get(ctx, "AAAAA")
+		require.NoError(t, err)
+		assert.Nil(t, code.TeamID)
+
+		res = testutils.PerformRequest(router, http.MethodGet, "/api/meta/teams/1", nil, headers)
+		assert.Equal(t, http.StatusNotFound, res.Code)
+	})
+}
+
+func TestArchiveTeam(t *testing.T) {
+	t.Setenv("ADMIN_TOKEN", "secret")
+	_, router := setupTeamTestController(t)
+	headers := map[string]string{"x-admin-token": "secret"}
+
+	for i := 1; i <= 2; i++ {
+		res := testutils.PerformRequest(router, http.MethodPost, "/api/meta/teams", models.TeamCreateRequest{ID: i, Name: "Team " + strconv.Itoa(i)}, headers)
+		require.Equal(t, http.StatusOK, res.Code)
+	}
+
+	listIDs := func(path string, headers map[string]string) []int {
+		res := testutils.PerformRequest(router, http.MethodGet, path, nil, headers)
+		require.Equal(t, http.StatusOK, res.Code)
+		var teams []models.TeamResponse
+		require.NoError(t, json.Unmarshal(res.Body.Bytes(), &teams))
+		var ids []int
+		for _, team := range teams {
+			ids = append(ids, team.ID)
+		}
+		return ids
+	}
+
+	res := testutils.PerformRequest(router, http.MethodPost, "/api/meta/teams/2/archive", nil, headers)
+	require.Equal(t, http.StatusOK, res.Code, res.Body.String())
+	var archived models.TeamResponse
+	require.NoError(t, json.Unmarshal(res.Body.Bytes(), &archived))
+	assert.True(t, archived.Archived)
+	assert.Equal(t, 2, archived.Version)
+
+	assert.Equal(t, []int{1}, listIDs("/api/meta/teams", nil))
+	assert.Equal(t, []int{1}, listIDs("/api/meta/teams?includeArchived=true", nil), "only admins see archived teams")
+	assert.Equal(t, []int{1, 2}, listIDs("/api/meta/teams?includeArchived=true", headers))
+	res = testutils.PerformRequest(router, http.MethodGet, "/api/meta/teams/2", nil, nil)
+	assert.Equal(t, http.StatusNotFound, res.Code, "the public route hides an archived team")
+	res = testutils.PerformRequest(router, http.MethodGet, "/api/meta/teams/2?includeArchived=true", nil, headers)
+	assert.Equal(t, http.StatusOK, res.Code)
+
+	res = testutils.PerformRequest(router, http.MethodPost, "/api/meta/teams/2/restore", nil, headers)
+	require.Equal(t, http.StatusOK, res.Code, res.Body.String())
+	assert.Equal(t, []int{1, 2}, listIDs("/api/meta/teams", nil))
+
+	res = testutils.PerformRequest(router, http.MethodPost, "/api/meta/teams/3/archive", nil, headers)
+	assert.Equal(t, http.StatusNotFound, res.Code)
+}
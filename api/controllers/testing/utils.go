@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/json"
 	"github.com/gin-gonic/gin"
+	"io"
 	"net/http/httptest"
 )
 
@@ -30,3 +31,16 @@ func PerformRequest(router *gin.Engine, method, path string, body interface{}, h
 	router.ServeHTTP(res, req)
 	return res
 }
+
+// PerformRawRequest performs a request with a body that is sent as is, such as a CSV file.
+func PerformRawRequest(router *gin.Engine, method, path, contentType string, body io.Reader, headers map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, body)
+	req.Header.Set("Content-Type", contentType)
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	res := httptest.NewRecorder()
+	router.ServeHTTP(res, req)
+	return res
+}
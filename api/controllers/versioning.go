@@ -0,0 +1,5 @@
+package controllers
+
+import "errors"
+
+var errMissingVersion = errors.New("invalid request, missing version")
@@ -1,6 +1,7 @@
 package controllers
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"github.com/alex-pricope/simple-voting-system/api/models"
@@ -9,23 +10,33 @@ import (
 	"github.com/gin-gonic/gin"
 	"net/http"
 	"sort"
-	"strings"
 	"time"
 )
 
+// errCodeExpired is returned with 410 Gone, so the UI can tell an expired code from a used one
+const errCodeExpired = "code expired"
+
 type VotingController struct {
 	codesStorage      storage.VotingCodeStorage
 	votesStorage      storage.VoteStorage
+	ballotsStorage    storage.BallotStorage
 	teamsStorage      storage.TeamStorage
 	categoriesStorage storage.VotingCategoryStorage
+	talliesStorage    storage.TallyStorage // nil computes the results from the raw votes
+	eventLoader       storage.EventLoader  // nil reads the raw votes, codes, teams and categories one storage at a time
+	ledgerStorage     storage.LedgerStorage
 }
 
-func NewVotingController(codeStorage storage.VotingCodeStorage, voteStorage storage.VoteStorage, teamStorage storage.TeamStorage, categoriesStorage storage.VotingCategoryStorage) *VotingController {
+func NewVotingController(codeStorage storage.VotingCodeStorage, voteStorage storage.VoteStorage, ballotStorage storage.BallotStorage, teamStorage storage.TeamStorage, categoriesStorage storage.VotingCategoryStorage, tallyStorage storage.TallyStorage, eventLoader storage.EventLoader, ledger storage.LedgerStorage) *VotingController {
 	return &VotingController{
 		codesStorage:      codeStorage,
 		votesStorage:      voteStorage,
+		ballotsStorage:    ballotStorage,
 		teamsStorage:      teamStorage,
 		categoriesStorage: categoriesStorage,
+		talliesStorage:    tallyStorage,
+		eventLoader:       eventLoader,
+		ledgerStorage:     ledger,
 	}
 }
 
@@ -38,6 +49,39 @@ func (c *VotingController) RegisterRoutes(engine *gin.Engine) {
 	group.GET("/vote/results", c.computeVoteResults)
 }
 
+// archivedReference returns why the votes cannot be accepted when one of them is for an archived team or category
+func (c *VotingController) archivedReference(ctx context.Context, votes []*storage.Vote) (string, error) {
+	teams, err := c.teamsStorage.GetAll(ctx)
+	if err != nil {
+		return "", err
+	}
+	categories, err := c.categoriesStorage.GetAll(ctx)
+	if err != nil {
+		return "", err
+	}
+	archivedTeams := make(map[int]bool)
+	for _, t := range teams {
+		if t.Archived {
+			archivedTeams[t.ID] = true
+		}
+	}
+	archivedCategories := make(map[int]bool)
+	for _, vc := range categories {
+		if vc.Archived {
+			archivedCategories[vc.ID] = true
+		}
+	}
+	for _, vote := range votes {
+		switch {
+		case archivedTeams[vote.TeamID]:
+			return fmt.Sprintf("team %d is archived", vote.TeamID), nil
+		case archivedCategories[vote.CategoryID]:
+			return fmt.Sprintf("category %d is archived", vote.CategoryID), nil
+		}
+	}
+	return "", nil
+}
+
 // registerVote godoc
 // @Summary Register a vote
 // @Description Accepts a vote submission for a given code
@@ -46,8 +90,9 @@ func (c *VotingController) RegisterRoutes(engine *gin.Engine) {
 // @Produce json
 // @Param vote body models.RegisterVoteRequest true "Vote submission"
 // @Success 200 {object} models.RegisterVoteResponse
-// @Failure 400 {object} models.ErrorResponse "Invalid vote data"
-// @Failure 409 {object} models.ErrorResponse "Code already used or invalid"
+// @Failure 400 {object} models.ErrorResponse "Invalid vote data, or a vote for an archived team or category"
+// @Failure 409 {object} models.ErrorResponse "Code already used or invalid, or a concurrent submission won"
+// @Failure 410 {object} models.ErrorResponse "Code expired"
 // @Failure 500 {object} models.ErrorResponse "Unexpected internal error"
 // @Router /api/vote [post]
 func (c *VotingController) registerVote(g *gin.Context) {
@@ -59,46 +104,118 @@ func (c *VotingController) registerVote(g *gin.Context) {
 
 	// Check code validity
 	votingCode, err := c.codesStorage.Get(g.Request.Context(), req.Code)
-	if err != nil || votingCode == nil || votingCode.Used {
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		logging.Log.Errorf("error trying to get code from storage: %v", err)
+		g.JSON(storageErrorStatus(err), &models.ErrorResponse{Error: "could not check code"})
+		return
+	}
+	if err != nil || votingCode.Used {
 		g.JSON(http.StatusConflict, &models.ErrorResponse{Error: "code not valid or already used"})
 		return
 	}
+	now := time.Now().UTC()
+	if votingCode.Expired(now) {
+		logging.Log.Warnf("Rejected ballot for code %s, expired at %s", req.Code, votingCode.ExpiresAt.Format(time.RFC3339))
+		g.JSON(http.StatusGone, &models.ErrorResponse{Error: errCodeExpired})
+		return
+	}
 
-	// Save all votes
+	// Build the ballot, rejecting duplicate team/category pairs up front
+	votes := make([]*storage.Vote, 0, len(req.Votes))
+	seen := make(map[string]bool, len(req.Votes))
 	for _, v := range req.Votes {
 		vote := &storage.Vote{
 			Code:       req.Code,
-			SortKey:    fmt.Sprintf("cat#%d#team#%d", v.CategoryID, v.TeamID),
+			SortKey:    storage.VoteSortKey(v.CategoryID, v.TeamID),
 			CategoryID: v.CategoryID,
 			TeamID:     v.TeamID,
 			Rating:     v.Rating,
-			Timestamp:  time.Now().UTC(),
+			Timestamp:  now,
 		}
-		logging.Log.Infof("Writing vote PK: %s, SK: %s, R: %d", vote.Code, vote.SortKey, vote.Rating)
-		if err := c.votesStorage.Create(g.Request.Context(), vote); err != nil {
-			logging.Log.Errorf("Failed to create vote PK: %s, SK: %s, R: %d,  %v",
-				vote.Code, vote.SortKey, vote.Rating, err)
-			if strings.Contains(err.Error(), "ConditionalCheckFailedException") {
-				g.JSON(http.StatusConflict, &models.ErrorResponse{Error: fmt.Sprintf("vote already exists or was submitted before PK: %s, SK: %s, R: %d",
-					vote.Code, vote.SortKey, vote.Rating)})
-			} else {
-				g.JSON(http.StatusInternalServerError, &models.ErrorResponse{Error: fmt.Sprintf("could not save vote PK: %s, SK: %s, R: %d", vote.Code, vote.SortKey, vote.Rating)})
-			}
+		if seen[vote.SortKey] {
+			g.JSON(http.StatusBadRequest, &models.ErrorResponse{Error: fmt.Sprintf("duplicate vote for category %d and team %d", v.CategoryID, v.TeamID)})
 			return
 		}
+		seen[vote.SortKey] = true
+		votes = append(votes, vote)
 	}
 
-	// Mark the code as used
-	votingCode.Used = true
-	if err := c.codesStorage.MarkUsed(g.Request.Context(), votingCode.Code); err != nil {
-		logging.Log.Errorf("failed to mark code as used: %v", err)
-		g.JSON(http.StatusInternalServerError, &models.ErrorResponse{Error: "could not mark code as used"})
+	// Archived teams and categories take no new votes
+	if problem, err := c.archivedReference(g.Request.Context(), votes); err != nil {
+		logging.Log.Errorf("failed to load the teams and categories of ballot PK: %s, %v", req.Code, err)
+		g.JSON(storageErrorStatus(err), &models.ErrorResponse{Error: "could not check the teams and categories"})
+		return
+	} else if problem != "" {
+		g.JSON(http.StatusBadRequest, &models.ErrorResponse{Error: problem})
+		return
+	}
+
+	// Keep other changes of the code, such as an admin reset, out until the ballot is in the ledger
+	unlock, err := lockCode(g, c.ledgerStorage, req.Code)
+	if err != nil {
+		g.JSON(storageErrorStatus(err), &models.ErrorResponse{Error: "another change of this code is in progress"})
+		return
+	}
+	defer unlock()
+
+	// Save all votes and mark the code as used, all-or-nothing
+	logging.Log.Infof("Writing ballot PK: %s with %d votes", req.Code, len(votes))
+	if err := c.ballotsStorage.Submit(g.Request.Context(), req.Code, votes); err != nil {
+		logging.Log.Errorf("Failed to submit ballot PK: %s, %v", req.Code, err)
+		switch {
+		case errors.Is(err, storage.ErrCodeAlreadyUsed):
+			g.JSON(http.StatusConflict, &models.ErrorResponse{Error: "code not valid or already used"})
+		case errors.Is(err, storage.ErrSubmissionConflict):
+			g.JSON(http.StatusConflict, &models.ErrorResponse{Error: "another submission for this code is in progress"})
+		case errors.Is(err, storage.ErrAlreadyExists):
+			g.JSON(http.StatusConflict, &models.ErrorResponse{Error: fmt.Sprintf("vote already exists or was submitted before PK: %s", req.Code)})
+		default:
+			g.JSON(storageErrorStatus(err), &models.ErrorResponse{Error: fmt.Sprintf("could not save votes PK: %s", req.Code)})
+		}
 		return
 	}
 
+	// A ballot the ledger does not know would be lost by a replay, so it is taken back and the voter can submit again
+	if err := appendLedger(g, c.ledgerStorage, &storage.LedgerEvent{
+		Type:       storage.LedgerBallotSubmitted,
+		Code:       req.Code,
+		VoterGroup: votingCode.Category,
+		Votes:      votes,
+	}); err != nil {
+		logging.Log.Errorf("Failed to record ballot PK: %s in the ledger, rolling it back: %v", req.Code, err)
+		c.rollbackBallot(g, req.Code, votes)
+		g.JSON(storageErrorStatus(err), &models.ErrorResponse{Error: fmt.Sprintf("could not record votes PK: %s", req.Code)})
+		return
+	}
+
+	// The ballot is stored, a failed tally update only delays the results until the tallies are rebuilt
+	if c.talliesStorage != nil {
+		if err := c.talliesStorage.Add(g.Request.Context(), votingCode.Category, votes); err != nil {
+			logging.Log.Errorf("Failed to update tallies for PK: %s, rebuild them with POST /api/admin/tallies/rebuild: %v", req.Code, err)
+		}
+	}
+
 	g.JSON(http.StatusOK, &models.RegisterVoteResponse{Message: "vote registered"})
 }
 
+// rollbackBallot deletes the votes of a ballot that could not be recorded in the ledger and releases its code.
+// The failed append may still have stored the ballot, or some parts of it, so the rollback is recorded as well
+// when the ledger takes appends again.
+func (c *VotingController) rollbackBallot(g *gin.Context, code string, votes []*storage.Vote) {
+	ctx := context.WithoutCancel(g.Request.Context())
+	if err := c.votesStorage.Delete(ctx, votes); err != nil {
+		logging.Log.Errorf("Rollback failed to delete the votes of PK: %s, compare with GET /api/admin/ledger/verify: %v", code, err)
+		return
+	}
+	events := []*storage.LedgerEvent{{Type: storage.LedgerVotesDeleted, Code: code, Votes: votes, Note: "ballot rolled back"}}
+	if err := c.codesStorage.MarkUnused(ctx, code); err != nil {
+		logging.Log.Errorf("Rollback failed to release code PK: %s: %v", code, err)
+	} else {
+		events = append(events, &storage.LedgerEvent{Type: storage.LedgerCodeReset, Code: code})
+	}
+	recordLedger(g, c.ledgerStorage, events...)
+}
+
 // validateVotingCode godoc
 // @Summary Validate a voting code
 // @Description Checks if a voting code exists and returns its category and usage status
@@ -108,6 +225,7 @@ func (c *VotingController) registerVote(g *gin.Context) {
 // @Success 200 {object} models.CodeValidationResponse
 // @Failure 400 {object} models.ErrorResponse "Missing code from request"
 // @Failure 404 {object} models.ErrorResponse "Code not found in storage"
+// @Failure 410 {object} models.ErrorResponse "Code expired"
 // @Failure 500 {object} models.ErrorResponse "Unexpected internal error"
 // @Router /api/verify/{code} [get]
 func (c *VotingController) validateVotingCode(g *gin.Context) {
@@ -121,14 +239,19 @@ func (c *VotingController) validateVotingCode(g *gin.Context) {
 	// Get from DynamoDB
 	votingCode, err := c.codesStorage.Get(g.Request.Context(), code)
 	if err != nil {
-		if errors.Is(err, storage.ErrCodeNotFound) {
+		if errors.Is(err, storage.ErrNotFound) {
 			logging.Log.Errorf("code not found in storage: %s", code)
 			g.JSON(http.StatusNotFound, &models.ErrorResponse{Error: fmt.Sprintf("code not found in storage: %s", code)})
 			return
 		}
 
 		logging.Log.Errorf("error trying to get code from storage: %v", err)
-		g.JSON(http.StatusInternalServerError, &models.ErrorResponse{Error: fmt.Sprintf("error trying to get code from storage: %v", err)})
+		g.JSON(storageErrorStatus(err), &models.ErrorResponse{Error: fmt.Sprintf("error trying to get code from storage: %v", err)})
+		return
+	}
+
+	if votingCode.Expired(time.Now()) {
+		g.JSON(http.StatusGone, &models.ErrorResponse{Error: errCodeExpired})
 		return
 	}
 
@@ -158,7 +281,7 @@ func (c *VotingController) getVotesByCode(g *gin.Context) {
 	votes, err := c.votesStorage.GetByCode(g.Request.Context(), code)
 	if err != nil {
 		logging.Log.Errorf("failed to retrieve votes for code %s: %v", code, err)
-		g.JSON(http.StatusInternalServerError, &models.ErrorResponse{Error: "could not retrieve votes"})
+		g.JSON(storageErrorStatus(err), &models.ErrorResponse{Error: "could not retrieve votes"})
 		return
 	}
 	if len(votes) == 0 {
@@ -169,14 +292,14 @@ func (c *VotingController) getVotesByCode(g *gin.Context) {
 	categories, err := c.categoriesStorage.GetAll(g.Request.Context())
 	if err != nil {
 		logging.Log.Errorf("failed to load categories: %v", err)
-		g.JSON(http.StatusInternalServerError, &models.ErrorResponse{Error: "could not load categories"})
+		g.JSON(storageErrorStatus(err), &models.ErrorResponse{Error: "could not load categories"})
 		return
 	}
 
 	teams, err := c.teamsStorage.GetAll(g.Request.Context())
 	if err != nil {
 		logging.Log.Errorf("failed to load teams: %v", err)
-		g.JSON(http.StatusInternalServerError, &models.ErrorResponse{Error: "could not load teams"})
+		g.JSON(storageErrorStatus(err), &models.ErrorResponse{Error: "could not load teams"})
 		return
 	}
 
@@ -212,7 +335,10 @@ func (c *VotingController) getVotesByCode(g *gin.Context) {
 
 // computeVoteResults godoc
 // @Summary Compute voting results
-// @Description Aggregates votes per team and category, applying category and voter weights
+// @Description Aggregates votes per team and category, applying category and voter weights.
+// @Description With storage.results set to tallies only the materialized tallies are read, or the raw votes
+// @Description while a partly applied tally update has left them stale.
+// @Description With the single-table layout the raw votes are read with one query of the event.
 // @Tags voting
 // @Produce json
 // @Success 200 {object} models.VoteResultsResponse
@@ -221,11 +347,14 @@ func (c *VotingController) getVotesByCode(g *gin.Context) {
 func (c *VotingController) computeVoteResults(g *gin.Context) {
 	ctx := g.Request.Context()
 
-	// Load all votes from the DB
-	allVotes, err := c.votesStorage.GetAll(ctx)
-	if err != nil {
-		logging.Log.Errorf("failed to retrieve all votes: %v", err)
-		g.JSON(http.StatusInternalServerError, &models.ErrorResponse{Error: "could not load votes"})
+	if c.eventLoader != nil && c.talliesStorage == nil {
+		data, err := c.eventLoader.LoadEvent(ctx)
+		if err != nil {
+			logging.Log.Errorf("failed to load the event: %v", err)
+			g.JSON(storageErrorStatus(err), &models.ErrorResponse{Error: "could not load the event"})
+			return
+		}
+		g.JSON(http.StatusOK, voteResultsResponse(data.Votes, data.Codes, data.Categories, data.Teams))
 		return
 	}
 
@@ -233,7 +362,7 @@ func (c *VotingController) computeVoteResults(g *gin.Context) {
 	votingCategories, err := c.categoriesStorage.GetAll(ctx)
 	if err != nil {
 		logging.Log.Errorf("failed to load categories: %v", err)
-		g.JSON(http.StatusInternalServerError, &models.ErrorResponse{Error: "could not load categories"})
+		g.JSON(storageErrorStatus(err), &models.ErrorResponse{Error: "could not load categories"})
 		return
 	}
 
@@ -241,7 +370,38 @@ func (c *VotingController) computeVoteResults(g *gin.Context) {
 	allTeams, err := c.teamsStorage.GetAll(ctx)
 	if err != nil {
 		logging.Log.Errorf("failed to load teams: %v", err)
-		g.JSON(http.StatusInternalServerError, &models.ErrorResponse{Error: "could not load teams"})
+		g.JSON(storageErrorStatus(err), &models.ErrorResponse{Error: "could not load teams"})
+		return
+	}
+
+	if c.talliesStorage != nil {
+		tallies, err := c.talliesStorage.GetAll(ctx)
+		if err != nil {
+			logging.Log.Errorf("failed to load tallies: %v", err)
+			g.JSON(storageErrorStatus(err), &models.ErrorResponse{Error: "could not load tallies"})
+			return
+		}
+		if !tallies.Stale {
+			// A code is used once its ballot is stored and released when its votes are deleted,
+			// so the ballot counter is the used codes without reading the codes
+			totalVotes := 0
+			for _, t := range tallies.Scores {
+				totalVotes += t.Count
+			}
+			g.JSON(http.StatusOK, models.VoteResultsResponse{
+				Results:    calculateTallyResults(tallies.Scores, votingCategories, allTeams),
+				TotalVotes: totalVotes,
+				UsedCodes:  tallies.Ballots})
+			return
+		}
+		logging.Log.Warnf("The tallies are stale, computing the results from the raw votes until they are rebuilt")
+	}
+
+	// Load all votes from the DB
+	allVotes, err := c.votesStorage.GetAll(ctx)
+	if err != nil {
+		logging.Log.Errorf("failed to retrieve all votes: %v", err)
+		g.JSON(storageErrorStatus(err), &models.ErrorResponse{Error: "could not load votes"})
 		return
 	}
 
@@ -249,34 +409,46 @@ func (c *VotingController) computeVoteResults(g *gin.Context) {
 	allUniqueCodes, err := c.codesStorage.GetAll(ctx)
 	if err != nil {
 		logging.Log.Errorf("failed to load voting codes (defined): %v", err)
-		g.JSON(http.StatusInternalServerError, &models.ErrorResponse{Error: "could not load voting codes"})
+		g.JSON(storageErrorStatus(err), &models.ErrorResponse{Error: "could not load voting codes"})
 		return
 	}
 
-	// Count how many codes have been used
-	usedCodesCount := 0
-	for _, code := range allUniqueCodes {
+	g.JSON(http.StatusOK, voteResultsResponse(allVotes, allUniqueCodes, votingCategories, allTeams))
+}
+
+// voteResultsResponse computes the results from the raw votes
+func voteResultsResponse(
+	allVotes []*storage.Vote, allCodes []*storage.VotingCode,
+	categories []*storage.VotingCategory, teams []*storage.Team,
+) models.VoteResultsResponse {
+	return models.VoteResultsResponse{
+		Results:    calculateVoteResults(allVotes, allCodes, categories, teams),
+		TotalVotes: len(allVotes),
+		UsedCodes:  usedCodes(allCodes)}
+}
+
+// usedCodes counts how many codes have been used
+func usedCodes(codes []*storage.VotingCode) int {
+	used := 0
+	for _, code := range codes {
 		if code.Used {
-			usedCodesCount++
+			used++
 		}
 	}
-
-	results := calculateVoteResults(allVotes, allUniqueCodes, votingCategories, allTeams)
-	g.JSON(http.StatusOK, models.VoteResultsResponse{
-		Results:    results,
-		TotalVotes: len(allVotes),
-		UsedCodes:  usedCodesCount})
+	return used
 }
 
 func calculateVoteResults(
 	allVotes []*storage.Vote, allCodes []*storage.VotingCode,
 	categories []*storage.VotingCategory, teams []*storage.Team,
 ) []models.VoteResult {
-	uniqueCodesWithCategoryMap := make(map[string]string)
-	for _, c := range allCodes {
-		uniqueCodesWithCategoryMap[c.Code] = c.Category
-	}
+	return calculateTallyResults(storage.BuildTallies(allVotes, allCodes).Scores, categories, teams)
+}
 
+// calculateTallyResults computes the results from the rating sums and counts per team, category and voter group
+func calculateTallyResults(
+	tallies []*storage.Tally, categories []*storage.VotingCategory, teams []*storage.Team,
+) []models.VoteResult {
 	categoryMap := make(map[int]storage.VotingCategory)
 	for _, c := range categories {
 		categoryMap[c.ID] = *c
@@ -295,32 +467,38 @@ func calculateVoteResults(
 	// Structure: map[teamID]map[categoryID]*entry where 'entry' contains the sum of weighted scores and the count of votes.
 	scoreMap := make(map[int]map[int]*entry)
 
-	// Parse the votes
-	for _, v := range allVotes {
-		codeCategory := uniqueCodesWithCategoryMap[v.Code]
-		votingCategory := categoryMap[v.CategoryID]
-		teamID := v.TeamID
+	// Parse the tallies, each one holds the ratings of one voter group
+	for _, t := range tallies {
+		votingCategory, knownCategory := categoryMap[t.CategoryID]
+		_, knownTeam := teamMap[t.TeamID]
+		teamID := t.TeamID
+
+		// Deleted teams and categories drop out of the results, archived ones stay
+		if !knownCategory || !knownTeam {
+			logging.Log.Warnf("Skipping %d votes for unknown team %d or category %d", t.Count, t.TeamID, t.CategoryID)
+			continue
+		}
 
 		// Create both weights
-		voterWeight, categoryWeight := models.CodeCategoryWeights[models.VotingCategory(codeCategory)], votingCategory.Weight
+		voterWeight, categoryWeight := models.CodeCategoryWeights[models.VotingCategory(t.VoterGroup)], votingCategory.Weight
 
 		if voterWeight == 0 {
-			logging.Log.Warnf("Unknown voter category %q; weight is 0", codeCategory)
+			logging.Log.Warnf("Unknown voter category %q; weight is 0", t.VoterGroup)
 		}
 
-		// The computed rating for each vote line
+		// The computed rating for each vote line, summed over the votes of the group
 		// (user_rating) × (importance of who votes) × (importance of what they're voting on)
-		weighted := float64(v.Rating) * voterWeight * categoryWeight
+		weighted := float64(t.Sum) * voterWeight * categoryWeight
 
 		// Iterate and sum the individual weighted score
 		if _, ok := scoreMap[teamID]; !ok {
 			scoreMap[teamID] = make(map[int]*entry)
 		}
-		if _, ok := scoreMap[teamID][v.CategoryID]; !ok {
-			scoreMap[teamID][v.CategoryID] = &entry{}
+		if _, ok := scoreMap[teamID][t.CategoryID]; !ok {
+			scoreMap[teamID][t.CategoryID] = &entry{}
 		}
-		scoreMap[teamID][v.CategoryID].sum += weighted
-		scoreMap[teamID][v.CategoryID].count++
+		scoreMap[teamID][t.CategoryID].sum += weighted
+		scoreMap[teamID][t.CategoryID].count += t.Count
 	}
 
 	// Note: Each voter is required (via frontend enforcement) to vote for every team in every category.
@@ -336,7 +514,14 @@ func calculateVoteResults(
 		var total float64
 		var categories []models.CategoryScore
 
-		for catID, entry := range catScores {
+		// In category ID order, so the total is summed the same way every time
+		catIDs := make([]int, 0, len(catScores))
+		for catID := range catScores {
+			catIDs = append(catIDs, catID)
+		}
+		sort.Ints(catIDs)
+		for _, catID := range catIDs {
+			entry := catScores[catID]
 			name := categoryMap[catID].Name
 
 			// Compute the average weighted score for this category and team.
@@ -351,8 +536,8 @@ func calculateVoteResults(
 			total += score
 		}
 
-		// Sort the categories by score
-		sort.Slice(categories, func(i, j int) bool {
+		// Sort the categories by score, ties by ID
+		sort.SliceStable(categories, func(i, j int) bool {
 			return categories[i].Score > categories[j].Score
 		})
 
@@ -365,9 +550,12 @@ func calculateVoteResults(
 		})
 	}
 
-	// Sort the teams by final total score
+	// Sort the teams by final total score, ties by ID, so the same votes always give the same results
 	sort.Slice(results, func(i, j int) bool {
-		return results[i].TotalScore > results[j].TotalScore
+		if results[i].TotalScore != results[j].TotalScore {
+			return results[i].TotalScore > results[j].TotalScore
+		}
+		return results[i].TeamID < results[j].TeamID
 	})
 	return results
 }
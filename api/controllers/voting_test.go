@@ -8,65 +8,30 @@ import (
 	"github.com/alex-pricope/simple-voting-system/api/models"
 	"github.com/alex-pricope/simple-voting-system/logging"
 	"github.com/alex-pricope/simple-voting-system/storage"
-	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/config"
-	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
-	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/alex-pricope/simple-voting-system/storage/memory"
 	"github.com/gin-gonic/gin"
 	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"net/http"
+	"sync"
 	"testing"
+	"time"
 )
 
-//nolint:staticcheck
 func setupTestVoteController(t *testing.T) (*VotingController, *gin.Engine) {
 	t.Helper()
 	logging.Log = logrus.New()
 
-	// Load localstack config
-	cfg, err := config.LoadDefaultConfig(context.TODO(),
-		config.WithRegion("us-east-1"),
-		config.WithEndpointResolverWithOptions(
-			aws.EndpointResolverWithOptionsFunc(func(service, region string, options ...interface{}) (aws.Endpoint, error) {
-				return aws.Endpoint{URL: "http://localhost:4566", HostnameImmutable: true}, nil
-			}),
-		),
-	)
-	if err != nil {
-		t.Fatalf("failed to load AWS config: %v", err)
-	}
-
-	db := dynamodb.NewFromConfig(cfg)
-	voteStorage := &storage.DynamoVoteStorage{
-		Client:    db,
-		TableName: "Votes",
-	}
-	codeStorage := &storage.DynamoVotingCodesStorage{
-		Client:    db,
-		TableName: "VotingCodes",
-	}
-	teamStorage := &storage.DynamoTeamStorage{
-		Client:    db,
-		TableName: "VotingTeams",
-	}
-	categoriesStorage := &storage.DynamoVotingCategoryStorage{
-		Client:    db,
-		TableName: "VotingCategories",
-	}
-
-	t.Cleanup(func() {
-		cleanupTableVotes(t, db)
-		cleanupTable(t, db, "VotingCodes")
-		cleanupTable(t, db, "VotingTeams")
-		cleanupTable(t, db, "VotingCategories")
-	})
+	voteStorage := memory.NewVoteStorage()
+	codeStorage := memory.NewVotingCodesStorage()
+	teamStorage := memory.NewTeamStorage()
+	categoriesStorage := memory.NewVotingCategoryStorage()
 
-	votingController := NewVotingController(codeStorage, voteStorage, teamStorage, categoriesStorage)
-	adminController := NewAdminController(codeStorage, teamStorage, voteStorage)
-	teamsController := NewTeamMetaController(teamStorage)
-	categoriesController := NewCategoryMetaController(categoriesStorage)
+	votingController := NewVotingController(codeStorage, voteStorage, memory.NewBallotStorage(codeStorage, voteStorage), teamStorage, categoriesStorage, nil, nil, nil)
+	adminController := NewAdminController(codeStorage, teamStorage, voteStorage, nil, nil)
+	teamsController := NewTeamMetaController(teamStorage, voteStorage, codeStorage, nil, nil)
+	categoriesController := NewCategoryMetaController(categoriesStorage, voteStorage, codeStorage, nil, nil)
 	gin.SetMode(gin.TestMode)
 	r := gin.New()
 
@@ -81,39 +46,8 @@ func setupTestVoteController(t *testing.T) (*VotingController, *gin.Engine) {
 	return votingController, r
 }
 
-func cleanupTableVotes(t *testing.T, client *dynamodb.Client) {
-	t.Helper()
-
-	out, err := client.Scan(context.TODO(), &dynamodb.ScanInput{
-		TableName: aws.String("Votes"),
-	})
-	if err != nil {
-		t.Fatalf("cleanup failed to scan %s: %v", "Votes", err)
-	}
-
-	for _, item := range out.Items {
-		key := make(map[string]types.AttributeValue)
-
-		if pk, ok := item["PK"]; ok {
-			key["PK"] = pk
-		}
-		if sk, ok := item["SK"]; ok {
-			key["SK"] = sk
-		}
-
-		_, err := client.DeleteItem(context.TODO(), &dynamodb.DeleteItemInput{
-			TableName: aws.String("Votes"),
-			Key:       key,
-		})
-		if err != nil {
-			t.Fatalf("cleanup failed to delete item from %s: %v", "Votes", err)
-		}
-	}
-}
-
-//nolint:staticcheck
 func TestValidateVotingCode(t *testing.T) {
-	_, router := setupTestVoteController(t)
+	controller, router := setupTestVoteController(t)
 
 	t.Run("Happy path - verify valid code", func(t *testing.T) {
 		payload := models.CreateCodeRequest{
@@ -165,13 +99,7 @@ func TestValidateVotingCode(t *testing.T) {
 		code := created[0].Code
 
 		// Mark as used
-		client := storage.DynamoVotingCodesStorage{
-			Client: dynamodb.NewFromConfig(aws.Config{Region: "us-east-1", EndpointResolverWithOptions: aws.EndpointResolverWithOptionsFunc(func(service, region string, options ...interface{}) (aws.Endpoint, error) {
-				return aws.Endpoint{URL: "http://localhost:4566", HostnameImmutable: true}, nil
-			})}),
-			TableName: "VotingCodes",
-		}
-		err := client.MarkUsed(context.TODO(), code)
+		err := controller.codesStorage.MarkUsed(context.TODO(), code)
 		assert.NoError(t, err, "Should mark code as used without error")
 
 		// Validate again
@@ -287,11 +215,14 @@ func TestVoteResultsEndpoint(t *testing.T) {
 	_, router := setupTestVoteController(t)
 
 	// 1. Create codes
-	//categories := []string{"grand_jury", "other_team", "general_public"}
+	// The team order asserted below depends on which voter groups the 9 ballots come from,
+	// so the codes are created in a fixed group order rather than in map order
+	categories := []string{"grand_jury", "other_team", "general_public"}
 	codeCount := map[string]int{"grand_jury": 3, "other_team": 3, "general_public": 4}
 	var codes []string
 
-	for category, count := range codeCount {
+	for _, category := range categories {
+		count := codeCount[category]
 		payload := models.CreateCodeRequest{Count: count, Category: category}
 		headers := map[string]string{"Content-Type": "application/json", "x-admin-token": "secret"}
 		res := testutils.PerformRequest(router, http.MethodPost, "/api/admin/codes", payload, headers)
@@ -615,3 +546,329 @@ func runTwoCategoryVoteWeightingTest(t *testing.T, categoryName string, voterWei
 		assert.Len(t, r.Categories, 2)
 	}
 }
+
+func TestRegisterVoteIsAtomic(t *testing.T) {
+	controller, router := setupTestVoteController(t)
+	headers := map[string]string{"Content-Type": "application/json", "x-admin-token": "secret"}
+
+	codePayload := models.CreateCodeRequest{Count: 1, Category: "grand_jury"}
+	res := testutils.PerformRequest(router, http.MethodPost, "/api/admin/codes", codePayload, headers)
+	var codes []*models.CodeResponse
+	require.NoError(t, json.Unmarshal(res.Body.Bytes(), &codes))
+	code := codes[0].Code
+
+	votes := []models.VoteEntry{
+		{CategoryID: 1, TeamID: 1, Rating: 5},
+		{CategoryID: 1, TeamID: 2, Rating: 4},
+	}
+
+	t.Run("Unhappy path - duplicate team and category in one ballot", func(t *testing.T) {
+		dupe := models.RegisterVoteRequest{Code: code, Votes: append(votes, models.VoteEntry{CategoryID: 1, TeamID: 1, Rating: 1})}
+		res := testutils.PerformRequest(router, http.MethodPost, "/api/vote/", dupe, headers)
+		assert.Equal(t, http.StatusBadRequest, res.Code)
+
+		vc, err := controller.codesStorage.Get(context.TODO(), code)
+		require.NoError(t, err)
+		assert.False(t, vc.Used, "rejected ballot must not consume the code")
+	})
+
+	t.Run("Concurrent submissions - exactly one wins", func(t *testing.T) {
+		const attempts = 10
+		statuses := make(chan int, attempts)
+		var wg sync.WaitGroup
+		for i := 0; i < attempts; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				voteReq := models.RegisterVoteRequest{Code: code, Votes: votes}
+				statuses <- testutils.PerformRequest(router, http.MethodPost, "/api/vote/", voteReq, headers).Code
+			}()
+		}
+		wg.Wait()
+		close(statuses)
+
+		counts := make(map[int]int)
+		for status := range statuses {
+			counts[status]++
+		}
+		assert.Equal(t, 1, counts[http.StatusOK])
+		assert.Equal(t, attempts-1, counts[http.StatusConflict])
+
+		stored, err := controller.votesStorage.GetByCode(context.TODO(), code)
+		require.NoError(t, err)
+		assert.Len(t, stored, len(votes))
+	})
+}
+
+func TestVoteResultsFromTallies(t *testing.T) {
+	controller, router := setupTestVoteController(t)
+	tallies := memory.NewTallyStorage()
+	controller.talliesStorage = tallies
+	router.POST("/api/admin/tallies/rebuild", NewTallyController(controller.codesStorage, controller.votesStorage, tallies).rebuild)
+
+	headers := map[string]string{"Content-Type": "application/json", "x-admin-token": "secret"}
+	for _, cat := range []models.VotingCategoryCreateRequest{{ID: 1, Name: "Cat1", Weight: 0.4}, {ID: 2, Name: "Cat2", Weight: 0.6}} {
+		require.Equal(t, http.StatusOK, testutils.PerformRequest(router, http.MethodPost, "/api/meta/categories", cat, headers).Code)
+	}
+	for i := 1; i <= 3; i++ {
+		team := models.TeamCreateRequest{ID: i, Name: fmt.Sprintf("Team %d", i)}
+		require.Equal(t, http.StatusOK, testutils.PerformRequest(router, http.MethodPost, "/api/meta/teams", team, headers).Code)
+	}
+
+	var codes []*models.CodeResponse
+	for _, group := range []string{"grand_jury", "general_public"} {
+		res := testutils.PerformRequest(router, http.MethodPost, "/api/admin/codes", models.CreateCodeRequest{Count: 2, Category: group}, headers)
+		var created []*models.CodeResponse
+		require.NoError(t, json.Unmarshal(res.Body.Bytes(), &created))
+		codes = append(codes, created...)
+	}
+	for i, code := range codes {
+		var entries []models.VoteEntry
+		for catID := 1; catID <= 2; catID++ {
+			for teamID := 1; teamID <= 3; teamID++ {
+				entries = append(entries, models.VoteEntry{CategoryID: catID, TeamID: teamID, Rating: (i+teamID*catID)%5 + 1})
+			}
+		}
+		vote := models.RegisterVoteRequest{Code: code.Code, Votes: entries}
+		require.Equal(t, http.StatusOK, testutils.PerformRequest(router, http.MethodPost, "/api/vote/", vote, nil).Code)
+	}
+
+	getResults := func() models.VoteResultsResponse {
+		res := testutils.PerformRequest(router, http.MethodGet, "/api/votes/result", nil, nil)
+		require.Equal(t, http.StatusOK, res.Code)
+		var result models.VoteResultsResponse
+		require.NoError(t, json.Unmarshal(res.Body.Bytes(), &result))
+		return result
+	}
+
+	// The tallies give the same results as the raw votes
+	fromTallies := getResults()
+	controller.talliesStorage = nil
+	fromVotes := getResults()
+	controller.talliesStorage = tallies
+
+	assert.Equal(t, 4, fromTallies.UsedCodes)
+	assert.Equal(t, fromVotes.TotalVotes, fromTallies.TotalVotes)
+	require.Len(t, fromTallies.Results, 3)
+	for i, r := range fromTallies.Results {
+		assert.Equal(t, fromVotes.Results[i].TeamID, r.TeamID)
+		assert.InDelta(t, fromVotes.Results[i].TotalScore, r.TotalScore, 0.0001)
+	}
+
+	t.Run("Rebuild repairs drifted tallies", func(t *testing.T) {
+		require.NoError(t, tallies.Replace(context.TODO(), &storage.Tallies{}))
+		assert.Empty(t, getResults().Results)
+
+		res := testutils.PerformRequest(router, http.MethodPost, "/api/admin/tallies/rebuild", nil, headers)
+		require.Equal(t, http.StatusOK, res.Code, res.Body.String())
+		var rebuilt models.RebuildTalliesResponse
+		require.NoError(t, json.Unmarshal(res.Body.Bytes(), &rebuilt))
+		assert.Equal(t, 4, rebuilt.Ballots)
+		assert.Equal(t, 24, rebuilt.Votes)
+
+		repaired := getResults()
+		assert.Equal(t, fromTallies.TotalVotes, repaired.TotalVotes)
+		assert.Equal(t, 4, repaired.UsedCodes)
+		require.Len(t, repaired.Results, 3)
+		assert.InDelta(t, fromTallies.Results[0].TotalScore, repaired.Results[0].TotalScore, 0.0001)
+	})
+}
+
+// countingEventLoader loads the event from the storages of the controller and counts the loads
+type countingEventLoader struct {
+	controller *VotingController
+	loads      int
+	err        error
+}
+
+func (l *countingEventLoader) LoadEvent(ctx context.Context) (*storage.EventData, error) {
+	l.loads++
+	if l.err != nil {
+		return nil, l.err
+	}
+	data := &storage.EventData{}
+	var err error
+	if data.Codes, err = l.controller.codesStorage.GetAll(ctx); err != nil {
+		return nil, err
+	}
+	if data.Votes, err = l.controller.votesStorage.GetAll(ctx); err != nil {
+		return nil, err
+	}
+	if data.Teams, err = l.controller.teamsStorage.GetAll(ctx); err != nil {
+		return nil, err
+	}
+	data.Categories, err = l.controller.categoriesStorage.GetAll(ctx)
+	return data, err
+}
+
+func TestVoteResultsFromEventLoader(t *testing.T) {
+	controller, router := setupTestVoteController(t)
+	ctx := context.TODO()
+	require.NoError(t, controller.categoriesStorage.Create(ctx, &storage.VotingCategory{ID: 1, Name: "Cat1", Weight: 1}))
+	require.NoError(t, controller.teamsStorage.Create(ctx, &storage.Team{ID: 1, Name: "Team 1"}))
+	require.NoError(t, controller.teamsStorage.Create(ctx, &storage.Team{ID: 2, Name: "Team 2"}))
+	for i, code := range []string{"AAAAA", "BBBBB"} {
+		require.NoError(t, controller.codesStorage.Put(ctx, &storage.VotingCode{Code: code, Category: "general_public"}))
+		vote := models.RegisterVoteRequest{Code: code, Votes: []models.VoteEntry{
+			{CategoryID: 1, TeamID: 1, Rating: 5 - i},
+			{CategoryID: 1, TeamID: 2, Rating: 2 + i},
+		}}
+		require.Equal(t, http.StatusOK, testutils.PerformRequest(router, http.MethodPost, "/api/vote/", vote, nil).Code)
+	}
+
+	getResults := func(t *testing.T, status int) models.VoteResultsResponse {
+		t.Helper()
+		res := testutils.PerformRequest(router, http.MethodGet, "/api/votes/result", nil, nil)
+		require.Equal(t, status, res.Code, res.Body.String())
+		var result models.VoteResultsResponse
+		require.NoError(t, json.Unmarshal(res.Body.Bytes(), &result))
+		return result
+	}
+	fromStorages := getResults(t, http.StatusOK)
+
+	t.Run("Results are computed from one load of the event", func(t *testing.T) {
+		loader := &countingEventLoader{controller: controller}
+		controller.eventLoader = loader
+		defer func() { controller.eventLoader = nil }()
+
+		assert.Equal(t, fromStorages, getResults(t, http.StatusOK))
+		assert.Equal(t, 1, loader.loads)
+	})
+
+	t.Run("A failed load is reported with the status of its kind", func(t *testing.T) {
+		controller.eventLoader = &countingEventLoader{controller: controller, err: storage.ErrThrottled}
+		defer func() { controller.eventLoader = nil }()
+
+		getResults(t, http.StatusTooManyRequests)
+	})
+}
+
+func TestExpiredCodeIsRejected(t *testing.T) {
+	controller, router := setupTestVoteController(t)
+	headers := map[string]string{"Content-Type": "application/json", "x-admin-token": "secret"}
+
+	codePayload := models.CreateCodeRequest{Count: 1, Category: "general_public", CodeExpiry: models.CodeExpiry{TTL: "1h"}}
+	res := testutils.PerformRequest(router, http.MethodPost, "/api/admin/codes", codePayload, headers)
+	require.Equal(t, http.StatusOK, res.Code, res.Body.String())
+	var codes []*models.CodeResponse
+	require.NoError(t, json.Unmarshal(res.Body.Bytes(), &codes))
+	code := codes[0].Code
+	require.NotNil(t, codes[0].ExpiresAt)
+
+	res = testutils.PerformRequest(router, http.MethodGet, "/api/verify/"+code, nil, nil)
+	require.Equal(t, http.StatusOK, res.Code)
+
+	expired := time.Now().Add(-time.Minute)
+	require.NoError(t, controller.codesStorage.SetExpiry(context.TODO(), code, &expired))
+
+	res = testutils.PerformRequest(router, http.MethodGet, "/api/verify/"+code, nil, nil)
+	assert.Equal(t, http.StatusGone, res.Code)
+	assert.Contains(t, res.Body.String(), "code expired")
+
+	voteReq := models.RegisterVoteRequest{Code: code, Votes: []models.VoteEntry{{CategoryID: 1, TeamID: 1, Rating: 5}}}
+	res = testutils.PerformRequest(router, http.MethodPost, "/api/vote/", voteReq, headers)
+	assert.Equal(t, http.StatusGone, res.Code)
+
+	vc, err := controller.codesStorage.Get(context.TODO(), code)
+	require.NoError(t, err)
+	assert.False(t, vc.Used, "rejected ballot must not consume the code")
+}
+
+func TestTalliesFollowDeletedVotes(t *testing.T) {
+	logging.Log = logrus.New()
+	t.Setenv("ADMIN_TOKEN", "secret")
+	ctx := context.Background()
+	codes, votes, tallies := memory.NewVotingCodesStorage(), memory.NewVoteStorage(), memory.NewTallyStorage()
+	teams, categories := memory.NewTeamStorage(), memory.NewVotingCategoryStorage()
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	NewVotingController(codes, votes, memory.NewBallotStorage(codes, votes), teams, categories, tallies, nil, nil).RegisterRoutes(router)
+	NewAdminController(codes, teams, votes, tallies, nil).RegisterRoutes(router)
+	NewTeamMetaController(teams, votes, codes, tallies, nil).RegisterRoutes(router)
+	NewCategoryMetaController(categories, votes, codes, tallies, nil).RegisterRoutes(router)
+
+	for _, vc := range []*storage.VotingCategory{{ID: 1, Name: "Cat1", Weight: 0.4}, {ID: 2, Name: "Cat2", Weight: 0.6}} {
+		require.NoError(t, categories.Create(ctx, vc))
+	}
+	for i := 1; i <= 3; i++ {
+		require.NoError(t, teams.Create(ctx, &storage.Team{ID: i, Name: fmt.Sprintf("Team %d", i)}))
+	}
+	for code, group := range map[string]string{"A": "grand_jury", "B": "grand_jury", "C": "general_public", "D": "other_team"} {
+		require.NoError(t, codes.Put(ctx, &storage.VotingCode{Code: code, Category: group}))
+	}
+	for i, code := range []string{"A", "B", "C", "D"} {
+		submitTestBallot(t, router, code, i)
+	}
+
+	// The results from the tallies are the results computed from the stored votes
+	assertTalliesMatch := func(t *testing.T) models.VoteResultsResponse {
+		t.Helper()
+		allVotes, err := votes.GetAll(ctx)
+		require.NoError(t, err)
+		allCodes, err := codes.GetAll(ctx)
+		require.NoError(t, err)
+		allCategories, err := categories.GetAll(ctx)
+		require.NoError(t, err)
+		allTeams, err := teams.GetAll(ctx)
+		require.NoError(t, err)
+
+		stored, err := tallies.GetAll(ctx)
+		require.NoError(t, err)
+
+		// The used codes are the counted ballots, read without the codes
+		fromTallies := getTestJSON[models.VoteResultsResponse](t, router, "/api/vote/results")
+		expected := voteResultsResponse(allVotes, allCodes, allCategories, allTeams)
+		expected.UsedCodes = stored.Ballots
+		assert.Equal(t, expected, fromTallies)
+		return fromTallies
+	}
+	admin := map[string]string{"x-admin-token": "secret"}
+
+	res := testutils.PerformRequest(router, http.MethodPost, "/api/admin/codes/B/invalidate", nil, admin)
+	require.Equal(t, http.StatusOK, res.Code, res.Body.String())
+	invalidated := assertTalliesMatch(t)
+	assert.Equal(t, 18, invalidated.TotalVotes)
+	assert.Equal(t, 3, invalidated.UsedCodes, "the invalidated code no longer has a ballot")
+
+	res = testutils.PerformRequest(router, http.MethodDelete, "/api/meta/teams/3?cascade=true", nil, admin)
+	require.Equal(t, http.StatusOK, res.Code, res.Body.String())
+	res = testutils.PerformRequest(router, http.MethodDelete, "/api/meta/categories/2?cascade=true", nil, admin)
+	require.Equal(t, http.StatusOK, res.Code, res.Body.String())
+	assert.Equal(t, 6, assertTalliesMatch(t).TotalVotes)
+
+	stored, err := tallies.GetAll(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 3, stored.Ballots)
+
+	res = testutils.PerformRequest(router, http.MethodDelete, "/api/admin/votes", nil, admin)
+	require.Equal(t, http.StatusOK, res.Code)
+	assert.Zero(t, assertTalliesMatch(t).TotalVotes)
+}
+
+func TestVotesForArchivedItemsAreRejected(t *testing.T) {
+	controller, router := setupTestVoteController(t)
+	ctx := context.Background()
+	require.NoError(t, controller.teamsStorage.Create(ctx, &storage.Team{ID: 1, Name: "Active"}))
+	require.NoError(t, controller.teamsStorage.Create(ctx, &storage.Team{ID: 2, Name: "Gone", Archived: true}))
+	require.NoError(t, controller.categoriesStorage.Create(ctx, &storage.VotingCategory{ID: 1, Name: "Active", Weight: 1}))
+	require.NoError(t, controller.categoriesStorage.Create(ctx, &storage.VotingCategory{ID: 2, Name: "Gone", Weight: 1, Archived: true}))
+	require.NoError(t, controller.codesStorage.Put(ctx, &storage.VotingCode{Code: "ARCH1", Category: "general_public"}))
+
+	for name, vote := range map[string]models.VoteEntry{
+		"Archived team":     {CategoryID: 1, TeamID: 2, Rating: 4},
+		"Archived category": {CategoryID: 2, TeamID: 1, Rating: 4},
+	} {
+		t.Run(name, func(t *testing.T) {
+			res := testutils.PerformRequest(router, http.MethodPost, "/api/vote/", models.RegisterVoteRequest{
+				Code:  "ARCH1",
+				Votes: []models.VoteEntry{{CategoryID: 1, TeamID: 1, Rating: 5}, vote},
+			}, nil)
+			assert.Equal(t, http.StatusBadRequest, res.Code)
+			assert.Contains(t, res.Body.String(), "is archived")
+
+			code, err := controller.codesStorage.Get(ctx, "ARCH1")
+			require.NoError(t, err)
+			assert.False(t, code.Used, "a rejected ballot does not use the code")
+		})
+	}
+}
@@ -0,0 +1,46 @@
+package models
+
+import (
+	"encoding/json"
+	"github.com/alex-pricope/simple-voting-system/storage"
+	"time"
+)
+
+type AuditEntryResponse struct {
+	ID        string          `json:"id"`
+	Timestamp time.Time       `json:"timestamp"`
+	Actor     string          `json:"actor"` // Name of the admin credential
+	Method    string          `json:"method"`
+	Route     string          `json:"route"`
+	Path      string          `json:"path"`
+	Status    int             `json:"status"`
+	RequestID string          `json:"requestId"`
+	Before    json.RawMessage `json:"before,omitempty" swaggertype:"object"`
+	After     json.RawMessage `json:"after,omitempty" swaggertype:"object"`
+}
+
+// AuditFailuresResponse counts the admin changes whose audit entry could not be appended
+type AuditFailuresResponse struct {
+	Failed      int        `json:"failed"`
+	LastFailure *time.Time `json:"lastFailure,omitempty"`
+}
+
+func TransformAuditEntryFromStorage(entry *storage.AuditEntry) AuditEntryResponse {
+	response := AuditEntryResponse{
+		ID:        entry.ID,
+		Timestamp: entry.Timestamp,
+		Actor:     entry.Actor,
+		Method:    entry.Method,
+		Route:     entry.Route,
+		Path:      entry.Path,
+		Status:    entry.Status,
+		RequestID: entry.RequestID,
+	}
+	if entry.Before != "" {
+		response.Before = json.RawMessage(entry.Before)
+	}
+	if entry.After != "" {
+		response.After = json.RawMessage(entry.After)
+	}
+	return response
+}
@@ -0,0 +1,193 @@
+package models
+
+import (
+	"github.com/alex-pricope/simple-voting-system/storage"
+	"sort"
+	"time"
+)
+
+// BackupVersion is the version of the archive written by GET /api/admin/backup.
+// Restore only accepts this version, bump it on any change old archives cannot be read with.
+const BackupVersion = 1
+
+// Backup is the archive of everything stored for one event
+type Backup struct {
+	Version    int              `json:"version"`
+	Event      string           `json:"event"`
+	CreatedAt  time.Time        `json:"createdAt"`
+	Codes      []BackupCode     `json:"codes"`
+	Teams      []BackupTeam     `json:"teams"`
+	Categories []BackupCategory `json:"categories"`
+	Votes      []BackupVote     `json:"votes"`
+}
+
+type BackupCode struct {
+	Code      string     `json:"code"`
+	Category  string     `json:"category"`
+	TeamID    *int       `json:"teamId,omitempty"`
+	CreatedAt time.Time  `json:"createdAt"`
+	Used      bool       `json:"used"`
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+}
+
+type BackupTeam struct {
+	ID          int      `json:"id"`
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Members     []string `json:"members"`
+	Archived    bool     `json:"archived"`
+}
+
+type BackupCategory struct {
+	ID          int     `json:"id"`
+	Name        string  `json:"name"`
+	Description string  `json:"description"`
+	Weight      float64 `json:"weight"`
+	Archived    bool    `json:"archived"`
+}
+
+type BackupVote struct {
+	Code       string    `json:"code"`
+	CategoryID int       `json:"categoryId"`
+	TeamID     int       `json:"teamId"`
+	Rating     int       `json:"rating"`
+	Timestamp  time.Time `json:"timestamp"`
+}
+
+// BackupSummary counts the items of an archive. It is the response of a restore, and of a backup written to S3.
+type BackupSummary struct {
+	Event      string    `json:"event"`
+	CreatedAt  time.Time `json:"createdAt"`
+	Codes      int       `json:"codes"`
+	Teams      int       `json:"teams"`
+	Categories int       `json:"categories"`
+	Votes      int       `json:"votes"`
+	Location   string    `json:"location,omitempty"` // s3://bucket/key of an uploaded archive
+}
+
+// RestoreErrorResponse lists every problem found in an archive, nothing is written when there is one
+type RestoreErrorResponse struct {
+	Error    string   `json:"error"`
+	Problems []string `json:"problems"`
+}
+
+// TransformStorageToBackup builds the archive of an event from its stored items.
+// Items are ordered by ID, so archives of the same data are identical.
+func TransformStorageToBackup(event string, codes []*storage.VotingCode, teams []*storage.Team,
+	categories []*storage.VotingCategory, votes []*storage.Vote) *Backup {
+	backup := &Backup{
+		Version:    BackupVersion,
+		Event:      event,
+		CreatedAt:  time.Now().UTC(),
+		Codes:      make([]BackupCode, 0, len(codes)),
+		Teams:      make([]BackupTeam, 0, len(teams)),
+		Categories: make([]BackupCategory, 0, len(categories)),
+		Votes:      make([]BackupVote, 0, len(votes)),
+	}
+	for _, c := range codes {
+		backup.Codes = append(backup.Codes, BackupCode{
+			Code:      c.Code,
+			Category:  c.Category,
+			TeamID:    c.TeamID,
+			CreatedAt: c.CreatedAt,
+			Used:      c.Used,
+			ExpiresAt: c.ExpiresAt,
+		})
+	}
+	for _, t := range teams {
+		backup.Teams = append(backup.Teams, BackupTeam{
+			ID:          t.ID,
+			Name:        t.Name,
+			Description: t.Description,
+			Members:     t.Members,
+			Archived:    t.Archived,
+		})
+	}
+	for _, c := range categories {
+		backup.Categories = append(backup.Categories, BackupCategory{
+			ID:          c.ID,
+			Name:        c.Name,
+			Description: c.Description,
+			Weight:      c.Weight,
+			Archived:    c.Archived,
+		})
+	}
+	for _, v := range votes {
+		backup.Votes = append(backup.Votes, BackupVote{
+			Code:       v.Code,
+			CategoryID: v.CategoryID,
+			TeamID:     v.TeamID,
+			Rating:     v.Rating,
+			Timestamp:  v.Timestamp,
+		})
+	}
+
+	sort.Slice(backup.Codes, func(i, j int) bool { return backup.Codes[i].Code < backup.Codes[j].Code })
+	sort.Slice(backup.Teams, func(i, j int) bool { return backup.Teams[i].ID < backup.Teams[j].ID })
+	sort.Slice(backup.Categories, func(i, j int) bool { return backup.Categories[i].ID < backup.Categories[j].ID })
+	sort.Slice(backup.Votes, func(i, j int) bool {
+		a, b := backup.Votes[i], backup.Votes[j]
+		if a.Code != b.Code {
+			return a.Code < b.Code
+		}
+		if a.CategoryID != b.CategoryID {
+			return a.CategoryID < b.CategoryID
+		}
+		return a.TeamID < b.TeamID
+	})
+	return backup
+}
+
+// Summary counts the items of the archive
+func (b *Backup) Summary() BackupSummary {
+	return BackupSummary{
+		Event:      b.Event,
+		CreatedAt:  b.CreatedAt,
+		Codes:      len(b.Codes),
+		Teams:      len(b.Teams),
+		Categories: len(b.Categories),
+		Votes:      len(b.Votes),
+	}
+}
+
+func (c BackupCode) ToStorage() *storage.VotingCode {
+	return &storage.VotingCode{
+		Code:      c.Code,
+		Category:  c.Category,
+		TeamID:    c.TeamID,
+		CreatedAt: c.CreatedAt,
+		Used:      c.Used,
+		ExpiresAt: c.ExpiresAt,
+	}
+}
+
+func (t BackupTeam) ToStorage() *storage.Team {
+	return &storage.Team{
+		ID:          t.ID,
+		Name:        t.Name,
+		Description: t.Description,
+		Members:     t.Members,
+		Archived:    t.Archived,
+	}
+}
+
+func (c BackupCategory) ToStorage() *storage.VotingCategory {
+	return &storage.VotingCategory{
+		ID:          c.ID,
+		Name:        c.Name,
+		Description: c.Description,
+		Weight:      c.Weight,
+		Archived:    c.Archived,
+	}
+}
+
+func (v BackupVote) ToStorage() *storage.Vote {
+	return &storage.Vote{
+		Code:       v.Code,
+		SortKey:    storage.VoteSortKey(v.CategoryID, v.TeamID),
+		CategoryID: v.CategoryID,
+		TeamID:     v.TeamID,
+		Rating:     v.Rating,
+		Timestamp:  v.Timestamp,
+	}
+}
@@ -13,6 +13,15 @@ type VotingCategoryUpdateRequest struct {
 	Name        string  `json:"name"`
 	Description string  `json:"description"`
 	Weight      float64 `json:"weight"`
+	Version     *int    `json:"version,omitempty"` // Optional, the update is rejected if the category changed since this version
+}
+
+// VotingCategoryPatchRequest updates only the fields that are set. Version is required.
+type VotingCategoryPatchRequest struct {
+	Name        *string  `json:"name,omitempty"`
+	Description *string  `json:"description,omitempty"`
+	Weight      *float64 `json:"weight,omitempty"`
+	Version     *int     `json:"version"`
 }
 
 type VotingCategoryResponse struct {
@@ -20,6 +29,8 @@ type VotingCategoryResponse struct {
 	Name        string  `json:"name"`
 	Description string  `json:"description"`
 	Weight      float64 `json:"weight"`
+	Version     int     `json:"version"`
+	Archived    bool    `json:"archived"`
 }
 
 func TransformVotingCategoryFromStorage(vc *storage.VotingCategory) VotingCategoryResponse {
@@ -28,5 +39,7 @@ func TransformVotingCategoryFromStorage(vc *storage.VotingCategory) VotingCatego
 		Name:        vc.Name,
 		Description: vc.Description,
 		Weight:      vc.Weight,
+		Version:     vc.Version,
+		Archived:    vc.Archived,
 	}
 }
@@ -20,25 +20,56 @@ var CodeCategoryWeights = map[VotingCategory]float64{
 }
 
 type CodeValidationResponse struct {
-	Valid     bool      `json:"valid"`
-	Category  string    `json:"category"`
-	Used      bool      `json:"used,omitempty"`
-	CreatedAt time.Time `json:"created_at,omitempty"`
-	Code      string    `json:"code,omitempty"`
-	TeamID    *int      `json:"team_id,omitempty"`
+	Valid     bool       `json:"valid"`
+	Category  string     `json:"category"`
+	Used      bool       `json:"used,omitempty"`
+	CreatedAt time.Time  `json:"created_at,omitempty"`
+	Code      string     `json:"code,omitempty"`
+	TeamID    *int       `json:"team_id,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// CodeExpiry sets when codes expire, either at an absolute time or after a TTL from now
+type CodeExpiry struct {
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	// TTL is a Go duration such as "90m" or "12h"
+	TTL string `json:"ttl,omitempty"`
 }
 
 type CreateCodeRequest struct {
 	Category string `json:"category"`
 	Count    int    `json:"count"`
+	// Optional, codes never expire without it
+	CodeExpiry
+}
+
+// SetCodeExpiryRequest changes the expiry of the listed codes, or of every code of a category
+type SetCodeExpiryRequest struct {
+	Codes    []string `json:"codes,omitempty"`
+	Category string   `json:"category,omitempty"`
+	CodeExpiry
+}
+
+type SetCodeExpiryResponse struct {
+	ExpiresAt time.Time `json:"expires_at"`
+	Updated   int       `json:"updated"`
+	Missing   []string  `json:"missing,omitempty"`
+}
+
+// CreateCodesErrorResponse is returned when fewer codes than requested could be created
+type CreateCodesErrorResponse struct {
+	Error   string          `json:"error"`
+	Created []*CodeResponse `json:"created"`
+	Missing int             `json:"missing"`
 }
 
 type CodeResponse struct {
-	Category  string    `json:"category"`
-	Code      string    `json:"code"`
-	CreatedAt time.Time `json:"created_at"`
-	Used      bool      `json:"used"`
-	TeamID    *int      `json:"team_id,omitempty"`
+	Category  string     `json:"category"`
+	Code      string     `json:"code"`
+	CreatedAt time.Time  `json:"created_at"`
+	Used      bool       `json:"used"`
+	TeamID    *int       `json:"team_id,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
 }
 
 func TransformVotingCodeToValidationResponse(vc *storage.VotingCode) *CodeValidationResponse {
@@ -49,6 +80,7 @@ func TransformVotingCodeToValidationResponse(vc *storage.VotingCode) *CodeValida
 		CreatedAt: vc.CreatedAt,
 		Code:      vc.Code,
 		TeamID:    vc.TeamID,
+		ExpiresAt: vc.ExpiresAt,
 	}
 }
 
@@ -59,5 +91,6 @@ func TransformVotingCodeToCodeResponse(vc *storage.VotingCode) *CodeResponse {
 		CreatedAt: vc.CreatedAt,
 		Code:      vc.Code,
 		TeamID:    vc.TeamID,
+		ExpiresAt: vc.ExpiresAt,
 	}
 }
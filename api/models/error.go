@@ -3,3 +3,10 @@ package models
 type ErrorResponse struct {
 	Error string `json:"error"`
 }
+
+// DeleteConflictResponse is returned when a team or category cannot be deleted because votes or codes point at it
+type DeleteConflictResponse struct {
+	Error string `json:"error"`
+	Votes int    `json:"votes"`
+	Codes int    `json:"codes"`
+}
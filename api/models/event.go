@@ -0,0 +1,29 @@
+package models
+
+import (
+	"github.com/alex-pricope/simple-voting-system/storage"
+	"time"
+)
+
+type EventCreateRequest struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type EventResponse struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"createdAt"`
+	Archived  bool      `json:"archived"`
+	Default   bool      `json:"default"` // Served by the routes without /events/{eventId}
+}
+
+func TransformEventFromStorage(event *storage.Event, defaultEvent string) EventResponse {
+	return EventResponse{
+		ID:        event.ID,
+		Name:      event.Name,
+		CreatedAt: event.CreatedAt,
+		Archived:  event.Archived,
+		Default:   event.ID == defaultEvent,
+	}
+}
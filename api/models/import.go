@@ -0,0 +1,16 @@
+package models
+
+// ImportResponse is the result of a CSV import. Nothing is written when a row has an error.
+type ImportResponse struct {
+	DryRun  bool             `json:"dryRun"`
+	Rows    int              `json:"rows"`
+	Created []string         `json:"created"` // IDs or codes created, or that the dry run would create
+	Errors  []ImportRowError `json:"errors,omitempty"`
+	Error   string           `json:"error,omitempty"` // Set when the storage failed part way, created lists what was written
+}
+
+// ImportRowError is a problem with one row, Line is the line of the CSV file counting the header as 1
+type ImportRowError struct {
+	Line  int    `json:"line"`
+	Error string `json:"error"`
+}
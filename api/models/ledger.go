@@ -0,0 +1,75 @@
+package models
+
+import (
+	"github.com/alex-pricope/simple-voting-system/storage"
+	"time"
+)
+
+type LedgerEventResponse struct {
+	ID         string            `json:"id"`
+	Timestamp  time.Time         `json:"timestamp"`
+	Type       string            `json:"type"`
+	Code       string            `json:"code,omitempty"`
+	Seq        int64             `json:"seq,omitempty"` // Order of the change among the changes of the code
+	VoterGroup string            `json:"voterGroup,omitempty"`
+	Votes      []LedgerVoteEntry `json:"votes,omitempty"`
+	Used       bool              `json:"used,omitempty"`
+	Actor      string            `json:"actor,omitempty"` // Name of the admin credential, empty for ballots
+	RequestID  string            `json:"requestId,omitempty"`
+	Note       string            `json:"note,omitempty"`
+}
+
+// LedgerVoteEntry is a rating stored or deleted by a ledger event, with its code
+// since the votes deleted with a team or category span codes
+type LedgerVoteEntry struct {
+	Code string `json:"code"`
+	VoteEntry
+}
+
+func TransformLedgerEventFromStorage(event *storage.LedgerEvent) LedgerEventResponse {
+	response := LedgerEventResponse{
+		ID:         event.ID,
+		Timestamp:  event.Timestamp,
+		Type:       event.Type,
+		Code:       event.Code,
+		Seq:        event.Seq,
+		VoterGroup: event.VoterGroup,
+		Used:       event.Used,
+		Actor:      event.Actor,
+		RequestID:  event.RequestID,
+		Note:       event.Note,
+	}
+	for _, v := range event.Votes {
+		response.Votes = append(response.Votes, LedgerVoteEntry{
+			Code:      v.Code,
+			VoteEntry: VoteEntry{CategoryID: v.CategoryID, TeamID: v.TeamID, Rating: v.Rating},
+		})
+	}
+	return response
+}
+
+// LedgerVerifyResponse compares the votes replayed from the ledger with the stored ones
+type LedgerVerifyResponse struct {
+	Events       int  `json:"events"`
+	Votes        int  `json:"votes"`       // Replayed from the ledger
+	StoredVotes  int  `json:"storedVotes"` // Read from the vote storage
+	Missing      int  `json:"missing"`     // Replayed but not stored
+	Extra        int  `json:"extra"`       // Stored but not replayed
+	Changed      int  `json:"changed"`     // Stored with another team, category or rating
+	ResultsMatch bool `json:"resultsMatch"`
+}
+
+type LedgerRebuildResponse struct {
+	Events  int `json:"events"`
+	Votes   int `json:"votes"`   // Replayed from the ledger, stored once the rebuild is done
+	Extra   int `json:"extra"`   // Stored votes the ledger does not have, kept unless deleteExtra is set
+	Created int `json:"created"` // Votes written, the missing and changed ones
+	Deleted int `json:"deleted"` // Votes removed, the changed ones and with deleteExtra the extra ones
+	Codes   int `json:"codes"`   // Codes whose used flag was corrected
+	Tallies int `json:"tallies"` // Tallies replaced, 0 when the results are computed from the votes
+}
+
+type LedgerSeedResponse struct {
+	Ballots int `json:"ballots"` // Events recorded, one per code with votes or marked used
+	Votes   int `json:"votes"`
+}
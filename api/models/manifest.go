@@ -0,0 +1,51 @@
+package models
+
+// Manifest describes the setup of an event, see POST /api/admin/manifest/plan
+type Manifest struct {
+	// Teams and Categories are nil when the section is missing, the stored ones are then left alone
+	Teams      []ManifestTeam     `yaml:"teams"`
+	Categories []ManifestCategory `yaml:"categories"`
+	// Codes is the number of codes wanted per voter group. Missing codes are generated, codes are never deleted.
+	Codes map[string]int `yaml:"codes"`
+}
+
+type ManifestTeam struct {
+	ID          int      `yaml:"id"`
+	Name        string   `yaml:"name"`
+	Description string   `yaml:"description"`
+	Members     []string `yaml:"members"`
+}
+
+type ManifestCategory struct {
+	ID          int     `yaml:"id"`
+	Name        string  `yaml:"name"`
+	Description string  `yaml:"description"`
+	Weight      float64 `yaml:"weight"`
+}
+
+// Actions of a ManifestChange
+const (
+	ManifestCreate  = "create"
+	ManifestUpdate  = "update"
+	ManifestDelete  = "delete"
+	ManifestArchive = "archive" // A team or category left out of the manifest that votes or codes still point at
+)
+
+// ManifestChange is one step of a plan
+type ManifestChange struct {
+	Action string   `json:"action"`
+	Kind   string   `json:"kind"` // team, category or codes
+	ID     string   `json:"id"`   // ID of the team or category, voter group of the codes
+	Name   string   `json:"name,omitempty"`
+	Fields []string `json:"fields,omitempty"` // Fields changed by an update
+	Count  int      `json:"count,omitempty"`  // Codes to generate
+}
+
+type ManifestPlanResponse struct {
+	Changes  []ManifestChange `json:"changes"`
+	Warnings []string         `json:"warnings,omitempty"`
+	Applied  bool             `json:"applied"`
+	// Error is set when apply failed part way, the changes before the failed one were applied.
+	// Applying the manifest again finishes the rest.
+	Error string `json:"error,omitempty"`
+}
@@ -0,0 +1,55 @@
+package models
+
+import (
+	"github.com/alex-pricope/simple-voting-system/storage"
+	"time"
+)
+
+type MigrationResponse struct {
+	Version   int        `json:"version"`
+	Name      string     `json:"name"`
+	Table     string     `json:"table"`
+	AppliedAt *time.Time `json:"appliedAt,omitempty"` // Missing while the migration is pending
+	Scanned   int        `json:"scanned"`
+	Rewritten int        `json:"rewritten"` // In a dry run, the items that would be rewritten
+}
+
+type MigrationRunResponse struct {
+	DryRun     bool                `json:"dryRun"`
+	Migrations []MigrationResponse `json:"migrations"`
+	Error      string              `json:"error,omitempty"` // Set when a migration failed, the later ones did not run
+}
+
+func TransformMigrationResults(results []*storage.MigrationResult) []MigrationResponse {
+	responses := make([]MigrationResponse, 0, len(results))
+	for _, r := range results {
+		responses = append(responses, MigrationResponse{
+			Version:   r.Version,
+			Name:      r.Name,
+			Table:     r.Table,
+			AppliedAt: r.AppliedAt,
+			Scanned:   r.Scanned,
+			Rewritten: r.Rewritten,
+		})
+	}
+	return responses
+}
+
+type SingleTableCopyResponse struct {
+	DryRun     bool   `json:"dryRun"`
+	Codes      int    `json:"codes"` // Items copied, or that would be in a dry run
+	Votes      int    `json:"votes"`
+	Teams      int    `json:"teams"`
+	Categories int    `json:"categories"`
+	Deleted    int    `json:"deleted"`         // Items of the partition missing from the four tables, removed by an overwrite
+	Error      string `json:"error,omitempty"` // Set when the copy failed, the counts are what was copied before
+}
+
+func TransformSingleTableCopyResult(dryRun bool, result *storage.SingleTableCopyResult) SingleTableCopyResponse {
+	response := SingleTableCopyResponse{DryRun: dryRun}
+	if result != nil {
+		response.Codes, response.Votes, response.Teams, response.Categories = result.Codes, result.Votes, result.Teams, result.Categories
+		response.Deleted = result.Deleted
+	}
+	return response
+}
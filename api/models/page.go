@@ -0,0 +1,7 @@
+package models
+
+// PageResponse is returned by list endpoints when paging is requested with limit or next.
+type PageResponse[T any] struct {
+	Items []T    `json:"items"`
+	Next  string `json:"next,omitempty"`
+}
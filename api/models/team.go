@@ -16,12 +16,23 @@ type TeamResponse struct {
 	Name        string   `json:"name"`
 	Members     []string `json:"members"`
 	Description string   `json:"description"`
+	Version     int      `json:"version"`
+	Archived    bool     `json:"archived"`
 }
 
 type TeamUpdateRequest struct {
 	Name        string   `json:"name"`
 	Description string   `json:"description"`
 	Members     []string `json:"members"`
+	Version     *int     `json:"version,omitempty"` // Optional, the update is rejected if the team changed since this version
+}
+
+// TeamPatchRequest updates only the fields that are set. Version is required.
+type TeamPatchRequest struct {
+	Name        *string   `json:"name,omitempty"`
+	Description *string   `json:"description,omitempty"`
+	Members     *[]string `json:"members,omitempty"`
+	Version     *int      `json:"version"`
 }
 
 func TransformTeamFromStorage(vc *storage.Team) TeamResponse {
@@ -30,5 +41,7 @@ func TransformTeamFromStorage(vc *storage.Team) TeamResponse {
 		Name:        vc.Name,
 		Description: vc.Description,
 		Members:     vc.Members,
+		Version:     vc.Version,
+		Archived:    vc.Archived,
 	}
 }
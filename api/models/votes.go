@@ -1,5 +1,7 @@
 package models
 
+import "time"
+
 // VoteEntry represents a single vote cast for a team in a category.
 type VoteEntry struct {
 	CategoryID int `json:"categoryId" binding:"required"`
@@ -52,3 +54,19 @@ type VoteResultsResponse struct {
 	Results    []VoteResult `json:"results"`
 	UsedCodes  int          `json:"usedCodes"`
 }
+
+type RebuildTalliesResponse struct {
+	Ballots int `json:"ballots"`
+	Votes   int `json:"votes"`
+	Tallies int `json:"tallies"`
+}
+
+// AdminVoteResponse is a single rating as seen by an admin. It carries the voter group of the
+// code instead of the code itself, so a rating cannot be traced back to a voter.
+type AdminVoteResponse struct {
+	CategoryID int       `json:"categoryId"`
+	TeamID     int       `json:"teamId"`
+	Rating     int       `json:"rating"`
+	VoterGroup string    `json:"voterGroup"`
+	Timestamp  time.Time `json:"timestamp"`
+}
@@ -2,75 +2,538 @@ package api
 
 import (
 	"context"
+	"database/sql"
 	"fmt"
 	"github.com/alex-pricope/simple-voting-system/api/controllers"
 	"github.com/alex-pricope/simple-voting-system/api/transport"
 	"github.com/alex-pricope/simple-voting-system/logging"
 	"github.com/alex-pricope/simple-voting-system/storage"
+	"github.com/alex-pricope/simple-voting-system/storage/cached"
+	"github.com/alex-pricope/simple-voting-system/storage/faults"
+	"github.com/alex-pricope/simple-voting-system/storage/instrumented"
+	"github.com/alex-pricope/simple-voting-system/storage/memory"
+	"github.com/alex-pricope/simple-voting-system/storage/sqlite"
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
-	awsconfig "github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
 	ginadapter "github.com/awslabs/aws-lambda-go-api-proxy/gin"
 	"github.com/gin-gonic/gin"
+	"net/http"
 	"os"
 )
 
 type Server struct {
 	config *Config
+
+	// Shared by the storages of every event
+	dynamoClient *dynamodb.Client
+	sqliteDB     *sql.DB
+	recorder     *instrumented.Recorder
+	faults       *faults.Injector // Nil unless storage.faults is configured
+	caches       *cached.Flushers
+	backups      storage.BackupStorage // Nil when no backup bucket is configured
+	// Audit log of the default event, which also records the admin changes that belong to no event
+	defaultAudit storage.AuditStorage
 }
 
 func NewServer(config *Config) *Server {
 	return &Server{
 		config: config,
+		caches: &cached.Flushers{},
 	}
 }
 
 func (s *Server) Start() {
 	r := transport.NewRouter(gin.DebugMode)
 
-	// Create storage
-	cfg, err := awsconfig.LoadDefaultConfig(context.Background())
-	if err != nil {
-		logging.Log.Errorf("failed to load AWS config: %v", err)
-		panic("failed to load AWS config")
+	s.checkConfig()
+	if s.config.Faults != (FaultsConfig{}) {
+		s.faults = s.createFaultInjector()
+	}
+	if s.config.Instrument {
+		logging.Log.Infof("Instrumenting storage, slow call threshold %s", s.config.SlowCallThreshold)
+		s.recorder = instrumented.NewRecorder(s.config.SlowCallThreshold)
+	}
+	events := s.createEventStorage()
+	if s.config.BackupBucket != "" {
+		s.backups = s.createBackupStorage()
 	}
 
-	dynamoClient := dynamodb.NewFromConfig(cfg)
+	//Register controllers
+	eventController := controllers.NewEventController(events, s.config.DefaultEvent, s.eventHandler, s.eventProvisioner())
+	if err := eventController.Init(context.Background()); err != nil {
+		logging.Log.Errorf("failed to open the default event %s: %v", s.config.DefaultEvent, err)
+		panic("failed to open the default event: " + err.Error())
+	}
+	// Record the admin changes of the routes below, such as creating an event or flushing the cache.
+	// The event scoped routes are recorded by the handler of their event.
+	r.Use(controllers.NewAuditController(s.defaultAudit).Middleware())
+	eventController.RegisterRoutes(r)
+	if s.recorder != nil {
+		controllers.NewStorageMetricsController(s.recorder).RegisterRoutes(r)
+	}
+	if s.config.CacheTTL > 0 {
+		controllers.NewCacheController(s.caches).RegisterRoutes(r)
+	}
 
-	codeStorage := &storage.DynamoVotingCodesStorage{
-		Client:    dynamoClient,
-		TableName: s.config.TableNameCodes,
+	//Do not run lambda helper locally
+	if os.Getenv("APP_ENV") == "local" {
+		startLocal(r, s.config.Port)
+	} else {
+		startLambda(r)
 	}
-	teamStorage := &storage.DynamoTeamStorage{
-		Client:    dynamoClient,
-		TableName: s.config.TableNameTeams,
+}
+
+// eventProvisioner returns the controllers.EventProvisioner creating the missing tables of an event,
+// nil unless storage.createTables is set with the dynamo backend. The SQLite tables are created when opened.
+func (s *Server) eventProvisioner() controllers.EventProvisioner {
+	if s.dynamoClient == nil || !s.config.CreateTables {
+		return nil
+	}
+	return func(ctx context.Context, event *storage.Event) error {
+		tables := s.config.tableNames().InNamespace(event.Namespace)
+		provisioner := &storage.DynamoProvisioner{Client: s.dynamoClient, CreateMissing: true}
+		return provisioner.Ensure(ctx, s.dynamoTableSchemas(tables))
+	}
+}
+
+// eventHandler builds the /api routes of one event on top of the tables of its namespace.
+// It is a controllers.EventHandlerFactory.
+func (s *Server) eventHandler(event *storage.Event) (http.Handler, error) {
+	tables := s.config.tableNames().InNamespace(event.Namespace)
+	stores, err := s.createStorage(tables)
+	if err != nil {
+		return nil, err
 	}
-	categoryStorage := &storage.DynamoVotingCategoryStorage{
-		Client:    dynamoClient,
-		TableName: s.config.TableNameVotingCategories,
+	labels := s.storageLabels(tables)
+	// Faults go inside the instrumentation, so the metrics show them like real storage failures
+	if s.faults != nil {
+		stores = s.injectFaults(stores, labels)
 	}
-	votesStorage := &storage.DynamoVoteStorage{
-		Client:    dynamoClient,
-		TableName: s.config.TableNameVotes,
+	if s.recorder != nil {
+		stores = s.instrumentStorage(stores, labels)
+	}
+	// Cache outside the instrumentation, so only the calls that reach the storage are timed
+	if s.config.CacheTTL > 0 {
+		s.caches.Add(s.cacheStorage(stores)...)
 	}
 
-	//Register controllers
-	votingController := controllers.NewVotingController(codeStorage, votesStorage, teamStorage, categoryStorage)
+	if event.ID == s.config.DefaultEvent {
+		s.defaultAudit = stores.audit
+	}
+
+	r := gin.New()
+	r.NoRoute(transport.NoRouteHandler())
+	// Record the admin changes of every route registered below
+	auditController := controllers.NewAuditController(stores.audit)
+	r.Use(auditController.Middleware())
+	auditController.RegisterRoutes(r)
+	votingController := controllers.NewVotingController(stores.codes, stores.votes, stores.ballots, stores.teams, stores.categories, stores.tallies, stores.loader, stores.ledger)
 	votingController.RegisterRoutes(r)
-	adminController := controllers.NewAdminController(codeStorage, teamStorage, votesStorage)
+	adminController := controllers.NewAdminController(stores.codes, stores.teams, stores.votes, stores.tallies, stores.ledger)
 	adminController.RegisterRoutes(r)
-	metaVotingCategoriesController := controllers.NewCategoryMetaController(categoryStorage)
+	metaVotingCategoriesController := controllers.NewCategoryMetaController(stores.categories, stores.votes, stores.codes, stores.tallies, stores.ledger)
 	metaVotingCategoriesController.RegisterRoutes(r)
-	metaTeamController := controllers.NewTeamMetaController(teamStorage)
+	metaTeamController := controllers.NewTeamMetaController(stores.teams, stores.votes, stores.codes, stores.tallies, stores.ledger)
 	metaTeamController.RegisterRoutes(r)
+	if stores.tallies != nil {
+		controllers.NewTallyController(stores.codes, stores.votes, stores.tallies).RegisterRoutes(r)
+	}
+	manifestController := controllers.NewManifestController(stores.teams, stores.categories, stores.codes, stores.votes)
+	manifestController.RegisterRoutes(r)
+	importController := controllers.NewImportController(stores.codes, stores.teams, stores.categories)
+	importController.RegisterRoutes(r)
+	backupController := controllers.NewBackupController(event.ID, stores.codes, stores.votes, stores.teams, stores.categories, stores.tallies, s.backups, stores.ledger)
+	backupController.RegisterRoutes(r)
+	ledgerController := controllers.NewLedgerController(stores.ledger, stores.codes, stores.votes, stores.teams, stores.categories, stores.tallies)
+	ledgerController.RegisterRoutes(r)
+	if s.dynamoClient != nil {
+		// The item migrations rewrite the four tables, which the single layout no longer serves
+		var migrator storage.Migrator
+		if !s.singleTable() {
+			migrator = &storage.DynamoMigrator{
+				Client:       s.dynamoClient,
+				RecordsTable: s.config.TableNameMigrations,
+				Tables:       tables,
+				Migrations:   storage.DynamoMigrations(),
+			}
+		}
+		copier := &storage.DynamoSingleTableCopier{
+			Client: s.dynamoClient,
+			From:   tables,
+			To:     storage.SingleTable{Client: s.dynamoClient, TableName: tables.Single, Partition: tables.Partition},
+		}
+		controllers.NewMigrationController(migrator, copier).RegisterRoutes(r)
+	}
+	if s.singleTable() {
+		logging.Log.Infof("Serving event %s from partition %s of table %s", event.ID, tables.Partition, tables.Single)
+	} else {
+		logging.Log.Infof("Serving event %s from the %s tables", event.ID, tables.Codes)
+	}
+	return r, nil
+}
 
-	//Do not run lambda helper locally
-	if os.Getenv("APP_ENV") == "local" {
-		startLocal(r, s.config.Port)
+// storageLabels are the table names the wrapped storages report their calls under.
+// In the single table they are named after the table and the kind of item.
+func (s *Server) storageLabels(tables storage.TableNames) storage.TableNames {
+	if !s.singleTable() {
+		return tables
+	}
+	labels := tables
+	labels.Codes = tables.Single + "/codes"
+	labels.Votes = tables.Single + "/votes"
+	labels.Teams = tables.Single + "/teams"
+	labels.Categories = tables.Single + "/categories"
+	return labels
+}
+
+type storages struct {
+	codes      storage.VotingCodeStorage
+	votes      storage.VoteStorage
+	ballots    storage.BallotStorage
+	teams      storage.TeamStorage
+	categories storage.VotingCategoryStorage
+	tallies    storage.TallyStorage // Only set when the results are read from tallies
+	audit      storage.AuditStorage
+	ledger     storage.LedgerStorage
+	loader     storage.EventLoader // Only set with the single-table layout
+}
+
+// checkConfig rejects unknown settings before anything is opened
+func (s *Server) checkConfig() {
+	if s.config.Backend != StorageBackendDynamo && s.config.VoteLayout != VoteLayoutRating {
+		logging.Log.Warnf("storage.voteLayout %s only applies to the dynamo backend, ignoring it", s.config.VoteLayout)
+	}
+	switch s.config.Results {
+	case ResultsFromVotes, ResultsFromTallies:
+	default:
+		logging.Log.Errorf("unknown results source: %s", s.config.Results)
+		panic("unknown results source: " + s.config.Results)
+	}
+	switch s.config.VoteLayout {
+	case VoteLayoutRating, VoteLayoutBallot:
+	default:
+		logging.Log.Errorf("unknown vote layout: %s", s.config.VoteLayout)
+		panic("unknown vote layout: " + s.config.VoteLayout)
+	}
+	switch s.config.TableLayout {
+	case TableLayoutTables, TableLayoutSingle:
+	default:
+		logging.Log.Errorf("unknown table layout: %s", s.config.TableLayout)
+		panic("unknown table layout: " + s.config.TableLayout)
+	}
+	if s.config.TableLayout == TableLayoutSingle {
+		if s.config.Backend != StorageBackendDynamo {
+			logging.Log.Warnf("storage.tableLayout %s only applies to the dynamo backend, ignoring it", s.config.TableLayout)
+		} else if s.config.VoteLayout != VoteLayoutRating {
+			logging.Log.Warnf("storage.voteLayout %s does not apply to the single table, it keeps one item per rating", s.config.VoteLayout)
+		}
+	}
+}
+
+// singleTable reports whether the codes, votes, teams and categories are kept in the single table
+func (s *Server) singleTable() bool {
+	return s.config.Backend == StorageBackendDynamo && s.config.TableLayout == TableLayoutSingle
+}
+
+// createEventStorage opens the configured backend and returns the storage of the events
+func (s *Server) createEventStorage() storage.EventStorage {
+	var events storage.EventStorage
+	switch s.config.Backend {
+	case StorageBackendMemory:
+		logging.Log.Warn("Using in-memory storage, data is lost on restart")
+		events = memory.NewEventStorage()
+	case StorageBackendSQLite:
+		db, err := sqlite.Open(s.config.SQLitePath)
+		if err != nil {
+			logging.Log.Errorf("failed to open SQLite database %s: %v", s.config.SQLitePath, err)
+			panic("failed to open SQLite database")
+		}
+		logging.Log.Infof("Using SQLite storage at %s", s.config.SQLitePath)
+		s.sqliteDB = db
+		sqliteEvents := &sqlite.EventStorage{DB: db, TableName: s.config.TableNameEvents}
+		if err := sqliteEvents.CreateTable(context.Background()); err != nil {
+			panic("failed to create SQLite schema: " + err.Error())
+		}
+		events = sqliteEvents
+	case StorageBackendDynamo:
+		dynamoClient, err := NewDynamoClient(context.Background(), s.config.StorageConfig)
+		if err != nil {
+			logging.Log.Errorf("failed to load AWS config: %v", err)
+			panic("failed to load AWS config")
+		}
+		if s.config.Endpoint != "" {
+			logging.Log.Infof("Using DynamoDB endpoint %s", s.config.Endpoint)
+		}
+		s.dynamoClient = dynamoClient
+		if err := s.ensureDynamoTables(storage.EventTableSchema(s.config.TableNameEvents),
+			storage.MigrationTableSchema(s.config.TableNameMigrations)); err != nil {
+			logging.Log.Errorf("DynamoDB tables are not usable: %v", err)
+			panic("DynamoDB tables are not usable: " + err.Error())
+		}
+		events = &storage.DynamoEventStorage{Client: dynamoClient, TableName: s.config.TableNameEvents}
+	default:
+		logging.Log.Errorf("unknown storage backend: %s", s.config.Backend)
+		panic("unknown storage backend: " + s.config.Backend)
+	}
+
+	if s.recorder != nil {
+		events = instrumented.NewEventStorage(events, s.config.TableNameEvents, s.recorder)
+	}
+	return events
+}
+
+// createBackupStorage returns the S3 bucket backups are uploaded to
+func (s *Server) createBackupStorage() storage.BackupStorage {
+	client, err := NewS3Client(context.Background(), s.config.StorageConfig)
+	if err != nil {
+		logging.Log.Errorf("failed to load AWS config: %v", err)
+		panic("failed to load AWS config")
+	}
+	logging.Log.Infof("Uploading backups to bucket %s", s.config.BackupBucket)
+	return &storage.S3BackupStorage{Client: client, Bucket: s.config.BackupBucket}
+}
+
+// createStorage builds the storage implementations of one event for the configured backend
+func (s *Server) createStorage(tables storage.TableNames) (*storages, error) {
+	switch s.config.Backend {
+	case StorageBackendMemory:
+		codes, votes := memory.NewVotingCodesStorage(), memory.NewVoteStorage()
+		stores := &storages{
+			codes:      codes,
+			votes:      votes,
+			ballots:    memory.NewBallotStorage(codes, votes),
+			teams:      memory.NewTeamStorage(),
+			categories: memory.NewVotingCategoryStorage(),
+			audit:      memory.NewAuditStorage(),
+			ledger:     memory.NewLedgerStorage(),
+		}
+		if s.useTallies() {
+			stores.tallies = memory.NewTallyStorage()
+		}
+		return stores, nil
+	case StorageBackendSQLite:
+		return s.createSQLiteStorage(tables)
+	default:
+		return s.createDynamoStorage(tables)
+	}
+}
+
+// ensureDynamoTables checks, and creates when configured, the given tables.
+// It does nothing when the table check is disabled.
+func (s *Server) ensureDynamoTables(schemas ...storage.TableSchema) error {
+	if !s.config.CheckTables && !s.config.CreateTables {
+		return nil
+	}
+	provisioner := &storage.DynamoProvisioner{Client: s.dynamoClient, CreateMissing: s.config.CreateTables}
+	return provisioner.Ensure(context.Background(), schemas)
+}
+
+// checkDynamoTables checks the tables of an event without creating them, that is left to eventProvisioner.
+// It does nothing when the table check is disabled.
+func (s *Server) checkDynamoTables(tables storage.TableNames) error {
+	if !s.config.CheckTables && !s.config.CreateTables {
+		return nil
+	}
+	provisioner := &storage.DynamoProvisioner{Client: s.dynamoClient}
+	if err := provisioner.Ensure(context.Background(), s.dynamoTableSchemas(tables)); err != nil {
+		logging.Log.Errorf("DynamoDB tables are not usable: %v", err)
+		return err
+	}
+	return nil
+}
+
+// dynamoTableSchemas returns the schemas of the tables of an event in the configured layout
+func (s *Server) dynamoTableSchemas(tables storage.TableNames) []storage.TableSchema {
+	var schemas []storage.TableSchema
+	if s.singleTable() {
+		schemas = append(schemas, storage.SingleTableSchema(tables.Single))
 	} else {
-		startLambda(r)
+		schemas = append(schemas, storage.DynamoTableSchemas(tables.Codes, tables.Votes, tables.Teams, tables.Categories)...)
+	}
+	schemas = append(schemas, storage.AuditTableSchema(tables.Audit), storage.LedgerTableSchema(tables.Ledger))
+	if s.useTallies() {
+		schemas = append(schemas, storage.TallyTableSchema(tables.Tallies))
+	}
+	return schemas
+}
+
+func (s *Server) createDynamoStorage(tables storage.TableNames) (*storages, error) {
+	if s.singleTable() {
+		return s.createSingleTableStorage(tables)
+	}
+
+	// Fail on startup instead of on the first request when the tables are missing or keyed differently
+	if err := s.checkDynamoTables(tables); err != nil {
+		return nil, err
+	}
+
+	var votes storage.VoteStorage
+	if s.config.VoteLayout == VoteLayoutBallot {
+		votes = &storage.DynamoBallotVoteStorage{Client: s.dynamoClient, TableName: tables.Votes}
+	} else {
+		votes = &storage.DynamoVoteStorage{Client: s.dynamoClient, TableName: tables.Votes}
+	}
+
+	stores := &storages{
+		codes: &storage.DynamoVotingCodesStorage{
+			Client:    s.dynamoClient,
+			TableName: tables.Codes,
+		},
+		votes: votes,
+		ballots: &storage.DynamoBallotStorage{
+			Client:         s.dynamoClient,
+			CodesTableName: tables.Codes,
+			VotesTableName: tables.Votes,
+			SingleItem:     s.config.VoteLayout == VoteLayoutBallot,
+		},
+		teams: &storage.DynamoTeamStorage{
+			Client:    s.dynamoClient,
+			TableName: tables.Teams,
+		},
+		categories: &storage.DynamoVotingCategoryStorage{
+			Client:    s.dynamoClient,
+			TableName: tables.Categories,
+		},
+		audit: &storage.DynamoAuditStorage{
+			Client:    s.dynamoClient,
+			TableName: tables.Audit,
+		},
+		ledger: &storage.DynamoLedgerStorage{
+			Client:    s.dynamoClient,
+			TableName: tables.Ledger,
+		},
+	}
+	if s.useTallies() {
+		stores.tallies = &storage.DynamoTallyStorage{Client: s.dynamoClient, TableName: tables.Tallies}
+	}
+	return stores, nil
+}
+
+// createSingleTableStorage keeps the codes, votes, teams and categories of the event in its partition of the
+// single table. Tallies, the audit log and the ledger stay in their own tables.
+func (s *Server) createSingleTableStorage(tables storage.TableNames) (*storages, error) {
+	if err := s.checkDynamoTables(tables); err != nil {
+		return nil, err
 	}
+
+	table := storage.SingleTable{Client: s.dynamoClient, TableName: tables.Single, Partition: tables.Partition}
+	stores := &storages{
+		codes:      &storage.SingleTableVotingCodesStorage{SingleTable: table},
+		votes:      &storage.SingleTableVoteStorage{SingleTable: table},
+		ballots:    &storage.SingleTableBallotStorage{SingleTable: table},
+		teams:      &storage.SingleTableTeamStorage{SingleTable: table},
+		categories: &storage.SingleTableVotingCategoryStorage{SingleTable: table},
+		audit:      &storage.DynamoAuditStorage{Client: s.dynamoClient, TableName: tables.Audit},
+		ledger:     &storage.DynamoLedgerStorage{Client: s.dynamoClient, TableName: tables.Ledger},
+		loader:     &storage.SingleTableEventLoader{SingleTable: table},
+	}
+	if s.useTallies() {
+		stores.tallies = &storage.DynamoTallyStorage{Client: s.dynamoClient, TableName: tables.Tallies}
+	}
+	return stores, nil
+}
+
+func (s *Server) createSQLiteStorage(names storage.TableNames) (*storages, error) {
+	db := s.sqliteDB
+	codes := &sqlite.VotingCodesStorage{DB: db, TableName: names.Codes}
+	votes := &sqlite.VoteStorage{DB: db, TableName: names.Votes}
+	teams := &sqlite.TeamStorage{DB: db, TableName: names.Teams}
+	categories := &sqlite.VotingCategoryStorage{DB: db, TableName: names.Categories}
+	audit := &sqlite.AuditStorage{DB: db, TableName: names.Audit}
+	ledger := &sqlite.LedgerStorage{DB: db, TableName: names.Ledger}
+	tables := []interface{ CreateTable(context.Context) error }{codes, votes, teams, categories, audit, ledger}
+
+	stores := &storages{
+		codes:      codes,
+		votes:      votes,
+		ballots:    &sqlite.BallotStorage{DB: db, CodesTableName: names.Codes, VotesTableName: names.Votes},
+		teams:      teams,
+		categories: categories,
+		audit:      audit,
+		ledger:     ledger,
+	}
+	if s.useTallies() {
+		tallies := &sqlite.TallyStorage{DB: db, TableName: names.Tallies}
+		tables = append(tables, tallies)
+		stores.tallies = tallies
+	}
+
+	// Create the schema of the event when it is first opened
+	ctx := context.Background()
+	for _, table := range tables {
+		if err := table.CreateTable(ctx); err != nil {
+			return nil, err
+		}
+	}
+	return stores, nil
+}
+
+// useTallies reports whether the results are read from tallies kept up to date at vote time
+func (s *Server) useTallies() bool {
+	return s.config.Results == ResultsFromTallies
+}
+
+// createFaultInjector validates storage.faults, which is meant for load tests only
+func (s *Server) createFaultInjector() *faults.Injector {
+	conf := s.config.Faults
+	injector, err := faults.NewInjector(conf.ErrorRate, conf.ThrottleRate, conf.Latency)
+	if err != nil {
+		logging.Log.Errorf("invalid storage.faults: %v", err)
+		panic("invalid storage.faults: " + err.Error())
+	}
+	logging.Log.Warnf("Injecting storage faults: error rate %g, throttle rate %g, latency %s",
+		conf.ErrorRate, conf.ThrottleRate, conf.Latency)
+	return injector
+}
+
+// injectFaults wraps every storage to slow down and fail calls as configured by storage.faults
+func (s *Server) injectFaults(stores *storages, tables storage.TableNames) *storages {
+	wrapped := &storages{
+		codes:      faults.NewVotingCodeStorage(stores.codes, tables.Codes, s.faults),
+		votes:      faults.NewVoteStorage(stores.votes, tables.Votes, s.faults),
+		ballots:    faults.NewBallotStorage(stores.ballots, tables.Votes, s.faults),
+		teams:      faults.NewTeamStorage(stores.teams, tables.Teams, s.faults),
+		categories: faults.NewVotingCategoryStorage(stores.categories, tables.Categories, s.faults),
+		audit:      faults.NewAuditStorage(stores.audit, tables.Audit, s.faults),
+		ledger:     faults.NewLedgerStorage(stores.ledger, tables.Ledger, s.faults),
+	}
+	if stores.tallies != nil {
+		wrapped.tallies = faults.NewTallyStorage(stores.tallies, tables.Tallies, s.faults)
+	}
+	if stores.loader != nil {
+		wrapped.loader = faults.NewEventLoader(stores.loader, tables.Single, s.faults)
+	}
+	return wrapped
+}
+
+// instrumentStorage wraps every storage to record per-table timings and errors
+func (s *Server) instrumentStorage(stores *storages, tables storage.TableNames) *storages {
+	wrapped := &storages{
+		codes:      instrumented.NewVotingCodeStorage(stores.codes, tables.Codes, s.recorder),
+		votes:      instrumented.NewVoteStorage(stores.votes, tables.Votes, s.recorder),
+		ballots:    instrumented.NewBallotStorage(stores.ballots, tables.Votes, s.recorder),
+		teams:      instrumented.NewTeamStorage(stores.teams, tables.Teams, s.recorder),
+		categories: instrumented.NewVotingCategoryStorage(stores.categories, tables.Categories, s.recorder),
+		audit:      instrumented.NewAuditStorage(stores.audit, tables.Audit, s.recorder),
+		ledger:     instrumented.NewLedgerStorage(stores.ledger, tables.Ledger, s.recorder),
+	}
+	if stores.tallies != nil {
+		wrapped.tallies = instrumented.NewTallyStorage(stores.tallies, tables.Tallies, s.recorder)
+	}
+	if stores.loader != nil {
+		wrapped.loader = instrumented.NewEventLoader(stores.loader, tables.Single, s.recorder)
+	}
+	return wrapped
+}
+
+// cacheStorage puts the read-through cache in front of teams and categories and returns the caches
+func (s *Server) cacheStorage(stores *storages) []cached.Flusher {
+	logging.Log.Infof("Caching teams and categories for %s", s.config.CacheTTL)
+	teams := cached.NewTeamStorage(stores.teams, s.config.CacheTTL)
+	categories := cached.NewVotingCategoryStorage(stores.categories, s.config.CacheTTL)
+	stores.teams, stores.categories = teams, categories
+	return []cached.Flusher{teams, categories}
 }
 
 // StartLambda sets up for AWS Lambda
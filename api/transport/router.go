@@ -1,18 +1,30 @@
 package transport
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"github.com/alex-pricope/simple-voting-system/logging"
 	"github.com/gin-gonic/gin"
 	swaggerFiles "github.com/swaggo/files"
 	ginSwagger "github.com/swaggo/gin-swagger"
 	"net/http"
 	"os"
+	"strings"
 )
 
+// RequestIDHeader carries the ID of a request, it is generated when the client does not send one
+const RequestIDHeader = "X-Request-Id"
+
+// AuditFailedHeader is set on the response of an admin change whose audit entry could not be appended
+const AuditFailedHeader = "X-Audit-Failed"
+
+// DefaultAdminName is the credential name of the ADMIN_TOKEN token
+const DefaultAdminName = "admin"
+
 func NewRouter(ginMode string) *gin.Engine {
 	gin.SetMode(ginMode)
 	engine := gin.New()
-	engine.Use(CORSMiddleware())
+	engine.Use(RequestIDMiddleware(), CORSMiddleware())
 
 	//Bypass swagger for non-local
 	if os.Getenv("APP_ENV") == "local" {
@@ -28,7 +40,8 @@ func CORSMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
 		c.Writer.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
-		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, x-admin-token")
+		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, x-admin-token, "+RequestIDHeader)
+		c.Writer.Header().Set("Access-Control-Expose-Headers", RequestIDHeader+", "+AuditFailedHeader)
 
 		if c.Request.Method == "OPTIONS" {
 			logging.Log.Infof("OPTIONS request received:%s", c.Request.URL.Path)
@@ -49,10 +62,7 @@ func NoRouteHandler() gin.HandlerFunc {
 
 func AdminAuthMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		token := c.GetHeader("x-admin-token")
-		expected := os.Getenv("ADMIN_TOKEN")
-
-		if token == "" || token != expected {
+		if !IsAdmin(c) {
 			logging.Log.Warnf("ADMIN: Unauthorized access attempt to %s", c.Request.URL.Path)
 			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
 			return
@@ -60,3 +70,44 @@ func AdminAuthMiddleware() gin.HandlerFunc {
 		c.Next()
 	}
 }
+
+// RequestIDMiddleware makes sure every request has an X-Request-Id header and echoes it in the response.
+// The header stays on the request, so handlers of forwarded requests see the same ID.
+func RequestIDMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(RequestIDHeader)
+		if id == "" {
+			b := make([]byte, 8)
+			_, _ = rand.Read(b)
+			id = hex.EncodeToString(b)
+			c.Request.Header.Set(RequestIDHeader, id)
+		}
+		c.Header(RequestIDHeader, id)
+		c.Next()
+	}
+}
+
+// IsAdmin reports whether the request carries an admin token, for public endpoints that show more to admins
+func IsAdmin(c *gin.Context) bool {
+	_, ok := AdminName(c)
+	return ok
+}
+
+// AdminName returns the name of the admin credential the request carries.
+// ADMIN_TOKEN is named DefaultAdminName, ADMIN_TOKENS adds named tokens as "name:token,name:token".
+func AdminName(c *gin.Context) (string, bool) {
+	token := c.GetHeader("x-admin-token")
+	if token == "" {
+		return "", false
+	}
+	if token == os.Getenv("ADMIN_TOKEN") {
+		return DefaultAdminName, true
+	}
+	for _, credential := range strings.Split(os.Getenv("ADMIN_TOKENS"), ",") {
+		name, value, ok := strings.Cut(strings.TrimSpace(credential), ":")
+		if ok && name != "" && value != "" && value == token {
+			return name, true
+		}
+	}
+	return "", false
+}
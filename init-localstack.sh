@@ -30,9 +30,57 @@ awslocal dynamodb create-table \
   --key-schema AttributeName=PK,KeyType=HASH \
   --billing-mode PAY_PER_REQUEST
 
-# Create Votes table (PK = code, SK = category#team)
+# Create Votes table (PK = code, SK = category#team), indexed by team and by category
 awslocal dynamodb create-table \
   --table-name Votes \
+  --attribute-definitions AttributeName=PK,AttributeType=S AttributeName=SK,AttributeType=S \
+    AttributeName=TeamID,AttributeType=N AttributeName=CategoryID,AttributeType=N \
+  --key-schema AttributeName=PK,KeyType=HASH AttributeName=SK,KeyType=RANGE \
+  --global-secondary-indexes \
+    'IndexName=TeamIndex,KeySchema=[{AttributeName=TeamID,KeyType=HASH}],Projection={ProjectionType=ALL}' \
+    'IndexName=CategoryIndex,KeySchema=[{AttributeName=CategoryID,KeyType=HASH}],Projection={ProjectionType=ALL}' \
+  --billing-mode PAY_PER_REQUEST
+
+# Create VoteTallies table (PK = team#..#cat#..#voter group), used when storage.results is tallies
+awslocal dynamodb create-table \
+  --table-name VoteTallies \
+  --attribute-definitions AttributeName=PK,AttributeType=S \
+  --key-schema AttributeName=PK,KeyType=HASH \
+  --billing-mode PAY_PER_REQUEST
+
+# Create VotingAudit table (PK = "audit", SK = time#random), the append-only log of admin changes
+awslocal dynamodb create-table \
+  --table-name VotingAudit \
+  --attribute-definitions AttributeName=PK,AttributeType=S AttributeName=SK,AttributeType=S \
+  --key-schema AttributeName=PK,KeyType=HASH AttributeName=SK,KeyType=RANGE \
+  --billing-mode PAY_PER_REQUEST
+
+# Create VotingLedger table (PK = "ledger", SK = time#random), the vote events replayed into votes and results
+awslocal dynamodb create-table \
+  --table-name VotingLedger \
+  --attribute-definitions AttributeName=PK,AttributeType=S AttributeName=SK,AttributeType=S \
+  --key-schema AttributeName=PK,KeyType=HASH AttributeName=SK,KeyType=RANGE \
+  --billing-mode PAY_PER_REQUEST
+
+# Create VotingMigrations table (PK = migrated table name, SK = migration version)
+awslocal dynamodb create-table \
+  --table-name VotingMigrations \
+  --attribute-definitions AttributeName=PK,AttributeType=S AttributeName=SK,AttributeType=N \
+  --key-schema AttributeName=PK,KeyType=HASH AttributeName=SK,KeyType=RANGE \
+  --billing-mode PAY_PER_REQUEST
+
+# Create VotingEvents table (PK = event ID). The tables of an event other than the default one
+# are suffixed with -<eventId> and created with the event when storage.createTables is set
+awslocal dynamodb create-table \
+  --table-name VotingEvents \
+  --attribute-definitions AttributeName=PK,AttributeType=S \
+  --key-schema AttributeName=PK,KeyType=HASH \
+  --billing-mode PAY_PER_REQUEST
+
+# Create Voting table (PK = EVENT#<eventId>, SK = CODE#, VOTE#, TEAM# or CATEGORY# prefixed key),
+# holding every event when storage.tableLayout is single
+awslocal dynamodb create-table \
+  --table-name Voting \
   --attribute-definitions AttributeName=PK,AttributeType=S AttributeName=SK,AttributeType=S \
   --key-schema AttributeName=PK,KeyType=HASH AttributeName=SK,KeyType=RANGE \
   --billing-mode PAY_PER_REQUEST
@@ -13,6 +13,7 @@ import (
 	"github.com/alex-pricope/simple-voting-system/api"
 	"github.com/alex-pricope/simple-voting-system/logging"
 	"github.com/spf13/viper"
+	"strings"
 )
 
 func main() {
@@ -22,6 +23,8 @@ func main() {
 	viper.SetConfigName("config")
 	viper.SetConfigType("yaml")
 	viper.AddConfigPath("./")
+	// Nested keys can be set from env, e.g. STORAGE_ENDPOINT for storage.endpoint
+	viper.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
 	viper.AutomaticEnv()
 
 	if err := viper.ReadInConfig(); err != nil {
@@ -0,0 +1,138 @@
+package storage
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"github.com/alex-pricope/simple-voting-system/logging"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"strings"
+	"time"
+)
+
+// auditPartition is the PK of every audit item. Admin changes are rare enough for a single partition,
+// which keeps the entries of a time range in one sorted Query.
+const auditPartition = "audit"
+
+// auditIDTimeFormat has a fixed width, so IDs sort in time order
+const auditIDTimeFormat = "20060102T150405.000000000Z"
+
+// AuditStorage is the append-only log of admin changes. There is no way to change or delete an entry.
+type AuditStorage interface {
+	Append(ctx context.Context, entry *AuditEntry) error
+	// Query returns the entries matching filter, oldest first
+	Query(ctx context.Context, filter AuditFilter) ([]*AuditEntry, error)
+}
+
+// AuditFilter selects audit entries. Zero fields match everything.
+type AuditFilter struct {
+	From      time.Time // Inclusive
+	To        time.Time // Exclusive
+	Actor     string
+	Method    string
+	Path      string // Substring of the requested path
+	RequestID string
+}
+
+// Matches reports whether entry passes every set field of the filter
+func (f AuditFilter) Matches(entry *AuditEntry) bool {
+	switch {
+	case !f.From.IsZero() && entry.Timestamp.Before(f.From):
+		return false
+	case !f.To.IsZero() && !entry.Timestamp.Before(f.To):
+		return false
+	case f.Actor != "" && entry.Actor != f.Actor:
+		return false
+	case f.Method != "" && !strings.EqualFold(entry.Method, f.Method):
+		return false
+	case f.Path != "" && !strings.Contains(entry.Path, f.Path):
+		return false
+	case f.RequestID != "" && entry.RequestID != f.RequestID:
+		return false
+	}
+	return true
+}
+
+// IDRange returns the range of entry IDs the time range of the filter can match, for backends
+// that narrow the lookup by ID before calling Matches
+func (f AuditFilter) IDRange() (from, to string) {
+	return idRange(f.From, f.To)
+}
+
+// idRange returns the range of NewAuditID IDs from the time from up to the time to, zero times leave it open
+func idRange(fromTime, toTime time.Time) (from, to string) {
+	from, to = "0", "~"
+	if !fromTime.IsZero() {
+		from = fromTime.UTC().Format(auditIDTimeFormat)
+	}
+	if !toTime.IsZero() {
+		to = toTime.UTC().Format(auditIDTimeFormat)
+	}
+	return from, to
+}
+
+// NewAuditID returns a unique entry ID starting with the time t
+func NewAuditID(t time.Time) string {
+	suffix := make([]byte, 4)
+	_, _ = rand.Read(suffix)
+	return t.UTC().Format(auditIDTimeFormat) + "#" + hex.EncodeToString(suffix)
+}
+
+type DynamoAuditStorage struct {
+	Client    *dynamodb.Client
+	TableName string
+}
+
+func (s *DynamoAuditStorage) Append(ctx context.Context, entry *AuditEntry) error {
+	item, err := attributevalue.MarshalMap(entry)
+	if err != nil {
+		logging.Log.Errorf("AUDIT: failed to marshal audit entry: %v", err)
+		return err
+	}
+	item["PK"] = &types.AttributeValueMemberS{Value: auditPartition}
+
+	_, err = s.Client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName:           &s.TableName,
+		Item:                item,
+		ConditionExpression: aws.String("attribute_not_exists(SK)"),
+	})
+	if err != nil {
+		logging.Log.Errorf("AUDIT: failed to append entry %s: %v", entry.ID, err)
+		return classify(err)
+	}
+	return nil
+}
+
+// Query reads the time range of the filter from the audit partition and applies the other fields in memory
+func (s *DynamoAuditStorage) Query(ctx context.Context, filter AuditFilter) ([]*AuditEntry, error) {
+	from, to := filter.IDRange()
+	items, err := queryAll(ctx, s.Client, &dynamodb.QueryInput{
+		TableName:              &s.TableName,
+		KeyConditionExpression: aws.String("PK = :pk AND SK BETWEEN :from AND :to"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":pk":   &types.AttributeValueMemberS{Value: auditPartition},
+			":from": &types.AttributeValueMemberS{Value: from},
+			":to":   &types.AttributeValueMemberS{Value: to},
+		},
+	})
+	if err != nil {
+		logging.Log.Errorf("AUDIT: query failed: %v", err)
+		return nil, classify(err)
+	}
+
+	var entries []*AuditEntry
+	if err := attributevalue.UnmarshalListOfMaps(items, &entries); err != nil {
+		logging.Log.Errorf("AUDIT: failed to unmarshal audit entries: %v", err)
+		return nil, err
+	}
+	matching := entries[:0]
+	for _, entry := range entries {
+		if filter.Matches(entry) {
+			matching = append(matching, entry)
+		}
+	}
+	return matching, nil
+}
@@ -0,0 +1,35 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"github.com/alex-pricope/simple-voting-system/logging"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// BackupStorage keeps backup archives outside the tables they were taken from
+type BackupStorage interface {
+	// Put writes the archive under key and returns where it was written
+	Put(ctx context.Context, key string, archive []byte) (string, error)
+}
+
+// S3BackupStorage writes backup archives as objects of an S3 bucket
+type S3BackupStorage struct {
+	Client *s3.Client
+	Bucket string
+}
+
+func (s *S3BackupStorage) Put(ctx context.Context, key string, archive []byte) (string, error) {
+	_, err := s.Client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      &s.Bucket,
+		Key:         &key,
+		Body:        bytes.NewReader(archive),
+		ContentType: aws.String("application/json"),
+	})
+	if err != nil {
+		logging.Log.Errorf("BACKUP: failed to write %s to bucket %s: %v", key, s.Bucket, err)
+		return "", classify(err)
+	}
+	return "s3://" + s.Bucket + "/" + key, nil
+}
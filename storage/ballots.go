@@ -0,0 +1,193 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/alex-pricope/simple-voting-system/logging"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// maxTransactItems is the DynamoDB limit of items in a single TransactWriteItems call.
+const maxTransactItems = 100
+
+// ErrRollbackFailed is returned with the error of a ballot written in chunks when taking back
+// its written votes failed too. The code stays used, with part of its votes stored.
+var ErrRollbackFailed = errors.New("rollback of a partly written ballot failed")
+
+// BallotStorage stores a complete ballot and consumes its voting code in one step,
+// so a code can never end up used with a partial ballot, or unused with votes written.
+type BallotStorage interface {
+	// Submit writes all votes for code and marks the code as used.
+	// It returns ErrCodeAlreadyUsed when the code does not exist or was already used,
+	// and ErrSubmissionConflict when another submission for the same code is in flight.
+	Submit(ctx context.Context, code string, votes []*Vote) error
+}
+
+type DynamoBallotStorage struct {
+	Client         *dynamodb.Client
+	CodesTableName string
+	VotesTableName string
+	// SingleItem writes the ballot as one Ballot item, matching DynamoBallotVoteStorage
+	SingleItem bool
+}
+
+func (s *DynamoBallotStorage) Submit(ctx context.Context, code string, votes []*Vote) error {
+	claim := types.TransactWriteItem{
+		Update: &types.Update{
+			TableName: aws.String(s.CodesTableName),
+			Key: map[string]types.AttributeValue{
+				"PK": &types.AttributeValueMemberS{Value: code},
+			},
+			UpdateExpression:    aws.String("SET Used = :used"),
+			ConditionExpression: aws.String("attribute_exists(PK) AND Used = :unused"),
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":used":   &types.AttributeValueMemberBOOL{Value: true},
+				":unused": &types.AttributeValueMemberBOOL{Value: false},
+			},
+		},
+	}
+
+	if s.SingleItem {
+		item, err := attributevalue.MarshalMap(NewBallot(code, votes))
+		if err != nil {
+			logging.Log.Errorf("BALLOT: failed to marshal ballot: %v", err)
+			return err
+		}
+		return transactBallot(ctx, s.Client, []types.TransactWriteItem{claim, {
+			Put: &types.Put{
+				TableName:           aws.String(s.VotesTableName),
+				Item:                item,
+				ConditionExpression: aws.String("attribute_not_exists(PK)"),
+			},
+		}})
+	}
+
+	puts := make([]types.TransactWriteItem, 0, len(votes))
+	for _, vote := range votes {
+		item, err := attributevalue.MarshalMap(vote)
+		if err != nil {
+			logging.Log.Errorf("BALLOT: failed to marshal vote: %v", err)
+			return err
+		}
+		puts = append(puts, types.TransactWriteItem{
+			Put: &types.Put{
+				TableName:           aws.String(s.VotesTableName),
+				Item:                item,
+				ConditionExpression: aws.String("attribute_not_exists(PK) AND attribute_not_exists(SK)"),
+			},
+		})
+	}
+
+	codes := &DynamoVotingCodesStorage{Client: s.Client, TableName: s.CodesTableName}
+	return submitBallot(ctx, s.Client, code, claim, puts, codes.MarkUnused)
+}
+
+// submitBallot writes the votes of a ballot together with the claim of its code, in one transaction when they fit.
+// release gives the code back when a ballot written in chunks has to be rolled back.
+func submitBallot(ctx context.Context, client *dynamodb.Client, code string, claim types.TransactWriteItem, puts []types.TransactWriteItem,
+	release func(ctx context.Context, code string) error) error {
+	if len(puts) < maxTransactItems {
+		return transactBallot(ctx, client, append([]types.TransactWriteItem{claim}, puts...))
+	}
+	return submitChunked(ctx, client, code, claim, puts, release)
+}
+
+// submitChunked handles ballots that do not fit in one transaction.
+// The first transaction claims the code together with the first votes, so a concurrent
+// submission still loses up front. If a later chunk fails, the written votes are deleted
+// and the code is released again. If some of them cannot be deleted the code is kept used,
+// so nobody votes on top of them, and ErrRollbackFailed is returned as well.
+func submitChunked(ctx context.Context, client *dynamodb.Client, code string, claim types.TransactWriteItem, puts []types.TransactWriteItem,
+	release func(ctx context.Context, code string) error) error {
+	first := maxTransactItems - 1
+	if err := transactBallot(ctx, client, append([]types.TransactWriteItem{claim}, puts[:first]...)); err != nil {
+		return classify(err)
+	}
+
+	for i := first; i < len(puts); i += maxTransactItems {
+		end := min(i+maxTransactItems, len(puts))
+		if err := transactBallot(ctx, client, puts[i:end]); err != nil {
+			logging.Log.Errorf("BALLOT: chunk %d-%d for code %s failed, rolling back: %v", i, end, code, err)
+			if rollbackErr := rollbackBallot(context.WithoutCancel(ctx), client, code, puts[:i], release); rollbackErr != nil {
+				return fmt.Errorf("%w: %w", classify(err), rollbackErr)
+			}
+			return classify(err)
+		}
+	}
+	logging.Log.Infof("BALLOT: stored %d votes for code %s in chunks", len(puts), code)
+	return nil
+}
+
+// rollbackBallot deletes the written votes of a ballot and then releases its code.
+// The code is only released once every vote is deleted.
+func rollbackBallot(ctx context.Context, client *dynamodb.Client, code string, written []types.TransactWriteItem,
+	release func(ctx context.Context, code string) error) error {
+	deletes := make([]types.TransactWriteItem, 0, len(written))
+	for _, w := range written {
+		deletes = append(deletes, types.TransactWriteItem{
+			Delete: &types.Delete{
+				TableName: w.Put.TableName,
+				Key: map[string]types.AttributeValue{
+					"PK": w.Put.Item["PK"],
+					"SK": w.Put.Item["SK"],
+				},
+			},
+		})
+	}
+	var left []string
+	var deleteErr error
+	for i := 0; i < len(deletes); i += maxTransactItems {
+		end := min(i+maxTransactItems, len(deletes))
+		if err := transactWithRetry(ctx, client, &dynamodb.TransactWriteItemsInput{TransactItems: deletes[i:end]}); err != nil {
+			deleteErr = err
+			for _, d := range deletes[i:end] {
+				if sk, ok := d.Delete.Key["SK"].(*types.AttributeValueMemberS); ok {
+					left = append(left, sk.Value)
+				}
+			}
+		}
+	}
+	if deleteErr != nil {
+		logging.Log.Errorf("BALLOT: ROLLBACK INCOMPLETE, code %s stays used with %d partial votes %v stored, repair it with "+
+			"POST /api/admin/codes/%s/invalidate and then reset the code: %v", code, len(left), left, code, deleteErr)
+		return fmt.Errorf("%w: %d votes of code %s left: %w", ErrRollbackFailed, len(left), code, classify(deleteErr))
+	}
+
+	if err := release(ctx, code); err != nil {
+		logging.Log.Errorf("BALLOT: rollback failed to release code %s: %v", code, err)
+		return fmt.Errorf("%w: code %s not released: %w", ErrRollbackFailed, code, classify(err))
+	}
+	return nil
+}
+
+// transactBallot writes one transaction of a ballot. The claim of the code is the only Update in it,
+// so a failed condition on an Update means the code was used, and on a Put that a vote exists.
+func transactBallot(ctx context.Context, client *dynamodb.Client, items []types.TransactWriteItem) error {
+	err := transactWithRetry(ctx, client, &dynamodb.TransactWriteItemsInput{
+		TransactItems: items,
+	})
+	if err == nil {
+		return nil
+	}
+
+	var tce *types.TransactionCanceledException
+	if errors.As(err, &tce) {
+		for i, reason := range tce.CancellationReasons {
+			switch aws.ToString(reason.Code) {
+			case "ConditionalCheckFailed":
+				if items[i].Update != nil {
+					return ErrCodeAlreadyUsed
+				}
+				return ErrAlreadyExists
+			case "TransactionConflict":
+				return ErrSubmissionConflict
+			}
+		}
+	}
+	logging.Log.Errorf("BALLOT: transaction failed: %v", err)
+	return classify(err)
+}
@@ -0,0 +1,54 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestSubmitChunkedRollback(t *testing.T) {
+	cancelled := `!{"__type":"com.amazonaws.dynamodb.v20120810#TransactionCanceledException","message":"Transaction cancelled",` +
+		`"CancellationReasons":[{"Code":"ConditionalCheckFailed"}]}`
+	claim := types.TransactWriteItem{Update: &types.Update{
+		TableName:        aws.String("VotingCodes"),
+		Key:              map[string]types.AttributeValue{"PK": &types.AttributeValueMemberS{Value: "ABCDE"}},
+		UpdateExpression: aws.String("SET Used = :used"),
+	}}
+	puts := make([]types.TransactWriteItem, 0, 150)
+	for i := range 150 {
+		puts = append(puts, types.TransactWriteItem{Put: &types.Put{
+			TableName: aws.String("Votes"),
+			Item: map[string]types.AttributeValue{
+				"PK": &types.AttributeValueMemberS{Value: "ABCDE"},
+				"SK": &types.AttributeValueMemberS{Value: fmt.Sprintf("cat#1#team#%d", i)},
+			},
+		}})
+	}
+
+	t.Run("The code is released once the written votes are deleted", func(t *testing.T) {
+		client, calls := fakeDynamo(t, `{}`, cancelled, `{}`)
+		released := false
+		release := func(context.Context, string) error { released = true; return nil }
+
+		err := submitChunked(context.TODO(), client, "ABCDE", claim, puts, release)
+		assert.Error(t, err)
+		assert.NotErrorIs(t, err, ErrRollbackFailed)
+		assert.True(t, released)
+		assert.Equal(t, 3, *calls)
+	})
+
+	t.Run("A failed delete keeps the code used and is returned", func(t *testing.T) {
+		client, calls := fakeDynamo(t, `{}`, cancelled, cancelled)
+		released := false
+		release := func(context.Context, string) error { released = true; return nil }
+
+		err := submitChunked(context.TODO(), client, "ABCDE", claim, puts, release)
+		assert.ErrorIs(t, err, ErrRollbackFailed)
+		assert.ErrorContains(t, err, "99 votes of code ABCDE left")
+		assert.False(t, released, "nobody may vote on top of the votes left")
+		assert.Equal(t, 3, *calls)
+	})
+}
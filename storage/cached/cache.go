@@ -0,0 +1,97 @@
+// Package cached wraps rarely changing storages with a read-through in-process cache.
+// On Lambda the cache lives as long as the warm container, so the TTL bounds how long
+// another instance may serve data older than a write it did not see.
+package cached
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Flusher is implemented by every cache in this package
+type Flusher interface {
+	Flush()
+}
+
+// Flushers flushes a set of caches that can grow while it is in use, such as the caches of each event
+type Flushers struct {
+	mu     sync.Mutex
+	caches []Flusher
+}
+
+func (f *Flushers) Add(caches ...Flusher) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.caches = append(f.caches, caches...)
+}
+
+func (f *Flushers) Flush() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, cache := range f.caches {
+		cache.Flush()
+	}
+}
+
+// listCache holds the result of a GetAll call for ttl
+type listCache[T any] struct {
+	ttl   time.Duration
+	clone func(T) T
+
+	mu       sync.Mutex
+	items    []T
+	loaded   bool
+	loadedAt time.Time
+	// generation is bumped by every flush, so a load that raced with a write is not stored
+	generation uint64
+}
+
+func newListCache[T any](ttl time.Duration, clone func(T) T) *listCache[T] {
+	return &listCache[T]{ttl: ttl, clone: clone}
+}
+
+// get returns copies of the cached items, calling load on a miss or after the ttl
+func (c *listCache[T]) get(ctx context.Context, load func(context.Context) ([]T, error)) ([]T, error) {
+	c.mu.Lock()
+	if c.loaded && time.Since(c.loadedAt) < c.ttl {
+		items := c.copyItems(c.items)
+		c.mu.Unlock()
+		return items, nil
+	}
+	generation := c.generation
+	c.mu.Unlock()
+
+	items, err := load(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	if generation == c.generation {
+		c.items = c.copyItems(items)
+		c.loaded = true
+		c.loadedAt = time.Now()
+	}
+	c.mu.Unlock()
+	return items, nil
+}
+
+func (c *listCache[T]) flush() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.items = nil
+	c.loaded = false
+	c.generation++
+}
+
+func (c *listCache[T]) copyItems(items []T) []T {
+	if items == nil {
+		return nil
+	}
+	copied := make([]T, len(items))
+	for i, item := range items {
+		copied[i] = c.clone(item)
+	}
+	return copied
+}
@@ -0,0 +1,104 @@
+package cached
+
+import (
+	"context"
+	"github.com/alex-pricope/simple-voting-system/logging"
+	"github.com/alex-pricope/simple-voting-system/storage"
+	"github.com/alex-pricope/simple-voting-system/storage/instrumented"
+	"github.com/alex-pricope/simple-voting-system/storage/memory"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+// getAllCalls returns how often GetAll reached the wrapped storage
+func getAllCalls(recorder *instrumented.Recorder) int64 {
+	for _, op := range recorder.Snapshot() {
+		if op.Method == "GetAll" {
+			return op.Calls
+		}
+	}
+	return 0
+}
+
+func TestTeamStorageCachesGetAll(t *testing.T) {
+	logging.Log = logrus.New()
+	ctx := context.TODO()
+	recorder := instrumented.NewRecorder(0)
+	s := NewTeamStorage(instrumented.NewTeamStorage(memory.NewTeamStorage(), "VotingTeams", recorder), time.Minute)
+
+	require.NoError(t, s.Create(ctx, &storage.Team{ID: 1, Name: "Alpha", Members: []string{"Alice"}}))
+
+	teams, err := s.GetAll(ctx)
+	require.NoError(t, err)
+	require.Len(t, teams, 1)
+	_, err = s.GetAll(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), getAllCalls(recorder), "second GetAll is served from the cache")
+
+	t.Run("Cached items do not alias the cache", func(t *testing.T) {
+		teams, err := s.GetAll(ctx)
+		require.NoError(t, err)
+		teams[0].Name = "Changed"
+		teams[0].Members[0] = "Mallory"
+
+		teams, err = s.GetAll(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, "Alpha", teams[0].Name)
+		assert.Equal(t, []string{"Alice"}, teams[0].Members)
+	})
+
+	t.Run("Writes flush the cache", func(t *testing.T) {
+		require.NoError(t, s.Create(ctx, &storage.Team{ID: 2, Name: "Beta"}))
+		teams, err := s.GetAll(ctx)
+		require.NoError(t, err)
+		assert.Len(t, teams, 2)
+	})
+
+	t.Run("Flush forces a reload", func(t *testing.T) {
+		before := getAllCalls(recorder)
+		s.Flush()
+		_, err := s.GetAll(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, before+1, getAllCalls(recorder))
+	})
+}
+
+// storedTeams returns the same teams from every GetAll
+type storedTeams struct {
+	storage.TeamStorage
+	teams []*storage.Team
+}
+
+func (s *storedTeams) GetAll(context.Context) ([]*storage.Team, error) {
+	return s.teams, nil
+}
+
+func TestTeamStorageKeepsEmptyMembers(t *testing.T) {
+	s := NewTeamStorage(&storedTeams{teams: []*storage.Team{{ID: 1, Members: []string{}}, {ID: 2}}}, time.Minute)
+
+	for i := 0; i < 2; i++ {
+		teams, err := s.GetAll(context.TODO())
+		require.NoError(t, err)
+		require.Len(t, teams, 2)
+		assert.NotNil(t, teams[0].Members, "an empty list is not turned into null")
+		assert.Nil(t, teams[1].Members)
+	}
+}
+
+func TestVotingCategoryStorageExpires(t *testing.T) {
+	logging.Log = logrus.New()
+	ctx := context.TODO()
+	recorder := instrumented.NewRecorder(0)
+	s := NewVotingCategoryStorage(instrumented.NewVotingCategoryStorage(memory.NewVotingCategoryStorage(), "VotingCategories", recorder), time.Millisecond)
+
+	require.NoError(t, s.Create(ctx, &storage.VotingCategory{ID: 1, Name: "Jury"}))
+	_, err := s.GetAll(ctx)
+	require.NoError(t, err)
+	time.Sleep(2 * time.Millisecond)
+	_, err = s.GetAll(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), getAllCalls(recorder))
+}
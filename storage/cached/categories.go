@@ -0,0 +1,51 @@
+package cached
+
+import (
+	"context"
+	"github.com/alex-pricope/simple-voting-system/storage"
+	"time"
+)
+
+// VotingCategoryStorage caches GetAll of the wrapped storage.VotingCategoryStorage. Get is not cached,
+// so an update always starts from the stored version. Writes flush the cache.
+type VotingCategoryStorage struct {
+	next storage.VotingCategoryStorage
+	all  *listCache[*storage.VotingCategory]
+}
+
+func NewVotingCategoryStorage(next storage.VotingCategoryStorage, ttl time.Duration) *VotingCategoryStorage {
+	return &VotingCategoryStorage{
+		next: next,
+		all: newListCache(ttl, func(c *storage.VotingCategory) *storage.VotingCategory {
+			category := *c
+			return &category
+		}),
+	}
+}
+
+func (s *VotingCategoryStorage) Get(ctx context.Context, id int) (*storage.VotingCategory, error) {
+	return s.next.Get(ctx, id)
+}
+
+func (s *VotingCategoryStorage) GetAll(ctx context.Context) ([]*storage.VotingCategory, error) {
+	return s.all.get(ctx, s.next.GetAll)
+}
+
+func (s *VotingCategoryStorage) Create(ctx context.Context, category *storage.VotingCategory) error {
+	defer s.Flush()
+	return s.next.Create(ctx, category)
+}
+
+func (s *VotingCategoryStorage) Update(ctx context.Context, category *storage.VotingCategory) error {
+	defer s.Flush()
+	return s.next.Update(ctx, category)
+}
+
+func (s *VotingCategoryStorage) Delete(ctx context.Context, id int) error {
+	defer s.Flush()
+	return s.next.Delete(ctx, id)
+}
+
+func (s *VotingCategoryStorage) Flush() {
+	s.all.flush()
+}
@@ -0,0 +1,54 @@
+package cached
+
+import (
+	"context"
+	"github.com/alex-pricope/simple-voting-system/storage"
+	"slices"
+	"time"
+)
+
+// TeamStorage caches GetAll of the wrapped storage.TeamStorage. Get is not cached,
+// so an update always starts from the stored version. Writes flush the cache.
+type TeamStorage struct {
+	next storage.TeamStorage
+	all  *listCache[*storage.Team]
+}
+
+func NewTeamStorage(next storage.TeamStorage, ttl time.Duration) *TeamStorage {
+	return &TeamStorage{
+		next: next,
+		all: newListCache(ttl, func(t *storage.Team) *storage.Team {
+			team := *t
+			// Clone keeps an empty list empty instead of nil, so it is still encoded as []
+			team.Members = slices.Clone(t.Members)
+			return &team
+		}),
+	}
+}
+
+func (s *TeamStorage) Get(ctx context.Context, id int) (*storage.Team, error) {
+	return s.next.Get(ctx, id)
+}
+
+func (s *TeamStorage) GetAll(ctx context.Context) ([]*storage.Team, error) {
+	return s.all.get(ctx, s.next.GetAll)
+}
+
+func (s *TeamStorage) Create(ctx context.Context, team *storage.Team) error {
+	defer s.Flush()
+	return s.next.Create(ctx, team)
+}
+
+func (s *TeamStorage) Update(ctx context.Context, team *storage.Team) error {
+	defer s.Flush()
+	return s.next.Update(ctx, team)
+}
+
+func (s *TeamStorage) Delete(ctx context.Context, id int) error {
+	defer s.Flush()
+	return s.next.Delete(ctx, id)
+}
+
+func (s *TeamStorage) Flush() {
+	s.all.flush()
+}
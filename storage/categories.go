@@ -14,6 +14,8 @@ type VotingCategoryStorage interface {
 	Get(ctx context.Context, id int) (*VotingCategory, error)
 	GetAll(ctx context.Context) ([]*VotingCategory, error)
 	Create(ctx context.Context, category *VotingCategory) error
+	// Update replaces an existing category if its Version still equals category.Version, and then bumps category.Version.
+	// It returns ErrNotFound for an unknown ID and ErrVersionConflict when the category was changed meanwhile.
 	Update(ctx context.Context, category *VotingCategory) error
 	Delete(ctx context.Context, id int) error
 }
@@ -36,11 +38,11 @@ func (s *DynamoVotingCategoryStorage) Get(ctx context.Context, id int) (*VotingC
 	})
 	if err != nil {
 		logging.Log.Errorf("CATEGORY: GetItem for ID %d failed: %v", id, err)
-		return nil, err
+		return nil, classify(err)
 	}
 	if out.Item == nil {
 		logging.Log.Warnf("CATEGORY: no category found with ID %d", id)
-		return nil, nil
+		return nil, ErrNotFound
 	}
 
 	var category VotingCategory
@@ -52,16 +54,16 @@ func (s *DynamoVotingCategoryStorage) Get(ctx context.Context, id int) (*VotingC
 }
 
 func (s *DynamoVotingCategoryStorage) GetAll(ctx context.Context) ([]*VotingCategory, error) {
-	out, err := s.Client.Scan(ctx, &dynamodb.ScanInput{
+	items, err := scanAll(ctx, s.Client, &dynamodb.ScanInput{
 		TableName: &s.TableName,
 	})
 	if err != nil {
 		logging.Log.Errorf("CATEGORY: scan failed: %v", err)
-		return nil, err
+		return nil, classify(err)
 	}
 
 	var categories []*VotingCategory
-	if err := attributevalue.UnmarshalListOfMaps(out.Items, &categories); err != nil {
+	if err := attributevalue.UnmarshalListOfMaps(items, &categories); err != nil {
 		logging.Log.Errorf("CATEGORY: failed to unmarshal list: %v", err)
 		return nil, err
 	}
@@ -69,6 +71,7 @@ func (s *DynamoVotingCategoryStorage) GetAll(ctx context.Context) ([]*VotingCate
 }
 
 func (s *DynamoVotingCategoryStorage) Create(ctx context.Context, category *VotingCategory) error {
+	category.Version = 1
 	item, err := attributevalue.MarshalMap(category)
 	if err != nil {
 		logging.Log.Errorf("CATEGORY: failed to marshal category: %v", err)
@@ -84,29 +87,32 @@ func (s *DynamoVotingCategoryStorage) Create(ctx context.Context, category *Voti
 		var cce *types.ConditionalCheckFailedException
 		if errors.As(err, &cce) {
 			logging.Log.Warnf("CATEGORY: item with ID %d already exists", category.ID)
-			return ErrItemWithIDAlreadyExists
+			return ErrAlreadyExists
 		}
 		logging.Log.Errorf("CATEGORY: failed to create category: %v", err)
-		return err
+		return classify(err)
 	}
 	return nil
 }
 
 func (s *DynamoVotingCategoryStorage) Update(ctx context.Context, category *VotingCategory) error {
-	item, err := attributevalue.MarshalMap(category)
+	updated := *category
+	updated.Version++
+	item, err := attributevalue.MarshalMap(updated)
 	if err != nil {
 		logging.Log.Errorf("CATEGORY: failed to marshal updated category: %v", err)
 		return err
 	}
 
-	_, err = s.Client.PutItem(ctx, &dynamodb.PutItemInput{
-		TableName: &s.TableName,
-		Item:      item,
-	})
-	if err != nil {
+	if err := putVersioned(ctx, s.Client, s.TableName, item, category.Version); err != nil {
+		if errors.Is(err, ErrNotFound) || errors.Is(err, ErrVersionConflict) {
+			logging.Log.Warnf("CATEGORY: update of ID %d at version %d rejected: %v", category.ID, category.Version, err)
+			return err
+		}
 		logging.Log.Errorf("CATEGORY: failed to update category: %v", err)
-		return err
+		return classify(err)
 	}
+	category.Version = updated.Version
 	return nil
 }
 
@@ -123,7 +129,7 @@ func (s *DynamoVotingCategoryStorage) Delete(ctx context.Context, id int) error
 	})
 	if err != nil {
 		logging.Log.Errorf("CATEGORY: failed to delete category with ID %d: %v", id, err)
-		return err
+		return classify(err)
 	}
 	logging.Log.Infof("CATEGORY: deleted category with ID %d", id)
 	return nil
@@ -2,6 +2,7 @@ package storage
 
 import (
 	"context"
+	"errors"
 	"github.com/alex-pricope/simple-voting-system/logging"
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
@@ -13,9 +14,19 @@ import (
 type VotingCodeStorage interface {
 	Get(ctx context.Context, code string) (*VotingCode, error)
 	GetAll(ctx context.Context) ([]*VotingCode, error)
+	// GetPage returns up to limit codes starting after the next token, and the token for the following page.
+	// An empty returned token means there are no more pages.
+	GetPage(ctx context.Context, limit int, next string) ([]*VotingCode, string, error)
 	Put(ctx context.Context, votingCode *VotingCode) error
+	// PutBatch stores every code that does not exist yet and returns the stored ones, the others collided
+	// with an existing code. The codes must be unique within the batch. On error the codes stored before it
+	// are still returned, the codes of the failed write may or may not have been stored.
+	PutBatch(ctx context.Context, codes []*VotingCode) ([]*VotingCode, error)
 	MarkUnused(ctx context.Context, code string) error
 	MarkUsed(ctx context.Context, code string) error
+	// SetExpiry changes only the expiry of an existing code, a nil expiresAt removes it.
+	// Returns ErrNotFound when the code does not exist.
+	SetExpiry(ctx context.Context, code string, expiresAt *time.Time) error
 	Delete(ctx context.Context, code string) error
 	Overwrite(ctx context.Context, code *VotingCode) error
 }
@@ -29,7 +40,7 @@ func (s *DynamoVotingCodesStorage) Get(ctx context.Context, code string) (*Votin
 	key, err := attributevalue.MarshalMap(map[string]string{"PK": code})
 	if err != nil {
 		logging.Log.Errorf("failed to marshal key: %v", err)
-		return &VotingCode{}, err
+		return nil, err
 	}
 
 	out, err := s.Client.GetItem(ctx, &dynamodb.GetItemInput{
@@ -38,37 +49,71 @@ func (s *DynamoVotingCodesStorage) Get(ctx context.Context, code string) (*Votin
 	})
 	if err != nil {
 		logging.Log.Errorf("GET storage failed: %v", err)
-		return &VotingCode{}, err
+		return nil, classify(err)
 	}
 	if out.Item == nil {
-		return nil, ErrCodeNotFound
+		return nil, ErrNotFound
 	}
 
 	var vc *VotingCode
 	if err := attributevalue.UnmarshalMap(out.Item, &vc); err != nil {
 		logging.Log.Errorf("failed to unmarshal result: %v", err)
-		return &VotingCode{}, err
+		return nil, err
 	}
 	return vc, nil
 }
 
 func (s *DynamoVotingCodesStorage) GetAll(ctx context.Context) ([]*VotingCode, error) {
-	out, err := s.Client.Scan(ctx, &dynamodb.ScanInput{
+	items, err := scanAll(ctx, s.Client, &dynamodb.ScanInput{
 		TableName: &s.TableName,
 	})
 	if err != nil {
 		logging.Log.Errorf("SCAN storage failed: %v", err)
-		return nil, err
+		return nil, classify(err)
 	}
 
 	var codes []*VotingCode
-	if err := attributevalue.UnmarshalListOfMaps(out.Items, &codes); err != nil {
+	if err := attributevalue.UnmarshalListOfMaps(items, &codes); err != nil {
 		logging.Log.Errorf("failed to unmarshal list: %v", err)
 		return nil, err
 	}
 	return codes, nil
 }
 
+func (s *DynamoVotingCodesStorage) GetPage(ctx context.Context, limit int, next string) ([]*VotingCode, string, error) {
+	input := &dynamodb.ScanInput{
+		TableName: &s.TableName,
+		Limit:     aws.Int32(int32(limit)),
+	}
+	if next != "" {
+		code, err := DecodePageToken(next)
+		if err != nil {
+			return nil, "", classify(err)
+		}
+		input.ExclusiveStartKey = map[string]types.AttributeValue{
+			"PK": &types.AttributeValueMemberS{Value: code},
+		}
+	}
+
+	out, err := s.Client.Scan(ctx, input)
+	if err != nil {
+		logging.Log.Errorf("SCAN page storage failed: %v", err)
+		return nil, "", classify(err)
+	}
+
+	var codes []*VotingCode
+	if err := attributevalue.UnmarshalListOfMaps(out.Items, &codes); err != nil {
+		logging.Log.Errorf("failed to unmarshal list: %v", err)
+		return nil, "", err
+	}
+
+	var nextToken string
+	if pk, ok := out.LastEvaluatedKey["PK"].(*types.AttributeValueMemberS); ok {
+		nextToken = EncodePageToken(pk.Value)
+	}
+	return codes, nextToken, nil
+}
+
 func (s *DynamoVotingCodesStorage) Put(ctx context.Context, code *VotingCode) error {
 	if code.CreatedAt.IsZero() {
 		code.CreatedAt = time.Now().UTC()
@@ -86,12 +131,83 @@ func (s *DynamoVotingCodesStorage) Put(ctx context.Context, code *VotingCode) er
 		ConditionExpression: aws.String("attribute_not_exists(PK)"),
 	})
 	if err != nil {
+		var cce *types.ConditionalCheckFailedException
+		if errors.As(err, &cce) {
+			logging.Log.Warnf("code %s already exists", code.Code)
+			return ErrAlreadyExists
+		}
 		logging.Log.Errorf("PUT storage failed: %v", err)
-		return err
+		return classify(err)
 	}
 	return nil
 }
 
+// PutBatch writes the codes in transactions of up to maxTransactItems conditional puts, see putCodeBatch
+func (s *DynamoVotingCodesStorage) PutBatch(ctx context.Context, codes []*VotingCode) ([]*VotingCode, error) {
+	return putCodeBatch(ctx, s.Client, codes, func(code *VotingCode) (*types.Put, error) {
+		item, err := attributevalue.MarshalMap(code)
+		if err != nil {
+			return nil, err
+		}
+		return &types.Put{
+			TableName:           &s.TableName,
+			Item:                item,
+			ConditionExpression: aws.String("attribute_not_exists(PK)"),
+		}, nil
+	})
+}
+
+// putCodeBatch writes the codes in transactions of up to maxTransactItems of the conditional puts built by put,
+// and returns the codes of the committed transactions. A transaction is cancelled as a whole when one code
+// collides, so the colliding codes are taken out and the rest of the chunk is written again.
+func putCodeBatch(ctx context.Context, client *dynamodb.Client, codes []*VotingCode, put func(*VotingCode) (*types.Put, error)) ([]*VotingCode, error) {
+	stored := make([]*VotingCode, 0, len(codes))
+	for i := 0; i < len(codes); i += maxTransactItems {
+		chunk := codes[i:min(i+maxTransactItems, len(codes))]
+		for len(chunk) > 0 {
+			items := make([]types.TransactWriteItem, 0, len(chunk))
+			for _, code := range chunk {
+				if code.CreatedAt.IsZero() {
+					code.CreatedAt = time.Now().UTC()
+				}
+				code.Used = false
+				item, err := put(code)
+				if err != nil {
+					logging.Log.Errorf("failed to marshal code: %v", err)
+					return stored, err
+				}
+				items = append(items, types.TransactWriteItem{Put: item})
+			}
+
+			err := transactWithRetry(ctx, client, &dynamodb.TransactWriteItemsInput{TransactItems: items})
+			if err == nil {
+				stored = append(stored, chunk...)
+				break
+			}
+
+			var tce *types.TransactionCanceledException
+			if !errors.As(err, &tce) {
+				logging.Log.Errorf("PUT batch storage failed: %v", err)
+				return stored, classify(err)
+			}
+			var retry []*VotingCode
+			for j, reason := range tce.CancellationReasons {
+				if aws.ToString(reason.Code) == "ConditionalCheckFailed" {
+					logging.Log.Warnf("code %s already exists", chunk[j].Code)
+				} else {
+					retry = append(retry, chunk[j])
+				}
+			}
+			if len(retry) == len(chunk) {
+				logging.Log.Errorf("PUT batch storage failed: %v", err)
+				return stored, classify(err)
+			}
+			chunk = retry
+		}
+	}
+	return stored, nil
+}
+
 func (s *DynamoVotingCodesStorage) Overwrite(ctx context.Context, code *VotingCode) error {
 	if code.CreatedAt.IsZero() {
 		code.CreatedAt = time.Now().UTC()
@@ -107,7 +223,7 @@ func (s *DynamoVotingCodesStorage) Overwrite(ctx context.Context, code *VotingCo
 	})
 	if err != nil {
 		logging.Log.Errorf("OVERWRITE failed: %v", err)
-		return err
+		return classify(err)
 	}
 	return nil
 }
@@ -122,7 +238,7 @@ func (s *DynamoVotingCodesStorage) MarkUnused(ctx context.Context, code string)
 		ExpressionAttributeValues: map[string]types.AttributeValue{":val": &types.AttributeValueMemberBOOL{Value: false}},
 	}
 	_, err := s.Client.UpdateItem(ctx, input)
-	return err
+	return classify(err)
 }
 
 func (s *DynamoVotingCodesStorage) MarkUsed(ctx context.Context, code string) error {
@@ -135,7 +251,38 @@ func (s *DynamoVotingCodesStorage) MarkUsed(ctx context.Context, code string) er
 		ExpressionAttributeValues: map[string]types.AttributeValue{":val": &types.AttributeValueMemberBOOL{Value: true}},
 	}
 	_, err := s.Client.UpdateItem(ctx, input)
-	return err
+	return classify(err)
+}
+
+func (s *DynamoVotingCodesStorage) SetExpiry(ctx context.Context, code string, expiresAt *time.Time) error {
+	input := &dynamodb.UpdateItemInput{
+		TableName: aws.String(s.TableName),
+		Key: map[string]types.AttributeValue{
+			"PK": &types.AttributeValueMemberS{Value: code},
+		},
+		UpdateExpression:    aws.String("REMOVE ExpiresAt"),
+		ConditionExpression: aws.String("attribute_exists(PK)"),
+	}
+	if expiresAt != nil {
+		value, err := attributevalue.Marshal(expiresAt.UTC())
+		if err != nil {
+			logging.Log.Errorf("failed to marshal expiry: %v", err)
+			return err
+		}
+		input.UpdateExpression = aws.String("SET ExpiresAt = :expiresAt")
+		input.ExpressionAttributeValues = map[string]types.AttributeValue{":expiresAt": value}
+	}
+
+	_, err := s.Client.UpdateItem(ctx, input)
+	if err != nil {
+		var cce *types.ConditionalCheckFailedException
+		if errors.As(err, &cce) {
+			return ErrNotFound
+		}
+		logging.Log.Errorf("failed to set expiry of code %s: %v", code, err)
+		return classify(err)
+	}
+	return nil
 }
 
 func (s *DynamoVotingCodesStorage) Delete(ctx context.Context, code string) error {
@@ -151,7 +298,7 @@ func (s *DynamoVotingCodesStorage) Delete(ctx context.Context, code string) erro
 	})
 	if err != nil {
 		logging.Log.Errorf("DEL storage item failed: %v", err)
-		return err
+		return classify(err)
 	}
 	return nil
 }
@@ -0,0 +1,46 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/smithy-go"
+	"net"
+	"net/http"
+)
+
+// classify wraps a DynamoDB SDK error into one of the storage error kinds, keeping the original in the chain.
+// Errors that already have a kind, and errors that match none, are returned unchanged.
+func classify(err error) error {
+	if err == nil || hasKind(err) {
+		return err
+	}
+
+	var throughput *types.ProvisionedThroughputExceededException
+	var requestLimit *types.RequestLimitExceeded
+	var txConflict *types.TransactionConflictException
+	var txInProgress *types.TransactionInProgressException
+	var internal *types.InternalServerError
+	var apiErr smithy.APIError
+	var respErr *awshttp.ResponseError
+	var netErr net.Error
+	switch {
+	case errors.As(err, &throughput), errors.As(err, &requestLimit):
+		return fmt.Errorf("%w: %w", ErrThrottled, err)
+	case errors.As(err, &apiErr) && apiErr.ErrorCode() == "ThrottlingException":
+		return fmt.Errorf("%w: %w", ErrThrottled, err)
+	case throttledTransaction(err):
+		return fmt.Errorf("%w: %w", ErrThrottled, err)
+	case errors.As(err, &txConflict), errors.As(err, &txInProgress):
+		return fmt.Errorf("%w: %w", ErrConflict, err)
+	case errors.As(err, &internal):
+		return fmt.Errorf("%w: %w", ErrUnavailable, err)
+	case errors.As(err, &respErr) && respErr.HTTPStatusCode() >= http.StatusInternalServerError:
+		return fmt.Errorf("%w: %w", ErrUnavailable, err)
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr):
+		return fmt.Errorf("%w: %w", ErrUnavailable, err)
+	}
+	return err
+}
@@ -0,0 +1,48 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/smithy-go"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestErrorKinds(t *testing.T) {
+	assert.ErrorIs(t, ErrCodeAlreadyUsed, ErrConflict)
+	assert.ErrorIs(t, ErrSubmissionConflict, ErrConflict)
+	assert.ErrorIs(t, ErrVersionConflict, ErrConflict)
+	assert.NotErrorIs(t, ErrNotFound, ErrConflict)
+}
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		kind error
+	}{
+		{"throughput exceeded", &types.ProvisionedThroughputExceededException{}, ErrThrottled},
+		{"request limit", &types.RequestLimitExceeded{}, ErrThrottled},
+		{"throttling exception", &smithy.GenericAPIError{Code: "ThrottlingException"}, ErrThrottled},
+		{"throttled transaction", &types.TransactionCanceledException{CancellationReasons: []types.CancellationReason{
+			{Code: aws.String("None")}, {Code: aws.String("ThrottlingError")},
+		}}, ErrThrottled},
+		{"transaction conflict", &types.TransactionConflictException{}, ErrConflict},
+		{"internal server error", &types.InternalServerError{}, ErrUnavailable},
+		{"deadline", context.DeadlineExceeded, ErrUnavailable},
+		{"already classified", ErrVersionConflict, ErrConflict},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := classify(tt.err)
+			assert.ErrorIs(t, err, tt.kind)
+			assert.ErrorIs(t, err, tt.err, "the original error stays in the chain")
+		})
+	}
+
+	other := errors.New("boom")
+	assert.Same(t, other, classify(other))
+	assert.NoError(t, classify(nil))
+}
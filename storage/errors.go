@@ -1,6 +1,36 @@
 package storage
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+)
 
-var ErrCodeNotFound = errors.New("item not found in storage")
-var ErrItemWithIDAlreadyExists = errors.New("voting category already exists")
+// Error kinds returned by every storage implementation. Callers check them with errors.Is;
+// the specific errors below, and classified backend errors, wrap one of them.
+var (
+	ErrNotFound      = errors.New("item not found in storage")
+	ErrAlreadyExists = errors.New("item with this ID already exists")
+	ErrConflict      = errors.New("conflicting write")
+	ErrThrottled     = errors.New("storage is throttling requests")
+	ErrUnavailable   = errors.New("storage is unavailable")
+)
+
+var (
+	ErrCodeAlreadyUsed    = fmt.Errorf("%w: voting code not found or already used", ErrConflict)
+	ErrSubmissionConflict = fmt.Errorf("%w: another submission for this code is in progress", ErrConflict)
+	ErrVersionConflict    = fmt.Errorf("%w: item was changed by someone else, reload and try again", ErrConflict)
+)
+
+var ErrInvalidPageToken = errors.New("invalid page token")
+var ErrTableMissing = errors.New("table does not exist")
+var ErrTableSchemaMismatch = errors.New("table key schema does not match")
+
+// hasKind reports whether err already carries one of the error kinds
+func hasKind(err error) bool {
+	for _, kind := range []error{ErrNotFound, ErrAlreadyExists, ErrConflict, ErrThrottled, ErrUnavailable} {
+		if errors.Is(err, kind) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,152 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"github.com/alex-pricope/simple-voting-system/logging"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"sort"
+)
+
+type EventStorage interface {
+	Get(ctx context.Context, id string) (*Event, error)
+	// GetAll returns the events ordered by ID
+	GetAll(ctx context.Context) ([]*Event, error)
+	Create(ctx context.Context, event *Event) error
+	// SetArchived changes only the archived flag, it returns ErrNotFound for an unknown ID
+	SetArchived(ctx context.Context, id string, archived bool) error
+}
+
+// TableNames are the names of the tables of one event
+type TableNames struct {
+	Codes      string
+	Votes      string
+	Teams      string
+	Categories string
+	Tallies    string
+	Audit      string
+	Ledger     string
+	Single     string // Table of the single-table layout, shared by every event
+	Partition  string // Partition of the event in Single, see SingleTablePartition
+}
+
+// InNamespace returns the table names of the event with the given namespace,
+// the names are suffixed with it unless it is empty. The single table is shared,
+// the event gets its own partition in it instead.
+func (t TableNames) InNamespace(namespace string) TableNames {
+	if namespace == "" {
+		return t
+	}
+	suffix := "-" + namespace
+	return TableNames{
+		Codes:      t.Codes + suffix,
+		Votes:      t.Votes + suffix,
+		Teams:      t.Teams + suffix,
+		Categories: t.Categories + suffix,
+		Tallies:    t.Tallies + suffix,
+		Audit:      t.Audit + suffix,
+		Ledger:     t.Ledger + suffix,
+		Single:     t.Single,
+		Partition:  SingleTablePartition(namespace),
+	}
+}
+
+type DynamoEventStorage struct {
+	Client    *dynamodb.Client
+	TableName string
+}
+
+func (s *DynamoEventStorage) Get(ctx context.Context, id string) (*Event, error) {
+	out, err := s.Client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: &s.TableName,
+		Key:       eventKey(id),
+	})
+	if err != nil {
+		logging.Log.Errorf("EVENT: GetItem for ID %s failed: %v", id, err)
+		return nil, classify(err)
+	}
+	if out.Item == nil {
+		return nil, ErrNotFound
+	}
+
+	var event Event
+	if err := attributevalue.UnmarshalMap(out.Item, &event); err != nil {
+		logging.Log.Errorf("EVENT: failed to unmarshal event: %v", err)
+		return nil, err
+	}
+	return &event, nil
+}
+
+func (s *DynamoEventStorage) GetAll(ctx context.Context) ([]*Event, error) {
+	items, err := scanAll(ctx, s.Client, &dynamodb.ScanInput{
+		TableName: &s.TableName,
+	})
+	if err != nil {
+		logging.Log.Errorf("EVENT: scan failed: %v", err)
+		return nil, classify(err)
+	}
+
+	var events []*Event
+	if err := attributevalue.UnmarshalListOfMaps(items, &events); err != nil {
+		logging.Log.Errorf("EVENT: failed to unmarshal event list: %v", err)
+		return nil, err
+	}
+	sort.Slice(events, func(i, j int) bool {
+		return events[i].ID < events[j].ID
+	})
+	return events, nil
+}
+
+func (s *DynamoEventStorage) Create(ctx context.Context, event *Event) error {
+	item, err := attributevalue.MarshalMap(event)
+	if err != nil {
+		logging.Log.Errorf("EVENT: failed to marshal event: %v", err)
+		return err
+	}
+
+	_, err = s.Client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName:           &s.TableName,
+		Item:                item,
+		ConditionExpression: aws.String("attribute_not_exists(PK)"),
+	})
+	if err != nil {
+		var cce *types.ConditionalCheckFailedException
+		if errors.As(err, &cce) {
+			logging.Log.Warnf("EVENT: event %s already exists", event.ID)
+			return ErrAlreadyExists
+		}
+		logging.Log.Errorf("EVENT: failed to create event: %v", err)
+		return classify(err)
+	}
+	return nil
+}
+
+func (s *DynamoEventStorage) SetArchived(ctx context.Context, id string, archived bool) error {
+	_, err := s.Client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:           &s.TableName,
+		Key:                 eventKey(id),
+		UpdateExpression:    aws.String("SET Archived = :archived"),
+		ConditionExpression: aws.String("attribute_exists(PK)"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":archived": &types.AttributeValueMemberBOOL{Value: archived},
+		},
+	})
+	if err != nil {
+		var cce *types.ConditionalCheckFailedException
+		if errors.As(err, &cce) {
+			return ErrNotFound
+		}
+		logging.Log.Errorf("EVENT: failed to set archived=%t on event %s: %v", archived, id, err)
+		return classify(err)
+	}
+	return nil
+}
+
+func eventKey(id string) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		"PK": &types.AttributeValueMemberS{Value: id},
+	}
+}
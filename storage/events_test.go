@@ -0,0 +1,24 @@
+package storage
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestTableNamesInNamespace(t *testing.T) {
+	tables := TableNames{Codes: "VotingCodes", Votes: "Votes", Teams: "VotingTeams", Categories: "VotingCategories", Tallies: "VoteTallies", Audit: "VotingAudit",
+		Ledger: "VotingLedger", Single: "Voting", Partition: SingleTablePartition("")}
+
+	assert.Equal(t, tables, tables.InNamespace(""))
+	assert.Equal(t, TableNames{
+		Codes:      "VotingCodes-spring",
+		Votes:      "Votes-spring",
+		Teams:      "VotingTeams-spring",
+		Categories: "VotingCategories-spring",
+		Tallies:    "VoteTallies-spring",
+		Audit:      "VotingAudit-spring",
+		Ledger:     "VotingLedger-spring",
+		Single:     "Voting",
+		Partition:  "EVENT#spring",
+	}, tables.InNamespace("spring"))
+}
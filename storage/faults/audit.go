@@ -0,0 +1,31 @@
+package faults
+
+import (
+	"context"
+	"github.com/alex-pricope/simple-voting-system/storage"
+)
+
+// AuditStorage injects faults into every call of the wrapped storage.AuditStorage
+type AuditStorage struct {
+	next   storage.AuditStorage
+	table  string
+	faults *Injector
+}
+
+func NewAuditStorage(next storage.AuditStorage, table string, faults *Injector) *AuditStorage {
+	return &AuditStorage{next: next, table: table, faults: faults}
+}
+
+func (s *AuditStorage) Append(ctx context.Context, entry *storage.AuditEntry) (err error) {
+	if err = s.faults.inject(ctx, s.table, "Append"); err != nil {
+		return
+	}
+	return s.next.Append(ctx, entry)
+}
+
+func (s *AuditStorage) Query(ctx context.Context, filter storage.AuditFilter) (_ []*storage.AuditEntry, err error) {
+	if err = s.faults.inject(ctx, s.table, "Query"); err != nil {
+		return
+	}
+	return s.next.Query(ctx, filter)
+}
@@ -0,0 +1,25 @@
+package faults
+
+import (
+	"context"
+	"github.com/alex-pricope/simple-voting-system/storage"
+)
+
+// BallotStorage injects faults into every call of the wrapped storage.BallotStorage.
+// A ballot spans the codes and votes tables, so its faults are reported under its own name.
+type BallotStorage struct {
+	next   storage.BallotStorage
+	table  string
+	faults *Injector
+}
+
+func NewBallotStorage(next storage.BallotStorage, table string, faults *Injector) *BallotStorage {
+	return &BallotStorage{next: next, table: table, faults: faults}
+}
+
+func (s *BallotStorage) Submit(ctx context.Context, code string, votes []*storage.Vote) (err error) {
+	if err = s.faults.inject(ctx, s.table, "Submit"); err != nil {
+		return
+	}
+	return s.next.Submit(ctx, code, votes)
+}
@@ -0,0 +1,52 @@
+package faults
+
+import (
+	"context"
+	"github.com/alex-pricope/simple-voting-system/storage"
+)
+
+// VotingCategoryStorage injects faults into every call of the wrapped storage.VotingCategoryStorage
+type VotingCategoryStorage struct {
+	next   storage.VotingCategoryStorage
+	table  string
+	faults *Injector
+}
+
+func NewVotingCategoryStorage(next storage.VotingCategoryStorage, table string, faults *Injector) *VotingCategoryStorage {
+	return &VotingCategoryStorage{next: next, table: table, faults: faults}
+}
+
+func (s *VotingCategoryStorage) Get(ctx context.Context, id int) (_ *storage.VotingCategory, err error) {
+	if err = s.faults.inject(ctx, s.table, "Get"); err != nil {
+		return
+	}
+	return s.next.Get(ctx, id)
+}
+
+func (s *VotingCategoryStorage) GetAll(ctx context.Context) (_ []*storage.VotingCategory, err error) {
+	if err = s.faults.inject(ctx, s.table, "GetAll"); err != nil {
+		return
+	}
+	return s.next.GetAll(ctx)
+}
+
+func (s *VotingCategoryStorage) Create(ctx context.Context, category *storage.VotingCategory) (err error) {
+	if err = s.faults.inject(ctx, s.table, "Create"); err != nil {
+		return
+	}
+	return s.next.Create(ctx, category)
+}
+
+func (s *VotingCategoryStorage) Update(ctx context.Context, category *storage.VotingCategory) (err error) {
+	if err = s.faults.inject(ctx, s.table, "Update"); err != nil {
+		return
+	}
+	return s.next.Update(ctx, category)
+}
+
+func (s *VotingCategoryStorage) Delete(ctx context.Context, id int) (err error) {
+	if err = s.faults.inject(ctx, s.table, "Delete"); err != nil {
+		return
+	}
+	return s.next.Delete(ctx, id)
+}
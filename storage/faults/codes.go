@@ -0,0 +1,88 @@
+package faults
+
+import (
+	"context"
+	"github.com/alex-pricope/simple-voting-system/storage"
+	"time"
+)
+
+// VotingCodeStorage injects faults into every call of the wrapped storage.VotingCodeStorage
+type VotingCodeStorage struct {
+	next   storage.VotingCodeStorage
+	table  string
+	faults *Injector
+}
+
+func NewVotingCodeStorage(next storage.VotingCodeStorage, table string, faults *Injector) *VotingCodeStorage {
+	return &VotingCodeStorage{next: next, table: table, faults: faults}
+}
+
+func (s *VotingCodeStorage) Get(ctx context.Context, code string) (_ *storage.VotingCode, err error) {
+	if err = s.faults.inject(ctx, s.table, "Get"); err != nil {
+		return
+	}
+	return s.next.Get(ctx, code)
+}
+
+func (s *VotingCodeStorage) GetAll(ctx context.Context) (_ []*storage.VotingCode, err error) {
+	if err = s.faults.inject(ctx, s.table, "GetAll"); err != nil {
+		return
+	}
+	return s.next.GetAll(ctx)
+}
+
+func (s *VotingCodeStorage) GetPage(ctx context.Context, limit int, next string) (_ []*storage.VotingCode, _ string, err error) {
+	if err = s.faults.inject(ctx, s.table, "GetPage"); err != nil {
+		return
+	}
+	return s.next.GetPage(ctx, limit, next)
+}
+
+func (s *VotingCodeStorage) Put(ctx context.Context, votingCode *storage.VotingCode) (err error) {
+	if err = s.faults.inject(ctx, s.table, "Put"); err != nil {
+		return
+	}
+	return s.next.Put(ctx, votingCode)
+}
+
+func (s *VotingCodeStorage) PutBatch(ctx context.Context, codes []*storage.VotingCode) (_ []*storage.VotingCode, err error) {
+	if err = s.faults.inject(ctx, s.table, "PutBatch"); err != nil {
+		return
+	}
+	return s.next.PutBatch(ctx, codes)
+}
+
+func (s *VotingCodeStorage) MarkUnused(ctx context.Context, code string) (err error) {
+	if err = s.faults.inject(ctx, s.table, "MarkUnused"); err != nil {
+		return
+	}
+	return s.next.MarkUnused(ctx, code)
+}
+
+func (s *VotingCodeStorage) MarkUsed(ctx context.Context, code string) (err error) {
+	if err = s.faults.inject(ctx, s.table, "MarkUsed"); err != nil {
+		return
+	}
+	return s.next.MarkUsed(ctx, code)
+}
+
+func (s *VotingCodeStorage) SetExpiry(ctx context.Context, code string, expiresAt *time.Time) (err error) {
+	if err = s.faults.inject(ctx, s.table, "SetExpiry"); err != nil {
+		return
+	}
+	return s.next.SetExpiry(ctx, code, expiresAt)
+}
+
+func (s *VotingCodeStorage) Delete(ctx context.Context, code string) (err error) {
+	if err = s.faults.inject(ctx, s.table, "Delete"); err != nil {
+		return
+	}
+	return s.next.Delete(ctx, code)
+}
+
+func (s *VotingCodeStorage) Overwrite(ctx context.Context, code *storage.VotingCode) (err error) {
+	if err = s.faults.inject(ctx, s.table, "Overwrite"); err != nil {
+		return
+	}
+	return s.next.Overwrite(ctx, code)
+}
@@ -0,0 +1,92 @@
+package faults
+
+import (
+	"context"
+	"github.com/alex-pricope/simple-voting-system/logging"
+	"github.com/alex-pricope/simple-voting-system/storage"
+	"github.com/alex-pricope/simple-voting-system/storage/memory"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestNewInjectorRejectsInvalidRates(t *testing.T) {
+	_, err := NewInjector(0.6, 0.5, 0)
+	assert.Error(t, err)
+	_, err = NewInjector(-0.1, 0, 0)
+	assert.Error(t, err)
+	_, err = NewInjector(0, 0, -time.Second)
+	assert.Error(t, err)
+	_, err = NewInjector(0.5, 0.5, time.Millisecond)
+	assert.NoError(t, err)
+}
+
+func TestInjectedFaults(t *testing.T) {
+	logging.Log = logrus.New()
+	ctx := context.TODO()
+
+	t.Run("Throttled calls do not reach the storage", func(t *testing.T) {
+		next := memory.NewVotingCodesStorage()
+		injector, err := NewInjector(0, 1, 0)
+		require.NoError(t, err)
+		codes := NewVotingCodeStorage(next, "VotingCodes", injector)
+
+		err = codes.Put(ctx, &storage.VotingCode{Code: "AAAAA"})
+		assert.ErrorIs(t, err, storage.ErrThrottled)
+		assert.ErrorContains(t, err, "VotingCodes.Put")
+		_, err = next.Get(ctx, "AAAAA")
+		assert.ErrorIs(t, err, storage.ErrNotFound)
+	})
+
+	t.Run("Errors are reported as unavailable", func(t *testing.T) {
+		injector, err := NewInjector(1, 0, 0)
+		require.NoError(t, err)
+		votes := NewVoteStorage(memory.NewVoteStorage(), "Votes", injector)
+
+		_, err = votes.GetAll(ctx)
+		assert.ErrorIs(t, err, storage.ErrUnavailable)
+	})
+
+	t.Run("Rates pick the fault of each call", func(t *testing.T) {
+		injector, err := NewInjector(0.3, 0.2, 0)
+		require.NoError(t, err)
+		teams := NewTeamStorage(memory.NewTeamStorage(), "VotingTeams", injector)
+
+		for r, want := range map[float64]error{0.1: storage.ErrThrottled, 0.4: storage.ErrUnavailable, 0.6: nil} {
+			injector.random = func() float64 { return r }
+			_, err := teams.GetAll(ctx)
+			if want == nil {
+				assert.NoError(t, err, "random %g", r)
+			} else {
+				assert.ErrorIs(t, err, want, "random %g", r)
+			}
+		}
+	})
+
+	t.Run("Latency delays every call and respects the context", func(t *testing.T) {
+		injector, err := NewInjector(0, 0, 20*time.Millisecond)
+		require.NoError(t, err)
+		categories := NewVotingCategoryStorage(memory.NewVotingCategoryStorage(), "VotingCategories", injector)
+
+		start := time.Now()
+		_, err = categories.GetAll(ctx)
+		require.NoError(t, err)
+		assert.GreaterOrEqual(t, time.Since(start), 20*time.Millisecond)
+
+		cancelled, cancel := context.WithCancel(ctx)
+		cancel()
+		_, err = categories.GetAll(cancelled)
+		assert.ErrorIs(t, err, storage.ErrUnavailable)
+		assert.ErrorIs(t, err, context.Canceled)
+	})
+
+	t.Run("Vote layout conversion stays visible", func(t *testing.T) {
+		injector, err := NewInjector(0, 0, 0)
+		require.NoError(t, err)
+		votes := NewVoteStorage(&storage.DynamoBallotVoteStorage{}, "Votes", injector)
+		_, ok := votes.(storage.VoteLayoutConverter)
+		assert.True(t, ok)
+	})
+}
@@ -0,0 +1,53 @@
+// Package faults wraps storage implementations to slow down and fail a share of their calls,
+// to test how voting degrades when the storage is throttled or unavailable.
+package faults
+
+import (
+	"context"
+	"fmt"
+	"github.com/alex-pricope/simple-voting-system/storage"
+	"math/rand/v2"
+	"time"
+)
+
+// Injector decides the faults of every call of the wrapped storages. It is safe for concurrent use.
+type Injector struct {
+	errorRate    float64
+	throttleRate float64
+	latency      time.Duration
+	random       func() float64
+}
+
+// NewInjector creates an Injector delaying every call by latency, then failing the share throttleRate of
+// the calls with storage.ErrThrottled and the share errorRate with storage.ErrUnavailable.
+// Failed calls never reach the wrapped storage.
+func NewInjector(errorRate, throttleRate float64, latency time.Duration) (*Injector, error) {
+	if errorRate < 0 || throttleRate < 0 || errorRate+throttleRate > 1 {
+		return nil, fmt.Errorf("fault rates must be between 0 and 1 together, got errors %g and throttles %g", errorRate, throttleRate)
+	}
+	if latency < 0 {
+		return nil, fmt.Errorf("fault latency must not be negative, got %s", latency)
+	}
+	return &Injector{errorRate: errorRate, throttleRate: throttleRate, latency: latency, random: rand.Float64}, nil
+}
+
+// inject is called by every wrapped method before it calls the wrapped storage, which it skips on an error
+func (i *Injector) inject(ctx context.Context, table, method string) error {
+	if i.latency > 0 {
+		timer := time.NewTimer(i.latency)
+		defer timer.Stop()
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%w: %w", storage.ErrUnavailable, ctx.Err())
+		case <-timer.C:
+		}
+	}
+
+	switch r := i.random(); {
+	case r < i.throttleRate:
+		return fmt.Errorf("%w: fault injected into %s.%s", storage.ErrThrottled, table, method)
+	case r < i.throttleRate+i.errorRate:
+		return fmt.Errorf("%w: fault injected into %s.%s", storage.ErrUnavailable, table, method)
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+package faults
+
+import (
+	"context"
+	"github.com/alex-pricope/simple-voting-system/storage"
+)
+
+// LedgerStorage injects faults into every call of the wrapped storage.LedgerStorage
+type LedgerStorage struct {
+	next   storage.LedgerStorage
+	table  string
+	faults *Injector
+}
+
+func NewLedgerStorage(next storage.LedgerStorage, table string, faults *Injector) *LedgerStorage {
+	return &LedgerStorage{next: next, table: table, faults: faults}
+}
+
+func (s *LedgerStorage) Append(ctx context.Context, event *storage.LedgerEvent) (err error) {
+	if err = s.faults.inject(ctx, s.table, "Append"); err != nil {
+		return
+	}
+	return s.next.Append(ctx, event)
+}
+
+func (s *LedgerStorage) Query(ctx context.Context, filter storage.LedgerFilter) (_ []*storage.LedgerEvent, err error) {
+	if err = s.faults.inject(ctx, s.table, "Query"); err != nil {
+		return
+	}
+	return s.next.Query(ctx, filter)
+}
+
+func (s *LedgerStorage) LockCode(ctx context.Context, code string) (_ *storage.LedgerLock, err error) {
+	if err = s.faults.inject(ctx, s.table, "LockCode"); err != nil {
+		return
+	}
+	return s.next.LockCode(ctx, code)
+}
+
+func (s *LedgerStorage) UnlockCode(ctx context.Context, lock *storage.LedgerLock) (err error) {
+	if err = s.faults.inject(ctx, s.table, "UnlockCode"); err != nil {
+		return
+	}
+	return s.next.UnlockCode(ctx, lock)
+}
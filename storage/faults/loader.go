@@ -0,0 +1,24 @@
+package faults
+
+import (
+	"context"
+	"github.com/alex-pricope/simple-voting-system/storage"
+)
+
+// EventLoader injects faults into every call of the wrapped storage.EventLoader
+type EventLoader struct {
+	next   storage.EventLoader
+	table  string
+	faults *Injector
+}
+
+func NewEventLoader(next storage.EventLoader, table string, faults *Injector) *EventLoader {
+	return &EventLoader{next: next, table: table, faults: faults}
+}
+
+func (s *EventLoader) LoadEvent(ctx context.Context) (_ *storage.EventData, err error) {
+	if err = s.faults.inject(ctx, s.table, "LoadEvent"); err != nil {
+		return
+	}
+	return s.next.LoadEvent(ctx)
+}
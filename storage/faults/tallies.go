@@ -0,0 +1,45 @@
+package faults
+
+import (
+	"context"
+	"github.com/alex-pricope/simple-voting-system/storage"
+)
+
+// TallyStorage injects faults into every call of the wrapped storage.TallyStorage
+type TallyStorage struct {
+	next   storage.TallyStorage
+	table  string
+	faults *Injector
+}
+
+func NewTallyStorage(next storage.TallyStorage, table string, faults *Injector) *TallyStorage {
+	return &TallyStorage{next: next, table: table, faults: faults}
+}
+
+func (s *TallyStorage) Add(ctx context.Context, voterGroup string, votes []*storage.Vote) (err error) {
+	if err = s.faults.inject(ctx, s.table, "Add"); err != nil {
+		return
+	}
+	return s.next.Add(ctx, voterGroup, votes)
+}
+
+func (s *TallyStorage) Subtract(ctx context.Context, voterGroup string, votes []*storage.Vote, ballots int) (err error) {
+	if err = s.faults.inject(ctx, s.table, "Subtract"); err != nil {
+		return
+	}
+	return s.next.Subtract(ctx, voterGroup, votes, ballots)
+}
+
+func (s *TallyStorage) GetAll(ctx context.Context) (_ *storage.Tallies, err error) {
+	if err = s.faults.inject(ctx, s.table, "GetAll"); err != nil {
+		return
+	}
+	return s.next.GetAll(ctx)
+}
+
+func (s *TallyStorage) Replace(ctx context.Context, tallies *storage.Tallies) (err error) {
+	if err = s.faults.inject(ctx, s.table, "Replace"); err != nil {
+		return
+	}
+	return s.next.Replace(ctx, tallies)
+}
@@ -0,0 +1,52 @@
+package faults
+
+import (
+	"context"
+	"github.com/alex-pricope/simple-voting-system/storage"
+)
+
+// TeamStorage injects faults into every call of the wrapped storage.TeamStorage
+type TeamStorage struct {
+	next   storage.TeamStorage
+	table  string
+	faults *Injector
+}
+
+func NewTeamStorage(next storage.TeamStorage, table string, faults *Injector) *TeamStorage {
+	return &TeamStorage{next: next, table: table, faults: faults}
+}
+
+func (s *TeamStorage) Get(ctx context.Context, id int) (_ *storage.Team, err error) {
+	if err = s.faults.inject(ctx, s.table, "Get"); err != nil {
+		return
+	}
+	return s.next.Get(ctx, id)
+}
+
+func (s *TeamStorage) GetAll(ctx context.Context) (_ []*storage.Team, err error) {
+	if err = s.faults.inject(ctx, s.table, "GetAll"); err != nil {
+		return
+	}
+	return s.next.GetAll(ctx)
+}
+
+func (s *TeamStorage) Create(ctx context.Context, team *storage.Team) (err error) {
+	if err = s.faults.inject(ctx, s.table, "Create"); err != nil {
+		return
+	}
+	return s.next.Create(ctx, team)
+}
+
+func (s *TeamStorage) Update(ctx context.Context, team *storage.Team) (err error) {
+	if err = s.faults.inject(ctx, s.table, "Update"); err != nil {
+		return
+	}
+	return s.next.Update(ctx, team)
+}
+
+func (s *TeamStorage) Delete(ctx context.Context, id int) (err error) {
+	if err = s.faults.inject(ctx, s.table, "Delete"); err != nil {
+		return
+	}
+	return s.next.Delete(ctx, id)
+}
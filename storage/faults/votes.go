@@ -0,0 +1,84 @@
+package faults
+
+import (
+	"context"
+	"github.com/alex-pricope/simple-voting-system/storage"
+)
+
+// VoteStorage injects faults into every call of the wrapped storage.VoteStorage
+type VoteStorage struct {
+	next   storage.VoteStorage
+	table  string
+	faults *Injector
+}
+
+// convertingVoteStorage keeps storage.VoteLayoutConverter visible through the wrapper
+type convertingVoteStorage struct {
+	*VoteStorage
+	converter storage.VoteLayoutConverter
+}
+
+// NewVoteStorage wraps next. If next is a storage.VoteLayoutConverter, so is the result.
+func NewVoteStorage(next storage.VoteStorage, table string, faults *Injector) storage.VoteStorage {
+	s := &VoteStorage{next: next, table: table, faults: faults}
+	if converter, ok := next.(storage.VoteLayoutConverter); ok {
+		return &convertingVoteStorage{VoteStorage: s, converter: converter}
+	}
+	return s
+}
+
+func (s *VoteStorage) GetAll(ctx context.Context) (_ []*storage.Vote, err error) {
+	if err = s.faults.inject(ctx, s.table, "GetAll"); err != nil {
+		return
+	}
+	return s.next.GetAll(ctx)
+}
+
+func (s *VoteStorage) Create(ctx context.Context, vote *storage.Vote) (err error) {
+	if err = s.faults.inject(ctx, s.table, "Create"); err != nil {
+		return
+	}
+	return s.next.Create(ctx, vote)
+}
+
+func (s *VoteStorage) GetByCode(ctx context.Context, code string) (_ []*storage.Vote, err error) {
+	if err = s.faults.inject(ctx, s.table, "GetByCode"); err != nil {
+		return
+	}
+	return s.next.GetByCode(ctx, code)
+}
+
+func (s *VoteStorage) GetByTeam(ctx context.Context, teamID int) (_ []*storage.Vote, err error) {
+	if err = s.faults.inject(ctx, s.table, "GetByTeam"); err != nil {
+		return
+	}
+	return s.next.GetByTeam(ctx, teamID)
+}
+
+func (s *VoteStorage) GetByCategory(ctx context.Context, categoryID int) (_ []*storage.Vote, err error) {
+	if err = s.faults.inject(ctx, s.table, "GetByCategory"); err != nil {
+		return
+	}
+	return s.next.GetByCategory(ctx, categoryID)
+}
+
+func (s *VoteStorage) Delete(ctx context.Context, votes []*storage.Vote) (err error) {
+	if err = s.faults.inject(ctx, s.table, "Delete"); err != nil {
+		return
+	}
+	return s.next.Delete(ctx, votes)
+}
+
+func (s *VoteStorage) DeleteAll(ctx context.Context) (err error) {
+	if err = s.faults.inject(ctx, s.table, "DeleteAll"); err != nil {
+		return
+	}
+	return s.next.DeleteAll(ctx)
+}
+
+func (s *convertingVoteStorage) ConvertRatingRows(ctx context.Context) (_ int, err error) {
+	if err = s.faults.inject(ctx, s.table, "ConvertRatingRows"); err != nil {
+		return
+	}
+	return s.converter.ConvertRatingRows(ctx)
+}
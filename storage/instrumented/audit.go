@@ -0,0 +1,28 @@
+package instrumented
+
+import (
+	"context"
+	"github.com/alex-pricope/simple-voting-system/storage"
+	"time"
+)
+
+// AuditStorage times every call of the wrapped storage.AuditStorage
+type AuditStorage struct {
+	next     storage.AuditStorage
+	table    string
+	recorder *Recorder
+}
+
+func NewAuditStorage(next storage.AuditStorage, table string, recorder *Recorder) *AuditStorage {
+	return &AuditStorage{next: next, table: table, recorder: recorder}
+}
+
+func (s *AuditStorage) Append(ctx context.Context, entry *storage.AuditEntry) (err error) {
+	defer s.recorder.observe(s.table, "Append", time.Now(), &err)
+	return s.next.Append(ctx, entry)
+}
+
+func (s *AuditStorage) Query(ctx context.Context, filter storage.AuditFilter) (_ []*storage.AuditEntry, err error) {
+	defer s.recorder.observe(s.table, "Query", time.Now(), &err)
+	return s.next.Query(ctx, filter)
+}
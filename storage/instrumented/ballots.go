@@ -0,0 +1,24 @@
+package instrumented
+
+import (
+	"context"
+	"github.com/alex-pricope/simple-voting-system/storage"
+	"time"
+)
+
+// BallotStorage times every call of the wrapped storage.BallotStorage.
+// A ballot spans the codes and votes tables, so it is recorded under its own name.
+type BallotStorage struct {
+	next     storage.BallotStorage
+	table    string
+	recorder *Recorder
+}
+
+func NewBallotStorage(next storage.BallotStorage, table string, recorder *Recorder) *BallotStorage {
+	return &BallotStorage{next: next, table: table, recorder: recorder}
+}
+
+func (s *BallotStorage) Submit(ctx context.Context, code string, votes []*storage.Vote) (err error) {
+	defer s.recorder.observe(s.table, "Submit", time.Now(), &err)
+	return s.next.Submit(ctx, code, votes)
+}
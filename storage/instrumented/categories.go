@@ -0,0 +1,43 @@
+package instrumented
+
+import (
+	"context"
+	"github.com/alex-pricope/simple-voting-system/storage"
+	"time"
+)
+
+// VotingCategoryStorage times every call of the wrapped storage.VotingCategoryStorage
+type VotingCategoryStorage struct {
+	next     storage.VotingCategoryStorage
+	table    string
+	recorder *Recorder
+}
+
+func NewVotingCategoryStorage(next storage.VotingCategoryStorage, table string, recorder *Recorder) *VotingCategoryStorage {
+	return &VotingCategoryStorage{next: next, table: table, recorder: recorder}
+}
+
+func (s *VotingCategoryStorage) Get(ctx context.Context, id int) (_ *storage.VotingCategory, err error) {
+	defer s.recorder.observe(s.table, "Get", time.Now(), &err)
+	return s.next.Get(ctx, id)
+}
+
+func (s *VotingCategoryStorage) GetAll(ctx context.Context) (_ []*storage.VotingCategory, err error) {
+	defer s.recorder.observe(s.table, "GetAll", time.Now(), &err)
+	return s.next.GetAll(ctx)
+}
+
+func (s *VotingCategoryStorage) Create(ctx context.Context, category *storage.VotingCategory) (err error) {
+	defer s.recorder.observe(s.table, "Create", time.Now(), &err)
+	return s.next.Create(ctx, category)
+}
+
+func (s *VotingCategoryStorage) Update(ctx context.Context, category *storage.VotingCategory) (err error) {
+	defer s.recorder.observe(s.table, "Update", time.Now(), &err)
+	return s.next.Update(ctx, category)
+}
+
+func (s *VotingCategoryStorage) Delete(ctx context.Context, id int) (err error) {
+	defer s.recorder.observe(s.table, "Delete", time.Now(), &err)
+	return s.next.Delete(ctx, id)
+}
@@ -0,0 +1,68 @@
+package instrumented
+
+import (
+	"context"
+	"github.com/alex-pricope/simple-voting-system/storage"
+	"time"
+)
+
+// VotingCodeStorage times every call of the wrapped storage.VotingCodeStorage
+type VotingCodeStorage struct {
+	next     storage.VotingCodeStorage
+	table    string
+	recorder *Recorder
+}
+
+func NewVotingCodeStorage(next storage.VotingCodeStorage, table string, recorder *Recorder) *VotingCodeStorage {
+	return &VotingCodeStorage{next: next, table: table, recorder: recorder}
+}
+
+func (s *VotingCodeStorage) Get(ctx context.Context, code string) (_ *storage.VotingCode, err error) {
+	defer s.recorder.observe(s.table, "Get", time.Now(), &err)
+	return s.next.Get(ctx, code)
+}
+
+func (s *VotingCodeStorage) GetAll(ctx context.Context) (_ []*storage.VotingCode, err error) {
+	defer s.recorder.observe(s.table, "GetAll", time.Now(), &err)
+	return s.next.GetAll(ctx)
+}
+
+func (s *VotingCodeStorage) GetPage(ctx context.Context, limit int, next string) (_ []*storage.VotingCode, _ string, err error) {
+	defer s.recorder.observe(s.table, "GetPage", time.Now(), &err)
+	return s.next.GetPage(ctx, limit, next)
+}
+
+func (s *VotingCodeStorage) Put(ctx context.Context, votingCode *storage.VotingCode) (err error) {
+	defer s.recorder.observe(s.table, "Put", time.Now(), &err)
+	return s.next.Put(ctx, votingCode)
+}
+
+func (s *VotingCodeStorage) PutBatch(ctx context.Context, codes []*storage.VotingCode) (_ []*storage.VotingCode, err error) {
+	defer s.recorder.observe(s.table, "PutBatch", time.Now(), &err)
+	return s.next.PutBatch(ctx, codes)
+}
+
+func (s *VotingCodeStorage) MarkUnused(ctx context.Context, code string) (err error) {
+	defer s.recorder.observe(s.table, "MarkUnused", time.Now(), &err)
+	return s.next.MarkUnused(ctx, code)
+}
+
+func (s *VotingCodeStorage) MarkUsed(ctx context.Context, code string) (err error) {
+	defer s.recorder.observe(s.table, "MarkUsed", time.Now(), &err)
+	return s.next.MarkUsed(ctx, code)
+}
+
+func (s *VotingCodeStorage) SetExpiry(ctx context.Context, code string, expiresAt *time.Time) (err error) {
+	defer s.recorder.observe(s.table, "SetExpiry", time.Now(), &err)
+	return s.next.SetExpiry(ctx, code, expiresAt)
+}
+
+func (s *VotingCodeStorage) Delete(ctx context.Context, code string) (err error) {
+	defer s.recorder.observe(s.table, "Delete", time.Now(), &err)
+	return s.next.Delete(ctx, code)
+}
+
+func (s *VotingCodeStorage) Overwrite(ctx context.Context, code *storage.VotingCode) (err error) {
+	defer s.recorder.observe(s.table, "Overwrite", time.Now(), &err)
+	return s.next.Overwrite(ctx, code)
+}
@@ -0,0 +1,38 @@
+package instrumented
+
+import (
+	"context"
+	"github.com/alex-pricope/simple-voting-system/storage"
+	"time"
+)
+
+// EventStorage times every call of the wrapped storage.EventStorage
+type EventStorage struct {
+	next     storage.EventStorage
+	table    string
+	recorder *Recorder
+}
+
+func NewEventStorage(next storage.EventStorage, table string, recorder *Recorder) *EventStorage {
+	return &EventStorage{next: next, table: table, recorder: recorder}
+}
+
+func (s *EventStorage) Get(ctx context.Context, id string) (_ *storage.Event, err error) {
+	defer s.recorder.observe(s.table, "Get", time.Now(), &err)
+	return s.next.Get(ctx, id)
+}
+
+func (s *EventStorage) GetAll(ctx context.Context) (_ []*storage.Event, err error) {
+	defer s.recorder.observe(s.table, "GetAll", time.Now(), &err)
+	return s.next.GetAll(ctx)
+}
+
+func (s *EventStorage) Create(ctx context.Context, event *storage.Event) (err error) {
+	defer s.recorder.observe(s.table, "Create", time.Now(), &err)
+	return s.next.Create(ctx, event)
+}
+
+func (s *EventStorage) SetArchived(ctx context.Context, id string, archived bool) (err error) {
+	defer s.recorder.observe(s.table, "SetArchived", time.Now(), &err)
+	return s.next.SetArchived(ctx, id, archived)
+}
@@ -0,0 +1,67 @@
+package instrumented
+
+import (
+	"context"
+	"github.com/alex-pricope/simple-voting-system/logging"
+	"github.com/alex-pricope/simple-voting-system/storage"
+	"github.com/alex-pricope/simple-voting-system/storage/memory"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestRecorderCountsCallsAndErrors(t *testing.T) {
+	logging.Log = logrus.New()
+	ctx := context.TODO()
+	recorder := NewRecorder(0)
+	codes := NewVotingCodeStorage(memory.NewVotingCodesStorage(), "VotingCodes", recorder)
+
+	require.NoError(t, codes.Put(ctx, &storage.VotingCode{Code: "AAAAA"}))
+	assert.ErrorIs(t, codes.Put(ctx, &storage.VotingCode{Code: "AAAAA"}), storage.ErrAlreadyExists)
+	_, err := codes.Get(ctx, "AAAAA")
+	require.NoError(t, err)
+
+	snapshot := recorder.Snapshot()
+	require.Len(t, snapshot, 2)
+	assert.Equal(t, "Get", snapshot[0].Method)
+	assert.Equal(t, int64(1), snapshot[0].Calls)
+	assert.Equal(t, "Put", snapshot[1].Method)
+	assert.Equal(t, "VotingCodes", snapshot[1].Table)
+	assert.Equal(t, int64(2), snapshot[1].Calls)
+	assert.Equal(t, int64(1), snapshot[1].Errors)
+	assert.Zero(t, snapshot[1].Slow)
+
+	recorder.Reset()
+	assert.Empty(t, recorder.Snapshot())
+}
+
+func TestRecorderFlagsSlowCalls(t *testing.T) {
+	logging.Log = logrus.New()
+	recorder := NewRecorder(time.Millisecond)
+
+	func() (err error) {
+		defer recorder.observe("Votes", "GetAll", time.Now(), &err)
+		time.Sleep(2 * time.Millisecond)
+		return nil
+	}()
+
+	snapshot := recorder.Snapshot()
+	require.Len(t, snapshot, 1)
+	assert.Equal(t, int64(1), snapshot[0].Slow)
+	assert.GreaterOrEqual(t, snapshot[0].Max, 2*time.Millisecond)
+	assert.Equal(t, snapshot[0].Total, snapshot[0].Average)
+}
+
+func TestVoteStorageKeepsConverter(t *testing.T) {
+	recorder := NewRecorder(0)
+
+	wrapped := NewVoteStorage(memory.NewVoteStorage(), "Votes", recorder)
+	_, ok := wrapped.(storage.VoteLayoutConverter)
+	assert.False(t, ok, "memory storage has no converter, the wrapper must not pretend otherwise")
+
+	wrapped = NewVoteStorage(&storage.DynamoBallotVoteStorage{}, "Votes", recorder)
+	_, ok = wrapped.(storage.VoteLayoutConverter)
+	assert.True(t, ok)
+}
@@ -0,0 +1,38 @@
+package instrumented
+
+import (
+	"context"
+	"github.com/alex-pricope/simple-voting-system/storage"
+	"time"
+)
+
+// LedgerStorage times every call of the wrapped storage.LedgerStorage
+type LedgerStorage struct {
+	next     storage.LedgerStorage
+	table    string
+	recorder *Recorder
+}
+
+func NewLedgerStorage(next storage.LedgerStorage, table string, recorder *Recorder) *LedgerStorage {
+	return &LedgerStorage{next: next, table: table, recorder: recorder}
+}
+
+func (s *LedgerStorage) Append(ctx context.Context, event *storage.LedgerEvent) (err error) {
+	defer s.recorder.observe(s.table, "Append", time.Now(), &err)
+	return s.next.Append(ctx, event)
+}
+
+func (s *LedgerStorage) Query(ctx context.Context, filter storage.LedgerFilter) (_ []*storage.LedgerEvent, err error) {
+	defer s.recorder.observe(s.table, "Query", time.Now(), &err)
+	return s.next.Query(ctx, filter)
+}
+
+func (s *LedgerStorage) LockCode(ctx context.Context, code string) (_ *storage.LedgerLock, err error) {
+	defer s.recorder.observe(s.table, "LockCode", time.Now(), &err)
+	return s.next.LockCode(ctx, code)
+}
+
+func (s *LedgerStorage) UnlockCode(ctx context.Context, lock *storage.LedgerLock) (err error) {
+	defer s.recorder.observe(s.table, "UnlockCode", time.Now(), &err)
+	return s.next.UnlockCode(ctx, lock)
+}
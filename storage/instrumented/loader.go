@@ -0,0 +1,23 @@
+package instrumented
+
+import (
+	"context"
+	"github.com/alex-pricope/simple-voting-system/storage"
+	"time"
+)
+
+// EventLoader times every call of the wrapped storage.EventLoader
+type EventLoader struct {
+	next     storage.EventLoader
+	table    string
+	recorder *Recorder
+}
+
+func NewEventLoader(next storage.EventLoader, table string, recorder *Recorder) *EventLoader {
+	return &EventLoader{next: next, table: table, recorder: recorder}
+}
+
+func (s *EventLoader) LoadEvent(ctx context.Context) (_ *storage.EventData, err error) {
+	defer s.recorder.observe(s.table, "LoadEvent", time.Now(), &err)
+	return s.next.LoadEvent(ctx)
+}
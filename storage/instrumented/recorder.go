@@ -0,0 +1,99 @@
+// Package instrumented wraps storage implementations to time every call,
+// count errors and log slow calls, tagged by table and method.
+package instrumented
+
+import (
+	"github.com/alex-pricope/simple-voting-system/logging"
+	"sort"
+	"sync"
+	"time"
+)
+
+// OperationStats are the totals of one table/method pair
+type OperationStats struct {
+	Table   string        `json:"table"`
+	Method  string        `json:"method"`
+	Calls   int64         `json:"calls"`
+	Errors  int64         `json:"errors"`
+	Slow    int64         `json:"slow"`
+	Total   time.Duration `json:"totalNs"`
+	Max     time.Duration `json:"maxNs"`
+	Average time.Duration `json:"averageNs"`
+}
+
+type operation struct {
+	table  string
+	method string
+}
+
+// Recorder collects the stats of all wrapped storages. It is safe for concurrent use.
+type Recorder struct {
+	slowThreshold time.Duration
+
+	mu    sync.Mutex
+	stats map[operation]*OperationStats
+}
+
+// NewRecorder creates a Recorder logging calls slower than slowThreshold. Zero disables slow-call logging.
+func NewRecorder(slowThreshold time.Duration) *Recorder {
+	return &Recorder{
+		slowThreshold: slowThreshold,
+		stats:         make(map[operation]*OperationStats),
+	}
+}
+
+// observe is deferred by every wrapped method with the call start and a pointer to its error result
+func (r *Recorder) observe(table, method string, start time.Time, err *error) {
+	elapsed := time.Since(start)
+	failed := err != nil && *err != nil
+	slow := r.slowThreshold > 0 && elapsed >= r.slowThreshold
+
+	r.mu.Lock()
+	op := operation{table: table, method: method}
+	stats, ok := r.stats[op]
+	if !ok {
+		stats = &OperationStats{Table: table, Method: method}
+		r.stats[op] = stats
+	}
+	stats.Calls++
+	stats.Total += elapsed
+	stats.Max = max(stats.Max, elapsed)
+	if failed {
+		stats.Errors++
+	}
+	if slow {
+		stats.Slow++
+	}
+	r.mu.Unlock()
+
+	if slow {
+		logging.Log.Warnf("STORAGE: slow call %s.%s took %s (failed: %t)", table, method, elapsed, failed)
+	}
+}
+
+// Snapshot returns a copy of the current stats, ordered by table and method
+func (r *Recorder) Snapshot() []OperationStats {
+	r.mu.Lock()
+	snapshot := make([]OperationStats, 0, len(r.stats))
+	for _, stats := range r.stats {
+		s := *stats
+		s.Average = s.Total / time.Duration(s.Calls)
+		snapshot = append(snapshot, s)
+	}
+	r.mu.Unlock()
+
+	sort.Slice(snapshot, func(i, j int) bool {
+		if snapshot[i].Table != snapshot[j].Table {
+			return snapshot[i].Table < snapshot[j].Table
+		}
+		return snapshot[i].Method < snapshot[j].Method
+	})
+	return snapshot
+}
+
+// Reset clears all stats, e.g. before a voting round
+func (r *Recorder) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.stats = make(map[operation]*OperationStats)
+}
@@ -0,0 +1,38 @@
+package instrumented
+
+import (
+	"context"
+	"github.com/alex-pricope/simple-voting-system/storage"
+	"time"
+)
+
+// TallyStorage times every call of the wrapped storage.TallyStorage
+type TallyStorage struct {
+	next     storage.TallyStorage
+	table    string
+	recorder *Recorder
+}
+
+func NewTallyStorage(next storage.TallyStorage, table string, recorder *Recorder) *TallyStorage {
+	return &TallyStorage{next: next, table: table, recorder: recorder}
+}
+
+func (s *TallyStorage) Add(ctx context.Context, voterGroup string, votes []*storage.Vote) (err error) {
+	defer s.recorder.observe(s.table, "Add", time.Now(), &err)
+	return s.next.Add(ctx, voterGroup, votes)
+}
+
+func (s *TallyStorage) Subtract(ctx context.Context, voterGroup string, votes []*storage.Vote, ballots int) (err error) {
+	defer s.recorder.observe(s.table, "Subtract", time.Now(), &err)
+	return s.next.Subtract(ctx, voterGroup, votes, ballots)
+}
+
+func (s *TallyStorage) GetAll(ctx context.Context) (_ *storage.Tallies, err error) {
+	defer s.recorder.observe(s.table, "GetAll", time.Now(), &err)
+	return s.next.GetAll(ctx)
+}
+
+func (s *TallyStorage) Replace(ctx context.Context, tallies *storage.Tallies) (err error) {
+	defer s.recorder.observe(s.table, "Replace", time.Now(), &err)
+	return s.next.Replace(ctx, tallies)
+}
@@ -0,0 +1,43 @@
+package instrumented
+
+import (
+	"context"
+	"github.com/alex-pricope/simple-voting-system/storage"
+	"time"
+)
+
+// TeamStorage times every call of the wrapped storage.TeamStorage
+type TeamStorage struct {
+	next     storage.TeamStorage
+	table    string
+	recorder *Recorder
+}
+
+func NewTeamStorage(next storage.TeamStorage, table string, recorder *Recorder) *TeamStorage {
+	return &TeamStorage{next: next, table: table, recorder: recorder}
+}
+
+func (s *TeamStorage) Get(ctx context.Context, id int) (_ *storage.Team, err error) {
+	defer s.recorder.observe(s.table, "Get", time.Now(), &err)
+	return s.next.Get(ctx, id)
+}
+
+func (s *TeamStorage) GetAll(ctx context.Context) (_ []*storage.Team, err error) {
+	defer s.recorder.observe(s.table, "GetAll", time.Now(), &err)
+	return s.next.GetAll(ctx)
+}
+
+func (s *TeamStorage) Create(ctx context.Context, team *storage.Team) (err error) {
+	defer s.recorder.observe(s.table, "Create", time.Now(), &err)
+	return s.next.Create(ctx, team)
+}
+
+func (s *TeamStorage) Update(ctx context.Context, team *storage.Team) (err error) {
+	defer s.recorder.observe(s.table, "Update", time.Now(), &err)
+	return s.next.Update(ctx, team)
+}
+
+func (s *TeamStorage) Delete(ctx context.Context, id int) (err error) {
+	defer s.recorder.observe(s.table, "Delete", time.Now(), &err)
+	return s.next.Delete(ctx, id)
+}
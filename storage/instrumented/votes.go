@@ -0,0 +1,69 @@
+package instrumented
+
+import (
+	"context"
+	"github.com/alex-pricope/simple-voting-system/storage"
+	"time"
+)
+
+// VoteStorage times every call of the wrapped storage.VoteStorage
+type VoteStorage struct {
+	next     storage.VoteStorage
+	table    string
+	recorder *Recorder
+}
+
+// convertingVoteStorage keeps storage.VoteLayoutConverter visible through the wrapper
+type convertingVoteStorage struct {
+	*VoteStorage
+	converter storage.VoteLayoutConverter
+}
+
+// NewVoteStorage wraps next. If next is a storage.VoteLayoutConverter, so is the result.
+func NewVoteStorage(next storage.VoteStorage, table string, recorder *Recorder) storage.VoteStorage {
+	s := &VoteStorage{next: next, table: table, recorder: recorder}
+	if converter, ok := next.(storage.VoteLayoutConverter); ok {
+		return &convertingVoteStorage{VoteStorage: s, converter: converter}
+	}
+	return s
+}
+
+func (s *VoteStorage) GetAll(ctx context.Context) (_ []*storage.Vote, err error) {
+	defer s.recorder.observe(s.table, "GetAll", time.Now(), &err)
+	return s.next.GetAll(ctx)
+}
+
+func (s *VoteStorage) Create(ctx context.Context, vote *storage.Vote) (err error) {
+	defer s.recorder.observe(s.table, "Create", time.Now(), &err)
+	return s.next.Create(ctx, vote)
+}
+
+func (s *VoteStorage) GetByCode(ctx context.Context, code string) (_ []*storage.Vote, err error) {
+	defer s.recorder.observe(s.table, "GetByCode", time.Now(), &err)
+	return s.next.GetByCode(ctx, code)
+}
+
+func (s *VoteStorage) GetByTeam(ctx context.Context, teamID int) (_ []*storage.Vote, err error) {
+	defer s.recorder.observe(s.table, "GetByTeam", time.Now(), &err)
+	return s.next.GetByTeam(ctx, teamID)
+}
+
+func (s *VoteStorage) GetByCategory(ctx context.Context, categoryID int) (_ []*storage.Vote, err error) {
+	defer s.recorder.observe(s.table, "GetByCategory", time.Now(), &err)
+	return s.next.GetByCategory(ctx, categoryID)
+}
+
+func (s *VoteStorage) Delete(ctx context.Context, votes []*storage.Vote) (err error) {
+	defer s.recorder.observe(s.table, "Delete", time.Now(), &err)
+	return s.next.Delete(ctx, votes)
+}
+
+func (s *VoteStorage) DeleteAll(ctx context.Context) (err error) {
+	defer s.recorder.observe(s.table, "DeleteAll", time.Now(), &err)
+	return s.next.DeleteAll(ctx)
+}
+
+func (s *convertingVoteStorage) ConvertRatingRows(ctx context.Context) (_ int, err error) {
+	defer s.recorder.observe(s.table, "ConvertRatingRows", time.Now(), &err)
+	return s.converter.ConvertRatingRows(ctx)
+}
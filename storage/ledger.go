@@ -0,0 +1,428 @@
+package storage
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/alex-pricope/simple-voting-system/logging"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Types of the ledger events
+const (
+	LedgerBallotSubmitted  = "ballot_submitted"  // A code voted: Code, VoterGroup and Votes
+	LedgerBallotImported   = "ballot_imported"   // A code and its votes were loaded by an admin: Code, VoterGroup, Votes and Used
+	LedgerCodeReset        = "code_reset"        // The code was marked unused, its votes stay
+	LedgerVotesInvalidated = "votes_invalidated" // Every vote of the code was deleted, the code stays used
+	LedgerVotesDeleted     = "votes_deleted"     // The listed votes were deleted, with a team or category
+	LedgerAllVotesDeleted  = "all_votes_deleted" // Every vote of the event was deleted
+	LedgerCodeDeleted      = "code_deleted"      // The code was deleted, its votes stay without a voter group
+)
+
+// ledgerPartition is the PK of every ledger item, like the audit log
+const ledgerPartition = "ledger"
+
+// MaxLedgerEventVotes bounds the votes of one event, so that its item stays well under the 400KB of DynamoDB
+const MaxLedgerEventVotes = 1000
+
+// ledgerLockPartition is the PK of the lock item of every code, next to the events
+const ledgerLockPartition = "lock"
+
+// ledgerLockLease bounds how long a lock is held, so a crashed holder does not block its code for good
+const ledgerLockLease = 30 * time.Second
+
+// ledgerLockWait bounds how long LockCode waits for another change of the code to finish
+const ledgerLockWait = 5 * time.Second
+
+// ledgerLockPoll is the first delay before LockCode tries a locked code again, it grows with every attempt
+const ledgerLockPoll = 20 * time.Millisecond
+
+// ErrCodeBusy is returned by LockCode when another change of the code did not finish in time
+var ErrCodeBusy = fmt.Errorf("%w: another change of this code is in progress", ErrConflict)
+
+// LedgerStorage is the append-only log of the changes to the votes of an event.
+// There is no way to change or delete an event.
+type LedgerStorage interface {
+	Append(ctx context.Context, event *LedgerEvent) error
+	// Query returns the events matching filter, oldest first
+	Query(ctx context.Context, filter LedgerFilter) ([]*LedgerEvent, error)
+	// LockCode waits until no other change of code is in progress, up to a few seconds, and locks it.
+	// The Seq of the lock is higher than that of every earlier lock of the code, so the events of changes
+	// stored under it replay in the order the changes were stored, whatever their timestamps.
+	LockCode(ctx context.Context, code string) (*LedgerLock, error)
+	// UnlockCode releases a lock once its change is stored and its events appended
+	UnlockCode(ctx context.Context, lock *LedgerLock) error
+}
+
+// LedgerLock is a lock on the changes to one code, see LedgerStorage.LockCode
+type LedgerLock struct {
+	Code  string
+	Seq   int64
+	Token string // Tells the holder apart, so an expired lock taken over by another change is not released
+}
+
+// LedgerFilter selects ledger events. Zero fields match everything.
+type LedgerFilter struct {
+	From time.Time // Inclusive
+	To   time.Time // Exclusive
+	Code string    // Also matches the events deleting votes of the code, or every vote
+}
+
+// Matches reports whether event passes every set field of the filter
+func (f LedgerFilter) Matches(event *LedgerEvent) bool {
+	switch {
+	case !f.From.IsZero() && event.Timestamp.Before(f.From):
+		return false
+	case !f.To.IsZero() && !event.Timestamp.Before(f.To):
+		return false
+	case f.Code != "" && !event.affects(f.Code):
+		return false
+	}
+	return true
+}
+
+// IDRange returns the range of event IDs the time range of the filter can match, see AuditFilter.IDRange
+func (f LedgerFilter) IDRange() (from, to string) {
+	return idRange(f.From, f.To)
+}
+
+// Split returns the event as events of at most MaxLedgerEventVotes votes each, with the same type, code and note.
+// Applying them in any order gives the projection of the whole event.
+func (e *LedgerEvent) Split() []*LedgerEvent {
+	if len(e.Votes) <= MaxLedgerEventVotes {
+		return []*LedgerEvent{e}
+	}
+	events := make([]*LedgerEvent, 0, (len(e.Votes)+MaxLedgerEventVotes-1)/MaxLedgerEventVotes)
+	for i := 0; i < len(e.Votes); i += MaxLedgerEventVotes {
+		part := *e
+		part.Votes = e.Votes[i:min(i+MaxLedgerEventVotes, len(e.Votes))]
+		events = append(events, &part)
+	}
+	return events
+}
+
+// affects reports whether the event changes the votes or the state of code
+func (e *LedgerEvent) affects(code string) bool {
+	switch e.Type {
+	case LedgerAllVotesDeleted:
+		return true
+	case LedgerVotesDeleted:
+		for _, v := range e.Votes {
+			if v.Code == code {
+				return true
+			}
+		}
+		return false
+	}
+	return e.Code == code
+}
+
+// LedgerProjection is the state of the votes replayed from the ledger: the stored votes and,
+// for every code that voted, its voter group and whether it is used
+type LedgerProjection struct {
+	Events int // Events applied
+	votes  map[string]*Vote
+	codes  map[string]*VotingCode
+}
+
+func NewLedgerProjection() *LedgerProjection {
+	return &LedgerProjection{votes: make(map[string]*Vote), codes: make(map[string]*VotingCode)}
+}
+
+// ReplayLedger applies the events to an empty projection, in the order of OrderLedger
+func ReplayLedger(events []*LedgerEvent) *LedgerProjection {
+	p := NewLedgerProjection()
+	for _, event := range OrderLedger(events) {
+		p.Apply(event)
+	}
+	return p
+}
+
+// OrderLedger returns the events, ordered by ID, in the order their changes were stored.
+// The events of a code that carry a Seq are put in Seq order, on the positions they take among the events;
+// events of one change share its Seq and keep their order. The others, such as the events spanning codes,
+// stay in time order.
+func OrderLedger(events []*LedgerEvent) []*LedgerEvent {
+	ordered := append([]*LedgerEvent(nil), events...)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return ordered[i].ID < ordered[j].ID
+	})
+
+	positions := make(map[string][]int)
+	for i, event := range ordered {
+		if event.Code != "" && event.Seq > 0 {
+			positions[event.Code] = append(positions[event.Code], i)
+		}
+	}
+	for _, slots := range positions {
+		sequenced := make([]*LedgerEvent, 0, len(slots))
+		for _, i := range slots {
+			sequenced = append(sequenced, ordered[i])
+		}
+		sort.SliceStable(sequenced, func(i, j int) bool {
+			return sequenced[i].Seq < sequenced[j].Seq
+		})
+		for k, i := range slots {
+			ordered[i] = sequenced[k]
+		}
+	}
+	return ordered
+}
+
+// Apply changes the projection the way the event changed the storage. Unknown types are skipped.
+func (p *LedgerProjection) Apply(event *LedgerEvent) {
+	switch event.Type {
+	case LedgerBallotSubmitted, LedgerBallotImported:
+		p.codes[event.Code] = &VotingCode{
+			Code:     event.Code,
+			Category: event.VoterGroup,
+			Used:     event.Type == LedgerBallotSubmitted || event.Used,
+		}
+		for _, v := range event.Votes {
+			vote := *v
+			p.votes[ledgerVoteKey(&vote)] = &vote
+		}
+	case LedgerCodeReset:
+		if code, ok := p.codes[event.Code]; ok {
+			code.Used = false
+		}
+	case LedgerVotesInvalidated:
+		for key, v := range p.votes {
+			if v.Code == event.Code {
+				delete(p.votes, key)
+			}
+		}
+	case LedgerVotesDeleted:
+		for _, v := range event.Votes {
+			delete(p.votes, ledgerVoteKey(v))
+		}
+	case LedgerAllVotesDeleted:
+		clear(p.votes)
+	case LedgerCodeDeleted:
+		delete(p.codes, event.Code)
+	default:
+		logging.Log.Warnf("LEDGER: skipping event %s of unknown type %q", event.ID, event.Type)
+		return
+	}
+	p.Events++
+}
+
+// Votes returns the replayed votes ordered by code and sort key, like a scan of the Votes table
+func (p *LedgerProjection) Votes() []*Vote {
+	votes := make([]*Vote, 0, len(p.votes))
+	for _, v := range p.votes {
+		vote := *v
+		votes = append(votes, &vote)
+	}
+	sortVotes(votes)
+	return votes
+}
+
+// Codes returns the codes that voted and were not deleted since, ordered by code.
+// Their Category is the voter group they voted with, only Code, Category and Used are set.
+func (p *LedgerProjection) Codes() []*VotingCode {
+	codes := make([]*VotingCode, 0, len(p.codes))
+	for _, c := range p.codes {
+		code := *c
+		codes = append(codes, &code)
+	}
+	sort.Slice(codes, func(i, j int) bool {
+		return codes[i].Code < codes[j].Code
+	})
+	return codes
+}
+
+func ledgerVoteKey(v *Vote) string {
+	return v.Code + "#" + v.SortKey
+}
+
+type DynamoLedgerStorage struct {
+	Client    *dynamodb.Client
+	TableName string
+}
+
+func (s *DynamoLedgerStorage) Append(ctx context.Context, event *LedgerEvent) error {
+	item, err := attributevalue.MarshalMap(event)
+	if err != nil {
+		logging.Log.Errorf("LEDGER: failed to marshal ledger event: %v", err)
+		return err
+	}
+	item["PK"] = &types.AttributeValueMemberS{Value: ledgerPartition}
+
+	_, err = s.Client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName:           &s.TableName,
+		Item:                item,
+		ConditionExpression: aws.String("attribute_not_exists(SK)"),
+	})
+	if err != nil {
+		logging.Log.Errorf("LEDGER: failed to append event %s: %v", event.ID, err)
+		return classify(err)
+	}
+	return nil
+}
+
+// Query reads the time range of the filter from the ledger partition and applies the code in memory
+func (s *DynamoLedgerStorage) Query(ctx context.Context, filter LedgerFilter) ([]*LedgerEvent, error) {
+	from, to := filter.IDRange()
+	items, err := queryAll(ctx, s.Client, &dynamodb.QueryInput{
+		TableName:              &s.TableName,
+		KeyConditionExpression: aws.String("PK = :pk AND SK BETWEEN :from AND :to"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":pk":   &types.AttributeValueMemberS{Value: ledgerPartition},
+			":from": &types.AttributeValueMemberS{Value: from},
+			":to":   &types.AttributeValueMemberS{Value: to},
+		},
+	})
+	if err != nil {
+		logging.Log.Errorf("LEDGER: query failed: %v", err)
+		return nil, classify(err)
+	}
+
+	var events []*LedgerEvent
+	if err := attributevalue.UnmarshalListOfMaps(items, &events); err != nil {
+		logging.Log.Errorf("LEDGER: failed to unmarshal ledger events: %v", err)
+		return nil, err
+	}
+	matching := events[:0]
+	for _, event := range events {
+		if filter.Matches(event) {
+			matching = append(matching, event)
+		}
+	}
+	return matching, nil
+}
+
+// LockCode takes the lock item of the code, unless another change holds it and its lease has not run out,
+// and bumps the Seq of the code in the same update. It tries again, a little later each time, while the code is locked.
+func (s *DynamoLedgerStorage) LockCode(ctx context.Context, code string) (*LedgerLock, error) {
+	lock := &LedgerLock{Code: code, Token: newLockToken()}
+	deadline := time.Now().Add(ledgerLockWait)
+	for attempt := 1; ; attempt++ {
+		now := time.Now()
+		out, err := s.Client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+			TableName:           &s.TableName,
+			Key:                 ledgerLockKey(code),
+			UpdateExpression:    aws.String("SET Holder = :token, Expires = :expires ADD Seq :one"),
+			ConditionExpression: aws.String("attribute_not_exists(Holder) OR Expires < :now"),
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":token":   &types.AttributeValueMemberS{Value: lock.Token},
+				":expires": &types.AttributeValueMemberN{Value: strconv.FormatInt(now.Add(ledgerLockLease).UnixMilli(), 10)},
+				":now":     &types.AttributeValueMemberN{Value: strconv.FormatInt(now.UnixMilli(), 10)},
+				":one":     &types.AttributeValueMemberN{Value: "1"},
+			},
+			ReturnValues: types.ReturnValueUpdatedNew,
+		})
+		if err == nil {
+			if err := attributevalue.Unmarshal(out.Attributes["Seq"], &lock.Seq); err != nil {
+				logging.Log.Errorf("LEDGER: failed to read the sequence of code %s: %v", code, err)
+				return nil, err
+			}
+			return lock, nil
+		}
+
+		var cce *types.ConditionalCheckFailedException
+		if !errors.As(err, &cce) {
+			logging.Log.Errorf("LEDGER: failed to lock code %s: %v", code, err)
+			return nil, classify(err)
+		}
+		if time.Now().After(deadline) {
+			logging.Log.Warnf("LEDGER: code %s still locked after %s", code, ledgerLockWait)
+			return nil, ErrCodeBusy
+		}
+		select {
+		case <-ctx.Done():
+			return nil, classify(ctx.Err())
+		case <-time.After(time.Duration(attempt) * ledgerLockPoll):
+		}
+	}
+}
+
+// UnlockCode removes the holder from the lock item, only if the lock is still ours
+func (s *DynamoLedgerStorage) UnlockCode(ctx context.Context, lock *LedgerLock) error {
+	_, err := s.Client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:           &s.TableName,
+		Key:                 ledgerLockKey(lock.Code),
+		UpdateExpression:    aws.String("REMOVE Holder, Expires"),
+		ConditionExpression: aws.String("Holder = :token"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":token": &types.AttributeValueMemberS{Value: lock.Token},
+		},
+	})
+	var cce *types.ConditionalCheckFailedException
+	if errors.As(err, &cce) {
+		logging.Log.Warnf("LEDGER: lock of code %s expired before it was released", lock.Code)
+		return nil
+	}
+	if err != nil {
+		logging.Log.Errorf("LEDGER: failed to unlock code %s: %v", lock.Code, err)
+		return classify(err)
+	}
+	return nil
+}
+
+func ledgerLockKey(code string) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		"PK": &types.AttributeValueMemberS{Value: ledgerLockPartition},
+		"SK": &types.AttributeValueMemberS{Value: code},
+	}
+}
+
+// newLockToken returns a random token telling one lock holder from another
+func newLockToken() string {
+	token := make([]byte, 8)
+	_, _ = rand.Read(token)
+	return hex.EncodeToString(token)
+}
+
+// CodeLocks serializes the changes to each code within one process, for the ledger storages that
+// only ever have one instance. The zero value is ready to use.
+type CodeLocks struct {
+	mu   sync.Mutex
+	held map[string]chan struct{}
+}
+
+// Lock waits until code is free, up to a few seconds, and takes it
+func (l *CodeLocks) Lock(ctx context.Context, code string) error {
+	timer := time.NewTimer(ledgerLockWait)
+	defer timer.Stop()
+	for {
+		l.mu.Lock()
+		if l.held == nil {
+			l.held = make(map[string]chan struct{})
+		}
+		released, busy := l.held[code]
+		if !busy {
+			l.held[code] = make(chan struct{})
+			l.mu.Unlock()
+			return nil
+		}
+		l.mu.Unlock()
+
+		select {
+		case <-released:
+		case <-timer.C:
+			return ErrCodeBusy
+		case <-ctx.Done():
+			return classify(ctx.Err())
+		}
+	}
+}
+
+// Unlock frees code for the next change
+func (l *CodeLocks) Unlock(code string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if released, ok := l.held[code]; ok {
+		close(released)
+		delete(l.held, code)
+	}
+}
@@ -0,0 +1,147 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"github.com/alex-pricope/simple-voting-system/logging"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func ledgerTestVotes(code string, ratings ...int) []*Vote {
+	votes := make([]*Vote, 0, len(ratings))
+	for i, rating := range ratings {
+		votes = append(votes, &Vote{Code: code, SortKey: VoteSortKey(1, i+1), CategoryID: 1, TeamID: i + 1, Rating: rating})
+	}
+	return votes
+}
+
+func TestReplayLedger(t *testing.T) {
+	logging.Log = logrus.New()
+	events := []*LedgerEvent{
+		{Type: LedgerBallotSubmitted, Code: "AAAAA", VoterGroup: "grand_jury", Votes: ledgerTestVotes("AAAAA", 5, 3)},
+		{Type: LedgerBallotSubmitted, Code: "BBBBB", VoterGroup: "general_public", Votes: ledgerTestVotes("BBBBB", 4, 2)},
+		{Type: LedgerBallotImported, Code: "CCCCC", VoterGroup: "other_team", Votes: ledgerTestVotes("CCCCC", 1)},
+		{Type: LedgerCodeReset, Code: "AAAAA"},
+		{Type: LedgerVotesInvalidated, Code: "BBBBB"},
+		{Type: LedgerVotesDeleted, Votes: ledgerTestVotes("AAAAA", 5)},
+		{Type: "renamed_team"},
+	}
+
+	p := ReplayLedger(events)
+	assert.Equal(t, 6, p.Events)
+	assert.Equal(t, []*Vote{
+		{Code: "AAAAA", SortKey: VoteSortKey(1, 2), CategoryID: 1, TeamID: 2, Rating: 3},
+		{Code: "CCCCC", SortKey: VoteSortKey(1, 1), CategoryID: 1, TeamID: 1, Rating: 1},
+	}, p.Votes())
+	assert.Equal(t, []*VotingCode{
+		{Code: "AAAAA", Category: "grand_jury"},
+		{Code: "BBBBB", Category: "general_public", Used: true},
+		{Code: "CCCCC", Category: "other_team"},
+	}, p.Codes())
+
+	t.Run("Deleted codes keep their votes without a voter group", func(t *testing.T) {
+		p.Apply(&LedgerEvent{Type: LedgerCodeDeleted, Code: "CCCCC"})
+		assert.Len(t, p.Votes(), 2)
+		assert.Len(t, p.Codes(), 2)
+		assert.Equal(t, "", BuildTallies(p.Votes(), p.Codes()).Scores[0].VoterGroup)
+	})
+
+	t.Run("Deleting every vote keeps the codes", func(t *testing.T) {
+		p.Apply(&LedgerEvent{Type: LedgerAllVotesDeleted})
+		assert.Empty(t, p.Votes())
+		assert.Len(t, p.Codes(), 2)
+	})
+
+	t.Run("The projection does not share votes with the events", func(t *testing.T) {
+		replay := ReplayLedger(events[:1])
+		events[0].Votes[0].Rating = 1
+		assert.Equal(t, 5, replay.Votes()[0].Rating)
+
+		replay.Votes()[0].Rating = 2
+		assert.Equal(t, 5, replay.Votes()[0].Rating)
+	})
+}
+
+func TestOrderLedger(t *testing.T) {
+	logging.Log = logrus.New()
+	start := time.Date(2025, 3, 1, 9, 0, 0, 0, time.UTC)
+	at := func(seconds int) string { return NewAuditID(start.Add(time.Duration(seconds) * time.Second)) }
+
+	// The reset was stored before the ballot, but its event was appended last
+	ballot := &LedgerEvent{ID: at(1), Type: LedgerBallotSubmitted, Code: "AAAAA", Seq: 2, VoterGroup: "grand_jury", Votes: ledgerTestVotes("AAAAA", 5)}
+	other := &LedgerEvent{ID: at(2), Type: LedgerBallotSubmitted, Code: "BBBBB", Seq: 1, VoterGroup: "grand_jury", Votes: ledgerTestVotes("BBBBB", 4)}
+	deleted := &LedgerEvent{ID: at(3), Type: LedgerVotesDeleted, Votes: ledgerTestVotes("BBBBB", 4)}
+	reset := &LedgerEvent{ID: at(4), Type: LedgerCodeReset, Code: "AAAAA", Seq: 1}
+
+	ordered := OrderLedger([]*LedgerEvent{deleted, reset, other, ballot})
+	assert.Equal(t, []*LedgerEvent{reset, other, deleted, ballot}, ordered,
+		"the events of a code follow its sequence, the others stay in time order")
+
+	p := ReplayLedger([]*LedgerEvent{ballot, other, deleted, reset})
+	assert.Equal(t, []*VotingCode{
+		{Code: "AAAAA", Category: "grand_jury", Used: true},
+		{Code: "BBBBB", Category: "grand_jury", Used: true},
+	}, p.Codes(), "the ballot stored after the reset keeps its code used")
+	assert.Len(t, p.Votes(), 1)
+}
+
+func TestCodeLocks(t *testing.T) {
+	var locks CodeLocks
+	ctx := context.Background()
+	require.NoError(t, locks.Lock(ctx, "AAAAA"))
+	require.NoError(t, locks.Lock(ctx, "BBBBB"), "codes are locked on their own")
+
+	waiting, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	assert.Error(t, locks.Lock(waiting, "AAAAA"), "a locked code waits")
+
+	locked := make(chan error)
+	go func() { locked <- locks.Lock(ctx, "AAAAA") }()
+	locks.Unlock("AAAAA")
+	assert.NoError(t, <-locked, "an unlocked code goes to the next change")
+}
+
+func TestLedgerFilter(t *testing.T) {
+	start := time.Date(2025, 3, 1, 9, 0, 0, 0, time.UTC)
+	ballot := &LedgerEvent{Timestamp: start, Type: LedgerBallotSubmitted, Code: "AAAAA"}
+	deleted := &LedgerEvent{Timestamp: start.Add(time.Hour), Type: LedgerVotesDeleted, Votes: ledgerTestVotes("AAAAA", 5)}
+	all := &LedgerEvent{Timestamp: start.Add(2 * time.Hour), Type: LedgerAllVotesDeleted}
+
+	byCode := LedgerFilter{Code: "AAAAA"}
+	assert.True(t, byCode.Matches(ballot))
+	assert.True(t, byCode.Matches(deleted))
+	assert.True(t, byCode.Matches(all))
+	assert.False(t, LedgerFilter{Code: "BBBBB"}.Matches(deleted))
+
+	assert.False(t, LedgerFilter{To: start.Add(time.Hour)}.Matches(deleted))
+	assert.True(t, LedgerFilter{From: start.Add(time.Hour)}.Matches(deleted))
+	from, to := LedgerFilter{From: start}.IDRange()
+	assert.Less(t, from, NewAuditID(start.Add(time.Nanosecond)))
+	assert.Equal(t, "~", to)
+}
+
+func TestLedgerEventSplit(t *testing.T) {
+	event := &LedgerEvent{Type: LedgerVotesDeleted, Note: "cascade"}
+	assert.Equal(t, []*LedgerEvent{event}, event.Split())
+
+	for i := 0; i < 2*MaxLedgerEventVotes+1; i++ {
+		event.Votes = append(event.Votes, &Vote{Code: fmt.Sprintf("C%04d", i), SortKey: VoteSortKey(1, 1), CategoryID: 1, TeamID: 1, Rating: 3})
+	}
+	parts := event.Split()
+	require.Len(t, parts, 3)
+	assert.Len(t, parts[0].Votes, MaxLedgerEventVotes)
+	assert.Len(t, parts[2].Votes, 1)
+	assert.Equal(t, "cascade", parts[2].Note)
+
+	// Applying the parts in any order deletes every vote
+	projection := NewLedgerProjection()
+	projection.Apply(&LedgerEvent{Type: LedgerBallotImported, Code: "C0000", Votes: event.Votes})
+	for _, i := range []int{2, 0, 1} {
+		projection.Apply(parts[i])
+	}
+	assert.Empty(t, projection.Votes())
+}
@@ -0,0 +1,45 @@
+package memory
+
+import (
+	"context"
+	"github.com/alex-pricope/simple-voting-system/storage"
+	"sort"
+	"sync"
+)
+
+// AuditStorage is an in-memory storage.AuditStorage, entries are kept in ID order.
+type AuditStorage struct {
+	mu      sync.RWMutex
+	entries []storage.AuditEntry
+}
+
+func NewAuditStorage() *AuditStorage {
+	return &AuditStorage{}
+}
+
+func (s *AuditStorage) Append(_ context.Context, entry *storage.AuditEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := sort.Search(len(s.entries), func(i int) bool { return s.entries[i].ID >= entry.ID })
+	if i < len(s.entries) && s.entries[i].ID == entry.ID {
+		return storage.ErrAlreadyExists
+	}
+	s.entries = append(s.entries, storage.AuditEntry{})
+	copy(s.entries[i+1:], s.entries[i:])
+	s.entries[i] = *entry
+	return nil
+}
+
+func (s *AuditStorage) Query(_ context.Context, filter storage.AuditFilter) ([]*storage.AuditEntry, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var entries []*storage.AuditEntry
+	for _, entry := range s.entries {
+		if filter.Matches(&entry) {
+			entries = append(entries, &entry)
+		}
+	}
+	return entries, nil
+}
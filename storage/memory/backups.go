@@ -0,0 +1,32 @@
+package memory
+
+import (
+	"context"
+	"sync"
+)
+
+// BackupStorage is an in-memory storage.BackupStorage keyed by archive key.
+type BackupStorage struct {
+	mu    sync.RWMutex
+	items map[string][]byte
+}
+
+func NewBackupStorage() *BackupStorage {
+	return &BackupStorage{items: make(map[string][]byte)}
+}
+
+func (s *BackupStorage) Put(_ context.Context, key string, archive []byte) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.items[key] = append([]byte(nil), archive...)
+	return "memory://" + key, nil
+}
+
+// Get returns the archive written under key, nil if there is none
+func (s *BackupStorage) Get(key string) []byte {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.items[key]
+}
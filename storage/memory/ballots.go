@@ -0,0 +1,45 @@
+package memory
+
+import (
+	"context"
+	"github.com/alex-pricope/simple-voting-system/storage"
+)
+
+// BallotStorage is an in-memory storage.BallotStorage that writes to the given codes and votes storages
+// while holding both locks, so a submission is all-or-nothing.
+type BallotStorage struct {
+	codes *VotingCodesStorage
+	votes *VoteStorage
+}
+
+func NewBallotStorage(codes *VotingCodesStorage, votes *VoteStorage) *BallotStorage {
+	return &BallotStorage{codes: codes, votes: votes}
+}
+
+func (s *BallotStorage) Submit(_ context.Context, code string, votes []*storage.Vote) error {
+	s.codes.mu.Lock()
+	defer s.codes.mu.Unlock()
+	s.votes.mu.Lock()
+	defer s.votes.mu.Unlock()
+
+	vc, ok := s.codes.items[code]
+	if !ok || vc.Used {
+		return storage.ErrCodeAlreadyUsed
+	}
+
+	seen := make(map[voteKey]bool, len(votes))
+	for _, v := range votes {
+		key := voteKey{code: v.Code, sortKey: v.SortKey}
+		if _, exists := s.votes.items[key]; exists || seen[key] {
+			return storage.ErrAlreadyExists
+		}
+		seen[key] = true
+	}
+
+	for _, v := range votes {
+		s.votes.items[voteKey{code: v.Code, sortKey: v.SortKey}] = *v
+	}
+	vc.Used = true
+	s.codes.items[code] = vc
+	return nil
+}
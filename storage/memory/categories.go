@@ -0,0 +1,80 @@
+package memory
+
+import (
+	"context"
+	"github.com/alex-pricope/simple-voting-system/storage"
+	"sort"
+	"sync"
+)
+
+// VotingCategoryStorage is an in-memory storage.VotingCategoryStorage keyed by category ID.
+type VotingCategoryStorage struct {
+	mu    sync.RWMutex
+	items map[int]storage.VotingCategory
+}
+
+func NewVotingCategoryStorage() *VotingCategoryStorage {
+	return &VotingCategoryStorage{items: make(map[int]storage.VotingCategory)}
+}
+
+func (s *VotingCategoryStorage) Get(_ context.Context, id int) (*storage.VotingCategory, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	category, ok := s.items[id]
+	if !ok {
+		return nil, storage.ErrNotFound
+	}
+	return &category, nil
+}
+
+func (s *VotingCategoryStorage) GetAll(_ context.Context) ([]*storage.VotingCategory, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	categories := make([]*storage.VotingCategory, 0, len(s.items))
+	for _, c := range s.items {
+		category := c
+		categories = append(categories, &category)
+	}
+	sort.Slice(categories, func(i, j int) bool {
+		return categories[i].ID < categories[j].ID
+	})
+	return categories, nil
+}
+
+func (s *VotingCategoryStorage) Create(_ context.Context, category *storage.VotingCategory) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.items[category.ID]; ok {
+		return storage.ErrAlreadyExists
+	}
+	category.Version = 1
+	s.items[category.ID] = *category
+	return nil
+}
+
+func (s *VotingCategoryStorage) Update(_ context.Context, category *storage.VotingCategory) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.items[category.ID]
+	if !ok {
+		return storage.ErrNotFound
+	}
+	if stored.Version != category.Version {
+		return storage.ErrVersionConflict
+	}
+	category.Version++
+	s.items[category.ID] = *category
+	return nil
+}
+
+func (s *VotingCategoryStorage) Delete(_ context.Context, id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.items, id)
+	return nil
+}
@@ -0,0 +1,167 @@
+package memory
+
+import (
+	"context"
+	"github.com/alex-pricope/simple-voting-system/storage"
+	"sort"
+	"sync"
+	"time"
+)
+
+// VotingCodesStorage is an in-memory storage.VotingCodeStorage keyed by code.
+type VotingCodesStorage struct {
+	mu    sync.RWMutex
+	items map[string]storage.VotingCode
+}
+
+func NewVotingCodesStorage() *VotingCodesStorage {
+	return &VotingCodesStorage{items: make(map[string]storage.VotingCode)}
+}
+
+func (s *VotingCodesStorage) Get(_ context.Context, code string) (*storage.VotingCode, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	vc, ok := s.items[code]
+	if !ok {
+		return nil, storage.ErrNotFound
+	}
+	return copyCode(vc), nil
+}
+
+func (s *VotingCodesStorage) GetAll(_ context.Context) ([]*storage.VotingCode, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	codes := make([]*storage.VotingCode, 0, len(s.items))
+	for _, vc := range s.items {
+		codes = append(codes, copyCode(vc))
+	}
+	sort.Slice(codes, func(i, j int) bool {
+		return codes[i].Code < codes[j].Code
+	})
+	return codes, nil
+}
+
+func (s *VotingCodesStorage) GetPage(ctx context.Context, limit int, next string) ([]*storage.VotingCode, string, error) {
+	var after string
+	if next != "" {
+		var err error
+		if after, err = storage.DecodePageToken(next); err != nil {
+			return nil, "", err
+		}
+	}
+
+	codes, _ := s.GetAll(ctx)
+	start := sort.Search(len(codes), func(i int) bool {
+		return codes[i].Code > after
+	})
+	end := min(start+limit, len(codes))
+
+	var nextToken string
+	if end < len(codes) {
+		nextToken = storage.EncodePageToken(codes[end-1].Code)
+	}
+	return codes[start:end], nextToken, nil
+}
+
+func (s *VotingCodesStorage) Put(_ context.Context, code *storage.VotingCode) error {
+	if code.CreatedAt.IsZero() {
+		code.CreatedAt = time.Now().UTC()
+	}
+	code.Used = false
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.items[code.Code]; ok {
+		return storage.ErrAlreadyExists
+	}
+	s.items[code.Code] = *copyCode(*code)
+	return nil
+}
+
+func (s *VotingCodesStorage) PutBatch(_ context.Context, codes []*storage.VotingCode) ([]*storage.VotingCode, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored := make([]*storage.VotingCode, 0, len(codes))
+	for _, code := range codes {
+		if _, ok := s.items[code.Code]; ok {
+			continue
+		}
+		if code.CreatedAt.IsZero() {
+			code.CreatedAt = time.Now().UTC()
+		}
+		code.Used = false
+		s.items[code.Code] = *copyCode(*code)
+		stored = append(stored, code)
+	}
+	return stored, nil
+}
+
+func (s *VotingCodesStorage) Overwrite(_ context.Context, code *storage.VotingCode) error {
+	if code.CreatedAt.IsZero() {
+		code.CreatedAt = time.Now().UTC()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.items[code.Code] = *copyCode(*code)
+	return nil
+}
+
+func (s *VotingCodesStorage) MarkUnused(_ context.Context, code string) error {
+	s.setUsed(code, false)
+	return nil
+}
+
+func (s *VotingCodesStorage) MarkUsed(_ context.Context, code string) error {
+	s.setUsed(code, true)
+	return nil
+}
+
+// setUsed mirrors DynamoDB UpdateItem, which creates the item when the key does not exist.
+func (s *VotingCodesStorage) setUsed(code string, used bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	vc := s.items[code]
+	vc.Code = code
+	vc.Used = used
+	s.items[code] = vc
+}
+
+func (s *VotingCodesStorage) SetExpiry(_ context.Context, code string, expiresAt *time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	vc, ok := s.items[code]
+	if !ok {
+		return storage.ErrNotFound
+	}
+	vc.ExpiresAt = expiresAt
+	s.items[code] = *copyCode(vc)
+	return nil
+}
+
+func (s *VotingCodesStorage) Delete(_ context.Context, code string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.items, code)
+	return nil
+}
+
+func copyCode(vc storage.VotingCode) *storage.VotingCode {
+	if vc.TeamID != nil {
+		teamID := *vc.TeamID
+		vc.TeamID = &teamID
+	}
+	if vc.ExpiresAt != nil {
+		expiresAt := *vc.ExpiresAt
+		vc.ExpiresAt = &expiresAt
+	}
+	return &vc
+}
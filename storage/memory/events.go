@@ -0,0 +1,67 @@
+package memory
+
+import (
+	"context"
+	"github.com/alex-pricope/simple-voting-system/storage"
+	"sort"
+	"sync"
+)
+
+// EventStorage is an in-memory storage.EventStorage keyed by event ID.
+type EventStorage struct {
+	mu    sync.RWMutex
+	items map[string]storage.Event
+}
+
+func NewEventStorage() *EventStorage {
+	return &EventStorage{items: make(map[string]storage.Event)}
+}
+
+func (s *EventStorage) Get(_ context.Context, id string) (*storage.Event, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	event, ok := s.items[id]
+	if !ok {
+		return nil, storage.ErrNotFound
+	}
+	return &event, nil
+}
+
+func (s *EventStorage) GetAll(_ context.Context) ([]*storage.Event, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	events := make([]*storage.Event, 0, len(s.items))
+	for _, event := range s.items {
+		events = append(events, &event)
+	}
+	sort.Slice(events, func(i, j int) bool {
+		return events[i].ID < events[j].ID
+	})
+	return events, nil
+}
+
+func (s *EventStorage) Create(_ context.Context, event *storage.Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.items[event.ID]; ok {
+		return storage.ErrAlreadyExists
+	}
+	s.items[event.ID] = *event
+	return nil
+}
+
+func (s *EventStorage) SetArchived(_ context.Context, id string, archived bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	event, ok := s.items[id]
+	if !ok {
+		return storage.ErrNotFound
+	}
+	event.Archived = archived
+	s.items[id] = event
+	return nil
+}
@@ -0,0 +1,74 @@
+package memory
+
+import (
+	"context"
+	"github.com/alex-pricope/simple-voting-system/storage"
+	"sort"
+	"sync"
+)
+
+// LedgerStorage is an in-memory storage.LedgerStorage, events are kept in ID order.
+type LedgerStorage struct {
+	mu     sync.RWMutex
+	events []storage.LedgerEvent
+	seqs   map[string]int64
+	locks  storage.CodeLocks
+}
+
+func NewLedgerStorage() *LedgerStorage {
+	return &LedgerStorage{seqs: make(map[string]int64)}
+}
+
+func (s *LedgerStorage) Append(_ context.Context, event *storage.LedgerEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := sort.Search(len(s.events), func(i int) bool { return s.events[i].ID >= event.ID })
+	if i < len(s.events) && s.events[i].ID == event.ID {
+		return storage.ErrAlreadyExists
+	}
+	s.events = append(s.events, storage.LedgerEvent{})
+	copy(s.events[i+1:], s.events[i:])
+	s.events[i] = copyLedgerEvent(event)
+	return nil
+}
+
+func (s *LedgerStorage) Query(_ context.Context, filter storage.LedgerFilter) ([]*storage.LedgerEvent, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var events []*storage.LedgerEvent
+	for _, event := range s.events {
+		if filter.Matches(&event) {
+			event = copyLedgerEvent(&event)
+			events = append(events, &event)
+		}
+	}
+	return events, nil
+}
+
+func (s *LedgerStorage) LockCode(ctx context.Context, code string) (*storage.LedgerLock, error) {
+	if err := s.locks.Lock(ctx, code); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.seqs[code]++
+	return &storage.LedgerLock{Code: code, Seq: s.seqs[code]}, nil
+}
+
+func (s *LedgerStorage) UnlockCode(_ context.Context, lock *storage.LedgerLock) error {
+	s.locks.Unlock(lock.Code)
+	return nil
+}
+
+// copyLedgerEvent copies the votes too, so callers never share them with the stored event
+func copyLedgerEvent(event *storage.LedgerEvent) storage.LedgerEvent {
+	c := *event
+	c.Votes = make([]*storage.Vote, 0, len(event.Votes))
+	for _, v := range event.Votes {
+		vote := *v
+		c.Votes = append(c.Votes, &vote)
+	}
+	return c
+}
@@ -0,0 +1,246 @@
+package memory
+
+import (
+	"context"
+	"github.com/alex-pricope/simple-voting-system/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestVotingCodesStorage(t *testing.T) {
+	s := NewVotingCodesStorage()
+	ctx := context.TODO()
+
+	t.Run("Get unknown code returns not found", func(t *testing.T) {
+		_, err := s.Get(ctx, "NOPE")
+		assert.ErrorIs(t, err, storage.ErrNotFound)
+	})
+
+	t.Run("Put rejects duplicates and resets Used", func(t *testing.T) {
+		require.NoError(t, s.Put(ctx, &storage.VotingCode{Code: "AAAAA", Category: "grand_jury", Used: true}))
+		assert.ErrorIs(t, s.Put(ctx, &storage.VotingCode{Code: "AAAAA"}), storage.ErrAlreadyExists)
+
+		vc, err := s.Get(ctx, "AAAAA")
+		require.NoError(t, err)
+		assert.False(t, vc.Used)
+		assert.False(t, vc.CreatedAt.IsZero())
+		assert.Equal(t, "grand_jury", vc.Category)
+	})
+
+	t.Run("Returned codes do not alias storage", func(t *testing.T) {
+		vc, err := s.Get(ctx, "AAAAA")
+		require.NoError(t, err)
+		vc.Used = true
+
+		stored, err := s.Get(ctx, "AAAAA")
+		require.NoError(t, err)
+		assert.False(t, stored.Used)
+	})
+
+	t.Run("PutBatch stores and returns the new codes", func(t *testing.T) {
+		stored, err := s.PutBatch(ctx, []*storage.VotingCode{{Code: "AAAAA"}, {Code: "BBBBB"}})
+		require.NoError(t, err)
+		require.Len(t, stored, 1)
+		assert.Equal(t, "BBBBB", stored[0].Code)
+
+		_, err = s.Get(ctx, "BBBBB")
+		assert.NoError(t, err)
+	})
+
+	t.Run("SetExpiry changes only the expiry", func(t *testing.T) {
+		require.NoError(t, s.MarkUsed(ctx, "BBBBB"))
+		expiresAt := time.Now().Add(time.Hour)
+		require.NoError(t, s.SetExpiry(ctx, "BBBBB", &expiresAt))
+		assert.ErrorIs(t, s.SetExpiry(ctx, "NOPE", &expiresAt), storage.ErrNotFound)
+
+		vc, err := s.Get(ctx, "BBBBB")
+		require.NoError(t, err)
+		assert.True(t, vc.Used)
+		require.NotNil(t, vc.ExpiresAt)
+		assert.True(t, vc.ExpiresAt.Equal(expiresAt))
+		assert.False(t, vc.Expired(time.Now()))
+		assert.True(t, vc.Expired(expiresAt))
+	})
+}
+
+func TestVoteStorage(t *testing.T) {
+	s := NewVoteStorage()
+	ctx := context.TODO()
+
+	require.NoError(t, s.Create(ctx, &storage.Vote{Code: "B", SortKey: "cat#1#team#2", CategoryID: 1, TeamID: 2, Rating: 3}))
+	require.NoError(t, s.Create(ctx, &storage.Vote{Code: "B", SortKey: "cat#1#team#1", CategoryID: 1, TeamID: 1, Rating: 4}))
+	require.NoError(t, s.Create(ctx, &storage.Vote{Code: "A", SortKey: "cat#1#team#1", CategoryID: 1, TeamID: 1, Rating: 5}))
+	assert.ErrorIs(t, s.Create(ctx, &storage.Vote{Code: "A", SortKey: "cat#1#team#1"}), storage.ErrAlreadyExists)
+
+	votes, err := s.GetByCode(ctx, "B")
+	require.NoError(t, err)
+	require.Len(t, votes, 2)
+	assert.Equal(t, "cat#1#team#1", votes[0].SortKey)
+
+	byTeam, err := s.GetByTeam(ctx, 1)
+	require.NoError(t, err)
+	require.Len(t, byTeam, 2)
+	assert.Equal(t, "A", byTeam[0].Code)
+	byCategory, err := s.GetByCategory(ctx, 1)
+	require.NoError(t, err)
+	assert.Len(t, byCategory, 3)
+
+	require.NoError(t, s.Delete(ctx, []*storage.Vote{votes[0], {Code: "C", SortKey: "cat#1#team#1"}}))
+	votes, err = s.GetByCode(ctx, "B")
+	require.NoError(t, err)
+	require.Len(t, votes, 1)
+	assert.Equal(t, "cat#1#team#2", votes[0].SortKey)
+
+	require.NoError(t, s.DeleteAll(ctx))
+	all, err := s.GetAll(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, all)
+}
+
+func TestTeamStorage(t *testing.T) {
+	s := NewTeamStorage()
+	ctx := context.TODO()
+
+	_, err := s.Get(ctx, 1)
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+
+	require.NoError(t, s.Create(ctx, &storage.Team{ID: 1, Name: "Alpha", Members: []string{"Alice"}}))
+	assert.ErrorIs(t, s.Create(ctx, &storage.Team{ID: 1, Name: "Other"}), storage.ErrAlreadyExists)
+
+	team, err := s.Get(ctx, 1)
+	require.NoError(t, err)
+	team.Members[0] = "Mallory"
+
+	team, err = s.Get(ctx, 1)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Alice"}, team.Members)
+}
+
+func TestTallyStorage(t *testing.T) {
+	s := NewTallyStorage()
+	ctx := context.TODO()
+
+	votes := []*storage.Vote{{TeamID: 1, CategoryID: 1, Rating: 5}, {TeamID: 2, CategoryID: 1, Rating: 3}}
+	require.NoError(t, s.Add(ctx, "grand_jury", votes))
+	require.NoError(t, s.Add(ctx, "grand_jury", votes))
+
+	tallies, err := s.GetAll(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 2, tallies.Ballots)
+	require.Len(t, tallies.Scores, 2)
+	assert.Equal(t, 10, tallies.Scores[0].Sum)
+	assert.Equal(t, 2, tallies.Scores[0].Count)
+
+	require.NoError(t, s.Subtract(ctx, "grand_jury", votes[1:], 0))
+	require.NoError(t, s.Subtract(ctx, "grand_jury", votes, 1))
+	tallies, err = s.GetAll(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, tallies.Ballots)
+	require.Len(t, tallies.Scores, 1)
+	assert.Equal(t, 5, tallies.Scores[0].Sum)
+	assert.Equal(t, 1, tallies.Scores[0].Count)
+
+	require.NoError(t, s.Replace(ctx, storage.BuildTallies(votes[:1], nil)))
+	tallies, err = s.GetAll(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, tallies.Ballots)
+	require.Len(t, tallies.Scores, 1)
+	assert.Equal(t, 5, tallies.Scores[0].Sum)
+}
+
+func TestEventStorage(t *testing.T) {
+	s := NewEventStorage()
+	ctx := context.TODO()
+
+	_, err := s.Get(ctx, "spring")
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+
+	require.NoError(t, s.Create(ctx, &storage.Event{ID: "spring", Name: "Spring", Namespace: "spring"}))
+	require.NoError(t, s.Create(ctx, &storage.Event{ID: "default", Name: "Default"}))
+	assert.ErrorIs(t, s.Create(ctx, &storage.Event{ID: "spring"}), storage.ErrAlreadyExists)
+
+	require.NoError(t, s.SetArchived(ctx, "spring", true))
+	assert.ErrorIs(t, s.SetArchived(ctx, "unknown", true), storage.ErrNotFound)
+
+	events, err := s.GetAll(ctx)
+	require.NoError(t, err)
+	require.Len(t, events, 2)
+	assert.Equal(t, "default", events[0].ID)
+	assert.Equal(t, "spring", events[1].ID)
+	assert.True(t, events[1].Archived)
+}
+
+func TestAuditStorage(t *testing.T) {
+	s := NewAuditStorage()
+	ctx := context.TODO()
+	start := time.Date(2025, 3, 1, 9, 0, 0, 0, time.UTC)
+
+	// Appended out of order, read back in time order
+	later := &storage.AuditEntry{ID: storage.NewAuditID(start.Add(time.Hour)), Timestamp: start.Add(time.Hour), Actor: "jury", Method: "DELETE", Path: "/api/admin/codes/ABC"}
+	earlier := &storage.AuditEntry{ID: storage.NewAuditID(start), Timestamp: start, Actor: "admin", Method: "POST", Path: "/api/meta/teams"}
+	require.NoError(t, s.Append(ctx, later))
+	require.NoError(t, s.Append(ctx, earlier))
+	assert.ErrorIs(t, s.Append(ctx, earlier), storage.ErrAlreadyExists)
+
+	entries, err := s.Query(ctx, storage.AuditFilter{})
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	assert.Equal(t, "admin", entries[0].Actor)
+	assert.Equal(t, "jury", entries[1].Actor)
+
+	entries, err = s.Query(ctx, storage.AuditFilter{From: start.Add(time.Minute)})
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, later.ID, entries[0].ID)
+
+	entries, err = s.Query(ctx, storage.AuditFilter{Method: "post", Path: "/teams"})
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, earlier.ID, entries[0].ID)
+}
+
+func TestLedgerStorage(t *testing.T) {
+	s := NewLedgerStorage()
+	ctx := context.TODO()
+	start := time.Date(2025, 3, 1, 9, 0, 0, 0, time.UTC)
+
+	ballot := &storage.LedgerEvent{ID: storage.NewAuditID(start), Timestamp: start, Type: storage.LedgerBallotSubmitted, Code: "ABC",
+		VoterGroup: "grand_jury", Votes: []*storage.Vote{{Code: "ABC", SortKey: storage.VoteSortKey(1, 2), CategoryID: 1, TeamID: 2, Rating: 4}}}
+	reset := &storage.LedgerEvent{ID: storage.NewAuditID(start.Add(time.Hour)), Timestamp: start.Add(time.Hour), Type: storage.LedgerCodeReset, Code: "ABC"}
+	require.NoError(t, s.Append(ctx, reset))
+	require.NoError(t, s.Append(ctx, ballot))
+	assert.ErrorIs(t, s.Append(ctx, ballot), storage.ErrAlreadyExists)
+
+	events, err := s.Query(ctx, storage.LedgerFilter{})
+	require.NoError(t, err)
+	require.Len(t, events, 2)
+	assert.Equal(t, storage.LedgerBallotSubmitted, events[0].Type)
+	assert.Equal(t, storage.LedgerCodeReset, events[1].Type)
+
+	// The stored votes are copies
+	events[0].Votes[0].Rating = 1
+	events, err = s.Query(ctx, storage.LedgerFilter{To: start.Add(time.Minute), Code: "ABC"})
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	assert.Equal(t, 4, events[0].Votes[0].Rating)
+
+	events, err = s.Query(ctx, storage.LedgerFilter{Code: "XYZ"})
+	require.NoError(t, err)
+	assert.Empty(t, events)
+
+	t.Run("Every lock of a code has the next sequence", func(t *testing.T) {
+		first, err := s.LockCode(ctx, "ABC")
+		require.NoError(t, err)
+		require.NoError(t, s.UnlockCode(ctx, first))
+		second, err := s.LockCode(ctx, "ABC")
+		require.NoError(t, err)
+		assert.Equal(t, first.Seq+1, second.Seq)
+		require.NoError(t, s.UnlockCode(ctx, second))
+
+		other, err := s.LockCode(ctx, "XYZ")
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), other.Seq)
+	})
+}
@@ -0,0 +1,79 @@
+package memory
+
+import (
+	"context"
+	"github.com/alex-pricope/simple-voting-system/storage"
+	"sync"
+)
+
+// TallyStorage is an in-memory storage.TallyStorage keyed by tally key.
+type TallyStorage struct {
+	mu      sync.RWMutex
+	items   map[string]storage.Tally
+	ballots int
+}
+
+func NewTallyStorage() *TallyStorage {
+	return &TallyStorage{items: make(map[string]storage.Tally)}
+}
+
+func (s *TallyStorage) Add(_ context.Context, voterGroup string, votes []*storage.Vote) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, t := range storage.TallyVotes(voterGroup, votes) {
+		tally, ok := s.items[t.Key]
+		if !ok {
+			tally = *t
+		} else {
+			tally.Sum += t.Sum
+			tally.Count += t.Count
+		}
+		s.items[t.Key] = tally
+	}
+	s.ballots++
+	return nil
+}
+
+func (s *TallyStorage) Subtract(_ context.Context, voterGroup string, votes []*storage.Vote, ballots int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, t := range storage.TallyVotes(voterGroup, votes) {
+		tally := s.items[t.Key]
+		tally.Sum -= t.Sum
+		tally.Count -= t.Count
+		if tally.Count <= 0 {
+			delete(s.items, t.Key)
+			continue
+		}
+		s.items[t.Key] = tally
+	}
+	s.ballots -= ballots
+	return nil
+}
+
+func (s *TallyStorage) GetAll(_ context.Context) (*storage.Tallies, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	rows := make([]*storage.Tally, 0, len(s.items)+1)
+	for _, t := range s.items {
+		tally := t
+		rows = append(rows, &tally)
+	}
+	rows = append(rows, &storage.Tally{Key: storage.BallotsTallyKey, Count: s.ballots})
+	return storage.SplitTallies(rows), nil
+}
+
+func (s *TallyStorage) Replace(_ context.Context, tallies *storage.Tallies) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.items = make(map[string]storage.Tally, len(tallies.Scores))
+	for _, t := range tallies.Scores {
+		s.items[t.Key] = *t
+	}
+	s.ballots = tallies.Ballots
+	return nil
+}
@@ -0,0 +1,86 @@
+package memory
+
+import (
+	"context"
+	"github.com/alex-pricope/simple-voting-system/storage"
+	"sort"
+	"sync"
+)
+
+// TeamStorage is an in-memory storage.TeamStorage keyed by team ID.
+type TeamStorage struct {
+	mu    sync.RWMutex
+	items map[int]storage.Team
+}
+
+func NewTeamStorage() *TeamStorage {
+	return &TeamStorage{items: make(map[int]storage.Team)}
+}
+
+func (s *TeamStorage) Get(_ context.Context, id int) (*storage.Team, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	team, ok := s.items[id]
+	if !ok {
+		return nil, storage.ErrNotFound
+	}
+	return copyTeam(team), nil
+}
+
+func (s *TeamStorage) GetAll(_ context.Context) ([]*storage.Team, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	teams := make([]*storage.Team, 0, len(s.items))
+	for _, team := range s.items {
+		teams = append(teams, copyTeam(team))
+	}
+	sort.Slice(teams, func(i, j int) bool {
+		return teams[i].ID < teams[j].ID
+	})
+	return teams, nil
+}
+
+func (s *TeamStorage) Create(_ context.Context, team *storage.Team) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.items[team.ID]; ok {
+		return storage.ErrAlreadyExists
+	}
+	team.Version = 1
+	s.items[team.ID] = *copyTeam(*team)
+	return nil
+}
+
+func (s *TeamStorage) Update(_ context.Context, team *storage.Team) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.items[team.ID]
+	if !ok {
+		return storage.ErrNotFound
+	}
+	if stored.Version != team.Version {
+		return storage.ErrVersionConflict
+	}
+	team.Version++
+	s.items[team.ID] = *copyTeam(*team)
+	return nil
+}
+
+func (s *TeamStorage) Delete(_ context.Context, id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.items, id)
+	return nil
+}
+
+func copyTeam(team storage.Team) *storage.Team {
+	if team.Members != nil {
+		team.Members = append([]string(nil), team.Members...)
+	}
+	return &team
+}
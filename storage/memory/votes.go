@@ -0,0 +1,114 @@
+package memory
+
+import (
+	"context"
+	"github.com/alex-pricope/simple-voting-system/storage"
+	"sort"
+	"sync"
+)
+
+type voteKey struct {
+	code    string
+	sortKey string
+}
+
+// VoteStorage is an in-memory storage.VoteStorage keyed by code and sort key.
+type VoteStorage struct {
+	mu    sync.RWMutex
+	items map[voteKey]storage.Vote
+}
+
+func NewVoteStorage() *VoteStorage {
+	return &VoteStorage{items: make(map[voteKey]storage.Vote)}
+}
+
+func (s *VoteStorage) GetAll(_ context.Context) ([]*storage.Vote, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	votes := make([]*storage.Vote, 0, len(s.items))
+	for _, v := range s.items {
+		vote := v
+		votes = append(votes, &vote)
+	}
+	sortVotes(votes)
+	return votes, nil
+}
+
+func (s *VoteStorage) Create(_ context.Context, vote *storage.Vote) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := voteKey{code: vote.Code, sortKey: vote.SortKey}
+	if _, ok := s.items[key]; ok {
+		return storage.ErrAlreadyExists
+	}
+	s.items[key] = *vote
+	return nil
+}
+
+func (s *VoteStorage) GetByCode(_ context.Context, code string) ([]*storage.Vote, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var votes []*storage.Vote
+	for key, v := range s.items {
+		if key.code == code {
+			vote := v
+			votes = append(votes, &vote)
+		}
+	}
+	sortVotes(votes)
+	return votes, nil
+}
+
+func (s *VoteStorage) GetByTeam(_ context.Context, teamID int) ([]*storage.Vote, error) {
+	return s.filter(func(v *storage.Vote) bool { return v.TeamID == teamID }), nil
+}
+
+func (s *VoteStorage) GetByCategory(_ context.Context, categoryID int) ([]*storage.Vote, error) {
+	return s.filter(func(v *storage.Vote) bool { return v.CategoryID == categoryID }), nil
+}
+
+func (s *VoteStorage) filter(matches func(*storage.Vote) bool) []*storage.Vote {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var votes []*storage.Vote
+	for _, v := range s.items {
+		if matches(&v) {
+			vote := v
+			votes = append(votes, &vote)
+		}
+	}
+	sortVotes(votes)
+	return votes
+}
+
+func (s *VoteStorage) Delete(_ context.Context, votes []*storage.Vote) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, v := range votes {
+		delete(s.items, voteKey{code: v.Code, sortKey: v.SortKey})
+	}
+	return nil
+}
+
+func (s *VoteStorage) DeleteAll(_ context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.items = make(map[voteKey]storage.Vote)
+	return nil
+}
+
+// sortVotes orders votes the way a DynamoDB query returns them: by partition key, then sort key.
+func sortVotes(votes []*storage.Vote) {
+	sort.Slice(votes, func(i, j int) bool {
+		if votes[i].Code != votes[j].Code {
+			return votes[i].Code < votes[j].Code
+		}
+		return votes[i].SortKey < votes[j].SortKey
+	})
+}
@@ -0,0 +1,390 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/alex-pricope/simple-voting-system/logging"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Migration brings the items of one table of an event to the shape the current code writes, adding the
+// attributes they lack and retyping the ones stored with the wrong type.
+// Items are handled as raw attribute maps, so items that no longer unmarshal can still be fixed.
+type Migration struct {
+	Version int // Unique, migrations run in ascending order
+	Name    string
+	// Table picks the table of the event the migration rewrites
+	Table func(tables TableNames) string
+	// Migrate returns the new values of the attributes of the item it changes, or nil when the item needs no change.
+	// They replace the scanned values only while the item still has them, so a newer write is never overwritten
+	// and running a migration again changes nothing.
+	Migrate func(item map[string]types.AttributeValue) (map[string]types.AttributeValue, error)
+}
+
+// MigrationRecord marks a migration as applied to one table
+type MigrationRecord struct {
+	Table     string    `dynamodbav:"PK"`
+	Version   int       `dynamodbav:"SK"`
+	Name      string    `dynamodbav:"Name"`
+	AppliedAt time.Time `dynamodbav:"AppliedAt"`
+	Scanned   int       `dynamodbav:"Scanned"`
+	Rewritten int       `dynamodbav:"Rewritten"`
+}
+
+// MigrationResult is the state of one migration for the tables of an event
+type MigrationResult struct {
+	Version   int
+	Name      string
+	Table     string
+	AppliedAt *time.Time // Nil while the migration is pending
+	Scanned   int
+	Rewritten int // Items rewritten, or that would be rewritten in a dry run
+}
+
+// Migrator applies the migrations to the tables of one event
+type Migrator interface {
+	// Status lists every migration with the record of when it was applied, if it was
+	Status(ctx context.Context) ([]*MigrationResult, error)
+	// Run applies the pending migrations in order and stops at the first failure. A dry run scans and
+	// counts the items that would be rewritten, without writing them or recording the migrations.
+	Run(ctx context.Context, dryRun bool) ([]*MigrationResult, error)
+}
+
+// DynamoMigrations are the migrations of the DynamoDB tables, in order. Append new ones with the next
+// version, never renumber or remove one that may have been applied.
+// Versions 1 to 3 were an earlier take on 4 to 6 and stay reserved, as tables may have them recorded as applied.
+func DynamoMigrations() []Migration {
+	return []Migration{
+		{
+			Version: 4,
+			Name:    "voting codes get an explicit numeric TeamID",
+			Table:   func(t TableNames) string { return t.Codes },
+			Migrate: fixAttributes(map[string]attributeFix{
+				"TeamID": asNumber(&types.AttributeValueMemberNULL{Value: true}),
+			}),
+		},
+		{
+			Version: 5,
+			Name:    "teams get a numeric Version and a boolean Archived",
+			Table:   func(t TableNames) string { return t.Teams },
+			Migrate: fixAttributes(map[string]attributeFix{
+				"Version":  asNumber(&types.AttributeValueMemberN{Value: "0"}),
+				"Archived": asBool(false),
+			}),
+		},
+		{
+			Version: 6,
+			Name:    "categories get a numeric Version and Weight and a boolean Archived",
+			Table:   func(t TableNames) string { return t.Categories },
+			Migrate: fixAttributes(map[string]attributeFix{
+				"Version":  asNumber(&types.AttributeValueMemberN{Value: "0"}),
+				"Weight":   asNumber(&types.AttributeValueMemberN{Value: "0"}),
+				"Archived": asBool(false),
+			}),
+		},
+	}
+}
+
+// attributeFix returns the new value of one attribute, or nil when it needs no change.
+// The value is nil when the item lacks the attribute.
+type attributeFix func(value types.AttributeValue) (types.AttributeValue, error)
+
+// fixAttributes returns a Migrate function applying a fix to each of the named attributes
+func fixAttributes(fixes map[string]attributeFix) func(map[string]types.AttributeValue) (map[string]types.AttributeValue, error) {
+	return func(item map[string]types.AttributeValue) (map[string]types.AttributeValue, error) {
+		var changed map[string]types.AttributeValue
+		for name, fix := range fixes {
+			value, err := fix(item[name])
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", name, err)
+			}
+			if value == nil {
+				continue
+			}
+			if changed == nil {
+				changed = make(map[string]types.AttributeValue, len(fixes))
+			}
+			changed[name] = value
+		}
+		return changed, nil
+	}
+}
+
+// asNumber sets a missing or empty attribute to missing and turns numbers stored as strings into numbers
+func asNumber(missing types.AttributeValue) attributeFix {
+	return func(value types.AttributeValue) (types.AttributeValue, error) {
+		switch v := value.(type) {
+		case nil:
+			return missing, nil
+		case *types.AttributeValueMemberN, *types.AttributeValueMemberNULL:
+			return nil, nil
+		case *types.AttributeValueMemberS:
+			text := strings.TrimSpace(v.Value)
+			if text == "" {
+				return missing, nil
+			}
+			if _, err := strconv.ParseFloat(text, 64); err != nil {
+				return nil, fmt.Errorf("%q is not a number", v.Value)
+			}
+			return &types.AttributeValueMemberN{Value: text}, nil
+		default:
+			return nil, fmt.Errorf("cannot turn a %T into a number", value)
+		}
+	}
+}
+
+// asBool sets a missing attribute to missing and turns booleans stored as strings or 0 and 1 into booleans
+func asBool(missing bool) attributeFix {
+	return func(value types.AttributeValue) (types.AttributeValue, error) {
+		var text string
+		switch v := value.(type) {
+		case nil, *types.AttributeValueMemberNULL:
+			return &types.AttributeValueMemberBOOL{Value: missing}, nil
+		case *types.AttributeValueMemberBOOL:
+			return nil, nil
+		case *types.AttributeValueMemberS:
+			text = v.Value
+		case *types.AttributeValueMemberN:
+			text = v.Value
+		default:
+			return nil, fmt.Errorf("cannot turn a %T into a boolean", value)
+		}
+		parsed, err := strconv.ParseBool(strings.TrimSpace(text))
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a boolean", text)
+		}
+		return &types.AttributeValueMemberBOOL{Value: parsed}, nil
+	}
+}
+
+// SortMigrations orders migrations by version and rejects duplicate versions
+func SortMigrations(migrations []Migration) ([]Migration, error) {
+	sorted := append([]Migration(nil), migrations...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Version < sorted[j].Version
+	})
+	for i := 1; i < len(sorted); i++ {
+		if sorted[i].Version == sorted[i-1].Version {
+			return nil, fmt.Errorf("duplicate migration version %d", sorted[i].Version)
+		}
+	}
+	return sorted, nil
+}
+
+// ItemUpdate replaces the attributes in Set of the item with Key, while they still hold the values in Old.
+// An attribute missing from Old was missing from the scanned item.
+type ItemUpdate struct {
+	Key map[string]types.AttributeValue
+	Set map[string]types.AttributeValue
+	Old map[string]types.AttributeValue
+}
+
+// MigrateItems runs a migration over a page of items and returns the updates of the items that need one
+func MigrateItems(m Migration, items []map[string]types.AttributeValue) ([]ItemUpdate, error) {
+	var updates []ItemUpdate
+	for _, item := range items {
+		set, err := m.Migrate(item)
+		if err != nil {
+			return nil, fmt.Errorf("migration %d: %w", m.Version, err)
+		}
+		if len(set) == 0 {
+			continue
+		}
+		// Every table keys its items by PK, and by SK when it has a sort key
+		key := map[string]types.AttributeValue{"PK": item["PK"]}
+		if sk, ok := item["SK"]; ok {
+			key["SK"] = sk
+		}
+		old := make(map[string]types.AttributeValue, len(set))
+		for name := range set {
+			if value, ok := item[name]; ok {
+				old[name] = value
+			}
+		}
+		updates = append(updates, ItemUpdate{Key: key, Set: set, Old: old})
+	}
+	return updates, nil
+}
+
+// Input is the UpdateItem of the update. It only applies while the item still exists, so a deleted item
+// is not brought back, and while each replaced attribute still holds its scanned value, so a value
+// written since the scan is kept.
+func (u ItemUpdate) Input(table string) *dynamodb.UpdateItemInput {
+	names := make([]string, 0, len(u.Set))
+	for name := range u.Set {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	input := &dynamodb.UpdateItemInput{
+		TableName:                 aws.String(table),
+		Key:                       u.Key,
+		ExpressionAttributeNames:  make(map[string]string, len(names)),
+		ExpressionAttributeValues: make(map[string]types.AttributeValue, 2*len(names)),
+	}
+	sets := make([]string, 0, len(names))
+	conditions := []string{"attribute_exists(PK)"}
+	for i, name := range names {
+		input.ExpressionAttributeNames["#a"+strconv.Itoa(i)] = name
+		input.ExpressionAttributeValues[":a"+strconv.Itoa(i)] = u.Set[name]
+		sets = append(sets, fmt.Sprintf("#a%d = :a%d", i, i))
+		if old, ok := u.Old[name]; ok {
+			input.ExpressionAttributeValues[":o"+strconv.Itoa(i)] = old
+			conditions = append(conditions, fmt.Sprintf("#a%d = :o%d", i, i))
+		} else {
+			conditions = append(conditions, fmt.Sprintf("attribute_not_exists(#a%d)", i))
+		}
+	}
+	input.UpdateExpression = aws.String("SET " + strings.Join(sets, ", "))
+	input.ConditionExpression = aws.String(strings.Join(conditions, " AND "))
+	return input
+}
+
+// DynamoMigrator runs the migrations over the tables of one event and keeps what it applied
+// in the migrations table, keyed by the name of the migrated table.
+// Items are updated in place and only while they still hold the scanned values, so it can run while the event is in use.
+type DynamoMigrator struct {
+	Client       *dynamodb.Client
+	RecordsTable string
+	Tables       TableNames
+	Migrations   []Migration
+}
+
+func (m *DynamoMigrator) Status(ctx context.Context) ([]*MigrationResult, error) {
+	migrations, err := SortMigrations(m.Migrations)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]*MigrationResult, 0, len(migrations))
+	for _, migration := range migrations {
+		result := &MigrationResult{Version: migration.Version, Name: migration.Name, Table: migration.Table(m.Tables)}
+		record, err := m.getRecord(ctx, result.Table, migration.Version)
+		if err != nil {
+			return nil, err
+		}
+		if record != nil {
+			result.AppliedAt = &record.AppliedAt
+			result.Scanned, result.Rewritten = record.Scanned, record.Rewritten
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+func (m *DynamoMigrator) Run(ctx context.Context, dryRun bool) ([]*MigrationResult, error) {
+	results, err := m.Status(ctx)
+	if err != nil {
+		return nil, err
+	}
+	migrations, _ := SortMigrations(m.Migrations)
+
+	for i, migration := range migrations {
+		result := results[i]
+		if result.AppliedAt != nil {
+			continue
+		}
+		if err := m.migrate(ctx, migration, result, dryRun); err != nil {
+			logging.Log.Errorf("MIGRATION: %d on %s failed after %d of %d items: %v",
+				migration.Version, result.Table, result.Rewritten, result.Scanned, err)
+			return results, err
+		}
+		if dryRun {
+			logging.Log.Infof("MIGRATION: %d on %s would rewrite %d of %d items", migration.Version, result.Table, result.Rewritten, result.Scanned)
+			continue
+		}
+
+		record := &MigrationRecord{
+			Table:     result.Table,
+			Version:   migration.Version,
+			Name:      migration.Name,
+			AppliedAt: time.Now().UTC(),
+			Scanned:   result.Scanned,
+			Rewritten: result.Rewritten,
+		}
+		if err := m.putRecord(ctx, record); err != nil {
+			return results, err
+		}
+		result.AppliedAt = &record.AppliedAt
+		logging.Log.Infof("MIGRATION: %d on %s rewrote %d of %d items", migration.Version, result.Table, result.Rewritten, result.Scanned)
+	}
+	return results, nil
+}
+
+// migrate scans the table page by page and updates the items of each page that need it.
+// Items deleted since the scan, or whose migrated attributes were written since, are skipped and not counted.
+func (m *DynamoMigrator) migrate(ctx context.Context, migration Migration, result *MigrationResult, dryRun bool) error {
+	paginator := dynamodb.NewScanPaginator(m.Client, &dynamodb.ScanInput{TableName: aws.String(result.Table)})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return classify(err)
+		}
+		updates, err := MigrateItems(migration, page.Items)
+		if err != nil {
+			return err
+		}
+		result.Scanned += len(page.Items)
+
+		if dryRun {
+			result.Rewritten += len(updates)
+			continue
+		}
+		for _, update := range updates {
+			if _, err := m.Client.UpdateItem(ctx, update.Input(result.Table)); err != nil {
+				var cce *types.ConditionalCheckFailedException
+				if errors.As(err, &cce) {
+					continue
+				}
+				return classify(err)
+			}
+			result.Rewritten++
+		}
+	}
+	return nil
+}
+
+func (m *DynamoMigrator) getRecord(ctx context.Context, table string, version int) (*MigrationRecord, error) {
+	out, err := m.Client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: &m.RecordsTable,
+		Key: map[string]types.AttributeValue{
+			"PK": &types.AttributeValueMemberS{Value: table},
+			"SK": &types.AttributeValueMemberN{Value: strconv.Itoa(version)},
+		},
+	})
+	if err != nil {
+		logging.Log.Errorf("MIGRATION: failed to get record of %d on %s: %v", version, table, err)
+		return nil, classify(err)
+	}
+	if out.Item == nil {
+		return nil, nil
+	}
+
+	var record MigrationRecord
+	if err := attributevalue.UnmarshalMap(out.Item, &record); err != nil {
+		logging.Log.Errorf("MIGRATION: failed to unmarshal migration record: %v", err)
+		return nil, err
+	}
+	return &record, nil
+}
+
+func (m *DynamoMigrator) putRecord(ctx context.Context, record *MigrationRecord) error {
+	item, err := attributevalue.MarshalMap(record)
+	if err != nil {
+		logging.Log.Errorf("MIGRATION: failed to marshal migration record: %v", err)
+		return err
+	}
+	if _, err := m.Client.PutItem(ctx, &dynamodb.PutItemInput{TableName: &m.RecordsTable, Item: item}); err != nil {
+		logging.Log.Errorf("MIGRATION: failed to record %d on %s: %v", record.Version, record.Table, err)
+		return classify(err)
+	}
+	return nil
+}
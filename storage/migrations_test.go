@@ -0,0 +1,112 @@
+package storage
+
+import (
+	"errors"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestDynamoMigrationsAreOrdered(t *testing.T) {
+	migrations := DynamoMigrations()
+	sorted, err := SortMigrations(migrations)
+	require.NoError(t, err)
+	assert.Equal(t, len(migrations), len(sorted))
+	for i, m := range migrations {
+		assert.Equal(t, sorted[i].Version, m.Version, "migrations are listed in version order")
+		assert.NotEmpty(t, m.Name)
+		assert.NotEmpty(t, m.Table(TableNames{Codes: "c", Votes: "v", Teams: "t", Categories: "cat", Tallies: "ta", Audit: "a"}))
+		assert.Greater(t, m.Version, 3, "versions 1 to 3 were dropped and stay reserved")
+	}
+}
+
+func TestSortMigrationsRejectsDuplicates(t *testing.T) {
+	_, err := SortMigrations([]Migration{{Version: 2}, {Version: 1}, {Version: 2}})
+	assert.Error(t, err)
+
+	sorted, err := SortMigrations([]Migration{{Version: 3}, {Version: 1}})
+	require.NoError(t, err)
+	assert.Equal(t, 1, sorted[0].Version)
+	assert.Equal(t, 3, sorted[1].Version)
+}
+
+func TestDynamoMigrationsFixItems(t *testing.T) {
+	migrations := DynamoMigrations()
+	codes, teams, categories := migrations[0], migrations[1], migrations[2]
+
+	old := map[string]types.AttributeValue{
+		"PK":   &types.AttributeValueMemberS{Value: "ABC123"},
+		"Used": &types.AttributeValueMemberBOOL{Value: true},
+	}
+	typed := map[string]types.AttributeValue{
+		"PK":     &types.AttributeValueMemberS{Value: "DEF456"},
+		"TeamID": &types.AttributeValueMemberS{Value: " 7"},
+	}
+	current := map[string]types.AttributeValue{
+		"PK":     &types.AttributeValueMemberS{Value: "GHI789"},
+		"TeamID": &types.AttributeValueMemberN{Value: "2"},
+	}
+	updates, err := MigrateItems(codes, []map[string]types.AttributeValue{old, typed, current})
+	require.NoError(t, err)
+	require.Len(t, updates, 2, "items already in shape are left alone")
+	assert.NotContains(t, old, "TeamID", "the scanned item is not changed in place")
+	assert.Equal(t, map[string]types.AttributeValue{"TeamID": &types.AttributeValueMemberNULL{Value: true}}, updates[0].Set)
+	assert.Equal(t, map[string]types.AttributeValue{"TeamID": &types.AttributeValueMemberN{Value: "7"}}, updates[1].Set)
+
+	team := map[string]types.AttributeValue{
+		"PK":       &types.AttributeValueMemberN{Value: "1"},
+		"Version":  &types.AttributeValueMemberS{Value: "3"},
+		"Archived": &types.AttributeValueMemberS{Value: "true"},
+	}
+	updates, err = MigrateItems(teams, []map[string]types.AttributeValue{team})
+	require.NoError(t, err)
+	require.Len(t, updates, 1)
+	assert.Equal(t, map[string]types.AttributeValue{
+		"Version":  &types.AttributeValueMemberN{Value: "3"},
+		"Archived": &types.AttributeValueMemberBOOL{Value: true},
+	}, updates[0].Set)
+
+	category := map[string]types.AttributeValue{
+		"PK":     &types.AttributeValueMemberN{Value: "1"},
+		"Weight": &types.AttributeValueMemberS{Value: "heavy"},
+	}
+	_, err = MigrateItems(categories, []map[string]types.AttributeValue{category})
+	assert.ErrorContains(t, err, `migration 6: Weight: "heavy" is not a number`)
+}
+
+func TestItemUpdateInput(t *testing.T) {
+	key := map[string]types.AttributeValue{
+		"PK": &types.AttributeValueMemberS{Value: "ABC123"},
+		"SK": &types.AttributeValueMemberS{Value: "cat#1#team#2"},
+	}
+	update := ItemUpdate{
+		Key: key,
+		Set: map[string]types.AttributeValue{
+			"Archived": &types.AttributeValueMemberBOOL{Value: false},
+			"Version":  &types.AttributeValueMemberN{Value: "3"},
+		},
+		Old: map[string]types.AttributeValue{"Version": &types.AttributeValueMemberS{Value: "3"}},
+	}
+
+	// Only the changed attributes are written, and only while they still hold the scanned values
+	input := update.Input("Votes-spring")
+	assert.Equal(t, "Votes-spring", *input.TableName)
+	assert.Equal(t, key, input.Key)
+	assert.Equal(t, "SET #a0 = :a0, #a1 = :a1", *input.UpdateExpression)
+	assert.Equal(t, "attribute_exists(PK) AND attribute_not_exists(#a0) AND #a1 = :o1", *input.ConditionExpression)
+	assert.Equal(t, map[string]string{"#a0": "Archived", "#a1": "Version"}, input.ExpressionAttributeNames)
+	assert.Equal(t, map[string]types.AttributeValue{
+		":a0": &types.AttributeValueMemberBOOL{Value: false},
+		":a1": &types.AttributeValueMemberN{Value: "3"},
+		":o1": &types.AttributeValueMemberS{Value: "3"},
+	}, input.ExpressionAttributeValues)
+}
+
+func TestMigrateItemsStopsOnError(t *testing.T) {
+	m := Migration{Version: 9, Migrate: func(map[string]types.AttributeValue) (map[string]types.AttributeValue, error) {
+		return nil, errors.New("bad item")
+	}}
+	_, err := MigrateItems(m, []map[string]types.AttributeValue{{}})
+	assert.ErrorContains(t, err, "migration 9: bad item")
+}
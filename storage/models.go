@@ -8,6 +8,14 @@ type VotingCode struct {
 	TeamID    *int      `dynamodbav:"TeamID"`
 	CreatedAt time.Time `dynamodbav:"CreatedAt"`
 	Used      bool      `dynamodbav:"Used"`
+	// ExpiresAt is when the code stops being accepted, nil means it never expires.
+	// It is not a DynamoDB TTL attribute: expired codes are kept, they are needed for the results.
+	ExpiresAt *time.Time `dynamodbav:"ExpiresAt,omitempty"`
+}
+
+// Expired reports whether the code expired at or before now
+func (vc *VotingCode) Expired(now time.Time) bool {
+	return vc.ExpiresAt != nil && !now.Before(*vc.ExpiresAt)
 }
 
 type VotingCategory struct {
@@ -15,6 +23,8 @@ type VotingCategory struct {
 	Name        string  `dynamodbav:"Name"`
 	Description string  `dynamodbav:"Description"`
 	Weight      float64 `dynamodbav:"Weight"`
+	Version     int     `dynamodbav:"Version"`  // Bumped on every update, see VotingCategoryStorage.Update
+	Archived    bool    `dynamodbav:"Archived"` // Hidden from the public meta endpoints, still counted in the results
 }
 
 type Team struct {
@@ -22,6 +32,8 @@ type Team struct {
 	Name        string   `dynamodbav:"Name"`
 	Members     []string `dynamodbav:"Members"`
 	Description string   `dynamodbav:"Description"`
+	Version     int      `dynamodbav:"Version"`  // Bumped on every update, see TeamStorage.Update
+	Archived    bool     `dynamodbav:"Archived"` // Hidden from the public meta endpoints, still counted in the results
 }
 
 type Vote struct {
@@ -32,3 +44,77 @@ type Vote struct {
 	Rating     int       `dynamodbav:"Rating" json:"rating"`
 	Timestamp  time.Time `dynamodbav:"Timestamp" json:"timestamp"`
 }
+
+// Ballot is the single-item layout of a ballot in the Votes table: one item per code
+// holding every rating, keyed by the same cat#..#team#.. sort key a Vote row would use.
+type Ballot struct {
+	Code      string                 `dynamodbav:"PK"`
+	SortKey   string                 `dynamodbav:"SK"` // Always BallotSortKey
+	Entries   map[string]BallotEntry `dynamodbav:"Entries"`
+	Timestamp time.Time              `dynamodbav:"Timestamp"`
+}
+
+type BallotEntry struct {
+	CategoryID int `dynamodbav:"CategoryID"`
+	TeamID     int `dynamodbav:"TeamID"`
+	Rating     int `dynamodbav:"Rating"`
+}
+
+// Tally is the running sum and count of the ratings one voter group gave a team in a category.
+// The number of counted ballots is kept in a Tally with key BallotsTallyKey, only its Count is used.
+type Tally struct {
+	Key        string `dynamodbav:"PK"` // TallyKey of team, category and voter group
+	TeamID     int    `dynamodbav:"TeamID"`
+	CategoryID int    `dynamodbav:"CategoryID"`
+	VoterGroup string `dynamodbav:"VoterGroup"` // Category of the voting code
+	Sum        int    `dynamodbav:"Sum"`
+	Count      int    `dynamodbav:"Count"`
+}
+
+// Tallies are the materialized results: the ballots counted and one Tally per team, category and voter group
+type Tallies struct {
+	Ballots int
+	Scores  []*Tally
+	Stale   bool // An update was only partly applied, the tallies need a rebuild
+}
+
+// Event is one voting event, such as a single hackathon. Its codes, votes, teams and categories
+// live in their own tables, named after Namespace.
+type Event struct {
+	ID        string    `dynamodbav:"PK"`
+	Name      string    `dynamodbav:"Name"`
+	Namespace string    `dynamodbav:"Namespace"` // Suffix of the table names, empty for the original unsuffixed tables
+	CreatedAt time.Time `dynamodbav:"CreatedAt"`
+	Archived  bool      `dynamodbav:"Archived"` // Archived events are read only
+}
+
+// AuditEntry records one mutating admin request: who made it, what it changed and how it ended.
+// Entries are only ever appended.
+type AuditEntry struct {
+	ID        string    `dynamodbav:"SK"` // NewAuditID of Timestamp, so entries sort by time
+	Timestamp time.Time `dynamodbav:"Timestamp"`
+	Actor     string    `dynamodbav:"Actor"`  // Name of the admin credential
+	Method    string    `dynamodbav:"Method"` // HTTP method
+	Route     string    `dynamodbav:"Route"`  // Route pattern, such as /api/admin/codes/:code
+	Path      string    `dynamodbav:"Path"`   // Requested path with its query
+	Status    int       `dynamodbav:"Status"` // HTTP status of the response
+	RequestID string    `dynamodbav:"RequestID"`
+	Before    string    `dynamodbav:"Before,omitempty"` // JSON of the changed values before the request, if known
+	After     string    `dynamodbav:"After,omitempty"`  // JSON of the changed values after the request, if known
+}
+
+// LedgerEvent is one change to the votes of an event. The ledger only ever grows, replaying it
+// with ReplayLedger rebuilds the votes and the voter groups the results are computed from.
+type LedgerEvent struct {
+	ID         string    `dynamodbav:"SK"` // NewAuditID of Timestamp, so events sort by time
+	Timestamp  time.Time `dynamodbav:"Timestamp"`
+	Type       string    `dynamodbav:"Type"`                 // One of the Ledger* event types
+	Code       string    `dynamodbav:"Code,omitempty"`       // Voting code the event applies to, empty when it spans codes
+	Seq        int64     `dynamodbav:"Seq,omitempty"`        // Order of the change among the changes of Code, see LedgerStorage.LockCode
+	VoterGroup string    `dynamodbav:"VoterGroup,omitempty"` // Category of the code when its ballot was stored
+	Votes      []*Vote   `dynamodbav:"Votes,omitempty"`      // Ratings stored or deleted
+	Used       bool      `dynamodbav:"Used,omitempty"`       // Whether an imported code is used
+	Actor      string    `dynamodbav:"Actor,omitempty"`      // Name of the admin credential, empty for ballots
+	RequestID  string    `dynamodbav:"RequestID,omitempty"`
+	Note       string    `dynamodbav:"Note,omitempty"` // Why the votes of a code were invalidated, or where imported votes came from
+}
@@ -0,0 +1,51 @@
+package storage
+
+import (
+	"context"
+	"encoding/base64"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// scanAll runs a Scan and follows LastEvaluatedKey until the whole table has been read.
+// A single Scan call stops at 1 MB of data.
+func scanAll(ctx context.Context, client *dynamodb.Client, input *dynamodb.ScanInput) ([]map[string]types.AttributeValue, error) {
+	var items []map[string]types.AttributeValue
+	paginator := dynamodb.NewScanPaginator(client, input)
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, classify(err)
+		}
+		items = append(items, page.Items...)
+	}
+	return items, nil
+}
+
+// queryAll runs a Query and follows LastEvaluatedKey until all matching items have been read.
+func queryAll(ctx context.Context, client *dynamodb.Client, input *dynamodb.QueryInput) ([]map[string]types.AttributeValue, error) {
+	var items []map[string]types.AttributeValue
+	paginator := dynamodb.NewQueryPaginator(client, input)
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, classify(err)
+		}
+		items = append(items, page.Items...)
+	}
+	return items, nil
+}
+
+// EncodePageToken turns the key of the last returned item into an opaque page token.
+func EncodePageToken(key string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(key))
+}
+
+// DecodePageToken returns the key encoded by EncodePageToken.
+func DecodePageToken(token string) (string, error) {
+	key, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil || len(key) == 0 {
+		return "", ErrInvalidPageToken
+	}
+	return string(key), nil
+}
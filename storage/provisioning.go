@@ -0,0 +1,294 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/alex-pricope/simple-voting-system/logging"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"time"
+)
+
+// tableCreateTimeout bounds how long Ensure waits for a created table to become active
+const tableCreateTimeout = 2 * time.Minute
+
+// KeyAttribute is one key attribute of a table with its scalar type
+type KeyAttribute struct {
+	Name string
+	Type types.ScalarAttributeType
+}
+
+// IndexSchema is a global secondary index projecting every attribute
+type IndexSchema struct {
+	Name         string
+	PartitionKey KeyAttribute
+}
+
+// TableSchema is the key schema the storage code expects from a DynamoDB table
+type TableSchema struct {
+	Name         string
+	PartitionKey KeyAttribute
+	SortKey      *KeyAttribute
+	Indexes      []IndexSchema
+}
+
+// DynamoTableSchemas returns the schemas of the four tables, matching init-localstack.sh
+func DynamoTableSchemas(codes, votes, teams, categories string) []TableSchema {
+	return []TableSchema{
+		{Name: codes, PartitionKey: KeyAttribute{Name: "PK", Type: types.ScalarAttributeTypeS}},
+		{
+			Name:         votes,
+			PartitionKey: KeyAttribute{Name: "PK", Type: types.ScalarAttributeTypeS},
+			SortKey:      &KeyAttribute{Name: "SK", Type: types.ScalarAttributeTypeS},
+			Indexes: []IndexSchema{
+				{Name: VotesTeamIndex, PartitionKey: KeyAttribute{Name: "TeamID", Type: types.ScalarAttributeTypeN}},
+				{Name: VotesCategoryIndex, PartitionKey: KeyAttribute{Name: "CategoryID", Type: types.ScalarAttributeTypeN}},
+			},
+		},
+		{Name: teams, PartitionKey: KeyAttribute{Name: "PK", Type: types.ScalarAttributeTypeN}},
+		{Name: categories, PartitionKey: KeyAttribute{Name: "PK", Type: types.ScalarAttributeTypeN}},
+	}
+}
+
+// TallyTableSchema returns the schema of the optional tallies table, see DynamoTallyStorage
+func TallyTableSchema(tallies string) TableSchema {
+	return TableSchema{Name: tallies, PartitionKey: KeyAttribute{Name: "PK", Type: types.ScalarAttributeTypeS}}
+}
+
+// EventTableSchema returns the schema of the events table, see DynamoEventStorage
+func EventTableSchema(events string) TableSchema {
+	return TableSchema{Name: events, PartitionKey: KeyAttribute{Name: "PK", Type: types.ScalarAttributeTypeS}}
+}
+
+// MigrationTableSchema returns the schema of the table recording the applied migrations, see DynamoMigrator
+func MigrationTableSchema(migrations string) TableSchema {
+	return TableSchema{
+		Name:         migrations,
+		PartitionKey: KeyAttribute{Name: "PK", Type: types.ScalarAttributeTypeS},
+		SortKey:      &KeyAttribute{Name: "SK", Type: types.ScalarAttributeTypeN},
+	}
+}
+
+// AuditTableSchema returns the schema of the audit table, every entry is in one partition sorted by ID
+func AuditTableSchema(audit string) TableSchema {
+	return TableSchema{
+		Name:         audit,
+		PartitionKey: KeyAttribute{Name: "PK", Type: types.ScalarAttributeTypeS},
+		SortKey:      &KeyAttribute{Name: "SK", Type: types.ScalarAttributeTypeS},
+	}
+}
+
+// LedgerTableSchema returns the schema of the ledger table, keyed like the audit table
+func LedgerTableSchema(ledger string) TableSchema {
+	return AuditTableSchema(ledger)
+}
+
+// DynamoProvisioner checks that tables exist with the expected key schema,
+// and creates the missing ones when CreateMissing is set.
+type DynamoProvisioner struct {
+	Client        *dynamodb.Client
+	CreateMissing bool
+}
+
+// Ensure checks every schema and returns the first problem found.
+// A table with different keys is never changed, it returns ErrTableSchemaMismatch.
+// A missing index is not a problem: the storage scans until it exists, see DynamoVoteStorage. It is only logged,
+// and added to an existing table when CreateMissing is set, without waiting for the backfill of the existing items.
+func (p *DynamoProvisioner) Ensure(ctx context.Context, schemas []TableSchema) error {
+	for _, schema := range schemas {
+		out, err := p.Client.DescribeTable(ctx, &dynamodb.DescribeTableInput{TableName: aws.String(schema.Name)})
+		var notFound *types.ResourceNotFoundException
+		switch {
+		case errors.As(err, &notFound):
+			if !p.CreateMissing {
+				return fmt.Errorf("%w: %s", ErrTableMissing, schema.Name)
+			}
+			if err := p.create(ctx, schema); err != nil {
+				return err
+			}
+		case err != nil:
+			logging.Log.Errorf("PROVISION: failed to describe table %s: %v", schema.Name, err)
+			return err
+		default:
+			if err := CheckTableSchema(schema, out.Table); err != nil {
+				return err
+			}
+			for _, index := range MissingIndexes(schema, out.Table) {
+				if !p.CreateMissing {
+					logging.Log.Warnf("PROVISION: index %s on table %s does not exist, its lookups scan the table until it is added", index.Name, schema.Name)
+					continue
+				}
+				if err := p.addIndex(ctx, schema.Name, index); err != nil {
+					return err
+				}
+			}
+			logging.Log.Infof("PROVISION: table %s has the expected key schema", schema.Name)
+		}
+	}
+	return nil
+}
+
+func (p *DynamoProvisioner) create(ctx context.Context, schema TableSchema) error {
+	input := &dynamodb.CreateTableInput{
+		TableName:   aws.String(schema.Name),
+		BillingMode: types.BillingModePayPerRequest,
+		AttributeDefinitions: []types.AttributeDefinition{
+			{AttributeName: aws.String(schema.PartitionKey.Name), AttributeType: schema.PartitionKey.Type},
+		},
+		KeySchema: []types.KeySchemaElement{
+			{AttributeName: aws.String(schema.PartitionKey.Name), KeyType: types.KeyTypeHash},
+		},
+	}
+	if schema.SortKey != nil {
+		input.AttributeDefinitions = append(input.AttributeDefinitions, types.AttributeDefinition{
+			AttributeName: aws.String(schema.SortKey.Name), AttributeType: schema.SortKey.Type,
+		})
+		input.KeySchema = append(input.KeySchema, types.KeySchemaElement{
+			AttributeName: aws.String(schema.SortKey.Name), KeyType: types.KeyTypeRange,
+		})
+	}
+	for _, index := range schema.Indexes {
+		input.AttributeDefinitions = append(input.AttributeDefinitions, types.AttributeDefinition{
+			AttributeName: aws.String(index.PartitionKey.Name), AttributeType: index.PartitionKey.Type,
+		})
+		input.GlobalSecondaryIndexes = append(input.GlobalSecondaryIndexes, index.definition())
+	}
+
+	if _, err := p.Client.CreateTable(ctx, input); err != nil {
+		var inUse *types.ResourceInUseException
+		if !errors.As(err, &inUse) {
+			logging.Log.Errorf("PROVISION: failed to create table %s: %v", schema.Name, err)
+			return err
+		}
+		// Another instance is creating it at the same time, wait for it below
+	}
+
+	waiter := dynamodb.NewTableExistsWaiter(p.Client)
+	if err := waiter.Wait(ctx, &dynamodb.DescribeTableInput{TableName: aws.String(schema.Name)}, tableCreateTimeout); err != nil {
+		logging.Log.Errorf("PROVISION: table %s did not become active: %v", schema.Name, err)
+		return err
+	}
+	logging.Log.Infof("PROVISION: created table %s", schema.Name)
+	return nil
+}
+
+// addIndex starts creating a missing index on an existing table. DynamoDB backfills it from the existing items
+// in the background, which can take minutes, so it is not waited for.
+func (p *DynamoProvisioner) addIndex(ctx context.Context, table string, index IndexSchema) error {
+	definition := index.definition()
+	_, err := p.Client.UpdateTable(ctx, &dynamodb.UpdateTableInput{
+		TableName: aws.String(table),
+		AttributeDefinitions: []types.AttributeDefinition{
+			{AttributeName: aws.String(index.PartitionKey.Name), AttributeType: index.PartitionKey.Type},
+		},
+		GlobalSecondaryIndexUpdates: []types.GlobalSecondaryIndexUpdate{{
+			Create: &types.CreateGlobalSecondaryIndexAction{
+				IndexName:  definition.IndexName,
+				KeySchema:  definition.KeySchema,
+				Projection: definition.Projection,
+			},
+		}},
+	})
+	if err != nil {
+		// Another instance started it first, or the table is still updating: the lookups scan meanwhile
+		var inUse *types.ResourceInUseException
+		var limit *types.LimitExceededException
+		if errors.As(err, &inUse) || errors.As(err, &limit) {
+			logging.Log.Warnf("PROVISION: index %s on table %s not added now: %v", index.Name, table, err)
+			return nil
+		}
+		logging.Log.Errorf("PROVISION: failed to add index %s to table %s: %v", index.Name, table, err)
+		return err
+	}
+	logging.Log.Infof("PROVISION: adding index %s to table %s, its lookups scan the table until it is backfilled", index.Name, table)
+	return nil
+}
+
+func (index IndexSchema) definition() types.GlobalSecondaryIndex {
+	return types.GlobalSecondaryIndex{
+		IndexName: aws.String(index.Name),
+		KeySchema: []types.KeySchemaElement{
+			{AttributeName: aws.String(index.PartitionKey.Name), KeyType: types.KeyTypeHash},
+		},
+		Projection: &types.Projection{ProjectionType: types.ProjectionTypeAll},
+	}
+}
+
+// MissingIndexes returns the indexes of the schema that the existing table does not have
+func MissingIndexes(schema TableSchema, table *types.TableDescription) []IndexSchema {
+	existing := make(map[string]bool, len(table.GlobalSecondaryIndexes))
+	for _, gsi := range table.GlobalSecondaryIndexes {
+		existing[aws.ToString(gsi.IndexName)] = true
+	}
+	var missing []IndexSchema
+	for _, index := range schema.Indexes {
+		if !existing[index.Name] {
+			missing = append(missing, index)
+		}
+	}
+	return missing
+}
+
+// CheckTableSchema compares the keys of an existing table and of its expected indexes with the schema.
+// Indexes the table does not have are not checked, see MissingIndexes.
+func CheckTableSchema(schema TableSchema, table *types.TableDescription) error {
+	attrTypes := make(map[string]types.ScalarAttributeType, len(table.AttributeDefinitions))
+	for _, def := range table.AttributeDefinitions {
+		attrTypes[aws.ToString(def.AttributeName)] = def.AttributeType
+	}
+
+	var hash, rng *KeyAttribute
+	for _, key := range table.KeySchema {
+		attr := &KeyAttribute{Name: aws.ToString(key.AttributeName), Type: attrTypes[aws.ToString(key.AttributeName)]}
+		switch key.KeyType {
+		case types.KeyTypeHash:
+			hash = attr
+		case types.KeyTypeRange:
+			rng = attr
+		}
+	}
+
+	if !sameKey(hash, &schema.PartitionKey) {
+		return fmt.Errorf("%w: table %s must have partition key %s, found %s",
+			ErrTableSchemaMismatch, schema.Name, describeKey(&schema.PartitionKey), describeKey(hash))
+	}
+	if !sameKey(rng, schema.SortKey) {
+		return fmt.Errorf("%w: table %s must have sort key %s, found %s",
+			ErrTableSchemaMismatch, schema.Name, describeKey(schema.SortKey), describeKey(rng))
+	}
+
+	for _, gsi := range table.GlobalSecondaryIndexes {
+		for _, index := range schema.Indexes {
+			if aws.ToString(gsi.IndexName) != index.Name {
+				continue
+			}
+			var hash *KeyAttribute
+			for _, key := range gsi.KeySchema {
+				if key.KeyType == types.KeyTypeHash {
+					hash = &KeyAttribute{Name: aws.ToString(key.AttributeName), Type: attrTypes[aws.ToString(key.AttributeName)]}
+				}
+			}
+			if !sameKey(hash, &index.PartitionKey) {
+				return fmt.Errorf("%w: index %s of table %s must have partition key %s, found %s",
+					ErrTableSchemaMismatch, index.Name, schema.Name, describeKey(&index.PartitionKey), describeKey(hash))
+			}
+		}
+	}
+	return nil
+}
+
+func sameKey(a, b *KeyAttribute) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+func describeKey(key *KeyAttribute) string {
+	if key == nil {
+		return "none"
+	}
+	return fmt.Sprintf("%s (%s)", key.Name, key.Type)
+}
@@ -0,0 +1,85 @@
+package storage
+
+import (
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/smithy-go"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+// describe builds a table description with string typed keys
+func describe(keys ...types.KeySchemaElement) *types.TableDescription {
+	table := &types.TableDescription{KeySchema: keys}
+	for _, k := range keys {
+		table.AttributeDefinitions = append(table.AttributeDefinitions, types.AttributeDefinition{
+			AttributeName: k.AttributeName,
+			AttributeType: types.ScalarAttributeTypeS,
+		})
+	}
+	return table
+}
+
+func TestCheckTableSchema(t *testing.T) {
+	schemas := DynamoTableSchemas("VotingCodes", "Votes", "VotingTeams", "VotingCategories")
+	codes, votes, teams := schemas[0], schemas[1], schemas[2]
+
+	hash := types.KeySchemaElement{AttributeName: aws.String("PK"), KeyType: types.KeyTypeHash}
+	sort := types.KeySchemaElement{AttributeName: aws.String("SK"), KeyType: types.KeyTypeRange}
+
+	assert.NoError(t, CheckTableSchema(codes, describe(hash)))
+	assert.NoError(t, CheckTableSchema(votes, describe(hash, sort)))
+
+	err := CheckTableSchema(votes, describe(hash))
+	assert.ErrorIs(t, err, ErrTableSchemaMismatch)
+	assert.Contains(t, err.Error(), "must have sort key SK (S), found none")
+
+	err = CheckTableSchema(codes, describe(hash, sort))
+	assert.ErrorIs(t, err, ErrTableSchemaMismatch)
+
+	// Teams are keyed by a number, a string PK is rejected
+	err = CheckTableSchema(teams, describe(hash))
+	assert.ErrorIs(t, err, ErrTableSchemaMismatch)
+	assert.Contains(t, err.Error(), "must have partition key PK (N), found PK (S)")
+}
+
+func TestCheckTableIndexes(t *testing.T) {
+	votes := DynamoTableSchemas("VotingCodes", "Votes", "VotingTeams", "VotingCategories")[1]
+	table := describe(
+		types.KeySchemaElement{AttributeName: aws.String("PK"), KeyType: types.KeyTypeHash},
+		types.KeySchemaElement{AttributeName: aws.String("SK"), KeyType: types.KeyTypeRange})
+
+	// Without indexes the keys still match, the indexes are reported missing
+	assert.NoError(t, CheckTableSchema(votes, table))
+	assert.Len(t, MissingIndexes(votes, table), 2)
+
+	table.AttributeDefinitions = append(table.AttributeDefinitions, types.AttributeDefinition{
+		AttributeName: aws.String("TeamID"), AttributeType: types.ScalarAttributeTypeN,
+	})
+	table.GlobalSecondaryIndexes = []types.GlobalSecondaryIndexDescription{{
+		IndexName: aws.String(VotesTeamIndex),
+		KeySchema: []types.KeySchemaElement{{AttributeName: aws.String("TeamID"), KeyType: types.KeyTypeHash}},
+	}}
+	assert.NoError(t, CheckTableSchema(votes, table))
+	missing := MissingIndexes(votes, table)
+	assert.Len(t, missing, 1)
+	assert.Equal(t, VotesCategoryIndex, missing[0].Name)
+
+	// An index keyed differently is never changed
+	table.GlobalSecondaryIndexes[0].KeySchema[0].AttributeName = aws.String("PK")
+	err := CheckTableSchema(votes, table)
+	assert.ErrorIs(t, err, ErrTableSchemaMismatch)
+	assert.Contains(t, err.Error(), "index TeamIndex of table Votes must have partition key TeamID (N), found PK (S)")
+}
+
+func TestIndexUnavailable(t *testing.T) {
+	missing := &smithy.GenericAPIError{Code: "ValidationException", Message: "The table does not have the specified index: TeamIndex"}
+	backfilling := &smithy.GenericAPIError{Code: "ValidationException", Message: "Cannot read from backfilling global secondary index: TeamIndex"}
+	assert.True(t, indexUnavailable(fmt.Errorf("operation error DynamoDB: Query, %w", missing)))
+	assert.True(t, indexUnavailable(backfilling))
+
+	assert.False(t, indexUnavailable(&smithy.GenericAPIError{Code: "ValidationException", Message: "Invalid KeyConditionExpression"}))
+	assert.False(t, indexUnavailable(&types.ResourceNotFoundException{}))
+	assert.False(t, indexUnavailable(nil))
+}
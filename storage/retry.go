@@ -0,0 +1,94 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/alex-pricope/simple-voting-system/logging"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/smithy-go"
+	"time"
+)
+
+// The SDK client retries throttled and failed requests itself. These are the partial failures it
+// reports as a success or as a cancelled transaction, which the storage code has to retry.
+var (
+	ErrUnprocessedItems      = fmt.Errorf("%w: batch write left unprocessed items", ErrThrottled)
+	ErrTransactionThrottled  = fmt.Errorf("%w: transaction cancelled by throttling", ErrThrottled)
+	errRetryAttemptsExceeded = errors.New("retry attempts exceeded")
+)
+
+// backoff waits before retry attempt (counting from 1) of a partial failure, with the delays and the
+// attempt limit of the retryer of the client, so one setting bounds every retry.
+// It returns an error once the attempts are used up or ctx is done.
+func backoff(ctx context.Context, client *dynamodb.Client, attempt int, cause error) error {
+	retryer := client.Options().Retryer
+	if retryer == nil || attempt >= retryer.MaxAttempts() {
+		return fmt.Errorf("%w: %w", cause, errRetryAttemptsExceeded)
+	}
+	delay, err := retryer.RetryDelay(attempt, cause)
+	if err != nil {
+		return fmt.Errorf("%w: %w", cause, err)
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return classify(ctx.Err())
+	case <-timer.C:
+		return nil
+	}
+}
+
+// throttledTransaction reports whether a transaction was cancelled only because an item was throttled
+func throttledTransaction(err error) bool {
+	var tce *types.TransactionCanceledException
+	if !errors.As(err, &tce) {
+		return false
+	}
+	throttled := false
+	for _, reason := range tce.CancellationReasons {
+		switch aws.ToString(reason.Code) {
+		case "", "None":
+		case "ThrottlingError", "ProvisionedThroughputExceeded":
+			throttled = true
+		default:
+			return false
+		}
+	}
+	return throttled
+}
+
+// rejectedTransaction reports whether a transaction is known not to have been applied: it was cancelled,
+// it was invalid, or it was throttled until the retries ran out. Other errors, such as a timeout or
+// a server error, leave it unknown whether it committed.
+func rejectedTransaction(err error) bool {
+	var tce *types.TransactionCanceledException
+	var mismatch *types.IdempotentParameterMismatchException
+	var apiErr smithy.APIError
+	switch {
+	case errors.As(err, &tce), errors.As(err, &mismatch), errors.Is(err, ErrTransactionThrottled):
+		return true
+	case errors.As(err, &apiErr) && apiErr.ErrorCode() == "ValidationException":
+		return true
+	}
+	return false
+}
+
+// transactWithRetry runs TransactWriteItems and retries it while it is cancelled by throttling.
+// Other errors are returned as is, for the caller to read the cancellation reasons.
+func transactWithRetry(ctx context.Context, client *dynamodb.Client, input *dynamodb.TransactWriteItemsInput) error {
+	for attempt := 1; ; attempt++ {
+		_, err := client.TransactWriteItems(ctx, input)
+		if err == nil || !throttledTransaction(err) {
+			return err
+		}
+		logging.Log.Warnf("STORAGE: transaction of %d items throttled, attempt %d", len(input.TransactItems), attempt)
+		if err := backoff(ctx, client, attempt, ErrTransactionThrottled); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/alex-pricope/simple-voting-system/logging"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// fakeDynamo answers every DynamoDB call with the next of the given response bodies and counts the calls
+func fakeDynamo(t *testing.T, responses ...string) (*dynamodb.Client, *int) {
+	t.Helper()
+	logging.Log = logrus.New()
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+		body := responses[min(calls, len(responses)-1)]
+		calls++
+		w.Header().Set("Content-Type", "application/x-amz-json-1.0")
+		if len(body) > 0 && body[0] == '!' {
+			w.WriteHeader(http.StatusBadRequest)
+			body = body[1:]
+		}
+		_, _ = io.WriteString(w, body)
+	}))
+	t.Cleanup(server.Close)
+	return fakeDynamoClient(server.URL), &calls
+}
+
+// fakeDynamoClient is a client of the fake DynamoDB at url, retrying quickly
+func fakeDynamoClient(url string) *dynamodb.Client {
+	return dynamodb.New(dynamodb.Options{
+		BaseEndpoint: aws.String(url),
+		Region:       "eu-west-1",
+		Credentials:  credentials.NewStaticCredentialsProvider("local", "secret", ""),
+		Retryer: retry.NewStandard(func(o *retry.StandardOptions) {
+			o.MaxAttempts = 3
+			o.MaxBackoff = time.Millisecond
+		}),
+	})
+}
+
+func unprocessed(t *testing.T, table string, keys ...string) string {
+	t.Helper()
+	requests := make([]map[string]any, 0, len(keys))
+	for _, key := range keys {
+		requests = append(requests, map[string]any{
+			"DeleteRequest": map[string]any{"Key": map[string]any{"PK": map[string]string{"S": key}}},
+		})
+	}
+	b, err := json.Marshal(map[string]any{"UnprocessedItems": map[string]any{table: requests}})
+	require.NoError(t, err)
+	return string(b)
+}
+
+func deleteRequests(keys ...string) []types.WriteRequest {
+	requests := make([]types.WriteRequest, 0, len(keys))
+	for _, key := range keys {
+		requests = append(requests, types.WriteRequest{DeleteRequest: &types.DeleteRequest{
+			Key: map[string]types.AttributeValue{"PK": &types.AttributeValueMemberS{Value: key}},
+		}})
+	}
+	return requests
+}
+
+func TestBatchWriteRetriesUnprocessedItems(t *testing.T) {
+	t.Run("Unprocessed items are sent again", func(t *testing.T) {
+		client, calls := fakeDynamo(t, unprocessed(t, "Votes", "a", "b"), unprocessed(t, "Votes", "b"), `{}`)
+
+		require.NoError(t, batchWrite(context.TODO(), client, "Votes", deleteRequests("a", "b", "c")))
+		assert.Equal(t, 3, *calls)
+	})
+
+	t.Run("Items left after the last attempt fail the write", func(t *testing.T) {
+		client, calls := fakeDynamo(t, unprocessed(t, "Votes", "a"))
+
+		err := batchWrite(context.TODO(), client, "Votes", deleteRequests("a", "b"))
+		assert.ErrorIs(t, err, ErrUnprocessedItems)
+		assert.ErrorIs(t, err, ErrThrottled)
+		assert.Equal(t, 3, *calls, "one call per attempt of the retryer")
+	})
+
+	t.Run("Cancelled context stops the retries", func(t *testing.T) {
+		client, _ := fakeDynamo(t, unprocessed(t, "Votes", "a"))
+		client = dynamodb.New(client.Options(), func(o *dynamodb.Options) {
+			o.Retryer = retry.NewStandard(func(o *retry.StandardOptions) {
+				o.MaxAttempts = 3
+				o.MaxBackoff = time.Hour
+				o.Backoff = retry.BackoffDelayerFunc(func(int, error) (time.Duration, error) { return time.Hour, nil })
+			})
+		})
+		ctx, cancel := context.WithTimeout(context.TODO(), 10*time.Millisecond)
+		defer cancel()
+
+		err := batchWrite(ctx, client, "Votes", deleteRequests("a"))
+		assert.ErrorIs(t, err, ErrUnavailable)
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+}
+
+func TestTransactWithRetry(t *testing.T) {
+	throttled := `!{"__type":"com.amazonaws.dynamodb.v20120810#TransactionCanceledException","message":"Transaction cancelled",` +
+		`"CancellationReasons":[{"Code":"None"},{"Code":"ThrottlingError"}]}`
+	conditionFailed := `!{"__type":"com.amazonaws.dynamodb.v20120810#TransactionCanceledException","message":"Transaction cancelled",` +
+		`"CancellationReasons":[{"Code":"ConditionalCheckFailed"},{"Code":"ThrottlingError"}]}`
+	input := &dynamodb.TransactWriteItemsInput{TransactItems: []types.TransactWriteItem{}}
+
+	t.Run("Throttled transactions are retried", func(t *testing.T) {
+		client, calls := fakeDynamo(t, throttled, `{}`)
+
+		require.NoError(t, transactWithRetry(context.TODO(), client, input))
+		assert.Equal(t, 2, *calls)
+	})
+
+	t.Run("Throttling until the last attempt fails the transaction", func(t *testing.T) {
+		client, calls := fakeDynamo(t, throttled)
+
+		err := transactWithRetry(context.TODO(), client, input)
+		assert.ErrorIs(t, err, ErrTransactionThrottled)
+		assert.ErrorIs(t, err, ErrThrottled)
+		assert.Equal(t, 3, *calls)
+	})
+
+	t.Run("Other cancellations are returned at once", func(t *testing.T) {
+		client, calls := fakeDynamo(t, conditionFailed)
+
+		err := transactWithRetry(context.TODO(), client, input)
+		var tce *types.TransactionCanceledException
+		require.ErrorAs(t, err, &tce)
+		assert.Equal(t, 1, *calls)
+	})
+}
+
+func TestThrottledTransaction(t *testing.T) {
+	reasons := func(codes ...string) error {
+		tce := &types.TransactionCanceledException{}
+		for _, code := range codes {
+			tce.CancellationReasons = append(tce.CancellationReasons, types.CancellationReason{Code: aws.String(code)})
+		}
+		return tce
+	}
+
+	assert.True(t, throttledTransaction(reasons("None", "ThrottlingError")))
+	assert.True(t, throttledTransaction(reasons("ProvisionedThroughputExceeded", "")))
+	assert.False(t, throttledTransaction(reasons("None", "None")))
+	assert.False(t, throttledTransaction(reasons("ThrottlingError", "ConditionalCheckFailed")))
+	assert.False(t, throttledTransaction(&types.TransactionConflictException{}))
+}
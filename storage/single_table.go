@@ -0,0 +1,268 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/alex-pricope/simple-voting-system/logging"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// Sort key prefixes of the items of an event in the single table, one per item type
+const (
+	SingleTableCodePrefix     = "CODE#"
+	SingleTableVotePrefix     = "VOTE#"
+	SingleTableTeamPrefix     = "TEAM#"
+	SingleTableCategoryPrefix = "CATEGORY#"
+)
+
+// Attributes the single table adds to the attributes of the four-table items.
+// The original PK and SK of an item are kept under EntityPK and EntitySK.
+const (
+	singleTableEntityPK = "EntityPK"
+	singleTableEntitySK = "EntitySK"
+	singleTableType     = "Type"
+)
+
+// Values of the Type attribute of the single-table items
+const (
+	singleTableTypeCode     = "code"
+	singleTableTypeVote     = "vote"
+	singleTableTypeTeam     = "team"
+	singleTableTypeCategory = "category"
+)
+
+// SingleTablePartition is the partition key of the event with the given namespace,
+// EVENT for the original unsuffixed tables and EVENT#<namespace> for the others
+func SingleTablePartition(namespace string) string {
+	if namespace == "" {
+		return "EVENT"
+	}
+	return "EVENT#" + namespace
+}
+
+// SingleTableSchema returns the schema of the single table, the items of an event share one partition
+func SingleTableSchema(table string) TableSchema {
+	return TableSchema{
+		Name:         table,
+		PartitionKey: KeyAttribute{Name: "PK", Type: types.ScalarAttributeTypeS},
+		SortKey:      &KeyAttribute{Name: "SK", Type: types.ScalarAttributeTypeS},
+	}
+}
+
+// SingleTable is the partition of one event in the single-table layout. The codes, votes, teams and
+// categories of the event are all items of that partition, told apart by the prefix of their sort key,
+// so LoadEvent reads the whole event with one paginated query.
+// The SingleTable storages embed it and implement the same interfaces as the four-table storages.
+type SingleTable struct {
+	Client    *dynamodb.Client
+	TableName string
+	Partition string
+}
+
+// SingleTableCodeKey is the sort key of a voting code
+func SingleTableCodeKey(code string) string {
+	return SingleTableCodePrefix + code
+}
+
+// SingleTableVoteKey is the sort key of a vote, its SK in the Votes table prefixed with the code.
+// The votes of a code share the prefix SingleTableVotePrefix + code + "#".
+func SingleTableVoteKey(code, sortKey string) string {
+	return SingleTableVotePrefix + code + "#" + sortKey
+}
+
+// SingleTableTeamKey is the sort key of a team. IDs are zero padded so the teams sort by ID.
+func SingleTableTeamKey(id int) string {
+	return fmt.Sprintf("%s%010d", SingleTableTeamPrefix, id)
+}
+
+// SingleTableCategoryKey is the sort key of a voting category, zero padded like SingleTableTeamKey
+func SingleTableCategoryKey(id int) string {
+	return fmt.Sprintf("%s%010d", SingleTableCategoryPrefix, id)
+}
+
+func (t SingleTable) key(sortKey string) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		"PK": &types.AttributeValueMemberS{Value: t.Partition},
+		"SK": &types.AttributeValueMemberS{Value: sortKey},
+	}
+}
+
+// toItem marshals a model the way the four-table storages do and moves it into the partition under sortKey
+func (t SingleTable) toItem(model any, sortKey, itemType string) (map[string]types.AttributeValue, error) {
+	item, err := attributevalue.MarshalMap(model)
+	if err != nil {
+		return nil, err
+	}
+	if pk, ok := item["PK"]; ok {
+		item[singleTableEntityPK] = pk
+	}
+	if sk, ok := item["SK"]; ok {
+		item[singleTableEntitySK] = sk
+	}
+	item["PK"] = &types.AttributeValueMemberS{Value: t.Partition}
+	item["SK"] = &types.AttributeValueMemberS{Value: sortKey}
+	item[singleTableType] = &types.AttributeValueMemberS{Value: itemType}
+	return item, nil
+}
+
+// fromItem unmarshals an item of the partition into a model, restoring the keys it has in the four tables
+func fromItem(item map[string]types.AttributeValue, model any) error {
+	return attributevalue.UnmarshalMap(entityItem(item), model)
+}
+
+// fromItems is fromItem for a list of items
+func fromItems(items []map[string]types.AttributeValue, models any) error {
+	entities := make([]map[string]types.AttributeValue, 0, len(items))
+	for _, item := range items {
+		entities = append(entities, entityItem(item))
+	}
+	return attributevalue.UnmarshalListOfMaps(entities, models)
+}
+
+// entityItem returns a copy of a single-table item with the PK and SK of the four-table layout
+func entityItem(item map[string]types.AttributeValue) map[string]types.AttributeValue {
+	entity := make(map[string]types.AttributeValue, len(item))
+	for name, value := range item {
+		switch name {
+		case "PK", "SK", singleTableEntityPK, singleTableEntitySK, singleTableType:
+		default:
+			entity[name] = value
+		}
+	}
+	if pk, ok := item[singleTableEntityPK]; ok {
+		entity["PK"] = pk
+	}
+	if sk, ok := item[singleTableEntitySK]; ok {
+		entity["SK"] = sk
+	}
+	return entity
+}
+
+// queryPrefix returns every item of the partition whose sort key starts with prefix, in sort key order
+func (t SingleTable) queryPrefix(ctx context.Context, prefix string) ([]map[string]types.AttributeValue, error) {
+	return queryAll(ctx, t.Client, &dynamodb.QueryInput{
+		TableName:              &t.TableName,
+		KeyConditionExpression: aws.String("PK = :pk AND begins_with(SK, :prefix)"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":pk":     &types.AttributeValueMemberS{Value: t.Partition},
+			":prefix": &types.AttributeValueMemberS{Value: prefix},
+		},
+	})
+}
+
+// getItem reads the item under sortKey into model and returns ErrNotFound when there is none
+func (t SingleTable) getItem(ctx context.Context, sortKey string, model any) error {
+	out, err := t.Client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: &t.TableName,
+		Key:       t.key(sortKey),
+	})
+	if err != nil {
+		logging.Log.Errorf("SINGLE: GetItem %s failed: %v", sortKey, err)
+		return classify(err)
+	}
+	if out.Item == nil {
+		return ErrNotFound
+	}
+	if err := fromItem(out.Item, model); err != nil {
+		logging.Log.Errorf("SINGLE: failed to unmarshal %s: %v", sortKey, err)
+		return err
+	}
+	return nil
+}
+
+// createItem puts a new item and returns ErrAlreadyExists when its key is taken
+func (t SingleTable) createItem(ctx context.Context, item map[string]types.AttributeValue) error {
+	_, err := t.Client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName:           &t.TableName,
+		Item:                item,
+		ConditionExpression: aws.String("attribute_not_exists(PK)"),
+	})
+	var cce *types.ConditionalCheckFailedException
+	if errors.As(err, &cce) {
+		return ErrAlreadyExists
+	}
+	return classify(err)
+}
+
+// deleteItem removes the item under sortKey, a missing item is not an error
+func (t SingleTable) deleteItem(ctx context.Context, sortKey string) error {
+	_, err := t.Client.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName: &t.TableName,
+		Key:       t.key(sortKey),
+	})
+	if err != nil {
+		logging.Log.Errorf("SINGLE: DeleteItem %s failed: %v", sortKey, err)
+		return classify(err)
+	}
+	return nil
+}
+
+// EventData is everything stored for an event that the results are computed from
+type EventData struct {
+	Codes      []*VotingCode
+	Votes      []*Vote
+	Teams      []*Team
+	Categories []*VotingCategory
+}
+
+// EventLoader reads all the codes, votes, teams and categories of an event at once,
+// instead of one storage at a time
+type EventLoader interface {
+	LoadEvent(ctx context.Context) (*EventData, error)
+}
+
+// SingleTableEventLoader loads an event with a single query of its partition
+type SingleTableEventLoader struct {
+	SingleTable
+}
+
+func (s *SingleTableEventLoader) LoadEvent(ctx context.Context) (*EventData, error) {
+	items, err := queryAll(ctx, s.Client, &dynamodb.QueryInput{
+		TableName:              &s.TableName,
+		KeyConditionExpression: aws.String("PK = :pk"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":pk": &types.AttributeValueMemberS{Value: s.Partition},
+		},
+	})
+	if err != nil {
+		logging.Log.Errorf("SINGLE: failed to load partition %s: %v", s.Partition, err)
+		return nil, classify(err)
+	}
+
+	data, err := splitEventItems(items)
+	if err != nil {
+		logging.Log.Errorf("SINGLE: failed to unmarshal partition %s: %v", s.Partition, err)
+		return nil, err
+	}
+	logging.Log.Infof("SINGLE: loaded %d items of partition %s", len(items), s.Partition)
+	return data, nil
+}
+
+// splitEventItems unmarshals the items of a partition by their type
+func splitEventItems(items []map[string]types.AttributeValue) (*EventData, error) {
+	byType := make(map[string][]map[string]types.AttributeValue)
+	for _, item := range items {
+		itemType, _ := item[singleTableType].(*types.AttributeValueMemberS)
+		if itemType == nil {
+			continue
+		}
+		byType[itemType.Value] = append(byType[itemType.Value], item)
+	}
+
+	data := &EventData{}
+	for itemType, models := range map[string]any{
+		singleTableTypeCode:     &data.Codes,
+		singleTableTypeVote:     &data.Votes,
+		singleTableTypeTeam:     &data.Teams,
+		singleTableTypeCategory: &data.Categories,
+	} {
+		if err := fromItems(byType[itemType], models); err != nil {
+			return nil, fmt.Errorf("%s items: %w", itemType, err)
+		}
+	}
+	return data, nil
+}
@@ -0,0 +1,86 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"github.com/alex-pricope/simple-voting-system/logging"
+)
+
+// SingleTableVotingCategoryStorage keeps the categories of an event as CATEGORY# items of its partition
+type SingleTableVotingCategoryStorage struct {
+	SingleTable
+}
+
+func (s *SingleTableVotingCategoryStorage) Get(ctx context.Context, id int) (*VotingCategory, error) {
+	var category VotingCategory
+	if err := s.getItem(ctx, SingleTableCategoryKey(id), &category); err != nil {
+		if errors.Is(err, ErrNotFound) {
+			logging.Log.Warnf("SINGLE: no category found with ID %d", id)
+		}
+		return nil, err
+	}
+	return &category, nil
+}
+
+func (s *SingleTableVotingCategoryStorage) GetAll(ctx context.Context) ([]*VotingCategory, error) {
+	items, err := s.queryPrefix(ctx, SingleTableCategoryPrefix)
+	if err != nil {
+		logging.Log.Errorf("SINGLE: query of categories failed: %v", err)
+		return nil, err
+	}
+
+	var categories []*VotingCategory
+	if err := fromItems(items, &categories); err != nil {
+		logging.Log.Errorf("SINGLE: failed to unmarshal categories: %v", err)
+		return nil, err
+	}
+	return categories, nil
+}
+
+func (s *SingleTableVotingCategoryStorage) Create(ctx context.Context, category *VotingCategory) error {
+	category.Version = 1
+	item, err := s.toItem(category, SingleTableCategoryKey(category.ID), singleTableTypeCategory)
+	if err != nil {
+		logging.Log.Errorf("SINGLE: failed to marshal category: %v", err)
+		return err
+	}
+
+	if err := s.createItem(ctx, item); err != nil {
+		if errors.Is(err, ErrAlreadyExists) {
+			logging.Log.Warnf("SINGLE: category with ID %d already exists", category.ID)
+			return err
+		}
+		logging.Log.Errorf("SINGLE: failed to create category: %v", err)
+		return err
+	}
+	return nil
+}
+
+func (s *SingleTableVotingCategoryStorage) Update(ctx context.Context, category *VotingCategory) error {
+	updated := *category
+	updated.Version++
+	item, err := s.toItem(updated, SingleTableCategoryKey(category.ID), singleTableTypeCategory)
+	if err != nil {
+		logging.Log.Errorf("SINGLE: failed to marshal updated category: %v", err)
+		return err
+	}
+
+	if err := putVersioned(ctx, s.Client, s.TableName, item, category.Version); err != nil {
+		if errors.Is(err, ErrNotFound) || errors.Is(err, ErrVersionConflict) {
+			logging.Log.Warnf("SINGLE: update of category %d at version %d rejected: %v", category.ID, category.Version, err)
+			return err
+		}
+		logging.Log.Errorf("SINGLE: failed to update category: %v", err)
+		return err
+	}
+	category.Version = updated.Version
+	return nil
+}
+
+func (s *SingleTableVotingCategoryStorage) Delete(ctx context.Context, id int) error {
+	if err := s.deleteItem(ctx, SingleTableCategoryKey(id)); err != nil {
+		return err
+	}
+	logging.Log.Infof("SINGLE: deleted category with ID %d", id)
+	return nil
+}
@@ -0,0 +1,190 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"github.com/alex-pricope/simple-voting-system/logging"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"strings"
+	"time"
+)
+
+// SingleTableVotingCodesStorage keeps the voting codes of an event as CODE# items of its partition
+type SingleTableVotingCodesStorage struct {
+	SingleTable
+}
+
+func (s *SingleTableVotingCodesStorage) Get(ctx context.Context, code string) (*VotingCode, error) {
+	var vc VotingCode
+	if err := s.getItem(ctx, SingleTableCodeKey(code), &vc); err != nil {
+		return nil, err
+	}
+	return &vc, nil
+}
+
+func (s *SingleTableVotingCodesStorage) GetAll(ctx context.Context) ([]*VotingCode, error) {
+	items, err := s.queryPrefix(ctx, SingleTableCodePrefix)
+	if err != nil {
+		logging.Log.Errorf("SINGLE: query of codes failed: %v", err)
+		return nil, err
+	}
+
+	var codes []*VotingCode
+	if err := fromItems(items, &codes); err != nil {
+		logging.Log.Errorf("SINGLE: failed to unmarshal codes: %v", err)
+		return nil, err
+	}
+	return codes, nil
+}
+
+func (s *SingleTableVotingCodesStorage) GetPage(ctx context.Context, limit int, next string) ([]*VotingCode, string, error) {
+	input := &dynamodb.QueryInput{
+		TableName:              &s.TableName,
+		KeyConditionExpression: aws.String("PK = :pk AND begins_with(SK, :prefix)"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":pk":     &types.AttributeValueMemberS{Value: s.Partition},
+			":prefix": &types.AttributeValueMemberS{Value: SingleTableCodePrefix},
+		},
+		Limit: aws.Int32(int32(limit)),
+	}
+	if next != "" {
+		code, err := DecodePageToken(next)
+		if err != nil {
+			return nil, "", classify(err)
+		}
+		input.ExclusiveStartKey = s.key(SingleTableCodeKey(code))
+	}
+
+	out, err := s.Client.Query(ctx, input)
+	if err != nil {
+		logging.Log.Errorf("SINGLE: query of a page of codes failed: %v", err)
+		return nil, "", classify(err)
+	}
+
+	var codes []*VotingCode
+	if err := fromItems(out.Items, &codes); err != nil {
+		logging.Log.Errorf("SINGLE: failed to unmarshal codes: %v", err)
+		return nil, "", err
+	}
+
+	var nextToken string
+	if sk, ok := out.LastEvaluatedKey["SK"].(*types.AttributeValueMemberS); ok {
+		nextToken = EncodePageToken(strings.TrimPrefix(sk.Value, SingleTableCodePrefix))
+	}
+	return codes, nextToken, nil
+}
+
+func (s *SingleTableVotingCodesStorage) Put(ctx context.Context, code *VotingCode) error {
+	if code.CreatedAt.IsZero() {
+		code.CreatedAt = time.Now().UTC()
+	}
+	code.Used = false
+	item, err := s.toItem(code, SingleTableCodeKey(code.Code), singleTableTypeCode)
+	if err != nil {
+		logging.Log.Errorf("SINGLE: failed to marshal code: %v", err)
+		return err
+	}
+
+	if err := s.createItem(ctx, item); err != nil {
+		if errors.Is(err, ErrAlreadyExists) {
+			logging.Log.Warnf("SINGLE: code %s already exists", code.Code)
+			return err
+		}
+		logging.Log.Errorf("SINGLE: failed to put code: %v", err)
+		return err
+	}
+	return nil
+}
+
+func (s *SingleTableVotingCodesStorage) PutBatch(ctx context.Context, codes []*VotingCode) ([]*VotingCode, error) {
+	return putCodeBatch(ctx, s.Client, codes, func(code *VotingCode) (*types.Put, error) {
+		item, err := s.toItem(code, SingleTableCodeKey(code.Code), singleTableTypeCode)
+		if err != nil {
+			return nil, err
+		}
+		return &types.Put{
+			TableName:           &s.TableName,
+			Item:                item,
+			ConditionExpression: aws.String("attribute_not_exists(PK)"),
+		}, nil
+	})
+}
+
+func (s *SingleTableVotingCodesStorage) Overwrite(ctx context.Context, code *VotingCode) error {
+	if code.CreatedAt.IsZero() {
+		code.CreatedAt = time.Now().UTC()
+	}
+	item, err := s.toItem(code, SingleTableCodeKey(code.Code), singleTableTypeCode)
+	if err != nil {
+		logging.Log.Errorf("SINGLE: failed to marshal code: %v", err)
+		return err
+	}
+	if _, err := s.Client.PutItem(ctx, &dynamodb.PutItemInput{TableName: &s.TableName, Item: item}); err != nil {
+		logging.Log.Errorf("SINGLE: failed to overwrite code %s: %v", code.Code, err)
+		return classify(err)
+	}
+	return nil
+}
+
+func (s *SingleTableVotingCodesStorage) MarkUnused(ctx context.Context, code string) error {
+	return s.setUsed(ctx, code, false)
+}
+
+func (s *SingleTableVotingCodesStorage) MarkUsed(ctx context.Context, code string) error {
+	return s.setUsed(ctx, code, true)
+}
+
+// setUsed creates the code when it does not exist, like the UpdateItem of DynamoVotingCodesStorage,
+// with the attributes that make it a code item
+func (s *SingleTableVotingCodesStorage) setUsed(ctx context.Context, code string, used bool) error {
+	_, err := s.Client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:        &s.TableName,
+		Key:              s.key(SingleTableCodeKey(code)),
+		UpdateExpression: aws.String("SET Used = :used, #pk = if_not_exists(#pk, :code), #type = if_not_exists(#type, :type)"),
+		ExpressionAttributeNames: map[string]string{
+			"#pk":   singleTableEntityPK,
+			"#type": singleTableType,
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":used": &types.AttributeValueMemberBOOL{Value: used},
+			":code": &types.AttributeValueMemberS{Value: code},
+			":type": &types.AttributeValueMemberS{Value: singleTableTypeCode},
+		},
+	})
+	return classify(err)
+}
+
+func (s *SingleTableVotingCodesStorage) SetExpiry(ctx context.Context, code string, expiresAt *time.Time) error {
+	input := &dynamodb.UpdateItemInput{
+		TableName:           &s.TableName,
+		Key:                 s.key(SingleTableCodeKey(code)),
+		UpdateExpression:    aws.String("REMOVE ExpiresAt"),
+		ConditionExpression: aws.String("attribute_exists(PK)"),
+	}
+	if expiresAt != nil {
+		value, err := attributevalue.Marshal(expiresAt.UTC())
+		if err != nil {
+			logging.Log.Errorf("SINGLE: failed to marshal expiry: %v", err)
+			return err
+		}
+		input.UpdateExpression = aws.String("SET ExpiresAt = :expiresAt")
+		input.ExpressionAttributeValues = map[string]types.AttributeValue{":expiresAt": value}
+	}
+
+	if _, err := s.Client.UpdateItem(ctx, input); err != nil {
+		var cce *types.ConditionalCheckFailedException
+		if errors.As(err, &cce) {
+			return ErrNotFound
+		}
+		logging.Log.Errorf("SINGLE: failed to set expiry of code %s: %v", code, err)
+		return classify(err)
+	}
+	return nil
+}
+
+func (s *SingleTableVotingCodesStorage) Delete(ctx context.Context, code string) error {
+	return s.deleteItem(ctx, SingleTableCodeKey(code))
+}
@@ -0,0 +1,198 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"github.com/alex-pricope/simple-voting-system/logging"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// SingleTableCopyResult counts the items copied into the single table, or that would be in a dry run
+type SingleTableCopyResult struct {
+	Codes      int
+	Votes      int
+	Teams      int
+	Categories int
+	Deleted    int // Items of the partition no longer in the four tables, removed by an overwrite
+}
+
+// ErrPartitionNotEmpty is returned by a copy into a partition that already has items
+var ErrPartitionNotEmpty = fmt.Errorf("%w: the partition already has items, copy with overwrite to replace them", ErrConflict)
+
+// SingleTableCopier copies an event from its four tables into its partition of the single table
+type SingleTableCopier interface {
+	// Copy reads the four tables and puts their items into the partition. A partition that already has
+	// items is refused with ErrPartitionNotEmpty, since it may be served and hold claimed codes and votes
+	// the four tables do not have. With overwrite its items are replaced by the four tables: the copied
+	// items are put and the others deleted, so a failed copy is finished by running it again with overwrite.
+	// A dry run only reads and counts.
+	Copy(ctx context.Context, dryRun, overwrite bool) (*SingleTableCopyResult, error)
+}
+
+// DynamoSingleTableCopier reads the four tables with scans and writes the partition in batches.
+// Votes in either layout of the Votes table are copied one item per rating.
+// Run it while nobody votes: a ballot stored during the copy may be missed.
+type DynamoSingleTableCopier struct {
+	Client *dynamodb.Client
+	From   TableNames
+	To     SingleTable
+}
+
+func (c *DynamoSingleTableCopier) Copy(ctx context.Context, dryRun, overwrite bool) (*SingleTableCopyResult, error) {
+	result := &SingleTableCopyResult{}
+
+	existing, err := c.partitionKeys(ctx)
+	if err != nil {
+		return result, err
+	}
+	if len(existing) > 0 && !overwrite {
+		logging.Log.Warnf("SINGLE: partition %s of %s already has %d items, not copying", c.To.Partition, c.To.TableName, len(existing))
+		return result, ErrPartitionNotEmpty
+	}
+	copied := make(map[string]bool)
+
+	codes, err := (&DynamoVotingCodesStorage{Client: c.Client, TableName: c.From.Codes}).GetAll(ctx)
+	if err != nil {
+		return result, err
+	}
+	if err := c.copyItems(ctx, dryRun, copied, len(codes), &result.Codes, func(i int) (map[string]types.AttributeValue, error) {
+		return c.To.toItem(codes[i], SingleTableCodeKey(codes[i].Code), singleTableTypeCode)
+	}); err != nil {
+		return result, err
+	}
+
+	teams, err := (&DynamoTeamStorage{Client: c.Client, TableName: c.From.Teams}).GetAll(ctx)
+	if err != nil {
+		return result, err
+	}
+	if err := c.copyItems(ctx, dryRun, copied, len(teams), &result.Teams, func(i int) (map[string]types.AttributeValue, error) {
+		return c.To.toItem(teams[i], SingleTableTeamKey(teams[i].ID), singleTableTypeTeam)
+	}); err != nil {
+		return result, err
+	}
+
+	categories, err := (&DynamoVotingCategoryStorage{Client: c.Client, TableName: c.From.Categories}).GetAll(ctx)
+	if err != nil {
+		return result, err
+	}
+	if err := c.copyItems(ctx, dryRun, copied, len(categories), &result.Categories, func(i int) (map[string]types.AttributeValue, error) {
+		return c.To.toItem(categories[i], SingleTableCategoryKey(categories[i].ID), singleTableTypeCategory)
+	}); err != nil {
+		return result, err
+	}
+
+	items, err := scanAll(ctx, c.Client, &dynamodb.ScanInput{TableName: &c.From.Votes})
+	if err != nil {
+		logging.Log.Errorf("SINGLE: scan of %s failed: %v", c.From.Votes, err)
+		return result, err
+	}
+	votes, err := votesOfItems(items)
+	if err != nil {
+		logging.Log.Errorf("SINGLE: failed to unmarshal the votes of %s: %v", c.From.Votes, err)
+		return result, err
+	}
+	if err := c.copyItems(ctx, dryRun, copied, len(votes), &result.Votes, func(i int) (map[string]types.AttributeValue, error) {
+		return c.To.toItem(votes[i], SingleTableVoteKey(votes[i].Code, votes[i].SortKey), singleTableTypeVote)
+	}); err != nil {
+		return result, err
+	}
+
+	var deletes []types.WriteRequest
+	for _, sortKey := range existing {
+		if !copied[sortKey] {
+			deletes = append(deletes, types.WriteRequest{DeleteRequest: &types.DeleteRequest{Key: c.To.key(sortKey)}})
+		}
+	}
+	if !dryRun {
+		if err := batchWrite(ctx, c.Client, c.To.TableName, deletes); err != nil {
+			return result, err
+		}
+	}
+	result.Deleted = len(deletes)
+
+	logging.Log.Infof("SINGLE: copied %d codes, %d teams, %d categories and %d votes into partition %s of %s, deleted %d (dry run: %t)",
+		result.Codes, result.Teams, result.Categories, result.Votes, c.To.Partition, c.To.TableName, result.Deleted, dryRun)
+	return result, nil
+}
+
+// partitionKeys returns the sort keys of the items already in the partition
+func (c *DynamoSingleTableCopier) partitionKeys(ctx context.Context) ([]string, error) {
+	items, err := queryAll(ctx, c.Client, &dynamodb.QueryInput{
+		TableName:                 &c.To.TableName,
+		KeyConditionExpression:    aws.String("PK = :pk"),
+		ProjectionExpression:      aws.String("SK"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{":pk": &types.AttributeValueMemberS{Value: c.To.Partition}},
+	})
+	if err != nil {
+		logging.Log.Errorf("SINGLE: failed to read partition %s of %s: %v", c.To.Partition, c.To.TableName, err)
+		return nil, classify(err)
+	}
+	keys := make([]string, 0, len(items))
+	for _, item := range items {
+		if sk, ok := item["SK"].(*types.AttributeValueMemberS); ok {
+			keys = append(keys, sk.Value)
+		}
+	}
+	return keys, nil
+}
+
+// copyItems puts the n items built by item into the single table, adds their sort keys to written
+// and counts them in copied
+func (c *DynamoSingleTableCopier) copyItems(ctx context.Context, dryRun bool, written map[string]bool, n int, copied *int,
+	item func(i int) (map[string]types.AttributeValue, error)) error {
+	puts := make([]types.WriteRequest, 0, n)
+	for i := 0; i < n; i++ {
+		it, err := item(i)
+		if err != nil {
+			return fmt.Errorf("failed to marshal item: %w", err)
+		}
+		if sk, ok := it["SK"].(*types.AttributeValueMemberS); ok {
+			written[sk.Value] = true
+		}
+		puts = append(puts, types.WriteRequest{PutRequest: &types.PutRequest{Item: it}})
+	}
+	if !dryRun {
+		if err := batchWrite(ctx, c.Client, c.To.TableName, puts); err != nil {
+			return err
+		}
+	}
+	*copied = n
+	return nil
+}
+
+// votesOfItems unmarshals the items of a Votes table into one Vote per rating, expanding the items
+// of the ballot layout. A rating found both as a row and in a ballot, as left by an interrupted
+// layout conversion, is returned once.
+func votesOfItems(items []map[string]types.AttributeValue) ([]*Vote, error) {
+	var votes []*Vote
+	seen := make(map[string]bool)
+	add := func(vote *Vote) {
+		key := SingleTableVoteKey(vote.Code, vote.SortKey)
+		if !seen[key] {
+			seen[key] = true
+			votes = append(votes, vote)
+		}
+	}
+
+	for _, item := range items {
+		if sk, ok := item["SK"].(*types.AttributeValueMemberS); ok && sk.Value == BallotSortKey {
+			var ballot Ballot
+			if err := attributevalue.UnmarshalMap(item, &ballot); err != nil {
+				return nil, err
+			}
+			for _, vote := range ballot.Votes() {
+				add(vote)
+			}
+			continue
+		}
+		var vote Vote
+		if err := attributevalue.UnmarshalMap(item, &vote); err != nil {
+			return nil, err
+		}
+		add(&vote)
+	}
+	return votes, nil
+}
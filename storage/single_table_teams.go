@@ -0,0 +1,86 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"github.com/alex-pricope/simple-voting-system/logging"
+)
+
+// SingleTableTeamStorage keeps the teams of an event as TEAM# items of its partition
+type SingleTableTeamStorage struct {
+	SingleTable
+}
+
+func (s *SingleTableTeamStorage) Get(ctx context.Context, id int) (*Team, error) {
+	var team Team
+	if err := s.getItem(ctx, SingleTableTeamKey(id), &team); err != nil {
+		if errors.Is(err, ErrNotFound) {
+			logging.Log.Warnf("SINGLE: no team found with ID %d", id)
+		}
+		return nil, err
+	}
+	return &team, nil
+}
+
+func (s *SingleTableTeamStorage) GetAll(ctx context.Context) ([]*Team, error) {
+	items, err := s.queryPrefix(ctx, SingleTableTeamPrefix)
+	if err != nil {
+		logging.Log.Errorf("SINGLE: query of teams failed: %v", err)
+		return nil, err
+	}
+
+	var teams []*Team
+	if err := fromItems(items, &teams); err != nil {
+		logging.Log.Errorf("SINGLE: failed to unmarshal teams: %v", err)
+		return nil, err
+	}
+	return teams, nil
+}
+
+func (s *SingleTableTeamStorage) Create(ctx context.Context, team *Team) error {
+	team.Version = 1
+	item, err := s.toItem(team, SingleTableTeamKey(team.ID), singleTableTypeTeam)
+	if err != nil {
+		logging.Log.Errorf("SINGLE: failed to marshal team: %v", err)
+		return err
+	}
+
+	if err := s.createItem(ctx, item); err != nil {
+		if errors.Is(err, ErrAlreadyExists) {
+			logging.Log.Warnf("SINGLE: team with ID %d already exists", team.ID)
+			return err
+		}
+		logging.Log.Errorf("SINGLE: failed to create team: %v", err)
+		return err
+	}
+	return nil
+}
+
+func (s *SingleTableTeamStorage) Update(ctx context.Context, team *Team) error {
+	updated := *team
+	updated.Version++
+	item, err := s.toItem(updated, SingleTableTeamKey(team.ID), singleTableTypeTeam)
+	if err != nil {
+		logging.Log.Errorf("SINGLE: failed to marshal updated team: %v", err)
+		return err
+	}
+
+	if err := putVersioned(ctx, s.Client, s.TableName, item, team.Version); err != nil {
+		if errors.Is(err, ErrNotFound) || errors.Is(err, ErrVersionConflict) {
+			logging.Log.Warnf("SINGLE: update of team %d at version %d rejected: %v", team.ID, team.Version, err)
+			return err
+		}
+		logging.Log.Errorf("SINGLE: failed to update team: %v", err)
+		return err
+	}
+	team.Version = updated.Version
+	return nil
+}
+
+func (s *SingleTableTeamStorage) Delete(ctx context.Context, id int) error {
+	if err := s.deleteItem(ctx, SingleTableTeamKey(id)); err != nil {
+		return err
+	}
+	logging.Log.Infof("SINGLE: deleted team with ID %d", id)
+	return nil
+}
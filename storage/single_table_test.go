@@ -0,0 +1,119 @@
+package storage
+
+import (
+	"context"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestSingleTableKeys(t *testing.T) {
+	assert.Equal(t, "EVENT", SingleTablePartition(""))
+	assert.Equal(t, "EVENT#spring", SingleTablePartition("spring"))
+	assert.Equal(t, "CODE#ABC123", SingleTableCodeKey("ABC123"))
+	assert.Equal(t, "VOTE#ABC123#cat#1#team#2", SingleTableVoteKey("ABC123", VoteSortKey(1, 2)))
+	assert.Equal(t, "TEAM#0000000007", SingleTableTeamKey(7))
+	assert.Equal(t, "CATEGORY#0000000012", SingleTableCategoryKey(12))
+
+	keys := []string{SingleTableTeamKey(10), SingleTableTeamKey(9), SingleTableTeamKey(100)}
+	sort.Strings(keys)
+	assert.Equal(t, []string{SingleTableTeamKey(9), SingleTableTeamKey(10), SingleTableTeamKey(100)}, keys, "teams sort by ID")
+}
+
+func TestSingleTableItems(t *testing.T) {
+	table := SingleTable{TableName: "Voting", Partition: SingleTablePartition("spring")}
+	created := time.Date(2025, 5, 1, 10, 0, 0, 0, time.UTC)
+
+	t.Run("Items are keyed by partition and typed sort key", func(t *testing.T) {
+		item, err := table.toItem(&VotingCode{Code: "ABC123", Category: "jury", CreatedAt: created}, SingleTableCodeKey("ABC123"), singleTableTypeCode)
+		require.NoError(t, err)
+		assert.Equal(t, &types.AttributeValueMemberS{Value: "EVENT#spring"}, item["PK"])
+		assert.Equal(t, &types.AttributeValueMemberS{Value: "CODE#ABC123"}, item["SK"])
+		assert.Equal(t, &types.AttributeValueMemberS{Value: "ABC123"}, item["EntityPK"])
+		assert.Equal(t, &types.AttributeValueMemberS{Value: "code"}, item["Type"])
+		assert.NotContains(t, item, "EntitySK")
+	})
+
+	t.Run("Models survive the round trip", func(t *testing.T) {
+		teamID := 3
+		code := &VotingCode{Code: "ABC123", Category: "jury", TeamID: &teamID, CreatedAt: created, Used: true}
+		vote := &Vote{Code: "ABC123", SortKey: VoteSortKey(1, 2), CategoryID: 1, TeamID: 2, Rating: 4, Timestamp: created}
+		team := &Team{ID: 7, Name: "Rockets", Members: []string{"Ana"}, Version: 2}
+		category := &VotingCategory{ID: 1, Name: "Design", Weight: 0.5, Version: 1, Archived: true}
+
+		for _, tt := range []struct {
+			model, decoded any
+			sortKey        string
+		}{
+			{code, &VotingCode{}, SingleTableCodeKey(code.Code)},
+			{vote, &Vote{}, SingleTableVoteKey(vote.Code, vote.SortKey)},
+			{team, &Team{}, SingleTableTeamKey(team.ID)},
+			{category, &VotingCategory{}, SingleTableCategoryKey(category.ID)},
+		} {
+			item, err := table.toItem(tt.model, tt.sortKey, "any")
+			require.NoError(t, err)
+			require.NoError(t, fromItem(item, tt.decoded))
+			assert.Equal(t, tt.model, tt.decoded)
+		}
+	})
+
+	t.Run("A partition splits into the items of each type", func(t *testing.T) {
+		var items []map[string]types.AttributeValue
+		add := func(model any, sortKey, itemType string) {
+			item, err := table.toItem(model, sortKey, itemType)
+			require.NoError(t, err)
+			items = append(items, item)
+		}
+		add(&VotingCode{Code: "ABC123"}, SingleTableCodeKey("ABC123"), singleTableTypeCode)
+		add(&VotingCode{Code: "DEF456"}, SingleTableCodeKey("DEF456"), singleTableTypeCode)
+		add(&Vote{Code: "ABC123", SortKey: VoteSortKey(1, 7)}, SingleTableVoteKey("ABC123", VoteSortKey(1, 7)), singleTableTypeVote)
+		add(&Team{ID: 7}, SingleTableTeamKey(7), singleTableTypeTeam)
+		add(&VotingCategory{ID: 1}, SingleTableCategoryKey(1), singleTableTypeCategory)
+		items = append(items, map[string]types.AttributeValue{
+			"PK": &types.AttributeValueMemberS{Value: table.Partition},
+			"SK": &types.AttributeValueMemberS{Value: "OTHER#1"},
+		})
+
+		data, err := splitEventItems(items)
+		require.NoError(t, err)
+		assert.Len(t, data.Codes, 2)
+		require.Len(t, data.Votes, 1)
+		assert.Equal(t, VoteSortKey(1, 7), data.Votes[0].SortKey)
+		require.Len(t, data.Teams, 1)
+		assert.Equal(t, 7, data.Teams[0].ID)
+		require.Len(t, data.Categories, 1)
+	})
+}
+
+func TestVotesOfItems(t *testing.T) {
+	row := &Vote{Code: "ABC123", SortKey: VoteSortKey(1, 2), CategoryID: 1, TeamID: 2, Rating: 4}
+	rowItem, err := attributevalue.MarshalMap(row)
+	require.NoError(t, err)
+	ballot := NewBallot("ABC123", []*Vote{row, {Code: "ABC123", SortKey: VoteSortKey(1, 3), CategoryID: 1, TeamID: 3, Rating: 2}})
+	ballotItem, err := attributevalue.MarshalMap(ballot)
+	require.NoError(t, err)
+
+	votes, err := votesOfItems([]map[string]types.AttributeValue{rowItem, ballotItem})
+	require.NoError(t, err)
+	require.Len(t, votes, 2, "the rating in both layouts is copied once")
+	assert.Equal(t, VoteSortKey(1, 2), votes[0].SortKey)
+	assert.Equal(t, VoteSortKey(1, 3), votes[1].SortKey)
+}
+
+func TestSingleTableCopyRefusesNonEmptyPartition(t *testing.T) {
+	client, calls := fakeDynamo(t, `{"Count":1,"Items":[{"SK":{"S":"CODE#AAAAA"}}]}`)
+	copier := &DynamoSingleTableCopier{
+		Client: client,
+		From:   TableNames{Codes: "VotingCodes", Votes: "Votes", Teams: "Teams", Categories: "VotingCategories"},
+		To:     SingleTable{Client: client, TableName: "Voting", Partition: SingleTablePartition("")},
+	}
+
+	_, err := copier.Copy(context.Background(), false, false)
+	assert.ErrorIs(t, err, ErrPartitionNotEmpty)
+	assert.ErrorIs(t, err, ErrConflict)
+	assert.Equal(t, 1, *calls, "nothing is read from the four tables or written")
+}
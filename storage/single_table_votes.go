@@ -0,0 +1,156 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"github.com/alex-pricope/simple-voting-system/logging"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// SingleTableVoteStorage keeps the votes of an event as VOTE# items of its partition, one per rating.
+// The votes of a code are next to each other, so GetByCode is a query of their common prefix.
+type SingleTableVoteStorage struct {
+	SingleTable
+}
+
+func (s *SingleTableVoteStorage) GetAll(ctx context.Context) ([]*Vote, error) {
+	return s.query(ctx, SingleTableVotePrefix)
+}
+
+func (s *SingleTableVoteStorage) Create(ctx context.Context, vote *Vote) error {
+	item, err := s.toItem(vote, SingleTableVoteKey(vote.Code, vote.SortKey), singleTableTypeVote)
+	if err != nil {
+		logging.Log.Errorf("SINGLE: failed to marshal vote: %v", err)
+		return err
+	}
+
+	if err := s.createItem(ctx, item); err != nil {
+		if errors.Is(err, ErrAlreadyExists) {
+			logging.Log.Warnf("SINGLE: vote %s of code %s already exists", vote.SortKey, vote.Code)
+			return err
+		}
+		logging.Log.Errorf("SINGLE: failed to create vote: %v", err)
+		return err
+	}
+	return nil
+}
+
+func (s *SingleTableVoteStorage) GetByCode(ctx context.Context, code string) ([]*Vote, error) {
+	return s.query(ctx, SingleTableVoteKey(code, ""))
+}
+
+// GetByTeam reads every vote of the event, the partition has no index by team
+func (s *SingleTableVoteStorage) GetByTeam(ctx context.Context, teamID int) ([]*Vote, error) {
+	return s.filter(ctx, func(v *Vote) bool { return v.TeamID == teamID })
+}
+
+// GetByCategory reads every vote of the event, the partition has no index by category
+func (s *SingleTableVoteStorage) GetByCategory(ctx context.Context, categoryID int) ([]*Vote, error) {
+	return s.filter(ctx, func(v *Vote) bool { return v.CategoryID == categoryID })
+}
+
+func (s *SingleTableVoteStorage) filter(ctx context.Context, matches func(*Vote) bool) ([]*Vote, error) {
+	all, err := s.GetAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var votes []*Vote
+	for _, v := range all {
+		if matches(v) {
+			votes = append(votes, v)
+		}
+	}
+	return votes, nil
+}
+
+func (s *SingleTableVoteStorage) Delete(ctx context.Context, votes []*Vote) error {
+	keys := make([]map[string]types.AttributeValue, 0, len(votes))
+	for _, v := range votes {
+		keys = append(keys, s.key(SingleTableVoteKey(v.Code, v.SortKey)))
+	}
+	if err := batchDelete(ctx, s.Client, s.TableName, keys); err != nil {
+		logging.Log.Errorf("SINGLE: failed to delete %d votes: %v", len(votes), err)
+		return err
+	}
+	return nil
+}
+
+// DeleteAll removes the votes of the event and leaves its other items alone
+func (s *SingleTableVoteStorage) DeleteAll(ctx context.Context) error {
+	items, err := queryAll(ctx, s.Client, &dynamodb.QueryInput{
+		TableName:              &s.TableName,
+		KeyConditionExpression: aws.String("PK = :pk AND begins_with(SK, :prefix)"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":pk":     &types.AttributeValueMemberS{Value: s.Partition},
+			":prefix": &types.AttributeValueMemberS{Value: SingleTableVotePrefix},
+		},
+		ProjectionExpression: aws.String("PK, SK"),
+	})
+	if err != nil {
+		logging.Log.Errorf("SINGLE: query of votes to delete failed: %v", err)
+		return err
+	}
+	if err := batchDelete(ctx, s.Client, s.TableName, items); err != nil {
+		logging.Log.Errorf("SINGLE: failed to delete %d votes: %v", len(items), err)
+		return err
+	}
+	return nil
+}
+
+// query returns the votes whose sort key starts with prefix, ordered by code and sort key
+func (s *SingleTableVoteStorage) query(ctx context.Context, prefix string) ([]*Vote, error) {
+	items, err := s.queryPrefix(ctx, prefix)
+	if err != nil {
+		logging.Log.Errorf("SINGLE: query of votes %s failed: %v", prefix, err)
+		return nil, err
+	}
+
+	var votes []*Vote
+	if err := fromItems(items, &votes); err != nil {
+		logging.Log.Errorf("SINGLE: failed to unmarshal votes: %v", err)
+		return nil, err
+	}
+	return votes, nil
+}
+
+// SingleTableBallotStorage stores a ballot in the partition of its event, in the same transactions
+// as DynamoBallotStorage: the code item is claimed together with the vote items.
+type SingleTableBallotStorage struct {
+	SingleTable
+}
+
+func (s *SingleTableBallotStorage) Submit(ctx context.Context, code string, votes []*Vote) error {
+	claim := types.TransactWriteItem{
+		Update: &types.Update{
+			TableName:           &s.TableName,
+			Key:                 s.key(SingleTableCodeKey(code)),
+			UpdateExpression:    aws.String("SET Used = :used"),
+			ConditionExpression: aws.String("attribute_exists(PK) AND Used = :unused"),
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":used":   &types.AttributeValueMemberBOOL{Value: true},
+				":unused": &types.AttributeValueMemberBOOL{Value: false},
+			},
+		},
+	}
+
+	puts := make([]types.TransactWriteItem, 0, len(votes))
+	for _, vote := range votes {
+		item, err := s.toItem(vote, SingleTableVoteKey(vote.Code, vote.SortKey), singleTableTypeVote)
+		if err != nil {
+			logging.Log.Errorf("SINGLE: failed to marshal vote: %v", err)
+			return err
+		}
+		puts = append(puts, types.TransactWriteItem{
+			Put: &types.Put{
+				TableName:           &s.TableName,
+				Item:                item,
+				ConditionExpression: aws.String("attribute_not_exists(PK)"),
+			},
+		})
+	}
+
+	codes := &SingleTableVotingCodesStorage{SingleTable: s.SingleTable}
+	return submitBallot(ctx, s.Client, code, claim, puts, codes.MarkUnused)
+}
@@ -0,0 +1,81 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/alex-pricope/simple-voting-system/logging"
+	"github.com/alex-pricope/simple-voting-system/storage"
+)
+
+// AuditStorage is a storage.AuditStorage with one row per entry, the table is only ever inserted into.
+type AuditStorage struct {
+	DB        *sql.DB
+	TableName string
+}
+
+func (s *AuditStorage) CreateTable(ctx context.Context) error {
+	_, err := s.DB.ExecContext(ctx, fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+		id         TEXT PRIMARY KEY,
+		timestamp  TEXT NOT NULL,
+		actor      TEXT NOT NULL,
+		method     TEXT NOT NULL,
+		route      TEXT NOT NULL DEFAULT '',
+		path       TEXT NOT NULL,
+		status     INTEGER NOT NULL,
+		request_id TEXT NOT NULL DEFAULT '',
+		before     TEXT NOT NULL DEFAULT '',
+		after      TEXT NOT NULL DEFAULT ''
+	)`, quote(s.TableName)))
+	if err != nil {
+		logging.Log.Errorf("SQLITE: failed to create table %s: %v", s.TableName, err)
+	}
+	return err
+}
+
+func (s *AuditStorage) Append(ctx context.Context, entry *storage.AuditEntry) error {
+	res, err := s.DB.ExecContext(ctx, fmt.Sprintf(
+		`INSERT INTO %s (id, timestamp, actor, method, route, path, status, request_id, before, after)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?) ON CONFLICT(id) DO NOTHING`, quote(s.TableName)),
+		entry.ID, formatTime(entry.Timestamp), entry.Actor, entry.Method, entry.Route, entry.Path, entry.Status,
+		entry.RequestID, entry.Before, entry.After)
+	if err != nil {
+		logging.Log.Errorf("SQLITE: failed to append audit entry %s: %v", entry.ID, err)
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return storage.ErrAlreadyExists
+	}
+	return nil
+}
+
+// Query selects the time range of the filter by ID and applies the other fields in memory
+func (s *AuditStorage) Query(ctx context.Context, filter storage.AuditFilter) ([]*storage.AuditEntry, error) {
+	from, to := filter.IDRange()
+	rows, err := s.DB.QueryContext(ctx, fmt.Sprintf(
+		`SELECT id, timestamp, actor, method, route, path, status, request_id, before, after
+		FROM %s WHERE id BETWEEN ? AND ? ORDER BY id`, quote(s.TableName)), from, to)
+	if err != nil {
+		logging.Log.Errorf("SQLITE: failed to query audit entries: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []*storage.AuditEntry
+	for rows.Next() {
+		var entry storage.AuditEntry
+		var timestamp string
+		if err := rows.Scan(&entry.ID, &timestamp, &entry.Actor, &entry.Method, &entry.Route, &entry.Path,
+			&entry.Status, &entry.RequestID, &entry.Before, &entry.After); err != nil {
+			logging.Log.Errorf("SQLITE: failed to read audit row: %v", err)
+			return nil, err
+		}
+		if entry.Timestamp, err = parseTime(timestamp); err != nil {
+			return nil, err
+		}
+		if filter.Matches(&entry) {
+			entries = append(entries, &entry)
+		}
+	}
+	return entries, rows.Err()
+}
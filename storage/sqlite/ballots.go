@@ -0,0 +1,55 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/alex-pricope/simple-voting-system/logging"
+	"github.com/alex-pricope/simple-voting-system/storage"
+)
+
+// BallotStorage is a storage.BallotStorage that writes the votes and consumes the code in one SQL transaction.
+type BallotStorage struct {
+	DB             *sql.DB
+	CodesTableName string
+	VotesTableName string
+}
+
+func (s *BallotStorage) Submit(ctx context.Context, code string, votes []*storage.Vote) error {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		logging.Log.Errorf("SQLITE: failed to begin ballot transaction: %v", err)
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	res, err := tx.ExecContext(ctx, fmt.Sprintf(
+		"UPDATE %s SET used = 1 WHERE code = ? AND used = 0", quote(s.CodesTableName)), code)
+	if err != nil {
+		logging.Log.Errorf("SQLITE: failed to claim code %s: %v", code, err)
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return storage.ErrCodeAlreadyUsed
+	}
+
+	insert := fmt.Sprintf(
+		"INSERT INTO %s (code, sort_key, category_id, team_id, rating, timestamp) VALUES (?, ?, ?, ?, ?, ?) ON CONFLICT DO NOTHING",
+		quote(s.VotesTableName))
+	for _, v := range votes {
+		res, err := tx.ExecContext(ctx, insert, v.Code, v.SortKey, v.CategoryID, v.TeamID, v.Rating, formatTime(v.Timestamp))
+		if err != nil {
+			logging.Log.Errorf("SQLITE: failed to insert vote PK: %s, SK: %s: %v", v.Code, v.SortKey, err)
+			return err
+		}
+		if n, err := res.RowsAffected(); err == nil && n == 0 {
+			return storage.ErrAlreadyExists
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		logging.Log.Errorf("SQLITE: failed to commit ballot for code %s: %v", code, err)
+		return err
+	}
+	return nil
+}
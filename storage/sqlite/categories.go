@@ -0,0 +1,111 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/alex-pricope/simple-voting-system/logging"
+	"github.com/alex-pricope/simple-voting-system/storage"
+)
+
+type VotingCategoryStorage struct {
+	DB        *sql.DB
+	TableName string
+}
+
+func (s *VotingCategoryStorage) CreateTable(ctx context.Context) error {
+	_, err := s.DB.ExecContext(ctx, fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+		id          INTEGER PRIMARY KEY,
+		name        TEXT NOT NULL,
+		description TEXT NOT NULL DEFAULT '',
+		weight      REAL NOT NULL DEFAULT 0,
+		version     INTEGER NOT NULL DEFAULT 0,
+		archived    INTEGER NOT NULL DEFAULT 0
+	)`, quote(s.TableName)))
+	if err == nil {
+		err = addColumn(ctx, s.DB, s.TableName, "version", "INTEGER NOT NULL DEFAULT 0")
+	}
+	if err == nil {
+		err = addColumn(ctx, s.DB, s.TableName, "archived", "INTEGER NOT NULL DEFAULT 0")
+	}
+	if err != nil {
+		logging.Log.Errorf("SQLITE: failed to create table %s: %v", s.TableName, err)
+	}
+	return err
+}
+
+func (s *VotingCategoryStorage) Get(ctx context.Context, id int) (*storage.VotingCategory, error) {
+	var category storage.VotingCategory
+	err := s.DB.QueryRowContext(ctx, fmt.Sprintf(
+		"SELECT id, name, description, weight, version, archived FROM %s WHERE id = ?", quote(s.TableName)), id).
+		Scan(&category.ID, &category.Name, &category.Description, &category.Weight, &category.Version, &category.Archived)
+	if errors.Is(err, sql.ErrNoRows) {
+		logging.Log.Warnf("SQLITE: no category found with ID %d", id)
+		return nil, storage.ErrNotFound
+	}
+	if err != nil {
+		logging.Log.Errorf("SQLITE: failed to get category %d: %v", id, err)
+		return nil, err
+	}
+	return &category, nil
+}
+
+func (s *VotingCategoryStorage) GetAll(ctx context.Context) ([]*storage.VotingCategory, error) {
+	rows, err := s.DB.QueryContext(ctx, fmt.Sprintf(
+		"SELECT id, name, description, weight, version, archived FROM %s ORDER BY id", quote(s.TableName)))
+	if err != nil {
+		logging.Log.Errorf("SQLITE: failed to list categories: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var categories []*storage.VotingCategory
+	for rows.Next() {
+		var category storage.VotingCategory
+		if err := rows.Scan(&category.ID, &category.Name, &category.Description, &category.Weight, &category.Version, &category.Archived); err != nil {
+			logging.Log.Errorf("SQLITE: failed to read category row: %v", err)
+			return nil, err
+		}
+		categories = append(categories, &category)
+	}
+	return categories, rows.Err()
+}
+
+func (s *VotingCategoryStorage) Create(ctx context.Context, category *storage.VotingCategory) error {
+	category.Version = 1
+	res, err := s.DB.ExecContext(ctx, fmt.Sprintf(
+		"INSERT INTO %s (id, name, description, weight, version, archived) VALUES (?, ?, ?, ?, ?, ?) ON CONFLICT(id) DO NOTHING",
+		quote(s.TableName)), category.ID, category.Name, category.Description, category.Weight, category.Version, category.Archived)
+	if err != nil {
+		logging.Log.Errorf("SQLITE: failed to create category: %v", err)
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		logging.Log.Warnf("SQLITE: category with ID %d already exists", category.ID)
+		return storage.ErrAlreadyExists
+	}
+	return nil
+}
+
+func (s *VotingCategoryStorage) Update(ctx context.Context, category *storage.VotingCategory) error {
+	err := updateVersioned(ctx, s.DB, s.TableName, category.ID, fmt.Sprintf(
+		"UPDATE %s SET name = ?, description = ?, weight = ?, archived = ?, version = version + 1 WHERE id = ? AND version = ?",
+		quote(s.TableName)), category.Name, category.Description, category.Weight, category.Archived, category.ID, category.Version)
+	if err != nil {
+		logging.Log.Errorf("SQLITE: failed to update category %d: %v", category.ID, err)
+		return err
+	}
+	category.Version++
+	return nil
+}
+
+func (s *VotingCategoryStorage) Delete(ctx context.Context, id int) error {
+	_, err := s.DB.ExecContext(ctx, fmt.Sprintf("DELETE FROM %s WHERE id = ?", quote(s.TableName)), id)
+	if err != nil {
+		logging.Log.Errorf("SQLITE: failed to delete category with ID %d: %v", id, err)
+		return err
+	}
+	logging.Log.Infof("SQLITE: deleted category with ID %d", id)
+	return nil
+}
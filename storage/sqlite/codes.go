@@ -0,0 +1,261 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/alex-pricope/simple-voting-system/logging"
+	"github.com/alex-pricope/simple-voting-system/storage"
+	"time"
+)
+
+type VotingCodesStorage struct {
+	DB        *sql.DB
+	TableName string
+}
+
+func (s *VotingCodesStorage) CreateTable(ctx context.Context) error {
+	_, err := s.DB.ExecContext(ctx, fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+		code       TEXT PRIMARY KEY,
+		category   TEXT NOT NULL DEFAULT '',
+		team_id    INTEGER,
+		created_at TEXT NOT NULL,
+		used       INTEGER NOT NULL DEFAULT 0,
+		expires_at TEXT
+	)`, quote(s.TableName)))
+	if err == nil {
+		err = addColumn(ctx, s.DB, s.TableName, "expires_at", "TEXT")
+	}
+	if err != nil {
+		logging.Log.Errorf("SQLITE: failed to create table %s: %v", s.TableName, err)
+	}
+	return err
+}
+
+func (s *VotingCodesStorage) Get(ctx context.Context, code string) (*storage.VotingCode, error) {
+	row := s.DB.QueryRowContext(ctx, fmt.Sprintf(
+		"SELECT code, category, team_id, created_at, used, expires_at FROM %s WHERE code = ?", quote(s.TableName)), code)
+
+	vc, err := scanCode(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, storage.ErrNotFound
+	}
+	if err != nil {
+		logging.Log.Errorf("SQLITE: failed to get code %s: %v", code, err)
+		return nil, err
+	}
+	return vc, nil
+}
+
+func (s *VotingCodesStorage) GetAll(ctx context.Context) ([]*storage.VotingCode, error) {
+	rows, err := s.DB.QueryContext(ctx, fmt.Sprintf(
+		"SELECT code, category, team_id, created_at, used, expires_at FROM %s ORDER BY code", quote(s.TableName)))
+	if err != nil {
+		logging.Log.Errorf("SQLITE: failed to list codes: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var codes []*storage.VotingCode
+	for rows.Next() {
+		vc, err := scanCode(rows)
+		if err != nil {
+			logging.Log.Errorf("SQLITE: failed to read code row: %v", err)
+			return nil, err
+		}
+		codes = append(codes, vc)
+	}
+	return codes, rows.Err()
+}
+
+func (s *VotingCodesStorage) GetPage(ctx context.Context, limit int, next string) ([]*storage.VotingCode, string, error) {
+	var after string
+	if next != "" {
+		var err error
+		if after, err = storage.DecodePageToken(next); err != nil {
+			return nil, "", err
+		}
+	}
+
+	// Read one extra row to know whether another page follows
+	rows, err := s.DB.QueryContext(ctx, fmt.Sprintf(
+		"SELECT code, category, team_id, created_at, used, expires_at FROM %s WHERE code > ? ORDER BY code LIMIT ?",
+		quote(s.TableName)), after, limit+1)
+	if err != nil {
+		logging.Log.Errorf("SQLITE: failed to list codes page: %v", err)
+		return nil, "", err
+	}
+	defer rows.Close()
+
+	var codes []*storage.VotingCode
+	for rows.Next() {
+		vc, err := scanCode(rows)
+		if err != nil {
+			logging.Log.Errorf("SQLITE: failed to read code row: %v", err)
+			return nil, "", err
+		}
+		codes = append(codes, vc)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, "", err
+	}
+
+	var nextToken string
+	if len(codes) > limit {
+		codes = codes[:limit]
+		nextToken = storage.EncodePageToken(codes[limit-1].Code)
+	}
+	return codes, nextToken, nil
+}
+
+func (s *VotingCodesStorage) Put(ctx context.Context, code *storage.VotingCode) error {
+	if code.CreatedAt.IsZero() {
+		code.CreatedAt = time.Now().UTC()
+	}
+	code.Used = false
+
+	res, err := s.DB.ExecContext(ctx, fmt.Sprintf(
+		"INSERT INTO %s (code, category, team_id, created_at, used, expires_at) VALUES (?, ?, ?, ?, ?, ?) ON CONFLICT(code) DO NOTHING",
+		quote(s.TableName)), code.Code, code.Category, code.TeamID, formatTime(code.CreatedAt), code.Used, formatExpiry(code.ExpiresAt))
+	if err != nil {
+		logging.Log.Errorf("SQLITE: failed to put code %s: %v", code.Code, err)
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		logging.Log.Warnf("SQLITE: code %s already exists", code.Code)
+		return storage.ErrAlreadyExists
+	}
+	return nil
+}
+
+// PutBatch inserts all codes in one transaction, skipping the ones that already exist
+func (s *VotingCodesStorage) PutBatch(ctx context.Context, codes []*storage.VotingCode) ([]*storage.VotingCode, error) {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		logging.Log.Errorf("SQLITE: failed to begin code batch: %v", err)
+		return nil, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	insert := fmt.Sprintf(
+		"INSERT INTO %s (code, category, team_id, created_at, used, expires_at) VALUES (?, ?, ?, ?, ?, ?) ON CONFLICT(code) DO NOTHING",
+		quote(s.TableName))
+	// A failed batch is rolled back, so nothing is returned as stored with an error
+	stored := make([]*storage.VotingCode, 0, len(codes))
+	for _, code := range codes {
+		if code.CreatedAt.IsZero() {
+			code.CreatedAt = time.Now().UTC()
+		}
+		code.Used = false
+
+		res, err := tx.ExecContext(ctx, insert, code.Code, code.Category, code.TeamID, formatTime(code.CreatedAt), code.Used, formatExpiry(code.ExpiresAt))
+		if err != nil {
+			logging.Log.Errorf("SQLITE: failed to put code %s: %v", code.Code, err)
+			return nil, err
+		}
+		if n, err := res.RowsAffected(); err == nil && n == 0 {
+			logging.Log.Warnf("SQLITE: code %s already exists", code.Code)
+			continue
+		}
+		stored = append(stored, code)
+	}
+
+	if err := tx.Commit(); err != nil {
+		logging.Log.Errorf("SQLITE: failed to commit code batch: %v", err)
+		return nil, err
+	}
+	return stored, nil
+}
+
+func (s *VotingCodesStorage) Overwrite(ctx context.Context, code *storage.VotingCode) error {
+	if code.CreatedAt.IsZero() {
+		code.CreatedAt = time.Now().UTC()
+	}
+	_, err := s.DB.ExecContext(ctx, fmt.Sprintf(
+		"INSERT OR REPLACE INTO %s (code, category, team_id, created_at, used, expires_at) VALUES (?, ?, ?, ?, ?, ?)",
+		quote(s.TableName)), code.Code, code.Category, code.TeamID, formatTime(code.CreatedAt), code.Used, formatExpiry(code.ExpiresAt))
+	if err != nil {
+		logging.Log.Errorf("SQLITE: failed to overwrite code %s: %v", code.Code, err)
+	}
+	return err
+}
+
+func (s *VotingCodesStorage) MarkUnused(ctx context.Context, code string) error {
+	return s.setUsed(ctx, code, false)
+}
+
+func (s *VotingCodesStorage) MarkUsed(ctx context.Context, code string) error {
+	return s.setUsed(ctx, code, true)
+}
+
+// setUsed upserts like DynamoDB UpdateItem, which creates the item when the key does not exist.
+func (s *VotingCodesStorage) setUsed(ctx context.Context, code string, used bool) error {
+	_, err := s.DB.ExecContext(ctx, fmt.Sprintf(
+		"INSERT INTO %s (code, created_at, used) VALUES (?, ?, ?) ON CONFLICT(code) DO UPDATE SET used = excluded.used",
+		quote(s.TableName)), code, formatTime(time.Time{}), used)
+	if err != nil {
+		logging.Log.Errorf("SQLITE: failed to set used=%t on code %s: %v", used, code, err)
+	}
+	return err
+}
+
+func (s *VotingCodesStorage) SetExpiry(ctx context.Context, code string, expiresAt *time.Time) error {
+	res, err := s.DB.ExecContext(ctx, fmt.Sprintf("UPDATE %s SET expires_at = ? WHERE code = ?", quote(s.TableName)),
+		formatExpiry(expiresAt), code)
+	if err != nil {
+		logging.Log.Errorf("SQLITE: failed to set expiry of code %s: %v", code, err)
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return storage.ErrNotFound
+	}
+	return nil
+}
+
+func (s *VotingCodesStorage) Delete(ctx context.Context, code string) error {
+	_, err := s.DB.ExecContext(ctx, fmt.Sprintf("DELETE FROM %s WHERE code = ?", quote(s.TableName)), code)
+	if err != nil {
+		logging.Log.Errorf("SQLITE: failed to delete code %s: %v", code, err)
+	}
+	return err
+}
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanCode(row scanner) (*storage.VotingCode, error) {
+	var vc storage.VotingCode
+	var teamID sql.NullInt64
+	var createdAt string
+	var expiresAt sql.NullString
+	if err := row.Scan(&vc.Code, &vc.Category, &teamID, &createdAt, &vc.Used, &expiresAt); err != nil {
+		return nil, err
+	}
+	if expiresAt.Valid {
+		t, err := parseTime(expiresAt.String)
+		if err != nil {
+			return nil, err
+		}
+		vc.ExpiresAt = &t
+	}
+	if teamID.Valid {
+		id := int(teamID.Int64)
+		vc.TeamID = &id
+	}
+	t, err := parseTime(createdAt)
+	if err != nil {
+		return nil, err
+	}
+	vc.CreatedAt = t
+	return &vc, nil
+}
+
+// formatExpiry stores a missing expiry as NULL
+func formatExpiry(t *time.Time) any {
+	if t == nil {
+		return nil
+	}
+	return formatTime(*t)
+}
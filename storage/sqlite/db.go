@@ -0,0 +1,92 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/alex-pricope/simple-voting-system/logging"
+	"github.com/alex-pricope/simple-voting-system/storage"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// Open opens (or creates) the SQLite database file at path.
+// SQLite allows a single writer, so the pool is limited to one connection
+// and concurrent requests queue up instead of failing with SQLITE_BUSY.
+func Open(path string) (*sql.DB, error) {
+	dsn := fmt.Sprintf("file:%s?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)", path)
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		logging.Log.Errorf("SQLITE: failed to open %s: %v", path, err)
+		return nil, err
+	}
+	db.SetMaxOpenConns(1)
+
+	if err := db.Ping(); err != nil {
+		logging.Log.Errorf("SQLITE: failed to connect to %s: %v", path, err)
+		_ = db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// addColumn adds a column to a table created by an older version of the schema.
+// CREATE TABLE IF NOT EXISTS leaves such tables alone, so new columns are added here.
+func addColumn(ctx context.Context, db *sql.DB, table, column, definition string) error {
+	rows, err := db.QueryContext(ctx, fmt.Sprintf("SELECT name FROM pragma_table_info('%s')", table))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return err
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	_ = rows.Close()
+
+	_, err = db.ExecContext(ctx, fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", quote(table), column, definition))
+	return err
+}
+
+// updateVersioned runs an UPDATE guarded by "WHERE id = ? AND version = ?" and maps
+// zero affected rows to storage.ErrNotFound or storage.ErrVersionConflict.
+func updateVersioned(ctx context.Context, db *sql.DB, table string, id int, query string, args ...any) error {
+	res, err := db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil || n > 0 {
+		return err
+	}
+
+	var exists bool
+	if err := db.QueryRowContext(ctx, fmt.Sprintf("SELECT EXISTS(SELECT 1 FROM %s WHERE id = ?)", quote(table)), id).Scan(&exists); err != nil {
+		return err
+	}
+	if !exists {
+		return storage.ErrNotFound
+	}
+	return storage.ErrVersionConflict
+}
+
+// quote returns a table name as a quoted SQL identifier.
+func quote(name string) string {
+	return `"` + name + `"`
+}
+
+func formatTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+func parseTime(s string) (time.Time, error) {
+	return time.Parse(time.RFC3339Nano, s)
+}
@@ -0,0 +1,106 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/alex-pricope/simple-voting-system/logging"
+	"github.com/alex-pricope/simple-voting-system/storage"
+)
+
+type EventStorage struct {
+	DB        *sql.DB
+	TableName string
+}
+
+func (s *EventStorage) CreateTable(ctx context.Context) error {
+	_, err := s.DB.ExecContext(ctx, fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+		id         TEXT PRIMARY KEY,
+		name       TEXT NOT NULL DEFAULT '',
+		namespace  TEXT NOT NULL DEFAULT '',
+		created_at TEXT NOT NULL,
+		archived   INTEGER NOT NULL DEFAULT 0
+	)`, quote(s.TableName)))
+	if err != nil {
+		logging.Log.Errorf("SQLITE: failed to create table %s: %v", s.TableName, err)
+	}
+	return err
+}
+
+func (s *EventStorage) Get(ctx context.Context, id string) (*storage.Event, error) {
+	row := s.DB.QueryRowContext(ctx, fmt.Sprintf(
+		"SELECT id, name, namespace, created_at, archived FROM %s WHERE id = ?", quote(s.TableName)), id)
+
+	event, err := scanEvent(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, storage.ErrNotFound
+	}
+	if err != nil {
+		logging.Log.Errorf("SQLITE: failed to get event %s: %v", id, err)
+		return nil, err
+	}
+	return event, nil
+}
+
+func (s *EventStorage) GetAll(ctx context.Context) ([]*storage.Event, error) {
+	rows, err := s.DB.QueryContext(ctx, fmt.Sprintf(
+		"SELECT id, name, namespace, created_at, archived FROM %s ORDER BY id", quote(s.TableName)))
+	if err != nil {
+		logging.Log.Errorf("SQLITE: failed to list events: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []*storage.Event
+	for rows.Next() {
+		event, err := scanEvent(rows)
+		if err != nil {
+			logging.Log.Errorf("SQLITE: failed to read event row: %v", err)
+			return nil, err
+		}
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
+
+func (s *EventStorage) Create(ctx context.Context, event *storage.Event) error {
+	res, err := s.DB.ExecContext(ctx, fmt.Sprintf(
+		"INSERT INTO %s (id, name, namespace, created_at, archived) VALUES (?, ?, ?, ?, ?) ON CONFLICT(id) DO NOTHING",
+		quote(s.TableName)), event.ID, event.Name, event.Namespace, formatTime(event.CreatedAt), event.Archived)
+	if err != nil {
+		logging.Log.Errorf("SQLITE: failed to create event: %v", err)
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		logging.Log.Warnf("SQLITE: event %s already exists", event.ID)
+		return storage.ErrAlreadyExists
+	}
+	return nil
+}
+
+func (s *EventStorage) SetArchived(ctx context.Context, id string, archived bool) error {
+	res, err := s.DB.ExecContext(ctx, fmt.Sprintf("UPDATE %s SET archived = ? WHERE id = ?", quote(s.TableName)), archived, id)
+	if err != nil {
+		logging.Log.Errorf("SQLITE: failed to set archived=%t on event %s: %v", archived, id, err)
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return storage.ErrNotFound
+	}
+	return nil
+}
+
+func scanEvent(row scanner) (*storage.Event, error) {
+	var event storage.Event
+	var createdAt string
+	if err := row.Scan(&event.ID, &event.Name, &event.Namespace, &createdAt, &event.Archived); err != nil {
+		return nil, err
+	}
+	t, err := parseTime(createdAt)
+	if err != nil {
+		return nil, err
+	}
+	event.CreatedAt = t
+	return &event, nil
+}
@@ -0,0 +1,151 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"github.com/alex-pricope/simple-voting-system/logging"
+	"github.com/alex-pricope/simple-voting-system/storage"
+	"time"
+)
+
+// LedgerStorage is a storage.LedgerStorage with one row per event, the table is only ever inserted into.
+// The votes of an event are kept as a JSON array. The sequence of every code is kept in a second table,
+// named after the first; codes are locked within the process, which is the only one using the file.
+type LedgerStorage struct {
+	DB        *sql.DB
+	TableName string
+	locks     storage.CodeLocks
+}
+
+// ledgerVote is a vote in the JSON of a ledger row, with the sort key the JSON of storage.Vote leaves out
+type ledgerVote struct {
+	Code       string    `json:"code"`
+	SortKey    string    `json:"sortKey"`
+	CategoryID int       `json:"categoryId"`
+	TeamID     int       `json:"teamId"`
+	Rating     int       `json:"rating"`
+	Timestamp  time.Time `json:"timestamp"`
+}
+
+func (s *LedgerStorage) CreateTable(ctx context.Context) error {
+	_, err := s.DB.ExecContext(ctx, fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+		id          TEXT PRIMARY KEY,
+		timestamp   TEXT NOT NULL,
+		type        TEXT NOT NULL,
+		code        TEXT NOT NULL DEFAULT '',
+		voter_group TEXT NOT NULL DEFAULT '',
+		votes       TEXT NOT NULL DEFAULT '[]',
+		used        INTEGER NOT NULL DEFAULT 0,
+		actor       TEXT NOT NULL DEFAULT '',
+		request_id  TEXT NOT NULL DEFAULT '',
+		note        TEXT NOT NULL DEFAULT '',
+		seq         INTEGER NOT NULL DEFAULT 0
+	)`, quote(s.TableName)))
+	if err == nil {
+		err = addColumn(ctx, s.DB, s.TableName, "seq", "INTEGER NOT NULL DEFAULT 0")
+	}
+	if err == nil {
+		_, err = s.DB.ExecContext(ctx, fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+			code TEXT PRIMARY KEY,
+			seq  INTEGER NOT NULL
+		)`, quote(s.sequencesTable())))
+	}
+	if err != nil {
+		logging.Log.Errorf("SQLITE: failed to create table %s: %v", s.TableName, err)
+	}
+	return err
+}
+
+// sequencesTable is the table keeping the last sequence of every code
+func (s *LedgerStorage) sequencesTable() string {
+	return s.TableName + "Sequences"
+}
+
+func (s *LedgerStorage) Append(ctx context.Context, event *storage.LedgerEvent) error {
+	votes := make([]ledgerVote, 0, len(event.Votes))
+	for _, v := range event.Votes {
+		votes = append(votes, ledgerVote(*v))
+	}
+	encoded, err := json.Marshal(votes)
+	if err != nil {
+		logging.Log.Errorf("SQLITE: failed to encode the votes of ledger event %s: %v", event.ID, err)
+		return err
+	}
+
+	res, err := s.DB.ExecContext(ctx, fmt.Sprintf(
+		`INSERT INTO %s (id, timestamp, type, code, voter_group, votes, used, actor, request_id, note, seq)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?) ON CONFLICT(id) DO NOTHING`, quote(s.TableName)),
+		event.ID, formatTime(event.Timestamp), event.Type, event.Code, event.VoterGroup, string(encoded), event.Used,
+		event.Actor, event.RequestID, event.Note, event.Seq)
+	if err != nil {
+		logging.Log.Errorf("SQLITE: failed to append ledger event %s: %v", event.ID, err)
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return storage.ErrAlreadyExists
+	}
+	return nil
+}
+
+// Query selects the time range of the filter by ID and applies the code in memory
+func (s *LedgerStorage) Query(ctx context.Context, filter storage.LedgerFilter) ([]*storage.LedgerEvent, error) {
+	from, to := filter.IDRange()
+	rows, err := s.DB.QueryContext(ctx, fmt.Sprintf(
+		`SELECT id, timestamp, type, code, voter_group, votes, used, actor, request_id, note, seq
+		FROM %s WHERE id BETWEEN ? AND ? ORDER BY id`, quote(s.TableName)), from, to)
+	if err != nil {
+		logging.Log.Errorf("SQLITE: failed to query ledger events: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []*storage.LedgerEvent
+	for rows.Next() {
+		var event storage.LedgerEvent
+		var timestamp, encoded string
+		if err := rows.Scan(&event.ID, &timestamp, &event.Type, &event.Code, &event.VoterGroup, &encoded, &event.Used,
+			&event.Actor, &event.RequestID, &event.Note, &event.Seq); err != nil {
+			logging.Log.Errorf("SQLITE: failed to read ledger row: %v", err)
+			return nil, err
+		}
+		if event.Timestamp, err = parseTime(timestamp); err != nil {
+			return nil, err
+		}
+		var votes []ledgerVote
+		if err := json.Unmarshal([]byte(encoded), &votes); err != nil {
+			logging.Log.Errorf("SQLITE: failed to decode the votes of ledger event %s: %v", event.ID, err)
+			return nil, err
+		}
+		for _, v := range votes {
+			vote := storage.Vote(v)
+			event.Votes = append(event.Votes, &vote)
+		}
+		if filter.Matches(&event) {
+			events = append(events, &event)
+		}
+	}
+	return events, rows.Err()
+}
+
+func (s *LedgerStorage) LockCode(ctx context.Context, code string) (*storage.LedgerLock, error) {
+	if err := s.locks.Lock(ctx, code); err != nil {
+		return nil, err
+	}
+	lock := &storage.LedgerLock{Code: code}
+	err := s.DB.QueryRowContext(ctx, fmt.Sprintf(
+		`INSERT INTO %s (code, seq) VALUES (?, 1) ON CONFLICT(code) DO UPDATE SET seq = seq + 1 RETURNING seq`,
+		quote(s.sequencesTable())), code).Scan(&lock.Seq)
+	if err != nil {
+		s.locks.Unlock(code)
+		logging.Log.Errorf("SQLITE: failed to take the next sequence of code %s: %v", code, err)
+		return nil, err
+	}
+	return lock, nil
+}
+
+func (s *LedgerStorage) UnlockCode(_ context.Context, lock *storage.LedgerLock) error {
+	s.locks.Unlock(lock.Code)
+	return nil
+}
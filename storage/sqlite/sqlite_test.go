@@ -0,0 +1,371 @@
+package sqlite
+
+import (
+	"context"
+	"github.com/alex-pricope/simple-voting-system/logging"
+	"github.com/alex-pricope/simple-voting-system/storage"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func openTestDB(t *testing.T) *VotingCodesStorage {
+	t.Helper()
+	logging.Log = logrus.New()
+
+	db, err := Open(filepath.Join(t.TempDir(), "test.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = db.Close() })
+
+	s := &VotingCodesStorage{DB: db, TableName: "VotingCodes"}
+	require.NoError(t, s.CreateTable(context.TODO()))
+	return s
+}
+
+func TestVotingCodesStorage(t *testing.T) {
+	s := openTestDB(t)
+	ctx := context.TODO()
+
+	_, err := s.Get(ctx, "NOPE")
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+
+	teamID := 7
+	require.NoError(t, s.Put(ctx, &storage.VotingCode{Code: "AAAAA", Category: "other_team", TeamID: &teamID}))
+	assert.ErrorIs(t, s.Put(ctx, &storage.VotingCode{Code: "AAAAA"}), storage.ErrAlreadyExists)
+
+	require.NoError(t, s.MarkUsed(ctx, "AAAAA"))
+	vc, err := s.Get(ctx, "AAAAA")
+	require.NoError(t, err)
+	assert.True(t, vc.Used)
+	assert.Equal(t, "other_team", vc.Category)
+	require.NotNil(t, vc.TeamID)
+	assert.Equal(t, 7, *vc.TeamID)
+	assert.WithinDuration(t, time.Now(), vc.CreatedAt, time.Minute)
+
+	require.NoError(t, s.Put(ctx, &storage.VotingCode{Code: "BBBBB"}))
+	require.NoError(t, s.Put(ctx, &storage.VotingCode{Code: "CCCCC"}))
+	page, next, err := s.GetPage(ctx, 2, "")
+	require.NoError(t, err)
+	require.Len(t, page, 2)
+	require.NotEmpty(t, next)
+
+	page, next, err = s.GetPage(ctx, 2, next)
+	require.NoError(t, err)
+	require.Len(t, page, 1)
+	assert.Equal(t, "CCCCC", page[0].Code)
+	assert.Empty(t, next)
+
+	stored, err := s.PutBatch(ctx, []*storage.VotingCode{{Code: "CCCCC"}, {Code: "DDDDD"}, {Code: "EEEEE"}})
+	require.NoError(t, err)
+	require.Len(t, stored, 2)
+	assert.Equal(t, "DDDDD", stored[0].Code)
+	all, err := s.GetAll(ctx)
+	require.NoError(t, err)
+	assert.Len(t, all, 5)
+
+	expiresAt := time.Now().Add(time.Hour).UTC()
+	require.NoError(t, s.SetExpiry(ctx, "AAAAA", &expiresAt))
+	assert.ErrorIs(t, s.SetExpiry(ctx, "NOPE", &expiresAt), storage.ErrNotFound)
+	vc, err = s.Get(ctx, "AAAAA")
+	require.NoError(t, err)
+	assert.True(t, vc.Used, "SetExpiry keeps the other columns")
+	require.NotNil(t, vc.ExpiresAt)
+	assert.True(t, vc.ExpiresAt.Equal(expiresAt))
+
+	require.NoError(t, s.SetExpiry(ctx, "AAAAA", nil))
+	vc, err = s.Get(ctx, "AAAAA")
+	require.NoError(t, err)
+	assert.Nil(t, vc.ExpiresAt)
+}
+
+func TestVoteStorage(t *testing.T) {
+	codes := openTestDB(t)
+	s := &VoteStorage{DB: codes.DB, TableName: "Votes"}
+	ctx := context.TODO()
+	require.NoError(t, s.CreateTable(ctx))
+
+	require.NoError(t, s.Create(ctx, &storage.Vote{Code: "A", SortKey: "cat#1#team#2", CategoryID: 1, TeamID: 2, Rating: 3}))
+	require.NoError(t, s.Create(ctx, &storage.Vote{Code: "A", SortKey: "cat#1#team#1", CategoryID: 1, TeamID: 1, Rating: 4}))
+	assert.ErrorIs(t, s.Create(ctx, &storage.Vote{Code: "A", SortKey: "cat#1#team#1"}), storage.ErrAlreadyExists)
+
+	votes, err := s.GetByCode(ctx, "A")
+	require.NoError(t, err)
+	require.Len(t, votes, 2)
+	assert.Equal(t, 1, votes[0].TeamID)
+	assert.Equal(t, 4, votes[0].Rating)
+
+	require.NoError(t, s.Create(ctx, &storage.Vote{Code: "B", SortKey: "cat#2#team#1", CategoryID: 2, TeamID: 1, Rating: 5}))
+	byTeam, err := s.GetByTeam(ctx, 1)
+	require.NoError(t, err)
+	require.Len(t, byTeam, 2)
+	assert.Equal(t, "A", byTeam[0].Code)
+	byCategory, err := s.GetByCategory(ctx, 1)
+	require.NoError(t, err)
+	assert.Len(t, byCategory, 2)
+
+	require.NoError(t, s.Delete(ctx, []*storage.Vote{votes[0], {Code: "B", SortKey: "cat#1#team#1"}}))
+	votes, err = s.GetByCode(ctx, "A")
+	require.NoError(t, err)
+	require.Len(t, votes, 1)
+	assert.Equal(t, 2, votes[0].TeamID)
+
+	require.NoError(t, s.DeleteAll(ctx))
+	all, err := s.GetAll(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, all)
+}
+
+func TestTeamStorage(t *testing.T) {
+	codes := openTestDB(t)
+	s := &TeamStorage{DB: codes.DB, TableName: "VotingTeams"}
+	ctx := context.TODO()
+	require.NoError(t, s.CreateTable(ctx))
+
+	_, err := s.Get(ctx, 1)
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+
+	require.NoError(t, s.Create(ctx, &storage.Team{ID: 1, Name: "Alpha", Members: []string{"Alice", "Bob"}}))
+	assert.ErrorIs(t, s.Create(ctx, &storage.Team{ID: 1, Name: "Other"}), storage.ErrAlreadyExists)
+
+	update := &storage.Team{ID: 1, Name: "Alpha 2", Members: []string{"Alice"}, Archived: true, Version: 1}
+	require.NoError(t, s.Update(ctx, update))
+	assert.Equal(t, 2, update.Version)
+	team, err := s.Get(ctx, 1)
+	require.NoError(t, err)
+	assert.Equal(t, "Alpha 2", team.Name)
+	assert.True(t, team.Archived)
+	assert.Equal(t, []string{"Alice"}, team.Members)
+	assert.Equal(t, 2, team.Version)
+
+	assert.ErrorIs(t, s.Update(ctx, &storage.Team{ID: 1, Name: "Stale", Version: 1}), storage.ErrVersionConflict)
+	assert.ErrorIs(t, s.Update(ctx, &storage.Team{ID: 99, Name: "Ghost", Version: 1}), storage.ErrNotFound)
+}
+
+func TestBallotStorage(t *testing.T) {
+	codes := openTestDB(t)
+	votes := &VoteStorage{DB: codes.DB, TableName: "Votes"}
+	ballots := &BallotStorage{DB: codes.DB, CodesTableName: "VotingCodes", VotesTableName: "Votes"}
+	ctx := context.TODO()
+	require.NoError(t, votes.CreateTable(ctx))
+	require.NoError(t, codes.Put(ctx, &storage.VotingCode{Code: "AAAAA"}))
+
+	ballot := []*storage.Vote{
+		{Code: "AAAAA", SortKey: "cat#1#team#1", CategoryID: 1, TeamID: 1, Rating: 5},
+		{Code: "AAAAA", SortKey: "cat#1#team#1", CategoryID: 1, TeamID: 1, Rating: 2},
+	}
+	assert.ErrorIs(t, ballots.Submit(ctx, "AAAAA", ballot), storage.ErrAlreadyExists)
+
+	// The failed ballot must leave nothing behind
+	vc, err := codes.Get(ctx, "AAAAA")
+	require.NoError(t, err)
+	assert.False(t, vc.Used)
+	stored, err := votes.GetByCode(ctx, "AAAAA")
+	require.NoError(t, err)
+	assert.Empty(t, stored)
+
+	require.NoError(t, ballots.Submit(ctx, "AAAAA", ballot[:1]))
+	assert.ErrorIs(t, ballots.Submit(ctx, "AAAAA", ballot[:1]), storage.ErrCodeAlreadyUsed)
+	assert.ErrorIs(t, ballots.Submit(ctx, "NOPE", ballot[:1]), storage.ErrCodeAlreadyUsed)
+}
+
+func TestCreateTableAddsVersionToOldSchema(t *testing.T) {
+	db := openTestDB(t).DB
+	ctx := context.TODO()
+
+	// A categories table as created before versioning
+	_, err := db.ExecContext(ctx, `CREATE TABLE "VotingCategories" (id INTEGER PRIMARY KEY, name TEXT NOT NULL, description TEXT NOT NULL DEFAULT '', weight REAL NOT NULL DEFAULT 0)`)
+	require.NoError(t, err)
+	_, err = db.ExecContext(ctx, `INSERT INTO "VotingCategories" (id, name) VALUES (1, 'Jury')`)
+	require.NoError(t, err)
+
+	s := &VotingCategoryStorage{DB: db, TableName: "VotingCategories"}
+	require.NoError(t, s.CreateTable(ctx))
+	require.NoError(t, s.CreateTable(ctx))
+
+	category, err := s.Get(ctx, 1)
+	require.NoError(t, err)
+	assert.Equal(t, 0, category.Version)
+
+	category.Name = "Grand Jury"
+	require.NoError(t, s.Update(ctx, category))
+	assert.Equal(t, 1, category.Version)
+}
+
+func TestTallyStorage(t *testing.T) {
+	codes := openTestDB(t)
+	s := &TallyStorage{DB: codes.DB, TableName: "VoteTallies"}
+	ctx := context.TODO()
+	require.NoError(t, s.CreateTable(ctx))
+
+	votes := []*storage.Vote{{TeamID: 1, CategoryID: 1, Rating: 5}, {TeamID: 2, CategoryID: 1, Rating: 3}}
+	require.NoError(t, s.Add(ctx, "grand_jury", votes))
+	require.NoError(t, s.Add(ctx, "general_public", votes[:1]))
+	require.NoError(t, s.Add(ctx, "grand_jury", votes))
+
+	tallies, err := s.GetAll(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 3, tallies.Ballots)
+	require.Len(t, tallies.Scores, 3)
+	assert.Equal(t, &storage.Tally{Key: "team#1#cat#1#grand_jury", TeamID: 1, CategoryID: 1, VoterGroup: "grand_jury", Sum: 10, Count: 2}, tallies.Scores[1])
+
+	require.NoError(t, s.Subtract(ctx, "general_public", votes[:1], 1))
+	require.NoError(t, s.Subtract(ctx, "grand_jury", votes[1:], 0))
+	tallies, err = s.GetAll(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 2, tallies.Ballots)
+	require.Len(t, tallies.Scores, 2)
+	assert.Equal(t, &storage.Tally{Key: "team#2#cat#1#grand_jury", TeamID: 2, CategoryID: 1, VoterGroup: "grand_jury", Sum: 3, Count: 1}, tallies.Scores[1])
+
+	require.NoError(t, s.Replace(ctx, storage.BuildTallies(votes[:1], nil)))
+	tallies, err = s.GetAll(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, tallies.Ballots)
+	require.Len(t, tallies.Scores, 1)
+	assert.Equal(t, 5, tallies.Scores[0].Sum)
+}
+
+func TestEventStorage(t *testing.T) {
+	codes := openTestDB(t)
+	s := &EventStorage{DB: codes.DB, TableName: "VotingEvents"}
+	ctx := context.TODO()
+	require.NoError(t, s.CreateTable(ctx))
+
+	_, err := s.Get(ctx, "spring")
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+
+	created := time.Date(2025, 3, 1, 9, 0, 0, 0, time.UTC)
+	require.NoError(t, s.Create(ctx, &storage.Event{ID: "spring", Name: "Spring", Namespace: "spring", CreatedAt: created}))
+	require.NoError(t, s.Create(ctx, &storage.Event{ID: "default", Name: "Default", CreatedAt: created}))
+	assert.ErrorIs(t, s.Create(ctx, &storage.Event{ID: "spring"}), storage.ErrAlreadyExists)
+
+	require.NoError(t, s.SetArchived(ctx, "spring", true))
+	assert.ErrorIs(t, s.SetArchived(ctx, "unknown", true), storage.ErrNotFound)
+
+	event, err := s.Get(ctx, "spring")
+	require.NoError(t, err)
+	assert.Equal(t, "Spring", event.Name)
+	assert.Equal(t, "spring", event.Namespace)
+	assert.True(t, event.CreatedAt.Equal(created))
+	assert.True(t, event.Archived)
+
+	events, err := s.GetAll(ctx)
+	require.NoError(t, err)
+	require.Len(t, events, 2)
+	assert.Equal(t, "default", events[0].ID)
+	assert.Equal(t, "", events[0].Namespace)
+	assert.False(t, events[0].Archived)
+}
+
+func TestAuditStorage(t *testing.T) {
+	codes := openTestDB(t)
+	s := &AuditStorage{DB: codes.DB, TableName: "VotingAudit"}
+	ctx := context.TODO()
+	require.NoError(t, s.CreateTable(ctx))
+
+	start := time.Date(2025, 3, 1, 9, 0, 0, 0, time.UTC)
+	entry := &storage.AuditEntry{
+		ID:        storage.NewAuditID(start),
+		Timestamp: start,
+		Actor:     "admin",
+		Method:    "POST",
+		Route:     "/api/admin/codes/:code/reset",
+		Path:      "/api/admin/codes/ABC/reset",
+		Status:    200,
+		RequestID: "req-1",
+		Before:    `{"used":true}`,
+		After:     `{"used":false}`,
+	}
+	require.NoError(t, s.Append(ctx, entry))
+	assert.ErrorIs(t, s.Append(ctx, entry), storage.ErrAlreadyExists)
+	require.NoError(t, s.Append(ctx, &storage.AuditEntry{
+		ID: storage.NewAuditID(start.Add(time.Hour)), Timestamp: start.Add(time.Hour), Actor: "jury", Method: "DELETE", Path: "/api/admin/votes", Status: 200,
+	}))
+
+	entries, err := s.Query(ctx, storage.AuditFilter{})
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	assert.Equal(t, entry.ID, entries[0].ID)
+	assert.True(t, entries[0].Timestamp.Equal(start))
+	assert.Equal(t, `{"used":true}`, entries[0].Before)
+	assert.Equal(t, `{"used":false}`, entries[0].After)
+	assert.Equal(t, "req-1", entries[0].RequestID)
+
+	entries, err = s.Query(ctx, storage.AuditFilter{To: start.Add(time.Hour)})
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, "admin", entries[0].Actor)
+
+	entries, err = s.Query(ctx, storage.AuditFilter{Actor: "jury"})
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, "/api/admin/votes", entries[0].Path)
+}
+
+func TestLedgerStorage(t *testing.T) {
+	codes := openTestDB(t)
+	s := &LedgerStorage{DB: codes.DB, TableName: "VotingLedger"}
+	ctx := context.TODO()
+	require.NoError(t, s.CreateTable(ctx))
+
+	start := time.Date(2025, 3, 1, 9, 0, 0, 0, time.UTC)
+	event := &storage.LedgerEvent{
+		ID:         storage.NewAuditID(start),
+		Timestamp:  start,
+		Type:       storage.LedgerBallotImported,
+		Code:       "ABC",
+		VoterGroup: "grand_jury",
+		Votes:      []*storage.Vote{{Code: "ABC", SortKey: storage.VoteSortKey(1, 2), CategoryID: 1, TeamID: 2, Rating: 4}},
+		Used:       true,
+		Actor:      "admin",
+		RequestID:  "req-1",
+		Note:       "seeded from the stored votes",
+	}
+	require.NoError(t, s.Append(ctx, event))
+	assert.ErrorIs(t, s.Append(ctx, event), storage.ErrAlreadyExists)
+	require.NoError(t, s.Append(ctx, &storage.LedgerEvent{
+		ID: storage.NewAuditID(start.Add(time.Hour)), Timestamp: start.Add(time.Hour), Type: storage.LedgerAllVotesDeleted, Actor: "jury",
+	}))
+
+	events, err := s.Query(ctx, storage.LedgerFilter{})
+	require.NoError(t, err)
+	require.Len(t, events, 2)
+	assert.Equal(t, event.ID, events[0].ID)
+	assert.True(t, events[0].Timestamp.Equal(start))
+	assert.Equal(t, event.Votes, events[0].Votes)
+	assert.True(t, events[0].Used)
+	assert.Equal(t, "seeded from the stored votes", events[0].Note)
+	assert.Equal(t, "req-1", events[0].RequestID)
+
+	events, err = s.Query(ctx, storage.LedgerFilter{To: start.Add(time.Hour)})
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	assert.Equal(t, "grand_jury", events[0].VoterGroup)
+
+	// Deleting every vote affects every code
+	events, err = s.Query(ctx, storage.LedgerFilter{From: start.Add(time.Minute), Code: "ABC"})
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	assert.Equal(t, storage.LedgerAllVotesDeleted, events[0].Type)
+
+	t.Run("Locks of a code get the next sequence, kept by the events", func(t *testing.T) {
+		first, err := s.LockCode(ctx, "ABC")
+		require.NoError(t, err)
+		require.NoError(t, s.UnlockCode(ctx, first))
+		second, err := s.LockCode(ctx, "ABC")
+		require.NoError(t, err)
+		require.NoError(t, s.UnlockCode(ctx, second))
+		assert.Equal(t, int64(1), first.Seq)
+		assert.Equal(t, int64(2), second.Seq)
+
+		reset := &storage.LedgerEvent{ID: storage.NewAuditID(start.Add(2 * time.Hour)), Timestamp: start.Add(2 * time.Hour),
+			Type: storage.LedgerCodeReset, Code: "ABC", Seq: second.Seq}
+		require.NoError(t, s.Append(ctx, reset))
+		events, err := s.Query(ctx, storage.LedgerFilter{From: start.Add(2 * time.Hour)})
+		require.NoError(t, err)
+		require.Len(t, events, 1)
+		assert.Equal(t, int64(2), events[0].Seq)
+	})
+}
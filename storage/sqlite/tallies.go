@@ -0,0 +1,129 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/alex-pricope/simple-voting-system/logging"
+	"github.com/alex-pricope/simple-voting-system/storage"
+)
+
+// TallyStorage is a storage.TallyStorage with one row per tally, the ballot counter included.
+type TallyStorage struct {
+	DB        *sql.DB
+	TableName string
+}
+
+func (s *TallyStorage) CreateTable(ctx context.Context) error {
+	_, err := s.DB.ExecContext(ctx, fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+		key         TEXT PRIMARY KEY,
+		team_id     INTEGER NOT NULL DEFAULT 0,
+		category_id INTEGER NOT NULL DEFAULT 0,
+		voter_group TEXT NOT NULL DEFAULT '',
+		sum         INTEGER NOT NULL DEFAULT 0,
+		count       INTEGER NOT NULL DEFAULT 0
+	)`, quote(s.TableName)))
+	if err != nil {
+		logging.Log.Errorf("SQLITE: failed to create table %s: %v", s.TableName, err)
+	}
+	return err
+}
+
+// Add updates the tallies of the ballot and the ballot counter in one transaction
+func (s *TallyStorage) Add(ctx context.Context, voterGroup string, votes []*storage.Vote) error {
+	return s.update(ctx, storage.TallyVotes(voterGroup, votes), 1, 1)
+}
+
+// Subtract updates the tallies of the votes and the ballot counter in one transaction, and deletes
+// the tallies left without votes
+func (s *TallyStorage) Subtract(ctx context.Context, voterGroup string, votes []*storage.Vote, ballots int) error {
+	return s.update(ctx, storage.TallyVotes(voterGroup, votes), -1, -ballots)
+}
+
+// update adds sign times the tallies, and ballots to the ballot counter
+func (s *TallyStorage) update(ctx context.Context, tallies []*storage.Tally, sign, ballots int) error {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		logging.Log.Errorf("SQLITE: failed to begin tally transaction: %v", err)
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	upsert := fmt.Sprintf(`INSERT INTO %s (key, team_id, category_id, voter_group, sum, count) VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT (key) DO UPDATE SET sum = sum + excluded.sum, count = count + excluded.count`, quote(s.TableName))
+	for _, t := range tallies {
+		if _, err := tx.ExecContext(ctx, upsert, t.Key, t.TeamID, t.CategoryID, t.VoterGroup, sign*t.Sum, sign*t.Count); err != nil {
+			logging.Log.Errorf("SQLITE: failed to update tally %s: %v", t.Key, err)
+			return err
+		}
+	}
+	if _, err := tx.ExecContext(ctx, upsert, storage.BallotsTallyKey, 0, 0, "", 0, ballots); err != nil {
+		logging.Log.Errorf("SQLITE: failed to count ballots: %v", err)
+		return err
+	}
+	if sign < 0 {
+		empty := fmt.Sprintf("DELETE FROM %s WHERE count <= 0 AND key != ?", quote(s.TableName))
+		if _, err := tx.ExecContext(ctx, empty, storage.BallotsTallyKey); err != nil {
+			logging.Log.Errorf("SQLITE: failed to delete empty tallies: %v", err)
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		logging.Log.Errorf("SQLITE: failed to commit tallies: %v", err)
+		return err
+	}
+	return nil
+}
+
+func (s *TallyStorage) GetAll(ctx context.Context) (*storage.Tallies, error) {
+	rows, err := s.DB.QueryContext(ctx, fmt.Sprintf(
+		"SELECT key, team_id, category_id, voter_group, sum, count FROM %s", quote(s.TableName)))
+	if err != nil {
+		logging.Log.Errorf("SQLITE: failed to query tallies: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tallies []*storage.Tally
+	for rows.Next() {
+		var t storage.Tally
+		if err := rows.Scan(&t.Key, &t.TeamID, &t.CategoryID, &t.VoterGroup, &t.Sum, &t.Count); err != nil {
+			logging.Log.Errorf("SQLITE: failed to read tally row: %v", err)
+			return nil, err
+		}
+		tallies = append(tallies, &t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return storage.SplitTallies(tallies), nil
+}
+
+func (s *TallyStorage) Replace(ctx context.Context, tallies *storage.Tallies) error {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		logging.Log.Errorf("SQLITE: failed to begin tally transaction: %v", err)
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if _, err := tx.ExecContext(ctx, fmt.Sprintf("DELETE FROM %s", quote(s.TableName))); err != nil {
+		logging.Log.Errorf("SQLITE: failed to delete tallies: %v", err)
+		return err
+	}
+	insert := fmt.Sprintf(
+		"INSERT INTO %s (key, team_id, category_id, voter_group, sum, count) VALUES (?, ?, ?, ?, ?, ?)", quote(s.TableName))
+	for _, t := range tallies.Rows() {
+		if _, err := tx.ExecContext(ctx, insert, t.Key, t.TeamID, t.CategoryID, t.VoterGroup, t.Sum, t.Count); err != nil {
+			logging.Log.Errorf("SQLITE: failed to insert tally %s: %v", t.Key, err)
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		logging.Log.Errorf("SQLITE: failed to commit tallies: %v", err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/alex-pricope/simple-voting-system/logging"
+	"github.com/alex-pricope/simple-voting-system/storage"
+)
+
+type TeamStorage struct {
+	DB        *sql.DB
+	TableName string
+}
+
+func (s *TeamStorage) CreateTable(ctx context.Context) error {
+	_, err := s.DB.ExecContext(ctx, fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+		id          INTEGER PRIMARY KEY,
+		name        TEXT NOT NULL,
+		description TEXT NOT NULL DEFAULT '',
+		members     TEXT NOT NULL DEFAULT 'null',
+		version     INTEGER NOT NULL DEFAULT 0,
+		archived    INTEGER NOT NULL DEFAULT 0
+	)`, quote(s.TableName)))
+	if err == nil {
+		err = addColumn(ctx, s.DB, s.TableName, "version", "INTEGER NOT NULL DEFAULT 0")
+	}
+	if err == nil {
+		err = addColumn(ctx, s.DB, s.TableName, "archived", "INTEGER NOT NULL DEFAULT 0")
+	}
+	if err != nil {
+		logging.Log.Errorf("SQLITE: failed to create table %s: %v", s.TableName, err)
+	}
+	return err
+}
+
+func (s *TeamStorage) Get(ctx context.Context, id int) (*storage.Team, error) {
+	row := s.DB.QueryRowContext(ctx, fmt.Sprintf(
+		"SELECT id, name, description, members, version, archived FROM %s WHERE id = ?", quote(s.TableName)), id)
+
+	team, err := scanTeam(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		logging.Log.Warnf("SQLITE: no team found with ID %d", id)
+		return nil, storage.ErrNotFound
+	}
+	if err != nil {
+		logging.Log.Errorf("SQLITE: failed to get team %d: %v", id, err)
+		return nil, err
+	}
+	return team, nil
+}
+
+func (s *TeamStorage) GetAll(ctx context.Context) ([]*storage.Team, error) {
+	rows, err := s.DB.QueryContext(ctx, fmt.Sprintf(
+		"SELECT id, name, description, members, version, archived FROM %s ORDER BY id", quote(s.TableName)))
+	if err != nil {
+		logging.Log.Errorf("SQLITE: failed to list teams: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var teams []*storage.Team
+	for rows.Next() {
+		team, err := scanTeam(rows)
+		if err != nil {
+			logging.Log.Errorf("SQLITE: failed to read team row: %v", err)
+			return nil, err
+		}
+		teams = append(teams, team)
+	}
+	return teams, rows.Err()
+}
+
+func (s *TeamStorage) Create(ctx context.Context, team *storage.Team) error {
+	members, err := json.Marshal(team.Members)
+	if err != nil {
+		return err
+	}
+
+	team.Version = 1
+	res, err := s.DB.ExecContext(ctx, fmt.Sprintf(
+		"INSERT INTO %s (id, name, description, members, version, archived) VALUES (?, ?, ?, ?, ?, ?) ON CONFLICT(id) DO NOTHING",
+		quote(s.TableName)), team.ID, team.Name, team.Description, string(members), team.Version, team.Archived)
+	if err != nil {
+		logging.Log.Errorf("SQLITE: failed to create team: %v", err)
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		logging.Log.Warnf("SQLITE: team with ID %d already exists", team.ID)
+		return storage.ErrAlreadyExists
+	}
+	return nil
+}
+
+func (s *TeamStorage) Update(ctx context.Context, team *storage.Team) error {
+	members, err := json.Marshal(team.Members)
+	if err != nil {
+		return err
+	}
+
+	err = updateVersioned(ctx, s.DB, s.TableName, team.ID, fmt.Sprintf(
+		"UPDATE %s SET name = ?, description = ?, members = ?, archived = ?, version = version + 1 WHERE id = ? AND version = ?",
+		quote(s.TableName)), team.Name, team.Description, string(members), team.Archived, team.ID, team.Version)
+	if err != nil {
+		logging.Log.Errorf("SQLITE: failed to update team %d: %v", team.ID, err)
+		return err
+	}
+	team.Version++
+	return nil
+}
+
+func (s *TeamStorage) Delete(ctx context.Context, id int) error {
+	_, err := s.DB.ExecContext(ctx, fmt.Sprintf("DELETE FROM %s WHERE id = ?", quote(s.TableName)), id)
+	if err != nil {
+		logging.Log.Errorf("SQLITE: failed to delete team with ID %d: %v", id, err)
+		return err
+	}
+	logging.Log.Infof("SQLITE: deleted team with ID %d", id)
+	return nil
+}
+
+func scanTeam(row scanner) (*storage.Team, error) {
+	var team storage.Team
+	var members string
+	if err := row.Scan(&team.ID, &team.Name, &team.Description, &members, &team.Version, &team.Archived); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(members), &team.Members); err != nil {
+		return nil, err
+	}
+	return &team, nil
+}
@@ -0,0 +1,134 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/alex-pricope/simple-voting-system/logging"
+	"github.com/alex-pricope/simple-voting-system/storage"
+)
+
+type VoteStorage struct {
+	DB        *sql.DB
+	TableName string
+}
+
+func (s *VoteStorage) CreateTable(ctx context.Context) error {
+	_, err := s.DB.ExecContext(ctx, fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+		code        TEXT NOT NULL,
+		sort_key    TEXT NOT NULL,
+		category_id INTEGER NOT NULL,
+		team_id     INTEGER NOT NULL,
+		rating      INTEGER NOT NULL,
+		timestamp   TEXT NOT NULL,
+		PRIMARY KEY (code, sort_key)
+	)`, quote(s.TableName)))
+	if err != nil {
+		logging.Log.Errorf("SQLITE: failed to create table %s: %v", s.TableName, err)
+		return err
+	}
+
+	for _, column := range []string{"team_id", "category_id"} {
+		index := quote(s.TableName + "_" + column)
+		if _, err := s.DB.ExecContext(ctx, fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s ON %s (%s)",
+			index, quote(s.TableName), column)); err != nil {
+			logging.Log.Errorf("SQLITE: failed to create index %s: %v", index, err)
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *VoteStorage) GetAll(ctx context.Context) ([]*storage.Vote, error) {
+	return s.query(ctx, fmt.Sprintf(
+		"SELECT code, sort_key, category_id, team_id, rating, timestamp FROM %s ORDER BY code, sort_key",
+		quote(s.TableName)))
+}
+
+func (s *VoteStorage) Create(ctx context.Context, vote *storage.Vote) error {
+	res, err := s.DB.ExecContext(ctx, fmt.Sprintf(
+		"INSERT INTO %s (code, sort_key, category_id, team_id, rating, timestamp) VALUES (?, ?, ?, ?, ?, ?) ON CONFLICT DO NOTHING",
+		quote(s.TableName)),
+		vote.Code, vote.SortKey, vote.CategoryID, vote.TeamID, vote.Rating, formatTime(vote.Timestamp))
+	if err != nil {
+		logging.Log.Errorf("SQLITE: failed to create vote PK: %s, SK: %s: %v", vote.Code, vote.SortKey, err)
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		logging.Log.Warnf("SQLITE: vote PK: %s, SK: %s already exists", vote.Code, vote.SortKey)
+		return storage.ErrAlreadyExists
+	}
+	return nil
+}
+
+func (s *VoteStorage) GetByCode(ctx context.Context, code string) ([]*storage.Vote, error) {
+	return s.query(ctx, fmt.Sprintf(
+		"SELECT code, sort_key, category_id, team_id, rating, timestamp FROM %s WHERE code = ? ORDER BY sort_key",
+		quote(s.TableName)), code)
+}
+
+func (s *VoteStorage) GetByTeam(ctx context.Context, teamID int) ([]*storage.Vote, error) {
+	return s.query(ctx, fmt.Sprintf(
+		"SELECT code, sort_key, category_id, team_id, rating, timestamp FROM %s WHERE team_id = ? ORDER BY code, sort_key",
+		quote(s.TableName)), teamID)
+}
+
+func (s *VoteStorage) GetByCategory(ctx context.Context, categoryID int) ([]*storage.Vote, error) {
+	return s.query(ctx, fmt.Sprintf(
+		"SELECT code, sort_key, category_id, team_id, rating, timestamp FROM %s WHERE category_id = ? ORDER BY code, sort_key",
+		quote(s.TableName)), categoryID)
+}
+
+func (s *VoteStorage) Delete(ctx context.Context, votes []*storage.Vote) error {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		logging.Log.Errorf("SQLITE: failed to begin delete transaction: %v", err)
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	query := fmt.Sprintf("DELETE FROM %s WHERE code = ? AND sort_key = ?", quote(s.TableName))
+	for _, v := range votes {
+		if _, err := tx.ExecContext(ctx, query, v.Code, v.SortKey); err != nil {
+			logging.Log.Errorf("SQLITE: failed to delete vote PK: %s, SK: %s: %v", v.Code, v.SortKey, err)
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *VoteStorage) DeleteAll(ctx context.Context) error {
+	res, err := s.DB.ExecContext(ctx, fmt.Sprintf("DELETE FROM %s", quote(s.TableName)))
+	if err != nil {
+		logging.Log.Errorf("SQLITE: failed to delete votes: %v", err)
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil {
+		logging.Log.Infof("SQLITE: deleted %d votes", n)
+	}
+	return nil
+}
+
+func (s *VoteStorage) query(ctx context.Context, query string, args ...any) ([]*storage.Vote, error) {
+	rows, err := s.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		logging.Log.Errorf("SQLITE: failed to query votes: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var votes []*storage.Vote
+	for rows.Next() {
+		var v storage.Vote
+		var timestamp string
+		if err := rows.Scan(&v.Code, &v.SortKey, &v.CategoryID, &v.TeamID, &v.Rating, &timestamp); err != nil {
+			logging.Log.Errorf("SQLITE: failed to read vote row: %v", err)
+			return nil, err
+		}
+		if v.Timestamp, err = parseTime(timestamp); err != nil {
+			return nil, err
+		}
+		votes = append(votes, &v)
+	}
+	return votes, rows.Err()
+}
@@ -0,0 +1,274 @@
+package storage
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/alex-pricope/simple-voting-system/logging"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"sort"
+	"strconv"
+)
+
+// BallotsTallyKey is the key of the Tally counting the ballots
+const BallotsTallyKey = "ballots"
+
+// StaleTallyKey is the key of the marker left by an update that was only partly applied.
+// Tallies that carry it are not trusted until they are replaced.
+const StaleTallyKey = "stale"
+
+// TallyStorage keeps the running results, so reading them does not need the raw votes.
+// Tallies are updated after a ballot is stored, a failed update leaves them behind the votes
+// until they are rebuilt.
+type TallyStorage interface {
+	// Add counts one ballot of voterGroup into the tallies
+	Add(ctx context.Context, voterGroup string, votes []*Vote) error
+	// Subtract takes deleted votes of voterGroup out of the tallies, and ballots out of the ballot counter
+	Subtract(ctx context.Context, voterGroup string, votes []*Vote, ballots int) error
+	// GetAll returns the tallies ordered by key
+	GetAll(ctx context.Context) (*Tallies, error)
+	// Replace overwrites every tally, it is used to rebuild the tallies from the raw votes
+	Replace(ctx context.Context, tallies *Tallies) error
+}
+
+// TallyKey is the key of the tally of a team and category for one voter group
+func TallyKey(teamID, categoryID int, voterGroup string) string {
+	return fmt.Sprintf("team#%d#cat#%d#%s", teamID, categoryID, voterGroup)
+}
+
+// TallyVotes returns the tallies of a single ballot, one per team and category
+func TallyVotes(voterGroup string, votes []*Vote) []*Tally {
+	byKey := make(map[string]*Tally, len(votes))
+	for _, v := range votes {
+		addVote(byKey, voterGroup, v)
+	}
+	return sortedTallies(byKey)
+}
+
+// BuildTallies computes the tallies from the raw votes. The voter group of a vote is the
+// category of its code, and every code with votes counts as one ballot.
+func BuildTallies(votes []*Vote, codes []*VotingCode) *Tallies {
+	groups := make(map[string]string, len(codes))
+	for _, c := range codes {
+		groups[c.Code] = c.Category
+	}
+
+	byKey := make(map[string]*Tally)
+	ballots := make(map[string]bool)
+	for _, v := range votes {
+		addVote(byKey, groups[v.Code], v)
+		ballots[v.Code] = true
+	}
+	return &Tallies{Ballots: len(ballots), Scores: sortedTallies(byKey)}
+}
+
+func addVote(byKey map[string]*Tally, voterGroup string, vote *Vote) {
+	key := TallyKey(vote.TeamID, vote.CategoryID, voterGroup)
+	tally, ok := byKey[key]
+	if !ok {
+		tally = &Tally{Key: key, TeamID: vote.TeamID, CategoryID: vote.CategoryID, VoterGroup: voterGroup}
+		byKey[key] = tally
+	}
+	tally.Sum += vote.Rating
+	tally.Count++
+}
+
+func sortedTallies(byKey map[string]*Tally) []*Tally {
+	tallies := make([]*Tally, 0, len(byKey))
+	for _, t := range byKey {
+		tallies = append(tallies, t)
+	}
+	sort.Slice(tallies, func(i, j int) bool {
+		return tallies[i].Key < tallies[j].Key
+	})
+	return tallies
+}
+
+// DynamoTallyStorage keeps one item per tally, keyed by PK only. Add and Subtract use atomic counters,
+// so concurrent ballots never conflict. The counters of an update are written in one transaction when they fit;
+// larger ones are split. Each transaction carries a token derived from its votes, so a retry after an ambiguous
+// failure is not counted twice, and an update that fails part-way or with an unknown outcome leaves the stale marker behind.
+type DynamoTallyStorage struct {
+	Client    *dynamodb.Client
+	TableName string
+}
+
+func (s *DynamoTallyStorage) Add(ctx context.Context, voterGroup string, votes []*Vote) error {
+	return s.update(ctx, votes, TallyVotes(voterGroup, votes), 1, 1)
+}
+
+func (s *DynamoTallyStorage) Subtract(ctx context.Context, voterGroup string, votes []*Vote, ballots int) error {
+	return s.update(ctx, votes, TallyVotes(voterGroup, votes), -1, -ballots)
+}
+
+// update adds sign times the tallies of votes and ballots to the counters
+func (s *DynamoTallyStorage) update(ctx context.Context, votes []*Vote, tallies []*Tally, sign, ballots int) error {
+	items := make([]types.TransactWriteItem, 0, len(tallies)+1)
+	if ballots != 0 {
+		items = append(items, types.TransactWriteItem{Update: &types.Update{
+			TableName:                &s.TableName,
+			Key:                      tallyKey(BallotsTallyKey),
+			UpdateExpression:         aws.String("ADD #count :ballots"),
+			ExpressionAttributeNames: map[string]string{"#count": "Count"},
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":ballots": &types.AttributeValueMemberN{Value: strconv.Itoa(ballots)},
+			},
+		}})
+	}
+	for _, tally := range tallies {
+		items = append(items, types.TransactWriteItem{Update: &types.Update{
+			TableName:        &s.TableName,
+			Key:              tallyKey(tally.Key),
+			UpdateExpression: aws.String("ADD #sum :sum, #count :count SET TeamID = :team, CategoryID = :category, VoterGroup = :group"),
+			ExpressionAttributeNames: map[string]string{
+				"#sum":   "Sum",
+				"#count": "Count",
+			},
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":sum":      &types.AttributeValueMemberN{Value: strconv.Itoa(sign * tally.Sum)},
+				":count":    &types.AttributeValueMemberN{Value: strconv.Itoa(sign * tally.Count)},
+				":team":     &types.AttributeValueMemberN{Value: strconv.Itoa(tally.TeamID)},
+				":category": &types.AttributeValueMemberN{Value: strconv.Itoa(tally.CategoryID)},
+				":group":    &types.AttributeValueMemberS{Value: tally.VoterGroup},
+			},
+		}})
+	}
+
+	for i := 0; i < len(items); i += maxTransactItems {
+		end := min(i+maxTransactItems, len(items))
+		input := &dynamodb.TransactWriteItemsInput{
+			TransactItems:      items[i:end],
+			ClientRequestToken: aws.String(tallyRequestToken(votes, sign, ballots, i)),
+		}
+		if err := transactWithRetry(ctx, s.Client, input); err != nil {
+			logging.Log.Errorf("TALLY: failed to update tallies %d-%d of %d: %v", i, end, len(items), err)
+			// Earlier chunks are applied, and this one may be
+			if i > 0 || !rejectedTransaction(err) {
+				s.markStale(ctx)
+			}
+			return classify(err)
+		}
+	}
+	return nil
+}
+
+// tallyRequestToken is the idempotency token of the chunk of an update starting at item first.
+// It is derived from the votes, whose timestamps tell a ballot from a later one with the same ratings,
+// so DynamoDB applies a retried chunk once and two updates never share a token.
+func tallyRequestToken(votes []*Vote, sign, ballots, first int) string {
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%d#%d#%d", sign, ballots, first)
+	for _, v := range votes {
+		fmt.Fprintf(hash, "#%s#%s#%d#%d", v.Code, v.SortKey, v.Rating, v.Timestamp.UnixNano())
+	}
+	// Tokens are at most 36 characters
+	return hex.EncodeToString(hash.Sum(nil))[:32]
+}
+
+// markStale records that an update was only partly applied, or may have been, until Replace removes the marker
+func (s *DynamoTallyStorage) markStale(ctx context.Context) {
+	_, err := s.Client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: &s.TableName,
+		Item:      tallyKey(StaleTallyKey),
+	})
+	if err != nil {
+		logging.Log.Errorf("TALLY: failed to mark the tallies stale: %v", err)
+		return
+	}
+	logging.Log.Warnf("TALLY: marked the tallies stale, rebuild them with POST /api/admin/tallies/rebuild")
+}
+
+func (s *DynamoTallyStorage) GetAll(ctx context.Context) (*Tallies, error) {
+	items, err := scanAll(ctx, s.Client, &dynamodb.ScanInput{
+		TableName: &s.TableName,
+	})
+	if err != nil {
+		logging.Log.Errorf("TALLY: scan failed: %v", err)
+		return nil, classify(err)
+	}
+
+	var rows []*Tally
+	if err := attributevalue.UnmarshalListOfMaps(items, &rows); err != nil {
+		logging.Log.Errorf("TALLY: failed to unmarshal tally list: %v", err)
+		return nil, err
+	}
+	return SplitTallies(rows), nil
+}
+
+// Replace writes every tally and then deletes the items that are not part of tallies anymore.
+// Readers see old or new values per item during the rebuild, never an empty table.
+func (s *DynamoTallyStorage) Replace(ctx context.Context, tallies *Tallies) error {
+	items, err := scanAll(ctx, s.Client, &dynamodb.ScanInput{
+		TableName:            &s.TableName,
+		ProjectionExpression: aws.String("PK"),
+	})
+	if err != nil {
+		logging.Log.Errorf("TALLY: scan for replace failed: %v", err)
+		return classify(err)
+	}
+
+	keep := make(map[string]bool, len(tallies.Scores)+1)
+	puts := make([]types.WriteRequest, 0, len(tallies.Scores)+1)
+	for _, tally := range tallies.Rows() {
+		item, err := attributevalue.MarshalMap(tally)
+		if err != nil {
+			logging.Log.Errorf("TALLY: failed to marshal tally: %v", err)
+			return err
+		}
+		keep[tally.Key] = true
+		puts = append(puts, types.WriteRequest{PutRequest: &types.PutRequest{Item: item}})
+	}
+	if err := batchWrite(ctx, s.Client, s.TableName, puts); err != nil {
+		return classify(err)
+	}
+
+	var deletes []types.WriteRequest
+	for _, item := range items {
+		pk, ok := item["PK"].(*types.AttributeValueMemberS)
+		if ok && !keep[pk.Value] {
+			deletes = append(deletes, types.WriteRequest{DeleteRequest: &types.DeleteRequest{Key: tallyKey(pk.Value)}})
+		}
+	}
+	if err := batchWrite(ctx, s.Client, s.TableName, deletes); err != nil {
+		return classify(err)
+	}
+	logging.Log.Infof("TALLY: replaced tallies, %d written and %d deleted", len(puts), len(deletes))
+	return nil
+}
+
+func tallyKey(key string) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		"PK": &types.AttributeValueMemberS{Value: key},
+	}
+}
+
+// SplitTallies separates the ballot counter and the stale marker from the score tallies, which are returned
+// ordered by key. Tallies whose votes were all subtracted are left out, as BuildTallies would.
+func SplitTallies(rows []*Tally) *Tallies {
+	tallies := &Tallies{Scores: make([]*Tally, 0, len(rows))}
+	for _, row := range rows {
+		switch {
+		case row.Key == BallotsTallyKey:
+			tallies.Ballots = row.Count
+		case row.Key == StaleTallyKey:
+			tallies.Stale = true
+		case row.Count > 0:
+			tallies.Scores = append(tallies.Scores, row)
+		}
+	}
+	sort.Slice(tallies.Scores, func(i, j int) bool {
+		return tallies.Scores[i].Key < tallies.Scores[j].Key
+	})
+	return tallies
+}
+
+// Rows returns the score tallies followed by the ballot counter, the reverse of SplitTallies
+func (t *Tallies) Rows() []*Tally {
+	rows := make([]*Tally, 0, len(t.Scores)+1)
+	rows = append(rows, t.Scores...)
+	return append(rows, &Tally{Key: BallotsTallyKey, Count: t.Ballots})
+}
@@ -0,0 +1,122 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/alex-pricope/simple-voting-system/logging"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBuildTallies(t *testing.T) {
+	codes := []*VotingCode{{Code: "AAAAA", Category: "grand_jury"}, {Code: "BBBBB", Category: "grand_jury"}, {Code: "CCCCC", Category: "general_public"}}
+	votes := []*Vote{
+		{Code: "AAAAA", TeamID: 1, CategoryID: 1, Rating: 5},
+		{Code: "AAAAA", TeamID: 2, CategoryID: 1, Rating: 3},
+		{Code: "BBBBB", TeamID: 1, CategoryID: 1, Rating: 4},
+		{Code: "CCCCC", TeamID: 1, CategoryID: 1, Rating: 2},
+		{Code: "ZZZZZ", TeamID: 1, CategoryID: 1, Rating: 1}, // code deleted since, no voter group
+	}
+
+	tallies := BuildTallies(votes, codes)
+	assert.Equal(t, 4, tallies.Ballots)
+	require.Len(t, tallies.Scores, 4)
+	assert.Equal(t, &Tally{Key: "team#1#cat#1#", TeamID: 1, CategoryID: 1, Sum: 1, Count: 1}, tallies.Scores[0])
+	assert.Equal(t, &Tally{Key: "team#1#cat#1#general_public", TeamID: 1, CategoryID: 1, VoterGroup: "general_public", Sum: 2, Count: 1}, tallies.Scores[1])
+	assert.Equal(t, &Tally{Key: "team#1#cat#1#grand_jury", TeamID: 1, CategoryID: 1, VoterGroup: "grand_jury", Sum: 9, Count: 2}, tallies.Scores[2])
+	assert.Equal(t, "team#2#cat#1#grand_jury", tallies.Scores[3].Key)
+}
+
+func TestTalliesRows(t *testing.T) {
+	tallies := TallyVotes("grand_jury", []*Vote{{TeamID: 2, CategoryID: 1, Rating: 3}, {TeamID: 1, CategoryID: 1, Rating: 5}})
+	require.Len(t, tallies, 2)
+	assert.Equal(t, "team#1#cat#1#grand_jury", tallies[0].Key)
+
+	rows := (&Tallies{Ballots: 1, Scores: tallies}).Rows()
+	require.Len(t, rows, 3)
+	assert.Equal(t, BallotsTallyKey, rows[2].Key)
+
+	split := SplitTallies([]*Tally{rows[2], rows[1], rows[0]})
+	assert.Equal(t, 1, split.Ballots)
+	assert.Equal(t, tallies, split.Scores)
+
+	t.Run("Stale marker and emptied tallies", func(t *testing.T) {
+		split := SplitTallies([]*Tally{{Key: StaleTallyKey}, {Key: "team#3#cat#1#grand_jury", TeamID: 3, CategoryID: 1}, rows[0]})
+		assert.True(t, split.Stale)
+		assert.Equal(t, []*Tally{rows[0]}, split.Scores)
+	})
+}
+
+func TestTallyRequestToken(t *testing.T) {
+	at := time.Date(2026, 5, 1, 12, 0, 0, 0, time.UTC)
+	votes := []*Vote{{Code: "AAAAA", SortKey: "cat#1#team#1", Rating: 5, Timestamp: at}}
+	token := tallyRequestToken(votes, 1, 1, 0)
+
+	assert.LessOrEqual(t, len(token), 36)
+	assert.Equal(t, token, tallyRequestToken(votes, 1, 1, 0), "a retry sends the same token")
+	assert.NotEqual(t, token, tallyRequestToken(votes, 1, 1, 25), "every chunk has its own token")
+	assert.NotEqual(t, token, tallyRequestToken(votes, -1, -1, 0), "subtracting the ballot is another update")
+	again := []*Vote{{Code: "AAAAA", SortKey: "cat#1#team#1", Rating: 5, Timestamp: at.Add(time.Minute)}}
+	assert.NotEqual(t, token, tallyRequestToken(again, 1, 1, 0), "the same ratings voted again are another update")
+}
+
+// tallyDynamo answers each DynamoDB call with the next response, a status and a body, and records the
+// operation and the ClientRequestToken of every call
+func tallyDynamo(t *testing.T, responses ...[2]string) (*dynamodb.Client, *[]string) {
+	t.Helper()
+	logging.Log = logrus.New()
+	var calls []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct{ ClientRequestToken string }
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		operation := strings.TrimPrefix(r.Header.Get("X-Amz-Target"), "DynamoDB_20120810.")
+		calls = append(calls, strings.TrimSuffix(operation+" "+body.ClientRequestToken, " "))
+
+		response := responses[min(len(calls)-1, len(responses)-1)]
+		w.Header().Set("Content-Type", "application/x-amz-json-1.0")
+		status := http.StatusOK
+		if response[0] != "" {
+			status = http.StatusBadRequest
+			if response[0] == "500" {
+				status = http.StatusInternalServerError
+			}
+		}
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte(response[1]))
+	}))
+	t.Cleanup(server.Close)
+	return fakeDynamoClient(server.URL), &calls
+}
+
+func TestDynamoTallyUpdateFailures(t *testing.T) {
+	votes := []*Vote{{Code: "AAAAA", SortKey: "cat#1#team#1", TeamID: 1, CategoryID: 1, Rating: 5, Timestamp: time.Now()}}
+	token := "TransactWriteItems " + tallyRequestToken(votes, 1, 1, 0)
+	ok := [2]string{"", `{}`}
+
+	t.Run("A retry after an unknown outcome reuses the token and marks the tallies stale", func(t *testing.T) {
+		internal := [2]string{"500", `{"__type":"com.amazonaws.dynamodb.v20120810#InternalServerError","message":"boom"}`}
+		client, calls := tallyDynamo(t, internal, internal, internal, ok)
+		tallies := &DynamoTallyStorage{Client: client, TableName: "VoteTallies"}
+
+		err := tallies.Add(context.TODO(), "grand_jury", votes)
+		assert.ErrorIs(t, err, ErrUnavailable)
+		assert.Equal(t, []string{token, token, token, "PutItem"}, *calls)
+	})
+
+	t.Run("A cancelled transaction was not applied and leaves the tallies trusted", func(t *testing.T) {
+		cancelled := [2]string{"400", `{"__type":"com.amazonaws.dynamodb.v20120810#TransactionCanceledException",` +
+			`"message":"Transaction cancelled","CancellationReasons":[{"Code":"ValidationError"}]}`}
+		client, calls := tallyDynamo(t, cancelled, ok)
+		tallies := &DynamoTallyStorage{Client: client, TableName: "VoteTallies"}
+
+		assert.Error(t, tallies.Add(context.TODO(), "grand_jury", votes))
+		assert.Equal(t, []string{token}, *calls)
+	})
+}
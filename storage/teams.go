@@ -14,6 +14,8 @@ type TeamStorage interface {
 	Get(ctx context.Context, id int) (*Team, error)
 	GetAll(ctx context.Context) ([]*Team, error)
 	Create(ctx context.Context, team *Team) error
+	// Update replaces an existing team if its Version still equals team.Version, and then bumps team.Version.
+	// It returns ErrNotFound for an unknown ID and ErrVersionConflict when the team was changed meanwhile.
 	Update(ctx context.Context, team *Team) error
 	Delete(ctx context.Context, id int) error
 }
@@ -24,16 +26,16 @@ type DynamoTeamStorage struct {
 }
 
 func (s *DynamoTeamStorage) GetAll(ctx context.Context) ([]*Team, error) {
-	out, err := s.Client.Scan(ctx, &dynamodb.ScanInput{
+	items, err := scanAll(ctx, s.Client, &dynamodb.ScanInput{
 		TableName: &s.TableName,
 	})
 	if err != nil {
 		logging.Log.Errorf("TEAM: scan failed: %v", err)
-		return nil, err
+		return nil, classify(err)
 	}
 
 	var teams []*Team
-	if err := attributevalue.UnmarshalListOfMaps(out.Items, &teams); err != nil {
+	if err := attributevalue.UnmarshalListOfMaps(items, &teams); err != nil {
 		logging.Log.Errorf("TEAM: failed to unmarshal team list: %v", err)
 		return nil, err
 	}
@@ -53,11 +55,11 @@ func (s *DynamoTeamStorage) Get(ctx context.Context, id int) (*Team, error) {
 	})
 	if err != nil {
 		logging.Log.Errorf("TEAM: GetItem for ID %d failed: %v", id, err)
-		return nil, err
+		return nil, classify(err)
 	}
 	if out.Item == nil {
 		logging.Log.Warnf("TEAM: no team found with ID %d", id)
-		return nil, nil
+		return nil, ErrNotFound
 	}
 
 	var team Team
@@ -69,6 +71,7 @@ func (s *DynamoTeamStorage) Get(ctx context.Context, id int) (*Team, error) {
 }
 
 func (s *DynamoTeamStorage) Create(ctx context.Context, team *Team) error {
+	team.Version = 1
 	item, err := attributevalue.MarshalMap(team)
 	if err != nil {
 		logging.Log.Errorf("TEAM: failed to marshal team: %v", err)
@@ -84,29 +87,32 @@ func (s *DynamoTeamStorage) Create(ctx context.Context, team *Team) error {
 		var cce *types.ConditionalCheckFailedException
 		if errors.As(err, &cce) {
 			logging.Log.Warnf("TEAM: item with ID %d already exists", team.ID)
-			return ErrItemWithIDAlreadyExists
+			return ErrAlreadyExists
 		}
 		logging.Log.Errorf("TEAM: failed to create team: %v", err)
-		return err
+		return classify(err)
 	}
 	return nil
 }
 
 func (s *DynamoTeamStorage) Update(ctx context.Context, team *Team) error {
-	item, err := attributevalue.MarshalMap(team)
+	updated := *team
+	updated.Version++
+	item, err := attributevalue.MarshalMap(updated)
 	if err != nil {
 		logging.Log.Errorf("TEAM: failed to marshal updated team: %v", err)
 		return err
 	}
 
-	_, err = s.Client.PutItem(ctx, &dynamodb.PutItemInput{
-		TableName: &s.TableName,
-		Item:      item,
-	})
-	if err != nil {
+	if err := putVersioned(ctx, s.Client, s.TableName, item, team.Version); err != nil {
+		if errors.Is(err, ErrNotFound) || errors.Is(err, ErrVersionConflict) {
+			logging.Log.Warnf("TEAM: update of ID %d at version %d rejected: %v", team.ID, team.Version, err)
+			return err
+		}
 		logging.Log.Errorf("TEAM: failed to update team: %v", err)
-		return err
+		return classify(err)
 	}
+	team.Version = updated.Version
 	return nil
 }
 
@@ -123,7 +129,7 @@ func (s *DynamoTeamStorage) Delete(ctx context.Context, id int) error {
 	})
 	if err != nil {
 		logging.Log.Errorf("TEAM: failed to delete team with ID %d: %v", id, err)
-		return err
+		return classify(err)
 	}
 	logging.Log.Infof("TEAM: deleted team with ID %d", id)
 	return nil
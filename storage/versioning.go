@@ -0,0 +1,39 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"strconv"
+)
+
+// putVersioned replaces an existing item only if its Version still equals expected.
+// Items written before versioning have no Version attribute and count as version 0.
+// It returns ErrNotFound when the item does not exist and ErrVersionConflict when it was changed meanwhile.
+func putVersioned(ctx context.Context, client *dynamodb.Client, tableName string, item map[string]types.AttributeValue, expected int) error {
+	condition := "attribute_exists(PK) AND Version = :expected"
+	if expected == 0 {
+		condition = "attribute_exists(PK) AND (attribute_not_exists(Version) OR Version = :expected)"
+	}
+
+	_, err := client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName:           &tableName,
+		Item:                item,
+		ConditionExpression: aws.String(condition),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":expected": &types.AttributeValueMemberN{Value: strconv.Itoa(expected)},
+		},
+		ReturnValuesOnConditionCheckFailure: types.ReturnValuesOnConditionCheckFailureAllOld,
+	})
+
+	var cce *types.ConditionalCheckFailedException
+	if errors.As(err, &cce) {
+		if cce.Item == nil {
+			return ErrNotFound
+		}
+		return ErrVersionConflict
+	}
+	return classify(err)
+}
@@ -2,17 +2,40 @@ package storage
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"github.com/alex-pricope/simple-voting-system/logging"
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/smithy-go"
+	"sort"
+	"strconv"
+	"strings"
 )
 
+// Global secondary indexes of the Votes table, used by GetByTeam and GetByCategory
+const (
+	VotesTeamIndex     = "TeamIndex"
+	VotesCategoryIndex = "CategoryIndex"
+)
+
+// VoteSortKey is the SK of the vote for a team in a category, a code rates each pair once
+func VoteSortKey(categoryID, teamID int) string {
+	return fmt.Sprintf("cat#%d#team#%d", categoryID, teamID)
+}
+
 type VoteStorage interface {
 	GetAll(ctx context.Context) ([]*Vote, error)
 	Create(ctx context.Context, vote *Vote) error
 	GetByCode(ctx context.Context, code string) ([]*Vote, error)
+	// GetByTeam returns the votes for a team in every category
+	GetByTeam(ctx context.Context, teamID int) ([]*Vote, error)
+	// GetByCategory returns the votes for every team in a category
+	GetByCategory(ctx context.Context, categoryID int) ([]*Vote, error)
+	// Delete removes the given votes, identified by Code and SortKey. Missing votes are ignored.
+	Delete(ctx context.Context, votes []*Vote) error
 	DeleteAll(ctx context.Context) error
 }
 
@@ -22,16 +45,16 @@ type DynamoVoteStorage struct {
 }
 
 func (s *DynamoVoteStorage) GetAll(ctx context.Context) ([]*Vote, error) {
-	out, err := s.Client.Scan(ctx, &dynamodb.ScanInput{
+	items, err := scanAll(ctx, s.Client, &dynamodb.ScanInput{
 		TableName: &s.TableName,
 	})
 	if err != nil {
 		logging.Log.Errorf("VOTE: scan failed: %v", err)
-		return nil, err
+		return nil, classify(err)
 	}
 
 	var votes []*Vote
-	if err := attributevalue.UnmarshalListOfMaps(out.Items, &votes); err != nil {
+	if err := attributevalue.UnmarshalListOfMaps(items, &votes); err != nil {
 		logging.Log.Errorf("VOTE: failed to unmarshal vote list: %v", err)
 		return nil, err
 	}
@@ -50,8 +73,13 @@ func (s *DynamoVoteStorage) Create(ctx context.Context, vote *Vote) error {
 		ConditionExpression: aws.String("attribute_not_exists(PK) AND attribute_not_exists(SK)"),
 	})
 	if err != nil {
+		var cce *types.ConditionalCheckFailedException
+		if errors.As(err, &cce) {
+			logging.Log.Warnf("VOTE: vote PK: %s, SK: %s already exists", vote.Code, vote.SortKey)
+			return ErrAlreadyExists
+		}
 		logging.Log.Errorf("VOTE: failed to create vote: %v", err)
-		return err
+		return classify(err)
 	}
 	return nil
 }
@@ -65,68 +93,177 @@ func (s *DynamoVoteStorage) GetByCode(ctx context.Context, code string) ([]*Vote
 		},
 	}
 
-	output, err := s.Client.Query(ctx, input)
+	items, err := queryAll(ctx, s.Client, input)
 	if err != nil {
 		logging.Log.Errorf("VOTE: failed to query votes by code: %v", err)
-		return nil, err
+		return nil, classify(err)
 	}
 
 	var votes []*Vote
-	if err := attributevalue.UnmarshalListOfMaps(output.Items, &votes); err != nil {
+	if err := attributevalue.UnmarshalListOfMaps(items, &votes); err != nil {
 		logging.Log.Errorf("VOTE: failed to unmarshal votes for code %s: %v", code, err)
 		return nil, err
 	}
 	return votes, nil
 }
 
+func (s *DynamoVoteStorage) GetByTeam(ctx context.Context, teamID int) ([]*Vote, error) {
+	return s.queryIndex(ctx, VotesTeamIndex, "TeamID", teamID)
+}
+
+func (s *DynamoVoteStorage) GetByCategory(ctx context.Context, categoryID int) ([]*Vote, error) {
+	return s.queryIndex(ctx, VotesCategoryIndex, "CategoryID", categoryID)
+}
+
+// queryIndex returns the votes whose numeric attribute equals value, through its global secondary index.
+// A table created before the index, or whose index is still backfilling, is scanned instead.
+func (s *DynamoVoteStorage) queryIndex(ctx context.Context, index, attribute string, value int) ([]*Vote, error) {
+	items, err := queryAll(ctx, s.Client, &dynamodb.QueryInput{
+		TableName:              &s.TableName,
+		IndexName:              aws.String(index),
+		KeyConditionExpression: aws.String("#attr = :value"),
+		ExpressionAttributeNames: map[string]string{
+			"#attr": attribute,
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":value": &types.AttributeValueMemberN{Value: strconv.Itoa(value)},
+		},
+	})
+	if indexUnavailable(err) {
+		logging.Log.Warnf("VOTE: index %s of %s cannot be queried yet, scanning instead: %v", index, s.TableName, err)
+		items, err = scanAll(ctx, s.Client, &dynamodb.ScanInput{
+			TableName:                 &s.TableName,
+			FilterExpression:          aws.String("#attr = :value"),
+			ExpressionAttributeNames:  map[string]string{"#attr": attribute},
+			ExpressionAttributeValues: map[string]types.AttributeValue{":value": &types.AttributeValueMemberN{Value: strconv.Itoa(value)}},
+		})
+	}
+	if err != nil {
+		logging.Log.Errorf("VOTE: failed to query %s for %s %d: %v", index, attribute, value, err)
+		return nil, classify(err)
+	}
+
+	var votes []*Vote
+	if err := attributevalue.UnmarshalListOfMaps(items, &votes); err != nil {
+		logging.Log.Errorf("VOTE: failed to unmarshal votes from %s: %v", index, err)
+		return nil, err
+	}
+	sortVotes(votes)
+	return votes, nil
+}
+
+func (s *DynamoVoteStorage) Delete(ctx context.Context, votes []*Vote) error {
+	keys := make([]map[string]types.AttributeValue, 0, len(votes))
+	for _, v := range votes {
+		keys = append(keys, map[string]types.AttributeValue{
+			"PK": &types.AttributeValueMemberS{Value: v.Code},
+			"SK": &types.AttributeValueMemberS{Value: v.SortKey},
+		})
+	}
+	if err := batchDelete(ctx, s.Client, s.TableName, keys); err != nil {
+		logging.Log.Errorf("VOTE: failed to delete %d votes: %v", len(votes), err)
+		return err
+	}
+	return nil
+}
+
 func (s *DynamoVoteStorage) DeleteAll(ctx context.Context) error {
+	return deleteAllItems(ctx, s.Client, s.TableName)
+}
+
+// sortVotes orders votes by code, then sort key, the order of the table itself.
+// Index queries return them grouped by the index key only.
+// indexUnavailable reports whether a query failed because its index does not exist or is still backfilling,
+// which DynamoDB answers with a validation error
+func indexUnavailable(err error) bool {
+	var apiErr smithy.APIError
+	return errors.As(err, &apiErr) && apiErr.ErrorCode() == "ValidationException" &&
+		strings.Contains(strings.ToLower(apiErr.ErrorMessage()), "index")
+}
+
+func sortVotes(votes []*Vote) {
+	sort.Slice(votes, func(i, j int) bool {
+		if votes[i].Code != votes[j].Code {
+			return votes[i].Code < votes[j].Code
+		}
+		return votes[i].SortKey < votes[j].SortKey
+	})
+}
+
+// deleteAllItems removes every PK/SK item of a votes table, whatever its layout
+func deleteAllItems(ctx context.Context, client *dynamodb.Client, tableName string) error {
 	var lastEvaluatedKey map[string]types.AttributeValue
 
 	for {
-		scanOutput, err := s.Client.Scan(ctx, &dynamodb.ScanInput{
-			TableName:            &s.TableName,
+		scanOutput, err := client.Scan(ctx, &dynamodb.ScanInput{
+			TableName:            &tableName,
 			ExclusiveStartKey:    lastEvaluatedKey,
 			ProjectionExpression: aws.String("PK, SK"),
 		})
 		if err != nil {
 			logging.Log.Errorf("VOTE: scan for delete failed: %v", err)
-			return err
+			return classify(err)
 		}
 
-		var writeRequests []types.WriteRequest
+		keys := make([]map[string]types.AttributeValue, 0, len(scanOutput.Items))
 		for _, item := range scanOutput.Items {
-			writeRequests = append(writeRequests, types.WriteRequest{
-				DeleteRequest: &types.DeleteRequest{
-					Key: map[string]types.AttributeValue{
-						"PK": item["PK"],
-						"SK": item["SK"],
-					},
-				},
+			keys = append(keys, map[string]types.AttributeValue{
+				"PK": item["PK"],
+				"SK": item["SK"],
 			})
 		}
+		if err := batchDelete(ctx, client, tableName, keys); err != nil {
+			return classify(err)
+		}
 
-		for i := 0; i < len(writeRequests); i += 25 {
-			end := i + 25
-			if end > len(writeRequests) {
-				end = len(writeRequests)
-			}
-			_, err := s.Client.BatchWriteItem(ctx, &dynamodb.BatchWriteItemInput{
+		if scanOutput.LastEvaluatedKey == nil {
+			break
+		}
+		lastEvaluatedKey = scanOutput.LastEvaluatedKey
+	}
+
+	return nil
+}
+
+// batchDelete deletes the given keys in batches of 25, the BatchWriteItem limit
+func batchDelete(ctx context.Context, client *dynamodb.Client, tableName string, keys []map[string]types.AttributeValue) error {
+	writeRequests := make([]types.WriteRequest, 0, len(keys))
+	for _, key := range keys {
+		writeRequests = append(writeRequests, types.WriteRequest{
+			DeleteRequest: &types.DeleteRequest{Key: key},
+		})
+	}
+	return batchWrite(ctx, client, tableName, writeRequests)
+}
+
+// batchWrite sends the write requests in batches of 25, the BatchWriteItem limit.
+// Items DynamoDB leaves unprocessed, which it does when throttling, are sent again with backoff;
+// ErrUnprocessedItems is returned if some are still left once the retries are used up.
+func batchWrite(ctx context.Context, client *dynamodb.Client, tableName string, writeRequests []types.WriteRequest) error {
+	for i := 0; i < len(writeRequests); i += 25 {
+		end := min(i+25, len(writeRequests))
+		pending := writeRequests[i:end]
+		for attempt := 1; len(pending) > 0; attempt++ {
+			out, err := client.BatchWriteItem(ctx, &dynamodb.BatchWriteItemInput{
 				RequestItems: map[string][]types.WriteRequest{
-					s.TableName: writeRequests[i:end],
+					tableName: pending,
 				},
 			})
 			if err != nil {
-				logging.Log.Errorf("VOTE: batch delete failed: %v", err)
+				logging.Log.Errorf("STORAGE: batch write to %s failed: %v", tableName, err)
+				return classify(err)
+			}
+			pending = out.UnprocessedItems[tableName]
+			if len(pending) == 0 {
+				break
+			}
+			logging.Log.Warnf("STORAGE: %d of %d items unprocessed by batch write to %s, attempt %d", len(pending), end-i, tableName, attempt)
+			if err := backoff(ctx, client, attempt, ErrUnprocessedItems); err != nil {
+				logging.Log.Errorf("STORAGE: giving up on %d unprocessed items of %s: %v", len(pending), tableName, err)
 				return err
 			}
-			logging.Log.Infof("VOTE: deleted batch of %d items", end-i)
-		}
-
-		if scanOutput.LastEvaluatedKey == nil {
-			break
 		}
-		lastEvaluatedKey = scanOutput.LastEvaluatedKey
+		logging.Log.Infof("STORAGE: wrote batch of %d items to %s", end-i, tableName)
 	}
-
 	return nil
 }
@@ -0,0 +1,375 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"github.com/alex-pricope/simple-voting-system/logging"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// BallotSortKey is the SK of the single ballot item of a code in the ballot layout
+const BallotSortKey = "ballot"
+
+// VoteLayoutConverter is implemented by vote storages that can move votes written
+// one item per rating into their own layout.
+type VoteLayoutConverter interface {
+	// ConvertRatingRows converts the existing rating rows and returns the number of ballots written
+	ConvertRatingRows(ctx context.Context) (int, error)
+}
+
+// DynamoBallotVoteStorage is a VoteStorage keeping one Ballot item per code in the Votes table,
+// instead of one item per rating. Votes are expanded back into Vote rows on read.
+// Rating rows left from the rating layout are read and deleted alongside the ballots until
+// ConvertRatingRows has moved them, so switching the layout never hides votes.
+type DynamoBallotVoteStorage struct {
+	Client    *dynamodb.Client
+	TableName string
+}
+
+func (s *DynamoBallotVoteStorage) GetAll(ctx context.Context) ([]*Vote, error) {
+	items, err := scanAll(ctx, s.Client, &dynamodb.ScanInput{
+		TableName: &s.TableName,
+	})
+	if err != nil {
+		logging.Log.Errorf("VOTE: scan failed: %v", err)
+		return nil, classify(err)
+	}
+	return s.votesOf(items)
+}
+
+// votesOf expands the ballot items and merges in the rating rows, the entries of a ballot win
+// over a row with the same sort key as in ConvertRatingRows
+func (s *DynamoBallotVoteStorage) votesOf(items []map[string]types.AttributeValue) ([]*Vote, error) {
+	var ballotItems, rowItems []map[string]types.AttributeValue
+	for _, item := range items {
+		if sk, ok := item["SK"].(*types.AttributeValueMemberS); ok && sk.Value == BallotSortKey {
+			ballotItems = append(ballotItems, item)
+		} else {
+			rowItems = append(rowItems, item)
+		}
+	}
+
+	var ballots []*Ballot
+	if err := attributevalue.UnmarshalListOfMaps(ballotItems, &ballots); err != nil {
+		logging.Log.Errorf("VOTE: failed to unmarshal ballot list: %v", err)
+		return nil, err
+	}
+	var rows []*Vote
+	if err := attributevalue.UnmarshalListOfMaps(rowItems, &rows); err != nil {
+		logging.Log.Errorf("VOTE: failed to unmarshal vote list: %v", err)
+		return nil, err
+	}
+
+	var votes []*Vote
+	entries := make(map[string]bool)
+	for _, ballot := range ballots {
+		for sortKey := range ballot.Entries {
+			entries[ballot.Code+"#"+sortKey] = true
+		}
+		votes = append(votes, ballot.Votes()...)
+	}
+	for _, row := range rows {
+		if !entries[row.Code+"#"+row.SortKey] {
+			votes = append(votes, row)
+		}
+	}
+	if len(rows) > 0 {
+		logging.Log.Warnf("VOTE: read %d rating rows in the ballot layout, convert them with POST /api/admin/votes/convert-layout", len(rows))
+	}
+	return votes, nil
+}
+
+// Create adds a single rating to the ballot of vote.Code, creating the ballot if needed
+func (s *DynamoBallotVoteStorage) Create(ctx context.Context, vote *Vote) error {
+	item, err := attributevalue.MarshalMap(NewBallot(vote.Code, []*Vote{vote}))
+	if err != nil {
+		logging.Log.Errorf("VOTE: failed to marshal ballot: %v", err)
+		return err
+	}
+	_, err = s.Client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName:           &s.TableName,
+		Item:                item,
+		ConditionExpression: aws.String("attribute_not_exists(PK)"),
+	})
+	if err == nil {
+		return nil
+	}
+	var cce *types.ConditionalCheckFailedException
+	if !errors.As(err, &cce) {
+		logging.Log.Errorf("VOTE: failed to create ballot: %v", err)
+		return classify(err)
+	}
+
+	// The ballot exists already, add the rating to it
+	entry, err := attributevalue.Marshal(newBallotEntry(vote))
+	if err != nil {
+		logging.Log.Errorf("VOTE: failed to marshal ballot entry: %v", err)
+		return err
+	}
+	_, err = s.Client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:           &s.TableName,
+		Key:                 ballotKey(vote.Code),
+		UpdateExpression:    aws.String("SET Entries.#sk = :entry"),
+		ConditionExpression: aws.String("attribute_not_exists(Entries.#sk)"),
+		ExpressionAttributeNames: map[string]string{
+			"#sk": vote.SortKey,
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":entry": entry,
+		},
+	})
+	if err != nil {
+		if errors.As(err, &cce) {
+			logging.Log.Warnf("VOTE: vote PK: %s, SK: %s already exists", vote.Code, vote.SortKey)
+			return ErrAlreadyExists
+		}
+		logging.Log.Errorf("VOTE: failed to add vote to ballot: %v", err)
+		return classify(err)
+	}
+	return nil
+}
+
+// GetByCode queries every item of the code, its ballot and any rating rows not converted yet
+func (s *DynamoBallotVoteStorage) GetByCode(ctx context.Context, code string) ([]*Vote, error) {
+	items, err := queryAll(ctx, s.Client, &dynamodb.QueryInput{
+		TableName:              &s.TableName,
+		KeyConditionExpression: aws.String("PK = :code"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":code": &types.AttributeValueMemberS{Value: code},
+		},
+	})
+	if err != nil {
+		logging.Log.Errorf("VOTE: failed to get ballot for code %s: %v", code, err)
+		return nil, classify(err)
+	}
+	return s.votesOf(items)
+}
+
+// GetByTeam scans every ballot, the ratings inside a ballot are not indexed
+func (s *DynamoBallotVoteStorage) GetByTeam(ctx context.Context, teamID int) ([]*Vote, error) {
+	return s.filter(ctx, func(v *Vote) bool { return v.TeamID == teamID })
+}
+
+// GetByCategory scans every ballot, the ratings inside a ballot are not indexed
+func (s *DynamoBallotVoteStorage) GetByCategory(ctx context.Context, categoryID int) ([]*Vote, error) {
+	return s.filter(ctx, func(v *Vote) bool { return v.CategoryID == categoryID })
+}
+
+func (s *DynamoBallotVoteStorage) filter(ctx context.Context, matches func(*Vote) bool) ([]*Vote, error) {
+	all, err := s.GetAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var votes []*Vote
+	for _, v := range all {
+		if matches(v) {
+			votes = append(votes, v)
+		}
+	}
+	return votes, nil
+}
+
+// Delete removes the entries of the votes from their ballots, one update per ballot,
+// and the rating rows of the votes that were not converted yet
+func (s *DynamoBallotVoteStorage) Delete(ctx context.Context, votes []*Vote) error {
+	byCode := make(map[string][]string)
+	rows := make([]map[string]types.AttributeValue, 0, len(votes))
+	for _, v := range votes {
+		byCode[v.Code] = append(byCode[v.Code], v.SortKey)
+		rows = append(rows, map[string]types.AttributeValue{
+			"PK": &types.AttributeValueMemberS{Value: v.Code},
+			"SK": &types.AttributeValueMemberS{Value: v.SortKey},
+		})
+	}
+	if err := batchDelete(ctx, s.Client, s.TableName, rows); err != nil {
+		logging.Log.Errorf("VOTE: failed to delete %d rating rows: %v", len(rows), err)
+		return classify(err)
+	}
+
+	for code, sortKeys := range byCode {
+		names := make(map[string]string, len(sortKeys))
+		removes := make([]string, 0, len(sortKeys))
+		for i, sk := range sortKeys {
+			name := "#sk" + strconv.Itoa(i)
+			names[name] = sk
+			removes = append(removes, "Entries."+name)
+		}
+		_, err := s.Client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+			TableName:                &s.TableName,
+			Key:                      ballotKey(code),
+			UpdateExpression:         aws.String("REMOVE " + strings.Join(removes, ", ")),
+			ConditionExpression:      aws.String("attribute_exists(PK)"),
+			ExpressionAttributeNames: names,
+		})
+		var cce *types.ConditionalCheckFailedException
+		if err != nil && !errors.As(err, &cce) {
+			logging.Log.Errorf("VOTE: failed to remove %d entries from ballot %s: %v", len(sortKeys), code, err)
+			return classify(err)
+		}
+	}
+	return nil
+}
+
+func (s *DynamoBallotVoteStorage) DeleteAll(ctx context.Context) error {
+	return deleteAllItems(ctx, s.Client, s.TableName)
+}
+
+// ConvertRatingRows moves the cat#..#team#.. rows of every code into the ballot item of that code.
+// Rows are merged into an existing ballot (entries already on the ballot win) and deleted only
+// after the ballot is written, so a failed conversion can simply be run again.
+func (s *DynamoBallotVoteStorage) ConvertRatingRows(ctx context.Context) (int, error) {
+	items, err := scanAll(ctx, s.Client, &dynamodb.ScanInput{
+		TableName:        &s.TableName,
+		FilterExpression: aws.String("SK <> :ballot"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":ballot": &types.AttributeValueMemberS{Value: BallotSortKey},
+		},
+	})
+	if err != nil {
+		logging.Log.Errorf("VOTE: scan for conversion failed: %v", err)
+		return 0, classify(err)
+	}
+
+	var rows []*Vote
+	if err := attributevalue.UnmarshalListOfMaps(items, &rows); err != nil {
+		logging.Log.Errorf("VOTE: failed to unmarshal vote list: %v", err)
+		return 0, err
+	}
+
+	byCode := make(map[string][]*Vote)
+	for _, row := range rows {
+		byCode[row.Code] = append(byCode[row.Code], row)
+	}
+
+	converted := 0
+	for code, votes := range byCode {
+		ballot, err := s.getBallot(ctx, code)
+		if err != nil {
+			logging.Log.Errorf("VOTE: failed to get ballot for code %s: %v", code, err)
+			return converted, classify(err)
+		}
+		ballot = ballot.merge(NewBallot(code, votes))
+
+		item, err := attributevalue.MarshalMap(ballot)
+		if err != nil {
+			logging.Log.Errorf("VOTE: failed to marshal ballot: %v", err)
+			return converted, err
+		}
+		if _, err := s.Client.PutItem(ctx, &dynamodb.PutItemInput{
+			TableName: &s.TableName,
+			Item:      item,
+		}); err != nil {
+			logging.Log.Errorf("VOTE: failed to write ballot for code %s: %v", code, err)
+			return converted, classify(err)
+		}
+
+		keys := make([]map[string]types.AttributeValue, 0, len(votes))
+		for _, v := range votes {
+			keys = append(keys, map[string]types.AttributeValue{
+				"PK": &types.AttributeValueMemberS{Value: v.Code},
+				"SK": &types.AttributeValueMemberS{Value: v.SortKey},
+			})
+		}
+		if err := batchDelete(ctx, s.Client, s.TableName, keys); err != nil {
+			return converted, classify(err)
+		}
+		converted++
+	}
+
+	logging.Log.Infof("VOTE: converted %d rating rows into %d ballots", len(rows), converted)
+	return converted, nil
+}
+
+func (s *DynamoBallotVoteStorage) getBallot(ctx context.Context, code string) (*Ballot, error) {
+	out, err := s.Client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: &s.TableName,
+		Key:       ballotKey(code),
+	})
+	if err != nil {
+		return nil, classify(err)
+	}
+	if out.Item == nil {
+		return nil, nil
+	}
+
+	var ballot Ballot
+	if err := attributevalue.UnmarshalMap(out.Item, &ballot); err != nil {
+		return nil, err
+	}
+	return &ballot, nil
+}
+
+func ballotKey(code string) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		"PK": &types.AttributeValueMemberS{Value: code},
+		"SK": &types.AttributeValueMemberS{Value: BallotSortKey},
+	}
+}
+
+// NewBallot builds the ballot item of code from its votes. The ballot keeps the latest vote timestamp.
+func NewBallot(code string, votes []*Vote) *Ballot {
+	ballot := &Ballot{
+		Code:    code,
+		SortKey: BallotSortKey,
+		Entries: make(map[string]BallotEntry, len(votes)),
+	}
+	for _, v := range votes {
+		ballot.Entries[v.SortKey] = newBallotEntry(v)
+		if v.Timestamp.After(ballot.Timestamp) {
+			ballot.Timestamp = v.Timestamp
+		}
+	}
+	return ballot
+}
+
+func newBallotEntry(vote *Vote) BallotEntry {
+	return BallotEntry{
+		CategoryID: vote.CategoryID,
+		TeamID:     vote.TeamID,
+		Rating:     vote.Rating,
+	}
+}
+
+// Votes expands the ballot into one Vote per rating, ordered by sort key
+func (b *Ballot) Votes() []*Vote {
+	votes := make([]*Vote, 0, len(b.Entries))
+	for sortKey, entry := range b.Entries {
+		votes = append(votes, &Vote{
+			Code:       b.Code,
+			SortKey:    sortKey,
+			CategoryID: entry.CategoryID,
+			TeamID:     entry.TeamID,
+			Rating:     entry.Rating,
+			Timestamp:  b.Timestamp,
+		})
+	}
+	sort.Slice(votes, func(i, j int) bool {
+		return votes[i].SortKey < votes[j].SortKey
+	})
+	return votes
+}
+
+// merge adds the entries of other that b does not have yet. A nil b yields other.
+func (b *Ballot) merge(other *Ballot) *Ballot {
+	if b == nil {
+		return other
+	}
+	if b.Entries == nil {
+		b.Entries = make(map[string]BallotEntry, len(other.Entries))
+	}
+	for sortKey, entry := range other.Entries {
+		if _, ok := b.Entries[sortKey]; !ok {
+			b.Entries[sortKey] = entry
+		}
+	}
+	if other.Timestamp.After(b.Timestamp) {
+		b.Timestamp = other.Timestamp
+	}
+	return b
+}
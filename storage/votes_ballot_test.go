@@ -0,0 +1,75 @@
+package storage
+
+import (
+	"github.com/alex-pricope/simple-voting-system/logging"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestBallotRoundTrip(t *testing.T) {
+	now := time.Now().UTC()
+	votes := []*Vote{
+		{Code: "ABCDE", SortKey: "cat#2#team#1", CategoryID: 2, TeamID: 1, Rating: 3, Timestamp: now},
+		{Code: "ABCDE", SortKey: "cat#1#team#1", CategoryID: 1, TeamID: 1, Rating: 5, Timestamp: now},
+	}
+
+	ballot := NewBallot("ABCDE", votes)
+	assert.Equal(t, BallotSortKey, ballot.SortKey)
+	assert.Len(t, ballot.Entries, 2)
+
+	expanded := ballot.Votes()
+	assert.Equal(t, []*Vote{votes[1], votes[0]}, expanded)
+}
+
+func TestBallotMerge(t *testing.T) {
+	older := time.Now().UTC().Add(-time.Hour)
+	newer := older.Add(time.Minute)
+
+	existing := NewBallot("ABCDE", []*Vote{
+		{Code: "ABCDE", SortKey: "cat#1#team#1", CategoryID: 1, TeamID: 1, Rating: 5, Timestamp: older},
+	})
+	rows := NewBallot("ABCDE", []*Vote{
+		{Code: "ABCDE", SortKey: "cat#1#team#1", CategoryID: 1, TeamID: 1, Rating: 1, Timestamp: newer},
+		{Code: "ABCDE", SortKey: "cat#1#team#2", CategoryID: 1, TeamID: 2, Rating: 4, Timestamp: newer},
+	})
+
+	merged := existing.merge(rows)
+	assert.Len(t, merged.Entries, 2)
+	assert.Equal(t, 5, merged.Entries["cat#1#team#1"].Rating, "entries already on the ballot win")
+	assert.Equal(t, 4, merged.Entries["cat#1#team#2"].Rating)
+	assert.Equal(t, newer, merged.Timestamp)
+
+	var missing *Ballot
+	assert.Same(t, rows, missing.merge(rows))
+}
+
+func TestBallotLayoutReadsRatingRows(t *testing.T) {
+	logging.Log = logrus.New()
+	now := time.Now().UTC()
+	ballot, err := attributevalue.MarshalMap(NewBallot("ABCDE", []*Vote{
+		{Code: "ABCDE", SortKey: "cat#1#team#1", CategoryID: 1, TeamID: 1, Rating: 5, Timestamp: now},
+	}))
+	require.NoError(t, err)
+	items := []map[string]types.AttributeValue{ballot}
+	for _, row := range []*Vote{
+		{Code: "ABCDE", SortKey: "cat#1#team#1", CategoryID: 1, TeamID: 1, Rating: 1, Timestamp: now},
+		{Code: "ABCDE", SortKey: "cat#1#team#2", CategoryID: 1, TeamID: 2, Rating: 4, Timestamp: now},
+		{Code: "FGHIJ", SortKey: "cat#1#team#1", CategoryID: 1, TeamID: 1, Rating: 3, Timestamp: now},
+	} {
+		item, err := attributevalue.MarshalMap(row)
+		require.NoError(t, err)
+		items = append(items, item)
+	}
+
+	votes, err := (&DynamoBallotVoteStorage{}).votesOf(items)
+	require.NoError(t, err)
+	require.Len(t, votes, 3, "rows not converted yet are read with the ballots")
+	assert.Equal(t, 5, votes[0].Rating, "entries already on the ballot win")
+	assert.Equal(t, "cat#1#team#2", votes[1].SortKey)
+	assert.Equal(t, "FGHIJ", votes[2].Code)
+}